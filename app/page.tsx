@@ -1,6 +1,6 @@
 "use client"
 
-import { useState } from "react"
+import { useEffect, useState } from "react"
 import { useStore } from "@/lib/store"
 import { LoginScreen } from "@/components/login-screen"
 import { AppSidebar, type Section } from "@/components/app-sidebar"
@@ -11,13 +11,48 @@ import { ApprovalsPage } from "@/components/pages/approvals-page"
 import { BillingPage } from "@/components/pages/billing-page"
 import { UsersPage } from "@/components/pages/users-page"
 import { SettingsPage } from "@/components/pages/settings-page"
+import { AlertCircle } from "lucide-react"
 
 export default function Home() {
-  const { currentUser } = useStore()
+  const { currentUser, verifyEmail } = useStore()
   const [activeSection, setActiveSection] = useState<Section>("dashboard")
+  const [emailNotice, setEmailNotice] = useState<{ ok: boolean; message: string } | null>(null)
+
+  // From an email change confirmation link; works signed in or out.
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.search).get("verify-email-token")
+    if (!token) return
+    window.history.replaceState(null, "", window.location.pathname)
+    verifyEmail(token)
+      .then((email) => setEmailNotice({ ok: true, message: `Your email address is now ${email}.` }))
+      .catch((err) =>
+        setEmailNotice({ ok: false, message: err instanceof Error ? err.message : "Could not confirm email address" })
+      )
+  }, [verifyEmail])
+
+  const notice = emailNotice && (
+    <div
+      className={`flex items-center gap-2 border-b p-3 text-sm ${
+        emailNotice.ok
+          ? "border-primary/30 bg-primary/10 text-primary"
+          : "border-destructive/50 bg-destructive/10 text-destructive"
+      }`}
+    >
+      {!emailNotice.ok && <AlertCircle className="h-4 w-4 shrink-0" />}
+      <span className="flex-1">{emailNotice.message}</span>
+      <button type="button" onClick={() => setEmailNotice(null)} className="text-xs uppercase tracking-wider">
+        Dismiss
+      </button>
+    </div>
+  )
 
   if (!currentUser) {
-    return <LoginScreen />
+    return (
+      <>
+        {notice}
+        <LoginScreen />
+      </>
+    )
   }
 
   const renderPage = () => {
@@ -44,7 +79,10 @@ export default function Home() {
   return (
     <div className="flex h-screen overflow-hidden">
       <AppSidebar activeSection={activeSection} onSectionChange={setActiveSection} />
-      <main className="flex-1 overflow-auto">{renderPage()}</main>
+      <main className="flex-1 overflow-auto">
+        {notice}
+        {renderPage()}
+      </main>
     </div>
   )
 }
@@ -8,9 +8,11 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/awslabs/aws-lambda-go-api-proxy/httpadapter"
+	"github.com/supporttickr/backend/internal/blob"
 	"github.com/supporttickr/backend/internal/config"
 	"github.com/supporttickr/backend/internal/routes"
 	"github.com/supporttickr/backend/internal/store"
+	"github.com/supporttickr/backend/internal/subscribers"
 )
 
 var adapter *httpadapter.HandlerAdapterV2
@@ -26,7 +28,12 @@ func init() {
 		log.Fatalf("Failed to create store: %v", err)
 	}
 
-	handler := routes.Setup(st, cfg)
+	blobs, err := blob.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create attachment storage: %v", err)
+	}
+
+	handler := routes.SetupServerless(st, blobs, subscribers.New(st, cfg), cfg)
 	adapter = httpadapter.NewV2(handler)
 
 	log.Println("Lambda initialization complete")
@@ -9,9 +9,13 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/supporttickr/backend/internal/blob"
 	"github.com/supporttickr/backend/internal/config"
+	"github.com/supporttickr/backend/internal/inbound"
+	"github.com/supporttickr/backend/internal/purge"
 	"github.com/supporttickr/backend/internal/routes"
 	"github.com/supporttickr/backend/internal/store"
+	"github.com/supporttickr/backend/internal/subscribers"
 )
 
 func main() {
@@ -26,7 +30,37 @@ func main() {
 		log.Fatalf("Failed to create store: %v", err)
 	}
 
-	handler := routes.Setup(st, cfg)
+	blobs, err := blob.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create attachment storage: %v", err)
+	}
+
+	subs := subscribers.New(st, cfg)
+	handler := routes.Setup(st, blobs, subs, cfg)
+
+	workerCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+	if cfg.InboundMaildir != "" {
+		poller := &inbound.Poller{
+			Dir:       cfg.InboundMaildir,
+			Processor: &inbound.Processor{Store: st, Blobs: blobs, Outbox: subs.Outbox, AuthServID: cfg.InboundAuthServID},
+		}
+		go poller.Run(workerCtx)
+	}
+
+	// Dispatch outbox events that were not flushed by the request that wrote them.
+	go subs.Outbox.Run(workerCtx, 10*time.Second)
+
+	// Pass stream events published by any instance to this one's clients.
+	go subs.Broker.Run(workerCtx, time.Second)
+
+	// Retry failed webhook deliveries with backoff.
+	go subs.Webhooks.Run(workerCtx, 30*time.Second)
+
+	// Purge users and organizations once their soft-delete retention is over,
+	// and run organization purge jobs.
+	purger := &purge.Purger{Store: st, Blobs: blobs, Retention: time.Duration(cfg.DeletedRetentionDays) * 24 * time.Hour}
+	go purger.Run(workerCtx, time.Minute)
 
 	srv := &http.Server{
 		Addr:         ":" + cfg.Port,
@@ -49,6 +83,7 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	stopWorkers()
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
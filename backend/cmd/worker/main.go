@@ -0,0 +1,62 @@
+// Command worker is a scheduled Lambda that runs background jobs: draining
+// the event outbox, retrying webhook deliveries that are due and purging
+// soft-deleted users and organizations whose retention is over.
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/supporttickr/backend/internal/blob"
+	"github.com/supporttickr/backend/internal/config"
+	"github.com/supporttickr/backend/internal/purge"
+	"github.com/supporttickr/backend/internal/store"
+	"github.com/supporttickr/backend/internal/subscribers"
+)
+
+var (
+	subs   *subscribers.Set
+	purger *purge.Purger
+)
+
+func init() {
+	cfg := config.Load()
+
+	st, err := store.NewStore(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to create store: %v", err)
+	}
+	subs = subscribers.New(st, cfg)
+
+	blobs, err := blob.New(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to create attachment storage: %v", err)
+	}
+	purger = &purge.Purger{Store: st, Blobs: blobs, Retention: time.Duration(cfg.DeletedRetentionDays) * 24 * time.Hour}
+}
+
+func handleTick(ctx context.Context) error {
+	dispatched, err := subs.Outbox.Drain(ctx)
+	if err != nil {
+		return err
+	}
+	if dispatched > 0 {
+		log.Printf("worker: dispatched %d outbox events", dispatched)
+	}
+
+	n, err := subs.Webhooks.RetryDue(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("worker: retried %d webhook deliveries", n)
+	}
+
+	return purger.Tick(ctx)
+}
+
+func main() {
+	lambda.Start(handleTick)
+}
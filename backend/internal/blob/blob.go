@@ -0,0 +1,127 @@
+// Package blob stores binary objects such as ticket attachments.
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/supporttickr/backend/internal/config"
+)
+
+// ErrNotFound is returned by Get when no object exists for the key.
+var ErrNotFound = errors.New("blob not found")
+
+// Storage is a minimal object store keyed by slash-separated paths.
+type Storage interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns S3 storage when cfg.AttachmentsBucket is set and local directory
+// storage under cfg.AttachmentsDir otherwise.
+func New(ctx context.Context, cfg *config.Config) (Storage, error) {
+	if cfg.AttachmentsBucket == "" {
+		return &DirStorage{Dir: cfg.AttachmentsDir}, nil
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Storage{Client: s3.NewFromConfig(awsCfg), Bucket: cfg.AttachmentsBucket}, nil
+}
+
+// S3Storage keeps objects in an S3 bucket.
+type S3Storage struct {
+	Client *s3.Client
+	Bucket string
+}
+
+func (s *S3Storage) Put(ctx context.Context, key, contentType string, data []byte) error {
+	_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nsk interface{ ErrorCode() string }
+		if errors.As(err, &nsk) && nsk.ErrorCode() == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// DirStorage keeps objects as files below Dir, for local development.
+type DirStorage struct {
+	Dir string
+}
+
+func (d *DirStorage) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if strings.Contains(clean, "..") {
+		return "", errors.New("invalid blob key")
+	}
+	return filepath.Join(d.Dir, filepath.FromSlash(clean)), nil
+}
+
+func (d *DirStorage) Put(ctx context.Context, key, contentType string, data []byte) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
+
+func (d *DirStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (d *DirStorage) Delete(ctx context.Context, key string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Package chat integrates with Slack-compatible chat workspaces: it posts
+// ticket notifications to each organization's incoming webhook and answers
+// slash commands sent by the workspace.
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// UrgentPriorities are the ticket priorities announced when a ticket is created.
+var UrgentPriorities = map[string]bool{"high": true, "critical": true}
+
+// Message is an incoming-webhook payload. Text is the fallback shown in
+// notifications; Blocks carry the formatted version.
+type Message struct {
+	Text   string  `json:"text"`
+	Blocks []Block `json:"blocks,omitempty"`
+}
+
+// Block is a layout block ("section" or "context").
+type Block struct {
+	Type     string `json:"type"`
+	Text     *Text  `json:"text,omitempty"`
+	Elements []Text `json:"elements,omitempty"`
+}
+
+// Text is a mrkdwn text object.
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func sectionBlock(text string) Block {
+	return Block{Type: "section", Text: &Text{Type: "mrkdwn", Text: text}}
+}
+
+func contextBlock(texts ...string) Block {
+	b := Block{Type: "context"}
+	for _, t := range texts {
+		b.Elements = append(b.Elements, Text{Type: "mrkdwn", Text: t})
+	}
+	return b
+}
+
+// Notifier posts notifications to organizations' chat webhooks. A nil
+// *Notifier is valid and posts nothing.
+type Notifier struct {
+	Store  store.Store
+	Client *http.Client
+	AppURL string
+}
+
+func New(st store.Store, appURL string) *Notifier {
+	return &Notifier{Store: st, Client: &http.Client{Timeout: 10 * time.Second}, AppURL: appURL}
+}
+
+// UrgentTicket announces a new high or critical priority ticket.
+func (n *Notifier) UrgentTicket(ctx context.Context, t *models.Ticket) {
+	if n == nil || !UrgentPriorities[t.Priority] {
+		return
+	}
+	org := n.org(ctx, t.OrganizationID)
+	if org == nil {
+		return
+	}
+	blocks := []Block{sectionBlock(fmt.Sprintf(":rotating_light: *New %s priority ticket* %s\n*%s*", t.Priority, n.ticketLink(t), escape(t.Title)))}
+	if desc := truncate(t.Description, 300); desc != "" {
+		blocks = append(blocks, sectionBlock(escape(desc)))
+	}
+	blocks = append(blocks, contextBlock("Organization: "+escape(org.Name), "Category: "+t.Category))
+	n.post(ctx, org, Message{
+		Text:   fmt.Sprintf("New %s priority ticket %s: %s", t.Priority, t.ID, t.Title),
+		Blocks: blocks,
+	})
+}
+
+// ApprovalPending announces a conversion request waiting for approval.
+func (n *Notifier) ApprovalPending(ctx context.Context, t *models.Ticket, cr *models.ConversionRequest) {
+	if n == nil {
+		return
+	}
+	org := n.org(ctx, t.OrganizationID)
+	if org == nil {
+		return
+	}
+	blocks := []Block{sectionBlock(fmt.Sprintf(":hourglass: *Approval needed* to convert %s to *%s*\n*%s*", n.ticketLink(t), cr.ProposedType, escape(t.Title)))}
+	if reason := truncate(cr.Reason, 300); reason != "" {
+		blocks = append(blocks, sectionBlock("> "+escape(reason)))
+	}
+	blocks = append(blocks, contextBlock("Organization: "+escape(org.Name)))
+	n.post(ctx, org, Message{
+		Text:   fmt.Sprintf("Approval needed: convert %s to %s", t.ID, cr.ProposedType),
+		Blocks: blocks,
+	})
+}
+
+func (n *Notifier) org(ctx context.Context, id string) *models.Organization {
+	org, err := n.Store.GetOrg(ctx, id)
+	if err != nil {
+		log.Printf("chat: load organization %s: %v", id, err)
+		return nil
+	}
+	if org == nil || org.ChatWebhookURL == "" {
+		return nil
+	}
+	return org
+}
+
+// post sends m to the organization's channel. A redelivered event is posted
+// once: the first post for an event is recorded by its ID.
+func (n *Notifier) post(ctx context.Context, org *models.Organization, m Message) {
+	if id := events.ID(ctx); id != "" {
+		err := n.Store.MarkSent(ctx, id+"/chat/"+org.ID)
+		if errors.Is(err, store.ErrAlreadySent) {
+			return
+		}
+		if err != nil {
+			log.Printf("chat: record post for %s: %v", id, err)
+		}
+	}
+	if err := Post(ctx, n.Client, org.ChatWebhookURL, m); err != nil {
+		log.Printf("chat: post to %s: %v", org.ID, err)
+	}
+}
+
+func (n *Notifier) ticketLink(t *models.Ticket) string {
+	return link(n.AppURL+"/tickets/"+t.ID, t.ID)
+}
+
+// Post sends m to an incoming webhook URL.
+func Post(ctx context.Context, client *http.Client, url string, m Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// link formats a mrkdwn link.
+func link(url, text string) string {
+	return "<" + url + "|" + escape(text) + ">"
+}
+
+// escape escapes the characters mrkdwn treats as control sequences.
+func escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(strings.TrimSpace(s))
+	if len(r) <= n {
+		return string(r)
+	}
+	return string(r[:n]) + "…"
+}
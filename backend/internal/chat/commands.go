@@ -0,0 +1,199 @@
+package chat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// Request signing headers. The signature is "v0=" + hex HMAC-SHA256 over
+// "v0:<timestamp>:<body>" using the workspace signing secret.
+const (
+	SignatureHeader = "X-Slack-Signature"
+	TimestampHeader = "X-Slack-Request-Timestamp"
+)
+
+// maxClockSkew rejects signed requests older than this, to limit replays.
+const maxClockSkew = 5 * time.Minute
+
+var ErrBadSignature = errors.New("invalid request signature")
+
+// Sign returns the signature header value for body sent at unix time ts.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%d:", ts)
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a request whose raw body is body.
+func Verify(secret string, h http.Header, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(h.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > maxClockSkew || d < -maxClockSkew {
+		return ErrBadSignature
+	}
+	if !hmac.Equal([]byte(h.Get(SignatureHeader)), []byte(Sign(secret, ts, body))) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Command is a slash command invocation, e.g. "/ticket tkt-1a2b3c4d".
+type Command struct {
+	Command  string
+	Text     string
+	UserName string
+}
+
+// Response is the reply shown in the channel. Ephemeral replies are only
+// shown to the user who ran the command.
+type Response struct {
+	ResponseType string  `json:"response_type"`
+	Text         string  `json:"text"`
+	Blocks       []Block `json:"blocks,omitempty"`
+}
+
+func ephemeral(format string, args ...any) Response {
+	return Response{ResponseType: "ephemeral", Text: fmt.Sprintf(format, args...)}
+}
+
+// Commands answers slash commands. Anyone in the workspace that holds the
+// signing secret is treated as support staff; commands that change a ticket
+// are recorded as the agent the chat user name resolves to (see actor).
+type Commands struct {
+	Store  store.Store
+	Outbox *outbox.Relay
+	AppURL string
+	// UserDomain completes chat user names that are not email addresses.
+	UserDomain string
+}
+
+func (c *Commands) Run(ctx context.Context, cmd Command) Response {
+	args := strings.Fields(cmd.Text)
+	switch strings.TrimPrefix(cmd.Command, "/") {
+	case "ticket":
+		if len(args) != 1 {
+			return ephemeral("Usage: /ticket <ticket id>")
+		}
+		return c.ticket(ctx, args[0])
+	case "assign":
+		if len(args) != 2 {
+			return ephemeral("Usage: /assign <ticket id> <agent email | none>")
+		}
+		return c.assign(ctx, cmd, args[0], args[1])
+	}
+	return ephemeral("Unknown command %s. Try /ticket or /assign.", cmd.Command)
+}
+
+func (c *Commands) ticket(ctx context.Context, id string) Response {
+	t, err := c.Store.GetTicket(ctx, strings.ToLower(id))
+	if err != nil || t == nil {
+		return ephemeral("Ticket %s not found.", id)
+	}
+
+	assignee := "unassigned"
+	if t.AssignedTo != nil {
+		assignee = *t.AssignedTo
+		if u, _ := c.Store.GetUser(ctx, *t.AssignedTo); u != nil {
+			assignee = u.Name
+		}
+	}
+	org := t.OrganizationID
+	if o, _ := c.Store.GetOrg(ctx, t.OrganizationID); o != nil {
+		org = o.Name
+	}
+
+	return Response{
+		ResponseType: "ephemeral",
+		Text:         fmt.Sprintf("%s: %s (%s, %s)", t.ID, t.Title, t.Status, t.Priority),
+		Blocks: []Block{
+			sectionBlock(fmt.Sprintf("%s *%s*", link(c.AppURL+"/tickets/"+t.ID, t.ID), escape(t.Title))),
+			contextBlock(
+				"Status: "+t.Status,
+				"Priority: "+t.Priority,
+				"Assignee: "+escape(assignee),
+				"Organization: "+escape(org),
+			),
+		},
+	}
+}
+
+// actor returns the agent who ran cmd: the user whose email is the chat user
+// name, or the name at UserDomain. It returns nil if there is none.
+func (c *Commands) actor(ctx context.Context, cmd Command) *models.User {
+	email := cmd.UserName
+	if !strings.Contains(email, "@") {
+		if c.UserDomain == "" || email == "" {
+			return nil
+		}
+		email += "@" + c.UserDomain
+	}
+	u, err := c.Store.GetUserByEmail(ctx, email)
+	if err != nil || u == nil || u.DeletedAt != nil || !policy.Allowed(u.Role, policy.TicketAssign) {
+		return nil
+	}
+	return u
+}
+
+func (c *Commands) assign(ctx context.Context, cmd Command, id, who string) Response {
+	actor := c.actor(ctx, cmd)
+	if actor == nil {
+		return ephemeral("Your chat user %s does not match an agent.", cmd.UserName)
+	}
+	t, err := c.Store.GetTicket(ctx, strings.ToLower(id))
+	if err != nil || t == nil {
+		return ephemeral("Ticket %s not found.", id)
+	}
+
+	assignTo := ""
+	name := "nobody"
+	if !strings.EqualFold(who, "none") {
+		u, err := c.Store.GetUserByEmail(ctx, strings.ToLower(strings.Trim(who, "<>")))
+		if err != nil || u == nil || u.DeletedAt != nil || !policy.Allowed(u.Role, policy.TicketAssign) {
+			return ephemeral("No agent with email %s.", who)
+		}
+		assignTo = u.ID
+		name = u.Name
+	}
+
+	after := *t
+	after.AssignedTo = nil
+	if assignTo != "" {
+		after.AssignedTo = &assignTo
+	}
+	after.UpdatedAt = time.Now().UTC()
+	msgs := outbox.Messages(events.TicketChanges(t, &after, actor.ID)...)
+	if err := c.Store.UpdateTicket(ctx, t.ID, nil, nil, &assignTo, nil, msgs...); err != nil {
+		return ephemeral("Failed to assign %s.", t.ID)
+	}
+	if assignTo != "" {
+		_ = c.Store.AddWatcher(ctx, &models.TicketWatcher{
+			TicketID:  t.ID,
+			UserID:    assignTo,
+			Source:    "assignee",
+			CreatedAt: after.UpdatedAt,
+		})
+	}
+	c.Outbox.Flush(ctx, msgs)
+
+	return Response{
+		ResponseType: "in_channel",
+		Text:         fmt.Sprintf("%s assigned %s to %s.", cmd.UserName, t.ID, name),
+	}
+}
@@ -0,0 +1,227 @@
+package chat_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/chat"
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/handlers"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+const secret = "signing-secret"
+
+func TestVerify(t *testing.T) {
+	body := []byte("command=%2Fticket&text=tkt-1")
+	now := time.Now()
+	for _, tc := range []struct {
+		name    string
+		ts      int64
+		sig     string // signed with secret at ts if empty
+		wantErr bool
+	}{
+		{name: "valid", ts: now.Unix()},
+		{name: "slightly ahead", ts: now.Add(time.Minute).Unix()},
+		{name: "wrong secret", ts: now.Unix(), sig: chat.Sign("other-secret", now.Unix(), body), wantErr: true},
+		{name: "other body", ts: now.Unix(), sig: chat.Sign(secret, now.Unix(), []byte("command=%2Fassign")), wantErr: true},
+		{name: "signed at another time", ts: now.Unix(), sig: chat.Sign(secret, now.Unix()-1, body), wantErr: true},
+		{name: "stale", ts: now.Add(-6 * time.Minute).Unix(), wantErr: true},
+		{name: "from the future", ts: now.Add(6 * time.Minute).Unix(), wantErr: true},
+		{name: "no signature", ts: now.Unix(), sig: "v0=", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sig := tc.sig
+			if sig == "" {
+				sig = chat.Sign(secret, tc.ts, body)
+			}
+			h := http.Header{}
+			h.Set(chat.TimestampHeader, strconv.FormatInt(tc.ts, 10))
+			h.Set(chat.SignatureHeader, sig)
+			if err := chat.Verify(secret, h, body, now); (err != nil) != tc.wantErr {
+				t.Fatalf("Verify = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// workspace is a stand-in for the chat workspace: it sends signed slash
+// commands to the API's command endpoint served by httptest.
+type workspace struct {
+	t   *testing.T
+	st  *storetest.Memory
+	api *httptest.Server
+}
+
+func newWorkspace(t *testing.T) *workspace {
+	ctx := context.Background()
+	st := storetest.New()
+	orgID := "org-acme"
+	st.CreateOrg(ctx, &models.Organization{ID: orgID, Name: "Acme"})
+	for _, u := range []*models.User{
+		{ID: "user-lead", Name: "Lee", Email: "lee@support.test", Role: policy.SupportLead},
+		{ID: "user-agent", Name: "Ann", Email: "ann@support.test", Role: policy.SupportStaff},
+		{ID: "user-client", Name: "Cal", Email: "cal@acme.test", Role: policy.Client, OrganizationID: &orgID},
+	} {
+		st.CreateUser(ctx, u)
+	}
+	st.CreateTicket(ctx, &models.Ticket{ID: "tkt-1", Title: "Printer <on> fire", Status: "open", Priority: "high",
+		OrganizationID: orgID, CreatedBy: "user-client"})
+
+	h := &handlers.ChatHandler{
+		Commands:      &chat.Commands{Store: st, AppURL: "http://app.test", UserDomain: "support.test"},
+		SigningSecret: secret,
+	}
+	api := httptest.NewServer(http.HandlerFunc(h.Command))
+	t.Cleanup(api.Close)
+	return &workspace{t: t, st: st, api: api}
+}
+
+// run sends a slash command as user and returns the status and reply.
+func (w *workspace) run(user, command, text string) (int, chat.Response) {
+	w.t.Helper()
+	body := url.Values{"command": {command}, "text": {text}, "user_name": {user}}.Encode()
+	req, _ := http.NewRequest(http.MethodPost, w.api.URL, strings.NewReader(body))
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(chat.TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(chat.SignatureHeader, chat.Sign(secret, ts, []byte(body)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out chat.Response
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+func TestCommandSignature(t *testing.T) {
+	w := newWorkspace(t)
+	body := "command=%2Fticket&text=tkt-1&user_name=lee"
+	req, _ := http.NewRequest(http.MethodPost, w.api.URL, strings.NewReader(body))
+	ts := time.Now().Unix()
+	req.Header.Set(chat.TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(chat.SignatureHeader, chat.Sign("guessed", ts, []byte(body)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestTicketCommand(t *testing.T) {
+	w := newWorkspace(t)
+	w.st.ApplyTicketChanges(context.Background(), "tkt-1", nil, nil, nil, strPtr("user-agent"), nil)
+
+	code, resp := w.run("lee", "/ticket", "TKT-1")
+	if code != http.StatusOK || resp.ResponseType != "ephemeral" {
+		t.Fatalf("status %d, response %+v", code, resp)
+	}
+	if resp.Text != "tkt-1: Printer <on> fire (open, high)" {
+		t.Errorf("text %q", resp.Text)
+	}
+	var texts []string
+	for _, b := range resp.Blocks {
+		if b.Text != nil {
+			texts = append(texts, b.Text.Text)
+		}
+		for _, e := range b.Elements {
+			texts = append(texts, e.Text)
+		}
+	}
+	blocks := strings.Join(texts, "\n")
+	for _, want := range []string{"<http://app.test/tickets/tkt-1|tkt-1> *Printer &lt;on&gt; fire*", "Assignee: Ann", "Organization: Acme"} {
+		if !strings.Contains(blocks, want) {
+			t.Errorf("blocks %q do not contain %q", blocks, want)
+		}
+	}
+
+	if _, resp := w.run("lee", "/ticket", "tkt-404"); resp.Text != "Ticket tkt-404 not found." {
+		t.Errorf("unknown ticket: %q", resp.Text)
+	}
+	if _, resp := w.run("lee", "/ticket", ""); !strings.HasPrefix(resp.Text, "Usage:") {
+		t.Errorf("no ticket ID: %q", resp.Text)
+	}
+}
+
+func TestAssignCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name, user, text string
+		wantText         string
+		wantAssignee     string
+	}{
+		{name: "by user name", user: "lee", text: "tkt-1 ann@support.test", wantText: "lee assigned tkt-1 to Ann.", wantAssignee: "user-agent"},
+		{name: "by email", user: "lee@support.test", text: "tkt-1 <ann@support.test>", wantText: "lee@support.test assigned tkt-1 to Ann.", wantAssignee: "user-agent"},
+		{name: "unknown chat user", user: "mallory", text: "tkt-1 ann@support.test", wantText: "Your chat user mallory does not match an agent."},
+		{name: "chat user is a client", user: "cal@acme.test", text: "tkt-1 ann@support.test", wantText: "Your chat user cal@acme.test does not match an agent."},
+		{name: "assignee is a client", user: "lee", text: "tkt-1 cal@acme.test", wantText: "No agent with email cal@acme.test."},
+		{name: "unknown ticket", user: "lee", text: "tkt-404 ann@support.test", wantText: "Ticket tkt-404 not found."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newWorkspace(t)
+			code, resp := w.run(tc.user, "/assign", tc.text)
+			if code != http.StatusOK || resp.Text != tc.wantText {
+				t.Fatalf("status %d, text %q, want %q", code, resp.Text, tc.wantText)
+			}
+
+			ctx := context.Background()
+			tk, _ := w.st.GetTicket(ctx, "tkt-1")
+			if got := deref(tk.AssignedTo); got != tc.wantAssignee {
+				t.Fatalf("assigned to %q, want %q", got, tc.wantAssignee)
+			}
+			if tc.wantAssignee == "" {
+				return
+			}
+			if watchers, _ := w.st.ListWatchers(ctx, "tkt-1"); len(watchers) != 1 || watchers[0].UserID != tc.wantAssignee {
+				t.Errorf("watchers %+v, want the assignee", watchers)
+			}
+			pending, _ := w.st.ListDueOutbox(ctx, time.Now().Add(time.Hour), 0)
+			for _, m := range pending {
+				e, err := events.Decode(m.Event, []byte(m.Payload))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if a, ok := e.(events.TicketAssigned); ok && a.ActorID != "user-lead" {
+					t.Errorf("assignment recorded as %q, want the chat user's agent", a.ActorID)
+				}
+			}
+			if len(pending) == 0 {
+				t.Error("no events recorded")
+			}
+		})
+	}
+}
+
+func TestUnassignCommand(t *testing.T) {
+	w := newWorkspace(t)
+	ctx := context.Background()
+	w.st.ApplyTicketChanges(ctx, "tkt-1", nil, nil, nil, strPtr("user-agent"), nil)
+	if _, resp := w.run("lee", "/assign", "tkt-1 none"); resp.Text != "lee assigned tkt-1 to nobody." {
+		t.Fatalf("text %q", resp.Text)
+	}
+	if tk, _ := w.st.GetTicket(ctx, "tkt-1"); tk.AssignedTo != nil {
+		t.Errorf("still assigned to %s", *tk.AssignedTo)
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
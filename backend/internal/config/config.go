@@ -1,41 +1,154 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+)
 
 type Config struct {
 	JWTSecret   string
 	FrontendURL string
 	Port        string
+	// Outbound mail: MailDriver is "log" (default), "file" or "smtp"
+	MailDriver   string
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	// Inbound mail and attachments
+	InboundEmailSecret string
+	InboundMaildir     string
+	// InboundAuthServID is the authserv-id of the relay that receives our
+	// mail; only its Authentication-Results header is trusted
+	InboundAuthServID string
+	AttachmentsBucket string
+	AttachmentsDir    string
+	// Chat integration: verifies slash commands from the chat workspace
+	ChatSigningSecret string
+	// ChatUserDomain is the email domain of agents' chat user names, so that
+	// slash commands from "jane" act as jane@<domain>
+	ChatUserDomain string
+	// Single sign-on: this API's OIDC callback URL, registered with each
+	// organization's identity provider
+	SSOCallbackURL string
+	// TrustProxy takes client addresses from the X-Forwarded-For entry added
+	// by the proxy in front of the API (API Gateway or a load balancer)
+	// instead of the connection's address
+	TrustProxy bool
+	// Password policy: minimum length, how many character classes (lower,
+	// upper, digit, symbol) to mix and how many recent passwords not to reuse
+	PasswordMinLength  int
+	PasswordMinClasses int
+	PasswordHistory    int
+	// Days soft-deleted users and organizations are kept before they are
+	// purged for good
+	DeletedRetentionDays int
 	// DynamoDB table names (from env in Lambda)
-	UsersTable             string
-	OrgsTable              string
-	TicketsTable           string
-	MessagesTable          string
-	TimeEntriesTable       string
+	UsersTable              string
+	OrgsTable               string
+	TicketsTable            string
+	MessagesTable           string
+	TimeEntriesTable        string
 	ConversionRequestsTable string
-	InvoicesTable          string
-	ActivitiesTable        string
+	InvoicesTable           string
+	ActivitiesTable         string
+	WatchersTable           string
+	UserTokensTable         string
+	MacrosTable             string
+	AttachmentsTable        string
+	WebhooksTable           string
+	WebhookDeliveriesTable  string
+	OutboxTable             string
+	SessionsTable           string
+	APITokensTable          string
+	RolesTable              string
+	LoginAttemptsTable      string
+	PurgeJobsTable          string
+	UserEmailsTable         string
+	SentTable               string
+	StreamTable             string
 }
 
 func Load() *Config {
 	return &Config{
-		JWTSecret:   getEnv("JWT_SECRET", "change-me-in-production"),
-		FrontendURL: getEnv("FRONTEND_URL", "http://localhost:3000"),
-		Port:        getEnv("PORT", "8080"),
-		UsersTable:             getEnv("USERS_TABLE", "supportdesk-users"),
-		OrgsTable:              getEnv("ORGS_TABLE", "supportdesk-organizations"),
-		TicketsTable:           getEnv("TICKETS_TABLE", "supportdesk-tickets"),
-		MessagesTable:          getEnv("MESSAGES_TABLE", "supportdesk-messages"),
-		TimeEntriesTable:       getEnv("TIME_ENTRIES_TABLE", "supportdesk-time-entries"),
+		JWTSecret:               getEnv("JWT_SECRET", "change-me-in-production"),
+		FrontendURL:             getEnv("FRONTEND_URL", "http://localhost:3000"),
+		Port:                    getEnv("PORT", "8080"),
+		MailDriver:              getEnv("MAIL_DRIVER", "log"),
+		MailFrom:                getEnv("MAIL_FROM", "SupportFix <no-reply@supportfix.ai>"),
+		MailDir:                 getEnv("MAIL_DIR", "mail-outbox"),
+		SMTPHost:                getEnv("SMTP_HOST", ""),
+		SMTPPort:                getEnv("SMTP_PORT", "587"),
+		SMTPUsername:            getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:            getEnv("SMTP_PASSWORD", ""),
+		InboundEmailSecret:      getEnv("INBOUND_EMAIL_SECRET", ""),
+		InboundMaildir:          getEnv("INBOUND_MAILDIR", ""),
+		InboundAuthServID:       getEnv("INBOUND_AUTHSERV_ID", ""),
+		AttachmentsBucket:       getEnv("ATTACHMENTS_BUCKET", ""),
+		AttachmentsDir:          getEnv("ATTACHMENTS_DIR", "attachments"),
+		ChatSigningSecret:       getEnv("CHAT_SIGNING_SECRET", ""),
+		ChatUserDomain:          getEnv("CHAT_USER_DOMAIN", ""),
+		SSOCallbackURL:          getEnv("SSO_CALLBACK_URL", "http://localhost:8080/api/auth/sso/callback"),
+		TrustProxy:              getEnvBool("TRUST_PROXY", false),
+		PasswordMinLength:       getEnvInt("PASSWORD_MIN_LENGTH", 10),
+		PasswordMinClasses:      getEnvInt("PASSWORD_MIN_CLASSES", 2),
+		PasswordHistory:         getEnvInt("PASSWORD_HISTORY", 5),
+		DeletedRetentionDays:    getEnvInt("DELETED_RETENTION_DAYS", 30),
+		UsersTable:              getEnv("USERS_TABLE", "supportdesk-users"),
+		OrgsTable:               getEnv("ORGS_TABLE", "supportdesk-organizations"),
+		TicketsTable:            getEnv("TICKETS_TABLE", "supportdesk-tickets"),
+		MessagesTable:           getEnv("MESSAGES_TABLE", "supportdesk-messages"),
+		TimeEntriesTable:        getEnv("TIME_ENTRIES_TABLE", "supportdesk-time-entries"),
 		ConversionRequestsTable: getEnv("CONVERSION_REQUESTS_TABLE", "supportdesk-conversion-requests"),
-		InvoicesTable:          getEnv("INVOICES_TABLE", "supportdesk-invoices"),
-		ActivitiesTable:       getEnv("ACTIVITIES_TABLE", "supportdesk-activities"),
+		InvoicesTable:           getEnv("INVOICES_TABLE", "supportdesk-invoices"),
+		ActivitiesTable:         getEnv("ACTIVITIES_TABLE", "supportdesk-activities"),
+		WatchersTable:           getEnv("WATCHERS_TABLE", "supportdesk-ticket-watchers"),
+		UserTokensTable:         getEnv("USER_TOKENS_TABLE", "supportdesk-user-tokens"),
+		MacrosTable:             getEnv("MACROS_TABLE", "supportdesk-macros"),
+		AttachmentsTable:        getEnv("ATTACHMENTS_TABLE", "supportdesk-attachments"),
+		WebhooksTable:           getEnv("WEBHOOKS_TABLE", "supportdesk-webhooks"),
+		WebhookDeliveriesTable:  getEnv("WEBHOOK_DELIVERIES_TABLE", "supportdesk-webhook-deliveries"),
+		OutboxTable:             getEnv("OUTBOX_TABLE", "supportdesk-outbox"),
+		SessionsTable:           getEnv("SESSIONS_TABLE", "supportdesk-sessions"),
+		APITokensTable:          getEnv("API_TOKENS_TABLE", "supportdesk-api-tokens"),
+		RolesTable:              getEnv("ROLES_TABLE", "supportdesk-roles"),
+		LoginAttemptsTable:      getEnv("LOGIN_ATTEMPTS_TABLE", "supportdesk-login-attempts"),
+		PurgeJobsTable:          getEnv("PURGE_JOBS_TABLE", "supportdesk-purge-jobs"),
+		UserEmailsTable:         getEnv("USER_EMAILS_TABLE", "supportdesk-user-emails"),
+		SentTable:               getEnv("SENT_TABLE", "supportdesk-sent"),
+		StreamTable:             getEnv("STREAM_TABLE", "supportdesk-stream"),
 	}
 }
 
+// AppURL returns the first configured frontend origin, used to build links in emails.
+func (c *Config) AppURL() string {
+	u := strings.TrimSpace(strings.Split(c.FrontendURL, ",")[0])
+	if u == "" || u == "*" {
+		return "http://localhost:3000"
+	}
+	return strings.TrimSuffix(u, "/")
+}
+
 func getEnv(key, fallback string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	if val, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return val
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if val, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return val
+	}
+	return fallback
+}
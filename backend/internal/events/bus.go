@@ -0,0 +1,90 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"slices"
+	"sync"
+)
+
+// Handler handles one published event. Handlers run synchronously, in
+// subscription order, and must not block for long. Returning an error has the
+// event delivered to the handler again later, so handlers must tolerate seeing
+// the same event (same ID) more than once.
+type Handler func(ctx context.Context, e Event) error
+
+type subscription struct {
+	name    string
+	names   map[string]bool // nil means every event
+	handler Handler
+}
+
+// Bus dispatches published events to subscribers. A nil *Bus is valid and
+// drops every event.
+type Bus struct {
+	mu   sync.RWMutex
+	subs []subscription
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers h as name for the named events, or for every event if no
+// names are given. The name identifies the subscriber to Publish and must stay
+// the same across restarts.
+func (b *Bus) Subscribe(name string, h Handler, names ...string) {
+	s := subscription{name: name, handler: h}
+	if len(names) > 0 {
+		s.names = map[string]bool{}
+		for _, n := range names {
+			s.names[n] = true
+		}
+	}
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+}
+
+// Publish delivers e to its subscribers, skipping those named in done, and
+// returns the names of the subscribers that handled it along with the errors
+// of those that did not. A failing or panicking subscriber does not stop the
+// others.
+func (b *Bus) Publish(ctx context.Context, e Event, done ...string) ([]string, error) {
+	if b == nil {
+		return nil, nil
+	}
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+
+	var handled []string
+	var errs []error
+	for _, s := range subs {
+		if s.names != nil && !s.names[e.Name()] {
+			continue
+		}
+		if slices.Contains(done, s.name) {
+			continue
+		}
+		if err := b.call(ctx, s.handler, e); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+			continue
+		}
+		handled = append(handled, s.name)
+	}
+	return handled, errors.Join(errs...)
+}
+
+func (b *Bus) call(ctx context.Context, h Handler, e Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("events: subscriber panic on %s: %v\n%s", e.Name(), r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, e)
+}
@@ -0,0 +1,53 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBusPublish(t *testing.T) {
+	var calls []string
+	record := func(name string, err error) Handler {
+		return func(ctx context.Context, e Event) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	bus := NewBus()
+	bus.Subscribe("first", record("first", nil))
+	bus.Subscribe("failing", record("failing", errors.New("mail server down")))
+	bus.Subscribe("panicking", func(ctx context.Context, e Event) error {
+		calls = append(calls, "panicking")
+		panic("nil map")
+	})
+	bus.Subscribe("tickets", record("tickets", nil), NameTicketCreated)
+	bus.Subscribe("last", record("last", nil))
+
+	handled, err := bus.Publish(context.Background(), AccountUnlocked{UserID: "user-jane"})
+	if want := []string{"first", "failing", "panicking", "last"}; !slices.Equal(calls, want) {
+		t.Errorf("called %v, want %v in subscription order", calls, want)
+	}
+	if want := []string{"first", "last"}; !slices.Equal(handled, want) {
+		t.Errorf("handled by %v, want %v", handled, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), "failing: mail server down") || !strings.Contains(err.Error(), "panicking: panic: nil map") {
+		t.Errorf("error %v, want both failures named", err)
+	}
+
+	// Subscribers that already handled the event are skipped.
+	calls = nil
+	if _, err := bus.Publish(context.Background(), AccountUnlocked{UserID: "user-jane"}, "first", "failing", "panicking"); err != nil {
+		t.Errorf("error %v", err)
+	}
+	if want := []string{"last"}; !slices.Equal(calls, want) {
+		t.Errorf("called %v, want %v", calls, want)
+	}
+
+	var nilBus *Bus
+	if handled, err := nilBus.Publish(context.Background(), AccountUnlocked{}); handled != nil || err != nil {
+		t.Errorf("nil bus: %v, %v", handled, err)
+	}
+}
@@ -0,0 +1,228 @@
+// Package events defines the domain events published by handlers and a
+// synchronous bus that fans them out to subscribers (activity log,
+// notifications, webhooks, metrics).
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+)
+
+// Event is a domain event. Name is stable and used for subscriptions,
+// metrics and serialization.
+type Event interface {
+	Name() string
+}
+
+// Names of the events below.
+const (
+	NameTicketCreated        = "ticket.created"
+	NameTicketUpdated        = "ticket.updated"
+	NameStatusChanged        = "ticket.status-changed"
+	NameTicketAssigned       = "ticket.assigned"
+	NameMessageAdded         = "message.added"
+	NameConversionRequested  = "conversion.requested"
+	NameApprovalDecided      = "conversion.decided"
+	NameInvoiceStatusChanged = "invoice.status-changed"
+	NameInvoiceIssued        = "invoice.issued"
+	NameAccountLocked        = "account.locked"
+	NameAccountUnlocked      = "account.unlocked"
+	NameOrgDeleted           = "organization.deleted"
+)
+
+// TicketCreated is published when a ticket is opened from the app or by email.
+type TicketCreated struct {
+	Ticket  *models.Ticket `json:"ticket"`
+	ActorID string         `json:"actorId"`
+	Via     string         `json:"via"` // "web" or "email"
+}
+
+// TicketUpdated is published once per change to a ticket's status, priority,
+// assignee or tags, with the ticket before and after the change. The more
+// specific StatusChanged and TicketAssigned are published alongside it.
+type TicketUpdated struct {
+	Before  *models.Ticket `json:"before"`
+	After   *models.Ticket `json:"after"`
+	ActorID string         `json:"actorId"`
+}
+
+type StatusChanged struct {
+	Ticket    *models.Ticket `json:"ticket"`
+	OldStatus string         `json:"oldStatus"`
+	NewStatus string         `json:"newStatus"`
+	ActorID   string         `json:"actorId"`
+}
+
+type TicketAssigned struct {
+	Ticket     *models.Ticket `json:"ticket"`
+	AssigneeID string         `json:"assigneeId"`
+	ActorID    string         `json:"actorId"`
+}
+
+// MessageAdded is published for replies and internal notes; check Message.IsInternal.
+type MessageAdded struct {
+	Ticket  *models.Ticket  `json:"ticket"`
+	Message *models.Message `json:"message"`
+	Via     string          `json:"via"` // "web", "email" or "macro"
+}
+
+type ConversionRequested struct {
+	Ticket  *models.Ticket            `json:"ticket"`
+	Request *models.ConversionRequest `json:"request"`
+}
+
+// ApprovalDecided is published when either side approves or rejects a conversion request.
+type ApprovalDecided struct {
+	Request        *models.ConversionRequest `json:"request"`
+	OrganizationID string                    `json:"organizationId"`
+	Side           string                    `json:"side"`
+	Status         string                    `json:"status"`
+	ActorID        string                    `json:"actorId"`
+}
+
+type InvoiceStatusChanged struct {
+	Invoice   *models.Invoice `json:"invoice"`
+	OldStatus string          `json:"oldStatus"`
+	ActorID   string          `json:"actorId"`
+}
+
+// InvoiceIssued is published when an invoice is marked as sent.
+type InvoiceIssued struct {
+	Invoice *models.Invoice `json:"invoice"`
+}
+
+// AccountLocked is published when repeated failed sign-ins lock an email
+// address (Email, and UserID if it belongs to a user) or a client IP.
+type AccountLocked struct {
+	UserID   string    `json:"userId,omitempty"`
+	Email    string    `json:"email,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+// AccountUnlocked is published when an admin lifts a lockout.
+type AccountUnlocked struct {
+	UserID  string `json:"userId"`
+	ActorID string `json:"actorId"`
+}
+
+// OrgDeleted is published when an organization is soft-deleted; its members
+// are deleted with it in the background (see subscribers.Members).
+type OrgDeleted struct {
+	OrganizationID string    `json:"organizationId"`
+	DeletedAt      time.Time `json:"deletedAt"`
+	ActorID        string    `json:"actorId"`
+}
+
+func (TicketCreated) Name() string        { return NameTicketCreated }
+func (TicketUpdated) Name() string        { return NameTicketUpdated }
+func (StatusChanged) Name() string        { return NameStatusChanged }
+func (TicketAssigned) Name() string       { return NameTicketAssigned }
+func (MessageAdded) Name() string         { return NameMessageAdded }
+func (ConversionRequested) Name() string  { return NameConversionRequested }
+func (ApprovalDecided) Name() string      { return NameApprovalDecided }
+func (InvoiceStatusChanged) Name() string { return NameInvoiceStatusChanged }
+func (InvoiceIssued) Name() string        { return NameInvoiceIssued }
+func (AccountLocked) Name() string        { return NameAccountLocked }
+func (AccountUnlocked) Name() string      { return NameAccountUnlocked }
+func (OrgDeleted) Name() string           { return NameOrgDeleted }
+
+// TicketChanges returns the events for a ticket going from before to after:
+// StatusChanged and TicketAssigned when those fields changed, then
+// TicketUpdated. It returns nil if nothing changed.
+func TicketChanges(before, after *models.Ticket, actorID string) []Event {
+	var out []Event
+	changed := false
+	if before.Status != after.Status {
+		out = append(out, StatusChanged{Ticket: after, OldStatus: before.Status, NewStatus: after.Status, ActorID: actorID})
+		changed = true
+	}
+	if assignee(after) != assignee(before) {
+		if assignee(after) != "" {
+			out = append(out, TicketAssigned{Ticket: after, AssigneeID: assignee(after), ActorID: actorID})
+		}
+		changed = true
+	}
+	if before.Priority != after.Priority || !slices.Equal(before.Tags, after.Tags) {
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return append(out, TicketUpdated{Before: before, After: after, ActorID: actorID})
+}
+
+func assignee(t *models.Ticket) string {
+	if t.AssignedTo == nil {
+		return ""
+	}
+	return *t.AssignedTo
+}
+
+// Encode serializes e for the outbox.
+func Encode(e Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Decode rebuilds an event serialized with Encode.
+func Decode(name string, payload []byte) (Event, error) {
+	var e Event
+	var err error
+	switch name {
+	case NameTicketCreated:
+		e, err = decodeAs[TicketCreated](payload)
+	case NameTicketUpdated:
+		e, err = decodeAs[TicketUpdated](payload)
+	case NameStatusChanged:
+		e, err = decodeAs[StatusChanged](payload)
+	case NameTicketAssigned:
+		e, err = decodeAs[TicketAssigned](payload)
+	case NameMessageAdded:
+		e, err = decodeAs[MessageAdded](payload)
+	case NameConversionRequested:
+		e, err = decodeAs[ConversionRequested](payload)
+	case NameApprovalDecided:
+		e, err = decodeAs[ApprovalDecided](payload)
+	case NameInvoiceStatusChanged:
+		e, err = decodeAs[InvoiceStatusChanged](payload)
+	case NameInvoiceIssued:
+		e, err = decodeAs[InvoiceIssued](payload)
+	case NameAccountLocked:
+		e, err = decodeAs[AccountLocked](payload)
+	case NameAccountUnlocked:
+		e, err = decodeAs[AccountUnlocked](payload)
+	case NameOrgDeleted:
+		e, err = decodeAs[OrgDeleted](payload)
+	default:
+		return nil, fmt.Errorf("unknown event %q", name)
+	}
+	return e, err
+}
+
+func decodeAs[T Event](payload []byte) (Event, error) {
+	var v T
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+type idKey struct{}
+
+// WithID attaches the ID of the outbox message being dispatched to ctx.
+// Subscribers use it to make their side effects idempotent under redelivery.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// ID returns the outbox message ID set by WithID, or "".
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
@@ -0,0 +1,54 @@
+package events
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics counts published events by name. Subscribe it with
+// bus.Subscribe("metrics", m.Handle).
+type Metrics struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	last   map[string]time.Time
+	since  time.Time
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{counts: map[string]int64{}, last: map[string]time.Time{}, since: time.Now().UTC()}
+}
+
+func (m *Metrics) Handle(_ context.Context, e Event) error {
+	m.mu.Lock()
+	m.counts[e.Name()]++
+	m.last[e.Name()] = time.Now().UTC()
+	m.mu.Unlock()
+	return nil
+}
+
+// EventCount is one row of a metrics snapshot.
+type EventCount struct {
+	Name       string    `json:"name"`
+	Count      int64     `json:"count"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+// MetricsSnapshot is the JSON view of Metrics. Counts are per process and
+// reset on restart.
+type MetricsSnapshot struct {
+	Since  time.Time    `json:"since"`
+	Events []EventCount `json:"events"`
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := MetricsSnapshot{Since: m.since, Events: []EventCount{}}
+	for name, n := range m.counts {
+		s.Events = append(s.Events, EventCount{Name: name, Count: n, LastSeenAt: m.last[name]})
+	}
+	sort.Slice(s.Events, func(i, j int) bool { return s.Events[i].Name < s.Events[j].Name })
+	return s
+}
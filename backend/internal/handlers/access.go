@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/policy"
+)
+
+// canAccessOrg reports whether the caller may act on records belonging to
+// organization orgID: staff work across organizations, clients only within
+// their own (and nowhere if they have none).
+func canAccessOrg(ctx context.Context, orgID string) bool {
+	if middleware.HasPermission(ctx, policy.AnyOrganization) {
+		return true
+	}
+	own := middleware.GetOrgID(ctx)
+	return own != "" && own == orgID
+}
+
+// orgFilter returns the organization a list must be limited to, or "" when
+// the caller sees every organization. ok is false for callers who can see
+// none, such as clients without an organization.
+func orgFilter(ctx context.Context) (orgID string, ok bool) {
+	if middleware.HasPermission(ctx, policy.AnyOrganization) {
+		return "", true
+	}
+	own := middleware.GetOrgID(ctx)
+	return own, own != ""
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+const (
+	defaultAPITokenDays = 90
+	maxAPITokenDays     = 365
+)
+
+// APITokenHandler manages API tokens: users manage their own under
+// /api/auth/tokens, admins manage service accounts' under /api/users/{id}/tokens.
+type APITokenHandler struct {
+	Store store.Store
+}
+
+func (h *APITokenHandler) ListMine(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, middleware.GetUserID(r.Context()))
+}
+
+func (h *APITokenHandler) CreateMine(w http.ResponseWriter, r *http.Request) {
+	user, err := h.Store.GetUser(r.Context(), middleware.GetUserID(r.Context()))
+	if err != nil || user == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	h.create(w, r, user)
+}
+
+func (h *APITokenHandler) RevokeMine(w http.ResponseWriter, r *http.Request) {
+	h.revoke(w, r, middleware.GetUserID(r.Context()), r.PathValue("id"))
+}
+
+// List returns any user's tokens, so admins can audit and revoke them.
+func (h *APITokenHandler) List(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, r.PathValue("id"))
+}
+
+// Create issues a token for a service account. Tokens for people are only
+// created by the people themselves.
+func (h *APITokenHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user, err := h.Store.GetUser(r.Context(), r.PathValue("id"))
+	if err != nil || user == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if !user.ServiceAccount {
+		writeError(w, http.StatusBadRequest, "tokens can only be created for service accounts")
+		return
+	}
+	h.create(w, r, user)
+}
+
+func (h *APITokenHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	h.revoke(w, r, r.PathValue("id"), r.PathValue("tokenId"))
+}
+
+func (h *APITokenHandler) list(w http.ResponseWriter, r *http.Request, userID string) {
+	tokens, err := h.Store.ListAPITokens(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list API tokens")
+		return
+	}
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+func (h *APITokenHandler) create(w http.ResponseWriter, r *http.Request, user *models.User) {
+	var req models.CreateAPITokenRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeError(w, http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+	for _, s := range req.Scopes {
+		if !slices.Contains(middleware.Scopes, s) {
+			writeError(w, http.StatusBadRequest, "unknown scope: "+s)
+			return
+		}
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPITokenDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > maxAPITokenDays {
+		writeError(w, http.StatusBadRequest, "expiresInDays must be between 1 and 365")
+		return
+	}
+
+	secret, _ := newUserToken()
+	now := time.Now().UTC()
+	t := models.APIToken{
+		ID:           "tok-" + uuid.NewString()[:8],
+		UserID:       user.ID,
+		Name:         req.Name,
+		SecretHash:   middleware.HashAPITokenSecret(secret),
+		Scopes:       slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		TokenVersion: user.TokenVersion,
+		CreatedBy:    middleware.GetUserID(r.Context()),
+		CreatedAt:    now,
+		ExpiresAt:    now.AddDate(0, 0, req.ExpiresInDays),
+	}
+	if err := h.Store.CreateAPIToken(r.Context(), &t); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create API token")
+		return
+	}
+	log.Printf("API token %s created for user %s by %s", t.ID, user.ID, t.CreatedBy)
+	writeJSON(w, http.StatusCreated, models.CreatedAPIToken{APIToken: t, Token: middleware.FormatAPIToken(t.ID, secret)})
+}
+
+func (h *APITokenHandler) revoke(w http.ResponseWriter, r *http.Request, userID, tokenID string) {
+	t, err := h.Store.GetAPIToken(r.Context(), tokenID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load API token")
+		return
+	}
+	if t == nil || t.UserID != userID {
+		writeError(w, http.StatusNotFound, "API token not found")
+		return
+	}
+	if err := h.Store.RevokeAPIToken(r.Context(), t.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to revoke API token")
+		return
+	}
+	log.Printf("API token %s revoked by %s", t.ID, middleware.GetUserID(r.Context()))
+	writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
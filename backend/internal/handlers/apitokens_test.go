@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+// callWithToken sends a request with bearer token through Auth and
+// RequireScope(scope) and returns the status code.
+func callWithToken(st *storetest.Memory, token, scope string) int {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := middleware.Auth(testSecret, st, st)(middleware.RequireScope(scope)(ok))
+	r := httptest.NewRequest("GET", "/api/tickets", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec.Code
+}
+
+func TestCreateAPIToken(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		body     string
+		wantCode int
+		wantDays int
+	}{
+		{name: "defaults to 90 days", body: `{"name":"ci","scopes":["tickets:read"]}`, wantCode: http.StatusCreated, wantDays: 90},
+		{name: "longest expiry", body: `{"name":"ci","scopes":["tickets:read","tickets:write"],"expiresInDays":365}`, wantCode: http.StatusCreated, wantDays: 365},
+		{name: "expiry too long", body: `{"name":"ci","scopes":["tickets:read"],"expiresInDays":366}`, wantCode: http.StatusBadRequest},
+		{name: "negative expiry", body: `{"name":"ci","scopes":["tickets:read"],"expiresInDays":-1}`, wantCode: http.StatusBadRequest},
+		{name: "no name", body: `{"name":" ","scopes":["tickets:read"]}`, wantCode: http.StatusBadRequest},
+		{name: "no scopes", body: `{"name":"ci","scopes":[]}`, wantCode: http.StatusBadRequest},
+		{name: "unknown scope", body: `{"name":"ci","scopes":["tickets:delete"]}`, wantCode: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, st := newAuthTest(t)
+			h := &APITokenHandler{Store: st}
+			rec := call(h.CreateMine, asUser("POST", "/api/auth/tokens", tc.body, "user-jane"))
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.wantCode, rec.Body)
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+			var created models.CreatedAPIToken
+			json.NewDecoder(rec.Body).Decode(&created)
+			if !strings.HasPrefix(created.Token, middleware.APITokenPrefix+created.ID+"_") {
+				t.Errorf("token %q does not name %s", created.Token, created.ID)
+			}
+			stored, _ := st.GetAPIToken(context.Background(), created.ID)
+			if stored == nil || stored.SecretHash == "" || strings.Contains(created.Token, stored.SecretHash) {
+				t.Errorf("stored token %+v, want only the secret's hash", stored)
+			}
+			if days := stored.ExpiresAt.Sub(stored.CreatedAt).Hours() / 24; int(days) != tc.wantDays {
+				t.Errorf("expires after %.0f days, want %d", days, tc.wantDays)
+			}
+		})
+	}
+}
+
+func TestAPITokenAuth(t *testing.T) {
+	const secret = "s3cret"
+	past := time.Now().Add(-time.Hour)
+
+	for _, tc := range []struct {
+		name     string
+		token    func(*models.APIToken)
+		user     func(*models.User)
+		bearer   string // if not the token's own
+		scope    string
+		wantCode int
+	}{
+		{name: "granted scope", scope: "tickets:read", wantCode: http.StatusOK},
+		{name: "missing scope", scope: "tickets:write", wantCode: http.StatusForbidden},
+		{name: "expired", token: func(t *models.APIToken) { t.ExpiresAt = past }, scope: "tickets:read", wantCode: http.StatusUnauthorized},
+		{name: "revoked", token: func(t *models.APIToken) { t.RevokedAt = &past }, scope: "tickets:read", wantCode: http.StatusUnauthorized},
+		{name: "wrong secret", bearer: middleware.FormatAPIToken("tok-1", "guess"), scope: "tickets:read", wantCode: http.StatusUnauthorized},
+		{name: "unknown token", bearer: middleware.FormatAPIToken("tok-2", secret), scope: "tickets:read", wantCode: http.StatusUnauthorized},
+		{name: "malformed", bearer: middleware.APITokenPrefix + "tok-1", scope: "tickets:read", wantCode: http.StatusUnauthorized},
+		{name: "password changed since", user: func(u *models.User) { u.TokenVersion = 1 }, scope: "tickets:read", wantCode: http.StatusUnauthorized},
+		{name: "user deleted", user: func(u *models.User) { u.DeletedAt = &past }, scope: "tickets:read", wantCode: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var setup []func(*models.User)
+			if tc.user != nil {
+				setup = append(setup, tc.user)
+			}
+			_, st := newAuthTest(t, setup...)
+			tok := &models.APIToken{
+				ID: "tok-1", UserID: "user-jane", Name: "ci", SecretHash: middleware.HashAPITokenSecret(secret),
+				Scopes: []string{"tickets:read"}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(24 * time.Hour),
+			}
+			if tc.token != nil {
+				tc.token(tok)
+			}
+			st.CreateAPIToken(context.Background(), tok)
+
+			bearer := tc.bearer
+			if bearer == "" {
+				bearer = middleware.FormatAPIToken(tok.ID, secret)
+			}
+			if code := callWithToken(st, bearer, tc.scope); code != tc.wantCode {
+				t.Fatalf("status %d, want %d", code, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestRevokeAPIToken(t *testing.T) {
+	_, st := newAuthTest(t)
+	st.CreateUser(context.Background(), newUser("user-joe", policy.SupportStaff))
+	h := &APITokenHandler{Store: st}
+
+	rec := call(h.CreateMine, asUser("POST", "/api/auth/tokens", `{"name":"ci","scopes":["tickets:read"]}`, "user-jane"))
+	var created models.CreatedAPIToken
+	json.NewDecoder(rec.Body).Decode(&created)
+	if code := callWithToken(st, created.Token, "tickets:read"); code != http.StatusOK {
+		t.Fatalf("new token: status %d", code)
+	}
+
+	// Someone else's token looks like one that does not exist.
+	revoke := func(userID string) int {
+		return call(h.RevokeMine, asUser("DELETE", "/api/auth/tokens/"+created.ID, "", userID), "id", created.ID).Code
+	}
+	if code := revoke("user-joe"); code != http.StatusNotFound {
+		t.Fatalf("revoke by another user: status %d, want 404", code)
+	}
+	if code := callWithToken(st, created.Token, "tickets:read"); code != http.StatusOK {
+		t.Fatalf("after failed revoke: status %d", code)
+	}
+
+	if code := revoke("user-jane"); code != http.StatusOK {
+		t.Fatalf("revoke: status %d", code)
+	}
+	if code := callWithToken(st, created.Token, "tickets:read"); code != http.StatusUnauthorized {
+		t.Fatalf("revoked token: status %d, want 401", code)
+	}
+	if code := revoke("user-jane"); code != http.StatusOK {
+		t.Fatalf("second revoke: status %d, want 200", code)
+	}
+}
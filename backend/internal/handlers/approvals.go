@@ -1,23 +1,28 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
-	"time"
 
-	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/events"
 	"github.com/supporttickr/backend/internal/middleware"
 	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/policy"
 	"github.com/supporttickr/backend/internal/store"
 )
 
 type ApprovalHandler struct {
-	Store store.Store
+	Store  store.Store
+	Outbox *outbox.Relay
 }
 
 func (h *ApprovalHandler) List(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetRole(r.Context())
-	orgID := middleware.GetOrgID(r.Context())
+	own, ok := orgFilter(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusOK, []models.ConversionRequest{})
+		return
+	}
 
 	list, err := h.Store.ListConversionRequestsPending(r.Context())
 	if err != nil {
@@ -27,9 +32,9 @@ func (h *ApprovalHandler) List(w http.ResponseWriter, r *http.Request) {
 
 	var approvals []models.ConversionRequest
 	for _, cr := range list {
-		if role == "client" && orgID != "" {
+		if own != "" {
 			t, _ := h.Store.GetTicket(r.Context(), cr.TicketID)
-			if t == nil || t.OrganizationID != orgID {
+			if t == nil || t.OrganizationID != own {
 				continue
 			}
 		}
@@ -45,7 +50,6 @@ func (h *ApprovalHandler) List(w http.ResponseWriter, r *http.Request) {
 func (h *ApprovalHandler) Update(w http.ResponseWriter, r *http.Request) {
 	crID := r.PathValue("id")
 	userID := middleware.GetUserID(r.Context())
-	role := middleware.GetRole(r.Context())
 
 	var req models.UpdateApprovalRequest
 	if err := decodeJSON(r, &req); err != nil {
@@ -62,45 +66,64 @@ func (h *ApprovalHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Side == "internal" && role == "client" {
-		writeError(w, http.StatusForbidden, "clients cannot approve internal side")
+	if req.Side == "internal" && !middleware.HasPermission(r.Context(), policy.ApprovalDecideInternal) {
+		writeError(w, http.StatusForbidden, "you cannot decide the internal side")
 		return
 	}
-	if req.Side == "client" && role != "client" && role != "admin" {
-		writeError(w, http.StatusForbidden, "only clients or admins can approve client side")
+	if req.Side == "client" && !middleware.HasPermission(r.Context(), policy.ApprovalDecideClient) {
+		writeError(w, http.StatusForbidden, "you cannot decide the client side")
 		return
 	}
 
-	var internalApproval, clientApproval *string
-	if req.Side == "internal" {
-		internalApproval = &req.Status
-	} else {
-		clientApproval = &req.Status
-	}
-
-	if err := h.Store.UpdateConversionRequest(r.Context(), crID, internalApproval, clientApproval); err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to update approval")
-		return
-	}
+	// A decision made at the same time as the other side's is retried on the
+	// fresh request, so the one completing the approval converts the ticket.
+	var msgs []models.OutboxMessage
+	for attempt := 0; ; attempt++ {
+		cr, err := h.Store.GetConversionByID(r.Context(), crID)
+		if err != nil || cr == nil {
+			writeError(w, http.StatusNotFound, "approval not found")
+			return
+		}
+		t, err := h.Store.GetTicket(r.Context(), cr.TicketID)
+		if err != nil || t == nil {
+			writeError(w, http.StatusNotFound, "ticket not found")
+			return
+		}
+		if !canAccessOrg(r.Context(), t.OrganizationID) {
+			writeError(w, http.StatusForbidden, "access denied")
+			return
+		}
 
-	cr, err := h.Store.GetConversionByID(r.Context(), crID)
-	if err != nil || cr == nil {
-		writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
-		return
-	}
+		after := *cr
+		var internalApproval, clientApproval *string
+		if req.Side == "internal" {
+			internalApproval = &req.Status
+			after.InternalApproval = req.Status
+		} else {
+			clientApproval = &req.Status
+			after.ClientApproval = req.Status
+		}
+		var category *string
+		if after.InternalApproval == "approved" && after.ClientApproval == "approved" {
+			category = &after.ProposedType
+		}
 
-	if cr.InternalApproval == "approved" && cr.ClientApproval == "approved" {
-		_ = h.Store.UpdateTicketCategory(r.Context(), cr.TicketID, cr.ProposedType)
+		msgs = outbox.Messages(events.ApprovalDecided{Request: &after, Side: req.Side, Status: req.Status, ActorID: userID, OrganizationID: t.OrganizationID})
+		err = h.Store.UpdateConversionRequest(r.Context(), cr, internalApproval, clientApproval, category, msgs...)
+		if errors.Is(err, store.ErrStale) {
+			if attempt < 2 {
+				continue
+			}
+			writeError(w, http.StatusConflict, "approval changed meanwhile, try again")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to update approval")
+			return
+		}
+		break
 	}
-
-	_ = h.Store.CreateActivity(r.Context(), &models.ActivityItem{
-		ID:          "act-" + uuid.NewString()[:8],
-		Type:        "conversion-approved",
-		Description: fmt.Sprintf("%s %s conversion for %s", req.Side, req.Status, cr.TicketID),
-		UserID:      userID,
-		TicketID:    &cr.TicketID,
-		CreatedAt:   time.Now().UTC(),
-	})
+	h.Outbox.Flush(r.Context(), msgs)
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
 }
@@ -1,19 +1,54 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/supporttickr/backend/internal/middleware"
 	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/notify"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/password"
+	"github.com/supporttickr/backend/internal/sso"
 	"github.com/supporttickr/backend/internal/store"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// resetTokenTTL is how long a password reset link stays valid.
+const resetTokenTTL = time.Hour
+
+// Access tokens are short-lived and cannot be revoked individually; clients
+// renew them with the refresh token of their session, which can.
+const (
+	accessTokenTTL = 15 * time.Minute
+	sessionTTL     = 30 * 24 * time.Hour
+)
+
 type AuthHandler struct {
 	Store     store.Store
 	JWTSecret string
+	Notifier  *notify.Notifier
+	Outbox    *outbox.Relay
+	Passwords password.Policy
+
+	// Single sign-on. SSOCallbackURL is this API's callback as registered
+	// with identity providers; AppURL is where the browser returns after it.
+	SSO            *sso.Client
+	SSOCallbackURL string
+	AppURL         string
+
+	// TrustProxy takes the client address from X-Forwarded-For (see clientIP).
+	TrustProxy bool
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -23,22 +58,225 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Email == "" || req.Password == "" {
+	if req.Email == "" {
 		writeError(w, http.StatusBadRequest, "email and password are required")
 		return
 	}
 
+	// Domains with single sign-on never use passwords; an email alone is
+	// enough to find out where to sign in.
+	org, err := h.ssoOrgForEmail(r.Context(), req.Email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to look up sign-in method")
+		return
+	}
+	if org != nil {
+		writeJSON(w, http.StatusOK, models.SSORedirect{SSORequired: true, LoginURL: ssoLoginURL(org.ID, req.Email)})
+		return
+	}
+	if req.Password == "" {
+		writeError(w, http.StatusBadRequest, "email and password are required")
+		return
+	}
+	if h.loginThrottled(w, r, req.Email) {
+		return
+	}
+
 	user, err := h.Store.GetUserByEmail(r.Context(), req.Email)
-	if err != nil || user == nil {
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+	if user == nil || user.ServiceAccount || user.Invitation != nil || user.DeletedAt != nil {
+		h.loginFailed(r, req.Email, nil)
 		writeError(w, http.StatusUnauthorized, "invalid email or password")
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		h.loginFailed(r, req.Email, user)
 		writeError(w, http.StatusUnauthorized, "invalid email or password")
 		return
 	}
+	if err := h.Store.ClearLoginAttempts(r.Context(), emailAttemptKey(req.Email)); err != nil {
+		log.Printf("Login: clear attempts for %s: %v", user.ID, err)
+	}
+
+	// A password set by an administrator only earns a token for choosing
+	// a new one (see SetPassword).
+	if user.PasswordChangeRequired {
+		challenge, err := h.issueChallenge(user, challengeSetPassword)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to generate token")
+			return
+		}
+		writeJSON(w, http.StatusOK, models.PasswordChangeChallenge{PasswordChangeRequired: true, Token: challenge})
+		return
+	}
+	h.finishLogin(w, r, user)
+}
+
+// finishLogin answers a login whose password checked out with a two-factor
+// challenge or a new session.
+func (h *AuthHandler) finishLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	// With two-factor enabled or required, the password only earns a
+	// challenge token for the second step (see VerifyTwoFactor).
+	required, err := h.twoFactorRequired(r.Context(), user)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load organization")
+		return
+	}
+	if user.TwoFactorEnabled() || required {
+		challenge, err := h.issueChallenge(user, challengeTwoFactor)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to generate token")
+			return
+		}
+		writeJSON(w, http.StatusOK, models.TwoFactorChallenge{
+			TwoFactorRequired: true,
+			SetupRequired:     !user.TwoFactorEnabled(),
+			Token:             challenge,
+		})
+		return
+	}
+
+	resp, err := h.startSession(r, user)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// startSession creates a session for user and returns its first access and
+// refresh tokens.
+func (h *AuthHandler) startSession(r *http.Request, user *models.User) (*models.LoginResponse, error) {
+	secret, secretHash := newUserToken()
+	now := time.Now().UTC()
+	sess := &models.Session{
+		ID:           "ses-" + uuid.NewString()[:8],
+		UserID:       user.ID,
+		TokenHash:    secretHash,
+		TokenVersion: user.TokenVersion,
+		UserAgent:    r.UserAgent(),
+		IP:           h.clientIP(r),
+		CreatedAt:    now,
+		LastUsedAt:   now,
+		ExpiresAt:    now.Add(sessionTTL),
+	}
+	if err := h.Store.CreateSession(r.Context(), sess); err != nil {
+		return nil, err
+	}
+	return h.sessionResponse(user, sess.ID, secret)
+}
+
+func (h *AuthHandler) sessionResponse(user *models.User, sessionID, secret string) (*models.LoginResponse, error) {
+	expiresAt := time.Now().Add(accessTokenTTL)
+	tokenStr, err := h.issueToken(user, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &models.LoginResponse{
+		Token:        tokenStr,
+		RefreshToken: sessionID + "." + secret,
+		ExpiresAt:    expiresAt.UTC(),
+		User:         user.ToResponse(),
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Each refresh token works once: presenting one that was already
+// rotated means it was copied, so the whole session is revoked.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	sessionID, secret, ok := strings.Cut(req.RefreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		writeError(w, http.StatusUnauthorized, "invalid or expired refresh token")
+		return
+	}
+
+	sess, err := h.Store.GetSession(r.Context(), sessionID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load session")
+		return
+	}
+	if sess == nil || sess.RevokedAt != nil || time.Now().After(sess.ExpiresAt) {
+		writeError(w, http.StatusUnauthorized, "invalid or expired refresh token")
+		return
+	}
+	presented := hashToken(secret)
+	if sess.PreviousHash != "" && presented == sess.PreviousHash {
+		log.Printf("Refresh: reuse of rotated token for session %s, revoking", sess.ID)
+		_ = h.Store.RevokeSession(r.Context(), sess.ID)
+		writeError(w, http.StatusUnauthorized, "invalid or expired refresh token")
+		return
+	}
+	if presented != sess.TokenHash {
+		writeError(w, http.StatusUnauthorized, "invalid or expired refresh token")
+		return
+	}
+
+	// Deleting the user, changing the password or revoking all sessions
+	// invalidates the session through the user's token version.
+	user, err := h.Store.GetUser(r.Context(), sess.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+	if user == nil || user.TokenVersion != sess.TokenVersion {
+		writeError(w, http.StatusUnauthorized, "invalid or expired refresh token")
+		return
+	}
+
+	newSecret, newHash := newUserToken()
+	if err := h.Store.RotateSession(r.Context(), sess.ID, presented, newHash); err != nil {
+		if errors.Is(err, store.ErrTokenUsed) {
+			// Lost a race with another refresh of the same token.
+			writeError(w, http.StatusUnauthorized, "invalid or expired refresh token")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to refresh session")
+		return
+	}
+	resp, err := h.sessionResponse(user, sess.ID, newSecret)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
 
+// Logout revokes the session of the given refresh token. The access token
+// stays valid until it expires, so clients discard it themselves.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	sessionID, secret, ok := strings.Cut(req.RefreshToken, ".")
+	if ok && sessionID != "" {
+		sess, err := h.Store.GetSession(r.Context(), sessionID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load session")
+			return
+		}
+		// Only the holder of the current refresh token may end the session.
+		if sess != nil && hashToken(secret) == sess.TokenHash {
+			if err := h.Store.RevokeSession(r.Context(), sess.ID); err != nil {
+				writeError(w, http.StatusInternalServerError, "failed to revoke session")
+				return
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "logged out"})
+}
+
+func (h *AuthHandler) issueToken(user *models.User, expiresAt time.Time) (string, error) {
 	orgID := ""
 	if user.OrganizationID != nil {
 		orgID = *user.OrganizationID
@@ -48,23 +286,15 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		UserID:         user.ID,
 		Role:           user.Role,
 		OrganizationID: orgID,
+		TokenVersion:   user.TokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenStr, err := token.SignedString([]byte(h.JWTSecret))
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to generate token")
-		return
-	}
-
-	writeJSON(w, http.StatusOK, models.LoginResponse{
-		Token: tokenStr,
-		User:  user.ToResponse(),
-	})
+	return token.SignedString([]byte(h.JWTSecret))
 }
 
 func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
@@ -76,7 +306,11 @@ func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, user.ToResponse())
+	resp := user.ToResponse()
+	for _, p := range middleware.GetPermissions(r.Context()) {
+		resp.Permissions = append(resp.Permissions, string(p))
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
@@ -95,31 +329,37 @@ func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "current password and new password are required")
 		return
 	}
-	if len(req.NewPassword) < 6 {
-		writeError(w, http.StatusBadRequest, "new password must be at least 6 characters")
-		return
-	}
 
 	user, err := h.Store.GetUser(r.Context(), userID)
 	if err != nil || user == nil {
 		writeError(w, http.StatusNotFound, "user not found")
 		return
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
-		writeError(w, http.StatusUnauthorized, "current password is incorrect")
+	if !h.passwordConfirmed(w, r, user, req.CurrentPassword, "current password is incorrect") {
 		return
 	}
-
-	newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to hash password")
+	if msg := checkNewPassword(h.Passwords, user, req.NewPassword); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
 		return
 	}
-	if err := h.Store.UpdatePassword(r.Context(), userID, string(newHash)); err != nil {
+	if err := storePassword(r.Context(), h.Store, h.Passwords, user, req.NewPassword, ""); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to update password")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+
+	// Changing the password revokes every existing session, including this one,
+	// so start a fresh one for the caller.
+	resp, err := h.startSession(r, user)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":       "updated",
+		"token":        resp.Token,
+		"refreshToken": resp.RefreshToken,
+		"expiresAt":    resp.ExpiresAt,
+	})
 }
 
 func (h *AuthHandler) UpdateMyProfile(w http.ResponseWriter, r *http.Request) {
@@ -167,8 +407,102 @@ func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Always return success to avoid email enumeration. In production, integrate with SES
-	// to send reset link. For now we just acknowledge the request.
-	_ = req.Email
+	// Always return success to avoid email enumeration; failures are only logged.
+	if user, err := h.Store.GetUserByEmail(r.Context(), req.Email); err != nil {
+		log.Printf("ForgotPassword: lookup %s: %v", req.Email, err)
+	} else if user != nil && !user.ServiceAccount && user.Invitation == nil && user.DeletedAt == nil {
+		token, tokenHash := newUserToken()
+		now := time.Now().UTC()
+		err := h.Store.CreateUserToken(r.Context(), &models.UserToken{
+			TokenHash: tokenHash,
+			UserID:    user.ID,
+			Purpose:   "password-reset",
+			ExpiresAt: now.Add(resetTokenTTL),
+			CreatedAt: now,
+		})
+		if err != nil {
+			log.Printf("ForgotPassword: create token for %s: %v", user.ID, err)
+		} else if err := h.Notifier.PasswordReset(r.Context(), user, token, resetTokenTTL); err != nil {
+			log.Printf("ForgotPassword: email %s: %v", user.ID, err)
+		}
+	}
 	writeJSON(w, http.StatusOK, map[string]string{"message": "If an account exists with that email, you will receive password reset instructions shortly."})
 }
+
+// ResetPassword sets a new password using a token from ForgotPassword. The token
+// is single-use, and the password change revokes all existing sessions.
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ResetPasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Token == "" || req.NewPassword == "" {
+		writeError(w, http.StatusBadRequest, "token and new password are required")
+		return
+	}
+
+	tokenHash := hashToken(req.Token)
+	t, err := h.Store.GetUserToken(r.Context(), tokenHash)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to verify token")
+		return
+	}
+	if t == nil || t.Purpose != "password-reset" || t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+		writeError(w, http.StatusBadRequest, "invalid or expired reset token")
+		return
+	}
+	user, err := h.Store.GetUser(r.Context(), t.UserID)
+	if err != nil || user == nil || user.DeletedAt != nil {
+		writeError(w, http.StatusBadRequest, "invalid or expired reset token")
+		return
+	}
+	if msg := checkNewPassword(h.Passwords, user, req.NewPassword); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	// The token is consumed with the new password, so a failed write leaves
+	// it usable and a token used by a concurrent request changes nothing.
+	if err := storePassword(r.Context(), h.Store, h.Passwords, user, req.NewPassword, tokenHash); err != nil {
+		if errors.Is(err, store.ErrTokenUsed) {
+			writeError(w, http.StatusBadRequest, "invalid or expired reset token")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to update password")
+		return
+	}
+	// Proving access to the mailbox lifts a lockout.
+	if err := h.Store.ClearLoginAttempts(r.Context(), emailAttemptKey(user.Email)); err != nil {
+		log.Printf("ResetPassword: clear attempts for %s: %v", user.ID, err)
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+}
+
+// newUserToken returns a random URL-safe token and the hash to store for it.
+func newUserToken() (token, tokenHash string) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token)
+}
+
+// clientIP returns the caller's address. With TrustProxy it is the last
+// X-Forwarded-For entry, the one the proxy added; earlier entries come from
+// the client and can be forged. Without a proxy the header is ignored, since
+// clients could set it to anything.
+func (h *AuthHandler) clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); h.TrustProxy && fwd != "" {
+		hops := strings.Split(fwd, ",")
+		return strings.TrimSpace(hops[len(hops)-1])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/supporttickr/backend/internal/chat"
+)
+
+// maxChatCommandSize bounds the form body of a slash command.
+const maxChatCommandSize = 64 << 10
+
+// ChatHandler receives slash commands from a Slack-compatible workspace.
+type ChatHandler struct {
+	Commands      *chat.Commands
+	SigningSecret string
+}
+
+// Command answers a slash command. The request is form-encoded and signed
+// with the workspace signing secret.
+func (h *ChatHandler) Command(w http.ResponseWriter, r *http.Request) {
+	if h.SigningSecret == "" {
+		writeError(w, http.StatusNotFound, "chat commands are not configured")
+		return
+	}
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxChatCommandSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "request too large")
+		return
+	}
+	if err := chat.Verify(h.SigningSecret, r.Header, body, time.Now()); err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid form body")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.Commands.Run(r.Context(), chat.Command{
+		Command:  form.Get("command"),
+		Text:     form.Get("text"),
+		UserName: form.Get("user_name"),
+	}))
+}
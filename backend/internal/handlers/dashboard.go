@@ -3,22 +3,21 @@ package handlers
 import (
 	"net/http"
 
-	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/events"
 	"github.com/supporttickr/backend/internal/models"
 	"github.com/supporttickr/backend/internal/store"
 )
 
 type DashboardHandler struct {
-	Store store.Store
+	Store   store.Store
+	Metrics *events.Metrics
 }
 
 func (h *DashboardHandler) Stats(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetRole(r.Context())
-	orgID := middleware.GetOrgID(r.Context())
-
-	organizationID := ""
-	if role == "client" && orgID != "" {
-		organizationID = orgID
+	organizationID, ok := orgFilter(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusOK, models.DashboardStats{AvgResponseTime: "2.4h"})
+		return
 	}
 
 	tickets, err := h.Store.ListTickets(r.Context(), "", "", "", organizationID, "", "")
@@ -52,14 +51,14 @@ func (h *DashboardHandler) Stats(w http.ResponseWriter, r *http.Request) {
 		stats.TotalHours += t.HoursWorked
 	}
 
-	if role != "client" {
+	if organizationID == "" {
 		pending, _ := h.Store.ListConversionRequestsPending(r.Context())
 		stats.PendingApproval = len(pending)
-	} else if orgID != "" {
+	} else {
 		pending, _ := h.Store.ListConversionRequestsPending(r.Context())
 		for _, cr := range pending {
 			t, _ := h.Store.GetTicket(r.Context(), cr.TicketID)
-			if t != nil && t.OrganizationID == orgID && cr.ClientApproval == "pending" {
+			if t != nil && t.OrganizationID == organizationID && cr.ClientApproval == "pending" {
 				stats.PendingApproval++
 			}
 		}
@@ -69,8 +68,11 @@ func (h *DashboardHandler) Stats(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *DashboardHandler) Activities(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetRole(r.Context())
-	orgID := middleware.GetOrgID(r.Context())
+	own, ok := orgFilter(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusOK, []models.ActivityResponse{})
+		return
+	}
 
 	list, err := h.Store.ListActivities(r.Context(), 50)
 	if err != nil {
@@ -80,9 +82,13 @@ func (h *DashboardHandler) Activities(w http.ResponseWriter, r *http.Request) {
 
 	var activities []models.ActivityResponse
 	for _, a := range list {
-		if role == "client" && orgID != "" && a.TicketID != nil {
+		// Clients only see activity on their own organization's tickets.
+		if own != "" {
+			if a.TicketID == nil {
+				continue
+			}
 			t, _ := h.Store.GetTicket(r.Context(), *a.TicketID)
-			if t == nil || t.OrganizationID != orgID {
+			if t == nil || t.OrganizationID != own {
 				continue
 			}
 		}
@@ -94,3 +100,12 @@ func (h *DashboardHandler) Activities(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, activities)
 }
+
+// EventMetrics returns per-event publish counts for this API instance. Admin only.
+func (h *DashboardHandler) EventMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.Metrics == nil {
+		writeJSON(w, http.StatusOK, events.NewMetrics().Snapshot())
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Metrics.Snapshot())
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// emailChangeTTL is how long the link confirming a new address stays valid.
+const emailChangeTTL = 24 * time.Hour
+
+// RequestEmailChange emails a confirmation link to the new address the
+// current user asks for; VerifyEmail applies the change. Users without a
+// password (single sign-on) have their address managed by an admin.
+func (h *AuthHandler) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req models.EmailChangeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.NewEmail = store.NormalizeEmail(req.NewEmail)
+	if !strings.Contains(req.NewEmail, "@") || req.CurrentPassword == "" {
+		writeError(w, http.StatusBadRequest, "a valid new email and your current password are required")
+		return
+	}
+
+	user, err := h.Store.GetUser(r.Context(), middleware.GetUserID(r.Context()))
+	if err != nil || user == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if user.PasswordHash == "" {
+		writeError(w, http.StatusBadRequest, "ask an administrator to change your email address")
+		return
+	}
+	if !h.passwordConfirmed(w, r, user, req.CurrentPassword, "current password is incorrect") {
+		return
+	}
+	if req.NewEmail == user.Email {
+		writeError(w, http.StatusBadRequest, "this is already your email address")
+		return
+	}
+	if existing, err := h.Store.GetUserByEmail(r.Context(), req.NewEmail); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to look up email")
+		return
+	} else if existing != nil {
+		writeError(w, http.StatusConflict, "a user with this email already exists")
+		return
+	}
+
+	token, tokenHash := newUserToken()
+	now := time.Now().UTC()
+	if err := h.Store.CreateUserToken(r.Context(), &models.UserToken{
+		TokenHash: tokenHash,
+		UserID:    user.ID,
+		Purpose:   "email-change",
+		NewEmail:  req.NewEmail,
+		ExpiresAt: now.Add(emailChangeTTL),
+		CreatedAt: now,
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create verification link")
+		return
+	}
+	if err := h.Notifier.EmailChange(r.Context(), user, req.NewEmail, token, emailChangeTTL); err != nil {
+		log.Printf("RequestEmailChange: email %s: %v", user.ID, err)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"message": "Check " + req.NewEmail + " for a link to confirm your new email address."})
+}
+
+// VerifyEmail applies the email change a link from RequestEmailChange
+// confirms, signs the user out everywhere and tells the old address. The link
+// is single-use and works without being signed in.
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req models.VerifyEmailRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	tokenHash := hashToken(req.Token)
+	t, err := h.Store.GetUserToken(r.Context(), tokenHash)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to verify token")
+		return
+	}
+	if t == nil || t.Purpose != "email-change" || t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+		writeError(w, http.StatusBadRequest, "invalid or expired verification link")
+		return
+	}
+	user, err := h.Store.GetUser(r.Context(), t.UserID)
+	if err != nil || user == nil || user.DeletedAt != nil {
+		writeError(w, http.StatusBadRequest, "invalid or expired verification link")
+		return
+	}
+	// The link is consumed with the change, so it still works if the change
+	// fails for a reason that goes away.
+	if err := h.Store.ChangeEmail(r.Context(), user.ID, t.NewEmail, tokenHash); err != nil {
+		switch {
+		case errors.Is(err, store.ErrTokenUsed):
+			writeError(w, http.StatusBadRequest, "invalid or expired verification link")
+		case errors.Is(err, store.ErrEmailTaken):
+			writeError(w, http.StatusConflict, "this email address is now used by another account")
+		default:
+			writeError(w, http.StatusInternalServerError, "failed to update email")
+		}
+		return
+	}
+	log.Printf("email of user %s changed to %s", user.ID, t.NewEmail)
+	if err := h.Notifier.EmailChanged(r.Context(), user, t.NewEmail); err != nil {
+		log.Printf("VerifyEmail: notify %s: %v", user.ID, err)
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "verified", "email": t.NewEmail})
+}
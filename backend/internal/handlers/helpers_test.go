@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/notify"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The handler tests call handlers directly, over a memory store, with the
+// request context the auth middleware would have set.
+
+const (
+	testPassword = "correct horse battery staple"
+	testSecret   = "test-secret"
+
+	// testOrg is the organization newStore creates and newUser puts clients in.
+	testOrg = "org-acme"
+)
+
+// newStore returns a memory store holding organization testOrg, named Acme,
+// and users.
+func newStore(t *testing.T, users ...*models.User) *storetest.Memory {
+	t.Helper()
+	ctx := context.Background()
+	st := storetest.New()
+	st.CreateOrg(ctx, &models.Organization{ID: testOrg, Name: "Acme"})
+	for _, u := range users {
+		if err := st.CreateUser(ctx, u); err != nil {
+			t.Fatalf("create %s: %v", u.ID, err)
+		}
+	}
+	return st
+}
+
+// newUser returns a user with role whose address is id without its "user-"
+// prefix at example.test. Clients belong to testOrg.
+func newUser(id, role string) *models.User {
+	u := &models.User{ID: id, Email: strings.TrimPrefix(id, "user-") + "@example.test", Role: role, CreatedAt: time.Now()}
+	if role == policy.Client {
+		org := testOrg
+		u.OrganizationID = &org
+	}
+	return u
+}
+
+// withPassword sets u's password to testPassword and returns u.
+func withPassword(t *testing.T, u *models.User) *models.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.PasswordHash = string(hash)
+	return u
+}
+
+// anon returns a request from a caller who has not signed in.
+func anon(method, target, body string) *http.Request {
+	return httptest.NewRequest(method, target, strings.NewReader(body))
+}
+
+// asUser returns a request from a signed-in user, as far as handlers that
+// only look at the caller's ID can tell.
+func asUser(method, target, body, userID string) *http.Request {
+	r := anon(method, target, body)
+	return r.WithContext(context.WithValue(r.Context(), middleware.UserIDKey, userID))
+}
+
+// asCaller returns a request from u with the role, organization and
+// permissions the auth middleware would have set.
+func asCaller(method, target, body string, u *models.User) *http.Request {
+	r := asUser(method, target, body, u.ID)
+	orgID := ""
+	if u.OrganizationID != nil {
+		orgID = *u.OrganizationID
+	}
+	ctx := context.WithValue(r.Context(), middleware.RoleKey, u.Role)
+	ctx = context.WithValue(ctx, middleware.OrgIDKey, orgID)
+	return r.WithContext(context.WithValue(ctx, middleware.PermissionsKey, policy.Permissions(u.Role)))
+}
+
+// call runs h on r with the path values given as name, value pairs and
+// returns the response.
+func call(h http.HandlerFunc, r *http.Request, path ...string) *httptest.ResponseRecorder {
+	for i := 0; i+1 < len(path); i += 2 {
+		r.SetPathValue(path[i], path[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h(rec, r)
+	return rec
+}
+
+// mailbox is a notify.Mailer that keeps what it is sent.
+type mailbox struct {
+	mu   sync.Mutex
+	mail []notify.Email
+}
+
+func (m *mailbox) Send(ctx context.Context, e *notify.Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mail = append(m.mail, *e)
+	return nil
+}
+
+// link returns the value of query parameter param in the last link mailed
+// to addr, or "" if there is none.
+func (m *mailbox) link(addr, param string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	re := regexp.MustCompile(`[?&]` + regexp.QuoteMeta(param) + `=([\w.-]+)`)
+	for i := len(m.mail) - 1; i >= 0; i-- {
+		if e := m.mail[i]; e.To[0] == addr {
+			if match := re.FindStringSubmatch(e.Text); match != nil {
+				return match[1]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/supporttickr/backend/internal/inbound"
+)
+
+// maxInboundEmailSize bounds the raw message accepted by the webhook.
+const maxInboundEmailSize = 30 << 20
+
+// InboundHandler receives raw RFC 5322 messages from a mail provider webhook.
+type InboundHandler struct {
+	Processor *inbound.Processor
+	Secret    string
+}
+
+// Email accepts the raw message as the request body. The caller authenticates
+// with the shared secret in the X-Inbound-Secret header; it is not accepted in
+// the URL, where it would end up in access logs.
+func (h *InboundHandler) Email(w http.ResponseWriter, r *http.Request) {
+	if h.Secret == "" {
+		writeError(w, http.StatusNotFound, "inbound email is not configured")
+		return
+	}
+	secret := r.Header.Get("X-Inbound-Secret")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(h.Secret)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid inbound secret")
+		return
+	}
+
+	defer r.Body.Close()
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxInboundEmailSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "message too large")
+		return
+	}
+
+	res, err := h.Processor.Process(r.Context(), raw)
+	if errors.Is(err, inbound.ErrDuplicate) {
+		// A provider retry of a message that was already processed.
+		writeJSON(w, http.StatusOK, map[string]string{"status": "duplicate"})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, inbound.ErrUnknownSender) || errors.Is(err, inbound.ErrUnauthenticated) {
+			// Accept so the provider does not retry; the message is dropped.
+			log.Printf("inbound: rejected message: %v", err)
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "rejected", "reason": err.Error()})
+			return
+		}
+		log.Printf("inbound: process message: %v", err)
+		writeError(w, http.StatusUnprocessableEntity, "failed to process message: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/supporttickr/backend/internal/inbound"
+	"github.com/supporttickr/backend/internal/policy"
+)
+
+func TestInboundEmail(t *testing.T) {
+	cal := newUser("user-cal", policy.Client)
+	h := &InboundHandler{
+		Processor: &inbound.Processor{Store: newStore(t, cal), AuthServID: "mx.support.test"},
+		Secret:    "inbound-secret",
+	}
+	message := func(id, auth string) string {
+		return strings.Join([]string{
+			"Authentication-Results: " + auth,
+			"From: cal@example.test",
+			"Message-ID: <" + id + "@example.test>",
+			"Subject: Printer on fire",
+			"",
+			"Send help.",
+		}, "\r\n")
+	}
+	const pass = "mx.support.test; dmarc=pass header.from=example.test"
+
+	for _, tc := range []struct {
+		name   string
+		secret string
+		body   string
+		want   int
+		reply  string
+	}{
+		{"no secret", "", message("m1", pass), http.StatusUnauthorized, "invalid inbound secret"},
+		{"wrong secret", "inbound-secre", message("m1", pass), http.StatusUnauthorized, "invalid inbound secret"},
+		{"new email", "inbound-secret", message("m1", pass), http.StatusOK, `"created":true`},
+		{"provider retry", "inbound-secret", message("m1", pass), http.StatusOK, `"status":"duplicate"`},
+		{"failing auth", "inbound-secret", message("m2", "mx.support.test; dmarc=fail header.from=example.test"), http.StatusAccepted, `"status":"rejected"`},
+		{"spoofed auth", "inbound-secret", message("m3", "mx.evil.test; dmarc=pass header.from=example.test"), http.StatusAccepted, `"status":"rejected"`},
+		{"not an email", "inbound-secret", "hello", http.StatusUnprocessableEntity, "failed to process message"},
+	} {
+		r := anon("POST", "/api/inbound/email", tc.body)
+		if tc.secret != "" {
+			r.Header.Set("X-Inbound-Secret", tc.secret)
+		}
+		rec := call(h.Email, r)
+		if rec.Code != tc.want || !strings.Contains(rec.Body.String(), tc.reply) {
+			t.Errorf("%s: %d %s, want %d with %s", tc.name, rec.Code, rec.Body, tc.want, tc.reply)
+		}
+	}
+
+	// Inbound email is off until a secret is configured.
+	off := &InboundHandler{Processor: h.Processor}
+	if rec := call(off.Email, anon("POST", "/api/inbound/email", message("m4", pass))); rec.Code != http.StatusNotFound {
+		t.Errorf("without a secret: %d, want 404", rec.Code)
+	}
+}
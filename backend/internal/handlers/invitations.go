@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/notify"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// invitationTTL is how long an invitation link stays valid.
+const invitationTTL = 7 * 24 * time.Hour
+
+// InvitationHandler invites users by email. An invited user is stored as a
+// pending user who cannot sign in until they choose their name and password
+// (see AcceptInvitation). Callers with user.manage invite anyone; others
+// holding user.invite (members get it only through a custom role) only
+// clients into their own organization, and only if it has MemberInvites on.
+type InvitationHandler struct {
+	Store    store.Store
+	Notifier *notify.Notifier
+}
+
+// newInvitation makes u a pending user with a fresh link and returns the
+// token for the email.
+func newInvitation(u *models.User, invitedBy string, now time.Time) string {
+	secret, secretHash := newUserToken()
+	u.Invitation = &models.Invitation{
+		InvitedBy: invitedBy,
+		InvitedAt: now,
+		ExpiresAt: now.Add(invitationTTL),
+		TokenHash: secretHash,
+	}
+	return u.ID + "." + secret
+}
+
+func (h *InvitationHandler) List(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgFilter(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusOK, []models.UserResponse{})
+		return
+	}
+	users, err := h.Store.ListUsers(r.Context(), policy.Admin, "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query users")
+		return
+	}
+	pending := []models.UserResponse{}
+	for _, u := range users {
+		if u.Invitation == nil || (orgID != "" && (u.OrganizationID == nil || *u.OrganizationID != orgID)) {
+			continue
+		}
+		pending = append(pending, u)
+	}
+	writeJSON(w, http.StatusOK, pending)
+}
+
+func (h *InvitationHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.InvitationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Email = store.NormalizeEmail(req.Email)
+	req.Name = strings.TrimSpace(req.Name)
+	if !strings.Contains(req.Email, "@") {
+		writeError(w, http.StatusBadRequest, "a valid email is required")
+		return
+	}
+	if req.Role == "" {
+		req.Role = policy.Client
+	}
+	if !policy.IsRole(req.Role) {
+		writeError(w, http.StatusBadRequest, "unknown role: "+req.Role)
+		return
+	}
+	if !middleware.HasPermission(r.Context(), policy.UserManage) {
+		if req.OrganizationID == "" {
+			req.OrganizationID = middleware.GetOrgID(r.Context())
+		}
+		if req.Role != policy.Client {
+			writeError(w, http.StatusForbidden, "you can only invite clients")
+			return
+		}
+		req.Team = ""
+	}
+	if msg, status := h.checkInviter(r.Context(), req.OrganizationID); msg != "" {
+		writeError(w, status, msg)
+		return
+	}
+	if req.CustomRoleID != "" {
+		if msg := checkCustomRole(r.Context(), h.Store, req.CustomRoleID, req.OrganizationID); msg != "" {
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+	}
+
+	existing, err := h.Store.GetUserByEmail(r.Context(), req.Email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to look up email")
+		return
+	}
+	if existing != nil {
+		writeError(w, http.StatusConflict, "a user with this email already exists")
+		return
+	}
+
+	if req.Name == "" {
+		req.Name, _, _ = strings.Cut(req.Email, "@")
+	}
+	var orgID *string
+	if req.OrganizationID != "" {
+		orgID = &req.OrganizationID
+	}
+	u := &models.User{
+		ID:             "user-" + generateID(),
+		Name:           req.Name,
+		Email:          req.Email,
+		Role:           req.Role,
+		OrganizationID: orgID,
+		Avatar:         initialsFromName(req.Name),
+		Team:           req.Team,
+		CustomRoleID:   req.CustomRoleID,
+		CreatedAt:      time.Now().UTC(),
+	}
+	token := newInvitation(u, middleware.GetUserID(r.Context()), u.CreatedAt)
+	if err := h.Store.CreateUser(r.Context(), u); err != nil {
+		if errors.Is(err, store.ErrEmailTaken) {
+			writeError(w, http.StatusConflict, "a user with this email already exists")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to create invitation")
+		return
+	}
+	if err := h.Notifier.Invitation(r.Context(), u, token, invitationTTL); err != nil {
+		log.Printf("CreateInvitation: email %s: %v", u.ID, err)
+	}
+	writeJSON(w, http.StatusCreated, u.ToResponse())
+}
+
+// Resend emails a new link, which replaces the previous one and restarts the
+// expiry.
+func (h *InvitationHandler) Resend(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	invitedAt := u.Invitation.InvitedAt
+	token := newInvitation(u, u.Invitation.InvitedBy, time.Now().UTC())
+	u.Invitation.InvitedAt = invitedAt
+	if err := h.Store.RenewInvitation(r.Context(), u.ID, u.Invitation.TokenHash, u.Invitation.ExpiresAt); err != nil {
+		if errors.Is(err, store.ErrNotInvited) {
+			writeError(w, http.StatusConflict, "invitation was already accepted")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to renew invitation")
+		return
+	}
+	if err := h.Notifier.Invitation(r.Context(), u, token, invitationTTL); err != nil {
+		log.Printf("ResendInvitation: email %s: %v", u.ID, err)
+	}
+	writeJSON(w, http.StatusOK, u.ToResponse())
+}
+
+// Revoke deletes the pending user, so their link stops working.
+func (h *InvitationHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Store.RevokeInvitation(r.Context(), u.ID); err != nil {
+		if errors.Is(err, store.ErrNotInvited) {
+			writeError(w, http.StatusConflict, "invitation was already accepted")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to revoke invitation")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
+
+// load returns the pending user named by the path if the caller may manage
+// their invitation.
+func (h *InvitationHandler) load(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	u, err := h.Store.GetUser(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user")
+		return nil, false
+	}
+	if u == nil || u.Invitation == nil {
+		writeError(w, http.StatusNotFound, "invitation not found")
+		return nil, false
+	}
+	orgID := ""
+	if u.OrganizationID != nil {
+		orgID = *u.OrganizationID
+	}
+	if msg, status := h.checkInviter(r.Context(), orgID); msg != "" {
+		writeError(w, status, msg)
+		return nil, false
+	}
+	return u, true
+}
+
+// checkInviter returns why the caller may not invite users into organization
+// orgID ("" for staff), with the status to answer, or "".
+func (h *InvitationHandler) checkInviter(ctx context.Context, orgID string) (string, int) {
+	var org *models.Organization
+	if orgID != "" {
+		var err error
+		if org, err = h.Store.GetOrg(ctx, orgID); err != nil {
+			return "failed to load organization", http.StatusInternalServerError
+		}
+		if org == nil {
+			return "organization not found", http.StatusBadRequest
+		}
+	}
+	if middleware.HasPermission(ctx, policy.UserManage) {
+		return "", 0
+	}
+	if org == nil || !canAccessOrg(ctx, orgID) {
+		return "you can only invite users into your own organization", http.StatusForbidden
+	}
+	if !org.MemberInvites {
+		return "your organization does not allow members to invite users", http.StatusForbidden
+	}
+	return "", 0
+}
+
+// invitedUser returns the pending user an invitation token belongs to, or nil
+// if the token is unknown, replaced or expired, or the user was deleted.
+func (h *AuthHandler) invitedUser(ctx context.Context, token string) (*models.User, error) {
+	userID, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, nil
+	}
+	u, err := h.Store.GetUser(ctx, userID)
+	if err != nil || u == nil {
+		return nil, err
+	}
+	if u.DeletedAt != nil || u.Invitation == nil || u.Invitation.TokenHash != hashToken(secret) || time.Now().After(u.Invitation.ExpiresAt) {
+		return nil, nil
+	}
+	return u, nil
+}
+
+// Invitation describes the invitation behind a link, for the accept form.
+func (h *AuthHandler) Invitation(w http.ResponseWriter, r *http.Request) {
+	u, err := h.invitedUser(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load invitation")
+		return
+	}
+	if u == nil {
+		writeError(w, http.StatusNotFound, "invalid or expired invitation")
+		return
+	}
+	info := models.InvitationInfo{Email: u.Email, Name: u.Name, ExpiresAt: u.Invitation.ExpiresAt}
+	if u.OrganizationID != nil {
+		if org, _ := h.Store.GetOrg(r.Context(), *u.OrganizationID); org != nil {
+			info.OrganizationName = org.Name
+		}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// AcceptInvitation sets the invited user's name and password and signs them
+// in like Login would.
+func (h *AuthHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var req models.AcceptInvitationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Token == "" || req.Name == "" || req.Password == "" {
+		writeError(w, http.StatusBadRequest, "token, name and password are required")
+		return
+	}
+
+	u, err := h.invitedUser(r.Context(), req.Token)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load invitation")
+		return
+	}
+	if u == nil {
+		writeError(w, http.StatusBadRequest, "invalid or expired invitation")
+		return
+	}
+	if err := h.Passwords.Check(req.Password, u.Email); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to hash password")
+		return
+	}
+
+	avatar := initialsFromName(req.Name)
+	if err := h.Store.AcceptInvitation(r.Context(), u.ID, u.Invitation.TokenHash, req.Name, avatar, string(hash)); err != nil {
+		if errors.Is(err, store.ErrTokenUsed) {
+			writeError(w, http.StatusBadRequest, "invalid or expired invitation")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to accept invitation")
+		return
+	}
+	u.Name, u.Avatar, u.PasswordHash, u.Invitation = req.Name, avatar, string(hash), nil
+	h.finishLogin(w, r, u)
+}
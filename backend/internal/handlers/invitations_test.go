@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/notify"
+	"github.com/supporttickr/backend/internal/policy"
+)
+
+func TestAcceptInvitation(t *testing.T) {
+	ctx := context.Background()
+	admin := newUser("user-admin", policy.Admin)
+	st := newStore(t, admin)
+	mail := &mailbox{}
+	inv := &InvitationHandler{Store: st, Notifier: notify.New(st, mail, "http://app.test", "support@example.test")}
+	auth := &AuthHandler{Store: st, JWTSecret: testSecret}
+
+	invite := func(email string) (string, string) {
+		t.Helper()
+		rec := call(inv.Create, asCaller("POST", "/api/invitations", `{"email":"`+email+`","organizationId":"`+testOrg+`"}`, admin))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("invite %s: %d %s", email, rec.Code, rec.Body)
+		}
+		u, _ := st.GetUserByEmail(ctx, email)
+		return u.ID, mail.link(email, "invitation-token")
+	}
+	accept := func(token string) int {
+		return call(auth.AcceptInvitation, anon("POST", "/api/invitations/accept", `{"token":"`+token+`","name":"Kim Lee","password":"`+testPassword+`"}`)).Code
+	}
+	show := func(token string) int {
+		return call(auth.Invitation, anon("GET", "/api/invitations/accept?token="+token, "")).Code
+	}
+
+	id, token := invite("kim@example.test")
+	if show(token) != http.StatusOK {
+		t.Fatal("fresh invitation link not shown")
+	}
+	secret := token[strings.Index(token, ".")+1:]
+	for _, bad := range []string{"", id, id + ".wrong", "user-other." + secret} {
+		if code := accept(bad); code != http.StatusBadRequest {
+			t.Errorf("accept with %q: %d, want 400", bad, code)
+		}
+	}
+	if code := accept(token); code != http.StatusOK {
+		t.Fatalf("accept: %d", code)
+	}
+	if u, _ := st.GetUser(ctx, id); u.Invitation != nil || u.Name != "Kim Lee" || u.PasswordHash == "" {
+		t.Fatalf("user after accepting %+v", u)
+	}
+	if code := accept(token); code != http.StatusBadRequest || show(token) != http.StatusNotFound {
+		t.Errorf("used link accepted again: %d", code)
+	}
+
+	// An expired link stops working until the invitation is resent, which
+	// replaces it.
+	id, expired := invite("lou@example.test")
+	u, _ := st.GetUser(ctx, id)
+	st.RenewInvitation(ctx, id, u.Invitation.TokenHash, time.Now().Add(-time.Minute))
+	if code := accept(expired); code != http.StatusBadRequest || show(expired) != http.StatusNotFound {
+		t.Fatalf("expired link: accept %d, show %d, want 400 and 404", code, show(expired))
+	}
+	if rec := call(inv.Resend, asCaller("POST", "/api/invitations/"+id+"/resend", "", admin), "id", id); rec.Code != http.StatusOK {
+		t.Fatalf("resend: %d %s", rec.Code, rec.Body)
+	}
+	renewed := mail.link("lou@example.test", "invitation-token")
+	if renewed == expired || accept(expired) != http.StatusBadRequest {
+		t.Fatal("the old link still works after a resend")
+	}
+	if u, _ := st.GetUser(ctx, id); !u.Invitation.ExpiresAt.After(time.Now().Add(invitationTTL - time.Minute)) {
+		t.Fatalf("resent invitation expires at %v, want a full %v from now", u.Invitation.ExpiresAt, invitationTTL)
+	}
+	if code := accept(renewed); code != http.StatusOK {
+		t.Fatalf("accept the resent link: %d", code)
+	}
+
+	// A revoked invitation's link is dead too.
+	id, revoked := invite("max@example.test")
+	call(inv.Revoke, asCaller("DELETE", "/api/invitations/"+id, "", admin), "id", id)
+	if code := accept(revoked); code != http.StatusBadRequest {
+		t.Errorf("revoked link: %d, want 400", code)
+	}
+}
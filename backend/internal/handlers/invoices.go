@@ -5,13 +5,16 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/events"
 	"github.com/supporttickr/backend/internal/middleware"
 	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/outbox"
 	"github.com/supporttickr/backend/internal/store"
 )
 
 type InvoiceHandler struct {
-	Store store.Store
+	Store  store.Store
+	Outbox *outbox.Relay
 }
 
 func (h *InvoiceHandler) List(w http.ResponseWriter, r *http.Request) {
@@ -36,6 +39,19 @@ func (h *InvoiceHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.OrganizationID == "" {
+		writeError(w, http.StatusBadRequest, "organizationId is required")
+		return
+	}
+	if req.Month < 1 || req.Month > 12 || req.Year < 2000 {
+		writeError(w, http.StatusBadRequest, "month and year must be a valid billing period")
+		return
+	}
+	if o, err := h.Store.GetOrg(r.Context(), req.OrganizationID); err != nil || o == nil {
+		writeError(w, http.StatusBadRequest, "organization not found")
+		return
+	}
+
 	invID := "inv-" + uuid.NewString()[:8]
 	now := time.Now().UTC()
 
@@ -61,6 +77,7 @@ func (h *InvoiceHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 func (h *InvoiceHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 	invID := r.PathValue("id")
+	userID := middleware.GetUserID(r.Context())
 
 	var req models.UpdateInvoiceStatusRequest
 	if err := decodeJSON(r, &req); err != nil {
@@ -73,10 +90,28 @@ func (h *InvoiceHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.Store.UpdateInvoiceStatus(r.Context(), invID, req.Status); err != nil {
+	inv, err := h.Store.GetInvoice(r.Context(), invID)
+	if err != nil || inv == nil {
+		writeError(w, http.StatusNotFound, "invoice not found")
+		return
+	}
+
+	var msgs []models.OutboxMessage
+	if req.Status != inv.Status {
+		updated := *inv
+		updated.Status = req.Status
+		evs := []events.Event{events.InvoiceStatusChanged{Invoice: &updated, OldStatus: inv.Status, ActorID: userID}}
+		if req.Status == "sent" {
+			evs = append(evs, events.InvoiceIssued{Invoice: &updated})
+		}
+		msgs = outbox.Messages(evs...)
+	}
+
+	if err := h.Store.UpdateInvoiceStatus(r.Context(), invID, req.Status, msgs...); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to update invoice status")
 		return
 	}
+	h.Outbox.Flush(r.Context(), msgs)
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
 }
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Failed sign-ins are counted per email address and per client IP. After a
+// few free attempts each failure doubles the wait before the next attempt is
+// accepted, and every lockAt failures lock the key for lockFor. Counts start
+// over after attemptWindow without failures or after a successful sign-in.
+type attemptLimit struct {
+	free    int // failures before delays start
+	lockAt  int
+	lockFor time.Duration
+}
+
+var (
+	accountLimit = attemptLimit{free: 3, lockAt: 10, lockFor: 15 * time.Minute}
+	ipLimit      = attemptLimit{free: 20, lockAt: 100, lockFor: 15 * time.Minute}
+	// Invalid two-factor codes are counted per user. The lock outlasts
+	// challengeTTL, so it also ends the challenge the codes were guessed for.
+	twoFactorLimit = attemptLimit{free: 2, lockAt: 5, lockFor: 15 * time.Minute}
+)
+
+const (
+	attemptWindow = time.Hour
+	maxLoginDelay = time.Minute
+)
+
+func emailAttemptKey(email string) string {
+	return "email:" + store.NormalizeEmail(email)
+}
+
+func ipAttemptKey(ip string) string { return "ip:" + ip }
+
+func twoFactorAttemptKey(userID string) string { return "2fa:" + userID }
+
+// retryAt returns when the next attempt for a will be accepted.
+func (l attemptLimit) retryAt(a *models.LoginAttempts) time.Time {
+	var at time.Time
+	if a == nil {
+		return at
+	}
+	if a.LockedUntil != nil {
+		at = *a.LockedUntil
+	}
+	if n := a.Failures - l.free; n > 0 {
+		delay := time.Duration(math.Pow(2, float64(n-1))) * time.Second
+		if delay > maxLoginDelay || delay <= 0 {
+			delay = maxLoginDelay
+		}
+		if next := a.LastFailureAt.Add(delay); next.After(at) {
+			at = next
+		}
+	}
+	return at
+}
+
+// loginThrottled answers the request with 429 and returns true if the email
+// address or client IP has to wait before trying again.
+func (h *AuthHandler) loginThrottled(w http.ResponseWriter, r *http.Request, email string) bool {
+	limits := map[string]attemptLimit{emailAttemptKey(email): accountLimit, ipAttemptKey(h.clientIP(r)): ipLimit}
+	return h.throttled(w, r, limits, "too many failed sign-in attempts, try again later")
+}
+
+// twoFactorThrottled is loginThrottled for two-factor codes of user.
+func (h *AuthHandler) twoFactorThrottled(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	limits := map[string]attemptLimit{twoFactorAttemptKey(user.ID): twoFactorLimit, ipAttemptKey(h.clientIP(r)): ipLimit}
+	return h.throttled(w, r, limits, "too many invalid codes, try again later")
+}
+
+func (h *AuthHandler) throttled(w http.ResponseWriter, r *http.Request, limits map[string]attemptLimit, msg string) bool {
+	now := time.Now()
+	var wait time.Time
+	for key, limit := range limits {
+		a, err := h.Store.GetLoginAttempts(r.Context(), key)
+		if err != nil {
+			log.Printf("Login: load attempts for %s: %v", key, err)
+			continue
+		}
+		if at := limit.retryAt(a); at.After(wait) {
+			wait = at
+		}
+	}
+	if !wait.After(now) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Sub(now).Seconds()))))
+	writeError(w, http.StatusTooManyRequests, msg)
+	return true
+}
+
+// passwordConfirmed re-checks the signed-in user's password before a
+// sensitive change. Wrong passwords count as failed sign-ins of the account,
+// so a stolen session cannot be used to guess it. It answers the request with
+// msg and returns false unless password is correct.
+func (h *AuthHandler) passwordConfirmed(w http.ResponseWriter, r *http.Request, user *models.User, password, msg string) bool {
+	if h.loginThrottled(w, r, user.Email) {
+		return false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		h.loginFailed(r, user.Email, user)
+		writeError(w, http.StatusUnauthorized, msg)
+		return false
+	}
+	return true
+}
+
+// loginFailed counts a failed sign-in for the email address and client IP,
+// locking them when they reach their limit. user is nil for unknown addresses.
+func (h *AuthHandler) loginFailed(r *http.Request, email string, user *models.User) {
+	ctx := context.WithoutCancel(r.Context())
+	ip := h.clientIP(r)
+	now := time.Now().UTC()
+
+	if a := h.countFailure(ctx, emailAttemptKey(email), now); a != nil && a.Failures%accountLimit.lockAt == 0 {
+		e := events.AccountLocked{Email: store.NormalizeEmail(email), IP: ip, Failures: a.Failures, Until: now.Add(accountLimit.lockFor)}
+		if user != nil {
+			e.UserID = user.ID
+		}
+		h.lock(ctx, emailAttemptKey(email), e)
+	}
+	h.ipFailed(ctx, ip, now)
+}
+
+// twoFactorFailed counts an invalid two-factor or recovery code for user and
+// the client IP.
+func (h *AuthHandler) twoFactorFailed(r *http.Request, user *models.User) {
+	ctx := context.WithoutCancel(r.Context())
+	ip := h.clientIP(r)
+	now := time.Now().UTC()
+
+	key := twoFactorAttemptKey(user.ID)
+	if a := h.countFailure(ctx, key, now); a != nil && a.Failures%twoFactorLimit.lockAt == 0 {
+		h.lock(ctx, key, events.AccountLocked{UserID: user.ID, Email: user.Email, IP: ip, Failures: a.Failures, Until: now.Add(twoFactorLimit.lockFor)})
+	}
+	h.ipFailed(ctx, ip, now)
+}
+
+// twoFactorPassed clears the count of invalid codes for user.
+func (h *AuthHandler) twoFactorPassed(ctx context.Context, user *models.User) {
+	if err := h.Store.ClearLoginAttempts(ctx, twoFactorAttemptKey(user.ID)); err != nil {
+		log.Printf("two-factor: clear attempts for %s: %v", user.ID, err)
+	}
+}
+
+func (h *AuthHandler) ipFailed(ctx context.Context, ip string, now time.Time) {
+	if a := h.countFailure(ctx, ipAttemptKey(ip), now); a != nil && a.Failures%ipLimit.lockAt == 0 {
+		h.lock(ctx, ipAttemptKey(ip), events.AccountLocked{IP: ip, Failures: a.Failures, Until: now.Add(ipLimit.lockFor)})
+	}
+}
+
+func (h *AuthHandler) countFailure(ctx context.Context, key string, now time.Time) *models.LoginAttempts {
+	a, err := h.Store.RecordLoginFailure(ctx, key, now, attemptWindow)
+	if err != nil {
+		log.Printf("Login: record failure for %s: %v", key, err)
+		return nil
+	}
+	return a
+}
+
+func (h *AuthHandler) lock(ctx context.Context, key string, e events.AccountLocked) {
+	msgs := outbox.Messages(e)
+	if err := h.Store.LockLogin(ctx, key, e.Until, msgs...); err != nil {
+		log.Printf("Login: lock %s: %v", key, err)
+		return
+	}
+	log.Printf("Login: %s locked until %s after %d failures", key, e.Until.Format(time.RFC3339), e.Failures)
+	h.Outbox.Flush(ctx, msgs)
+}
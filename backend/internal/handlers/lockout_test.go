@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+// newAuthTest returns an AuthHandler over a memory store holding one support
+// agent, user-jane, whose password is testPassword. setup may change the user
+// before it is stored.
+func newAuthTest(t *testing.T, setup ...func(*models.User)) (*AuthHandler, *storetest.Memory) {
+	t.Helper()
+	user := withPassword(t, newUser("user-jane", policy.SupportStaff))
+	for _, f := range setup {
+		f(user)
+	}
+	st := newStore(t, user)
+	return &AuthHandler{Store: st, JWTSecret: testSecret}, st
+}
+
+// seedFailures records n failures for key, the last one ago, and locks the
+// key for lockedFor from now if lockedFor is not zero.
+func seedFailures(st *storetest.Memory, key string, n int, ago, lockedFor time.Duration) {
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		st.RecordLoginFailure(ctx, key, time.Now().Add(-ago), attemptWindow)
+	}
+	if lockedFor != 0 {
+		st.LockLogin(ctx, key, time.Now().Add(lockedFor))
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		failures  int
+		ago       time.Duration
+		lockedFor time.Duration
+		password  string
+
+		wantCode     int
+		wantFailures int
+		wantLocked   bool
+	}{
+		{name: "right password", password: testPassword, wantCode: http.StatusOK},
+		{name: "wrong password", password: "wrong", wantCode: http.StatusUnauthorized, wantFailures: 1},
+		{name: "free attempts", failures: 2, password: "wrong", wantCode: http.StatusUnauthorized, wantFailures: 3},
+		{name: "right password clears failures", failures: 3, password: testPassword, wantCode: http.StatusOK},
+		{name: "delay after free attempts", failures: 4, password: testPassword, wantCode: http.StatusTooManyRequests, wantFailures: 4},
+		{name: "delay over", failures: 4, ago: 2 * time.Second, password: testPassword, wantCode: http.StatusOK},
+		{name: "old failures start over", failures: 9, ago: 2 * attemptWindow, password: "wrong", wantCode: http.StatusUnauthorized, wantFailures: 1},
+		{name: "lock at limit", failures: 9, ago: time.Minute, password: "wrong", wantCode: http.StatusUnauthorized, wantFailures: 10, wantLocked: true},
+		{name: "locked", failures: 10, ago: time.Minute, lockedFor: 10 * time.Minute, password: testPassword, wantCode: http.StatusTooManyRequests, wantFailures: 10, wantLocked: true},
+		{name: "lock expired", failures: 10, ago: 20 * time.Minute, lockedFor: -5 * time.Minute, password: testPassword, wantCode: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, st := newAuthTest(t)
+			key := emailAttemptKey("jane@example.test")
+			seedFailures(st, key, tc.failures, tc.ago, tc.lockedFor)
+
+			body := `{"email":"Jane@example.test","password":"` + tc.password + `"}`
+			rec := call(h.Login, anon("POST", "/api/auth/login", body))
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.wantCode, rec.Body)
+			}
+			if tc.wantCode == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Errorf("429 without Retry-After")
+			}
+
+			a, _ := st.GetLoginAttempts(context.Background(), key)
+			var failures int
+			var locked bool
+			if a != nil {
+				failures = a.Failures
+				locked = a.LockedUntil != nil && a.LockedUntil.After(time.Now())
+			}
+			if failures != tc.wantFailures || locked != tc.wantLocked {
+				t.Errorf("attempts %+v, want %d failures, locked %v", a, tc.wantFailures, tc.wantLocked)
+			}
+		})
+	}
+}
+
+func TestLoginUnknownEmailCounts(t *testing.T) {
+	h, st := newAuthTest(t)
+	rec := call(h.Login, anon("POST", "/api/auth/login", `{"email":"nobody@example.test","password":"x"}`))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", rec.Code)
+	}
+	if a, _ := st.GetLoginAttempts(context.Background(), emailAttemptKey("nobody@example.test")); a == nil || a.Failures != 1 {
+		t.Errorf("attempts %+v, want 1 failure", a)
+	}
+	if a, _ := st.GetLoginAttempts(context.Background(), ipAttemptKey("192.0.2.1")); a == nil || a.Failures != 1 {
+		t.Errorf("ip attempts %+v, want 1 failure", a)
+	}
+}
+
+func TestLoginIPThrottle(t *testing.T) {
+	h, st := newAuthTest(t)
+	seedFailures(st, ipAttemptKey("192.0.2.1"), ipLimit.free+1, 0, 0)
+
+	rec := call(h.Login, anon("POST", "/api/auth/login", `{"email":"jane@example.test","password":"`+testPassword+`"}`))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", rec.Code)
+	}
+}
+
+func TestChangePasswordCountsFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		failures int
+		password string
+		wantCode int
+		want     int
+	}{
+		{name: "wrong password", password: "wrong", wantCode: http.StatusUnauthorized, want: 1},
+		{name: "throttled", failures: 4, password: testPassword, wantCode: http.StatusTooManyRequests, want: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, st := newAuthTest(t)
+			key := emailAttemptKey("jane@example.test")
+			seedFailures(st, key, tc.failures, 0, 0)
+
+			body := `{"currentPassword":"` + tc.password + `","newPassword":"a much better passphrase"}`
+			rec := call(h.ChangePassword, asUser("PUT", "/api/auth/password", body, "user-jane"))
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.wantCode, rec.Body)
+			}
+			if a, _ := st.GetLoginAttempts(context.Background(), key); a == nil || a.Failures != tc.want {
+				t.Errorf("attempts %+v, want %d failures", a, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+var (
+	validStatuses   = map[string]bool{"open": true, "in-progress": true, "awaiting-client": true, "resolved": true, "closed": true}
+	validPriorities = map[string]bool{"low": true, "medium": true, "high": true, "critical": true}
+)
+
+type MacroHandler struct {
+	Store store.Store
+}
+
+// List returns global macros plus the caller's team macros. Admins see every macro.
+func (h *MacroHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	list, err := h.Store.ListMacros(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query macros")
+		return
+	}
+
+	team := h.userTeam(r, userID)
+	macros := []models.Macro{}
+	for _, m := range list {
+		if !teamAllowed(r, m.Scope, m.Team, team) {
+			continue
+		}
+		macros = append(macros, m)
+	}
+	sort.Slice(macros, func(i, j int) bool { return macros[i].Name < macros[j].Name })
+	writeJSON(w, http.StatusOK, macros)
+}
+
+func (h *MacroHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	var req models.MacroRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if msg := validateMacro(&req); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if !teamAllowed(r, req.Scope, req.Team, h.userTeam(r, userID)) {
+		writeError(w, http.StatusForbidden, "you can only manage your own team's macros")
+		return
+	}
+
+	now := time.Now().UTC()
+	m := &models.Macro{
+		ID:        "mac-" + uuid.NewString()[:8],
+		CreatedBy: userID,
+		CreatedAt: now,
+	}
+	applyMacroRequest(m, &req, now)
+	if err := h.Store.PutMacro(r.Context(), m); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create macro: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func (h *MacroHandler) Update(w http.ResponseWriter, r *http.Request) {
+	m, err := h.Store.GetMacro(r.Context(), r.PathValue("id"))
+	if err != nil || m == nil {
+		writeError(w, http.StatusNotFound, "macro not found")
+		return
+	}
+	team := h.userTeam(r, middleware.GetUserID(r.Context()))
+	if !teamAllowed(r, m.Scope, m.Team, team) {
+		writeError(w, http.StatusForbidden, "you can only manage your own team's macros")
+		return
+	}
+
+	var req models.MacroRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if msg := validateMacro(&req); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if !teamAllowed(r, req.Scope, req.Team, team) {
+		writeError(w, http.StatusForbidden, "you can only manage your own team's macros")
+		return
+	}
+
+	applyMacroRequest(m, &req, time.Now().UTC())
+	if err := h.Store.PutMacro(r.Context(), m); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update macro")
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (h *MacroHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	m, err := h.Store.GetMacro(r.Context(), r.PathValue("id"))
+	if err != nil || m == nil {
+		writeError(w, http.StatusNotFound, "macro not found")
+		return
+	}
+	if !teamAllowed(r, m.Scope, m.Team, h.userTeam(r, middleware.GetUserID(r.Context()))) {
+		writeError(w, http.StatusForbidden, "you can only manage your own team's macros")
+		return
+	}
+	if err := h.Store.DeleteMacro(r.Context(), m.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete macro")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func (h *MacroHandler) userTeam(r *http.Request, userID string) string {
+	if u, _ := h.Store.GetUser(r.Context(), userID); u != nil {
+		return u.Team
+	}
+	return ""
+}
+
+// teamAllowed reports whether a macro with this scope and team is available to
+// a caller on userTeam. Global macros are available to everyone.
+func teamAllowed(r *http.Request, scope, team, userTeam string) bool {
+	return scope != "team" || team == userTeam || middleware.HasPermission(r.Context(), policy.MacroAllTeams)
+}
+
+func validateMacro(req *models.MacroRequest) string {
+	if req.Name == "" {
+		return "name is required"
+	}
+	if req.Content == "" && req.SetStatus == nil && req.SetPriority == nil && len(req.AddTags) == 0 {
+		return "a macro must post content or change status, priority or tags"
+	}
+	if req.Scope == "" {
+		req.Scope = "global"
+	}
+	if req.Scope != "global" && req.Scope != "team" {
+		return "scope must be 'global' or 'team'"
+	}
+	if req.Scope == "team" && req.Team == "" {
+		return "team is required for team-scoped macros"
+	}
+	if req.SetStatus != nil && !validStatuses[*req.SetStatus] {
+		return "invalid setStatus"
+	}
+	if req.SetPriority != nil && !validPriorities[*req.SetPriority] {
+		return "invalid setPriority"
+	}
+	return ""
+}
+
+func applyMacroRequest(m *models.Macro, req *models.MacroRequest, now time.Time) {
+	m.Name = req.Name
+	m.Content = req.Content
+	m.IsInternal = req.IsInternal
+	m.Scope = req.Scope
+	m.Team = ""
+	if req.Scope == "team" {
+		m.Team = req.Team
+	}
+	m.SetStatus = req.SetStatus
+	m.SetPriority = req.SetPriority
+	m.AddTags = normalizeTags(req.AddTags)
+	m.UpdatedAt = now
+}
+
+// ApplyMacro posts a macro's rendered content to the ticket and applies its
+// status, priority and tag changes in one action.
+func (h *TicketHandler) ApplyMacro(w http.ResponseWriter, r *http.Request) {
+	ticketID := r.PathValue("id")
+	userID := middleware.GetUserID(r.Context())
+
+	t, err := h.Store.GetTicket(r.Context(), ticketID)
+	if err != nil || t == nil {
+		writeError(w, http.StatusNotFound, "ticket not found")
+		return
+	}
+	if !canAccessOrg(r.Context(), t.OrganizationID) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	var req models.ApplyMacroRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	m, err := h.Store.GetMacro(r.Context(), req.MacroID)
+	if err != nil || m == nil {
+		writeError(w, http.StatusNotFound, "macro not found")
+		return
+	}
+
+	agent, _ := h.Store.GetUser(r.Context(), userID)
+	team := ""
+	if agent != nil {
+		team = agent.Team
+	}
+	if !teamAllowed(r, m.Scope, m.Team, team) {
+		writeError(w, http.StatusForbidden, "macro is not available to your team")
+		return
+	}
+
+	now := time.Now().UTC()
+	after := *t
+	after.UpdatedAt = now
+	if m.SetStatus != nil {
+		after.Status = *m.SetStatus
+	}
+	if m.SetPriority != nil {
+		after.Priority = *m.SetPriority
+	}
+	var tags []string
+	if len(m.AddTags) > 0 {
+		tags = normalizeTags(append(append([]string{}, t.Tags...), m.AddTags...))
+		after.Tags = tags
+	}
+
+	var msg *models.Message
+	var msgs []models.OutboxMessage
+	if m.Content != "" {
+		msg = &models.Message{
+			ID:         "msg-" + uuid.NewString()[:8],
+			TicketID:   ticketID,
+			UserID:     userID,
+			Content:    renderMacro(m.Content, h.macroVars(r, t, agent)),
+			IsInternal: m.IsInternal,
+			CreatedAt:  now,
+		}
+		msgs = outbox.Messages(events.MessageAdded{Ticket: t, Message: msg, Via: "macro"})
+	}
+	msgs = append(msgs, outbox.Messages(events.TicketChanges(t, &after, userID)...)...)
+
+	// The message, the ticket changes and their events are written together.
+	if err := h.Store.ApplyTicketChanges(r.Context(), ticketID, msg, m.SetStatus, m.SetPriority, nil, tags, msgs...); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to apply macro")
+		return
+	}
+	if msg != nil {
+		h.watch(r, ticketID, userID, "commenter")
+	}
+	h.Outbox.Flush(r.Context(), msgs)
+
+	updated, _ := h.Store.GetTicket(r.Context(), ticketID)
+	if updated == nil {
+		updated = &after
+	}
+	writeJSON(w, http.StatusOK, updated.ToResponse())
+}
+
+// macroVars resolves the placeholder values available to macro templates.
+func (h *TicketHandler) macroVars(r *http.Request, t *models.Ticket, agent *models.User) map[string]string {
+	vars := map[string]string{
+		"ticket.id":    t.ID,
+		"ticket.title": t.Title,
+	}
+	if requester, _ := h.Store.GetUser(r.Context(), t.CreatedBy); requester != nil {
+		vars["requester.name"] = requester.Name
+		vars["requester.email"] = requester.Email
+	}
+	if org, _ := h.Store.GetOrg(r.Context(), t.OrganizationID); org != nil {
+		vars["org.name"] = org.Name
+	}
+	if agent != nil {
+		vars["agent.name"] = agent.Name
+	}
+	return vars
+}
+
+// renderMacro substitutes {{placeholder}} tokens. Unknown placeholders are left as-is
+// so mistakes are visible in the posted message rather than silently dropped.
+func renderMacro(content string, vars map[string]string) string {
+	var pairs []string
+	for k, v := range vars {
+		pairs = append(pairs, "{{"+k+"}}", v, "{{ "+k+" }}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(content)
+}
+
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
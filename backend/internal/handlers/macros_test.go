@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+func TestRenderMacro(t *testing.T) {
+	vars := map[string]string{"requester.name": "Ann", "ticket.id": "tkt-1"}
+	for _, tc := range []struct{ content, want string }{
+		{"Hi {{requester.name}},", "Hi Ann,"},
+		{"Hi {{ requester.name }}, re {{ticket.id}}", "Hi Ann, re tkt-1"},
+		{"Hi {{requester.name}} and {{requester.name}}", "Hi Ann and Ann"},
+		{"Dear {{requester.title}}", "Dear {{requester.title}}"},
+		{"no placeholders", "no placeholders"},
+	} {
+		if got := renderMacro(tc.content, vars); got != tc.want {
+			t.Errorf("renderMacro(%q) = %q, want %q", tc.content, got, tc.want)
+		}
+	}
+}
+
+// newMacroTest returns a store with a billing agent, a network agent, an
+// admin, a client and their ticket, and macros for everyone, for billing and
+// for network.
+func newMacroTest(t *testing.T) (*storetest.Memory, map[string]*models.User) {
+	t.Helper()
+	ctx := context.Background()
+	users := map[string]*models.User{
+		"billing": newUser("user-bill", policy.SupportLead),
+		"network": newUser("user-nat", policy.SupportLead),
+		"admin":   newUser("user-ada", policy.Admin),
+		"client":  newUser("user-cal", policy.Client),
+	}
+	users["billing"].Name, users["billing"].Team = "Bill", "billing"
+	users["network"].Name, users["network"].Team = "Nat", "network"
+	users["admin"].Name = "Ada"
+	users["client"].Name = "Cal"
+	st := newStore(t, slices.Collect(maps.Values(users))...)
+	st.CreateTicket(ctx, &models.Ticket{ID: "tkt-1", Title: "Printer on fire", Status: "open", Priority: "low",
+		OrganizationID: testOrg, CreatedBy: "user-cal", Tags: []string{"hardware"}, CreatedAt: time.Now()})
+
+	resolved, high := "resolved", "high"
+	for _, m := range []models.Macro{
+		{ID: "mac-global", Name: "Thanks", Scope: "global", Content: "Thanks {{requester.name}}, {{agent.name}} from {{org.name}} here."},
+		{ID: "mac-billing", Name: "Refund", Scope: "team", Team: "billing", Content: "Refunded.",
+			SetStatus: &resolved, SetPriority: &high, AddTags: []string{"refund", "hardware"}},
+		{ID: "mac-network", Name: "Reboot", Scope: "team", Team: "network", Content: "Please reboot."},
+	} {
+		st.PutMacro(ctx, &m)
+	}
+	return st, users
+}
+
+func TestMacroTeamScope(t *testing.T) {
+	st, users := newMacroTest(t)
+	h := &MacroHandler{Store: st}
+
+	for _, tc := range []struct {
+		user string
+		want []string
+	}{
+		{"billing", []string{"mac-billing", "mac-global"}},
+		{"network", []string{"mac-global", "mac-network"}},
+		{"admin", []string{"mac-billing", "mac-global", "mac-network"}},
+	} {
+		rec := call(h.List, asCaller("GET", "/api/macros", "", users[tc.user]))
+		var list []models.Macro
+		json.NewDecoder(rec.Body).Decode(&list)
+		var ids []string
+		for _, m := range list {
+			ids = append(ids, m.ID)
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, tc.want) {
+			t.Errorf("%s lists %v, want %v", tc.user, ids, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		name, user, macro, body string
+		wantCode                int
+	}{
+		{"own team", "billing", "mac-billing", `{"name":"Refund","content":"x","scope":"team","team":"billing"}`, http.StatusOK},
+		{"other team", "billing", "mac-network", `{"name":"Reboot","content":"x","scope":"team","team":"network"}`, http.StatusForbidden},
+		{"move to other team", "billing", "mac-billing", `{"name":"Refund","content":"x","scope":"team","team":"network"}`, http.StatusForbidden},
+		{"admin on any team", "admin", "mac-network", `{"name":"Reboot","content":"x","scope":"team","team":"billing"}`, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := call(h.Update, asCaller("PUT", "/api/macros/"+tc.macro, tc.body, users[tc.user]), "id", tc.macro)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.wantCode, rec.Body)
+			}
+		})
+	}
+}
+
+func applyMacro(st *storetest.Memory, u *models.User, macroID string) *httptest.ResponseRecorder {
+	h := &TicketHandler{Store: st}
+	return call(h.ApplyMacro, asCaller("POST", "/api/tickets/tkt-1/apply-macro", `{"macroId":"`+macroID+`"}`, u), "id", "tkt-1")
+}
+
+func TestApplyMacro(t *testing.T) {
+	st, users := newMacroTest(t)
+	ctx := context.Background()
+
+	// The in-memory store has no UpdateTicket or AddMessage, so applying the
+	// macro through anything but ApplyTicketChanges would panic.
+	if rec := applyMacro(st, users["billing"], "mac-billing"); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	tk, _ := st.GetTicket(ctx, "tkt-1")
+	if tk.Status != "resolved" || tk.Priority != "high" || !slices.Equal(tk.Tags, []string{"hardware", "refund"}) {
+		t.Errorf("ticket status %q, priority %q, tags %v", tk.Status, tk.Priority, tk.Tags)
+	}
+	msgs, _ := st.GetMessagesByTicketID(ctx, "tkt-1")
+	if len(msgs) != 1 || msgs[0].Content != "Refunded." || msgs[0].UserID != "user-bill" {
+		t.Errorf("messages %+v, want the macro's content from the agent", msgs)
+	}
+	pending, _ := st.ListDueOutbox(ctx, time.Now().Add(time.Hour), 0)
+	var names []string
+	for _, m := range pending {
+		names = append(names, m.Event)
+	}
+	if want := []string{events.NameMessageAdded, events.NameStatusChanged, events.NameTicketUpdated}; !slices.Equal(names, want) {
+		t.Errorf("outbox %v, want %v", names, want)
+	}
+}
+
+func TestApplyMacroPlaceholders(t *testing.T) {
+	st, users := newMacroTest(t)
+	if rec := applyMacro(st, users["network"], "mac-global"); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	msgs, _ := st.GetMessagesByTicketID(context.Background(), "tkt-1")
+	if len(msgs) != 1 || msgs[0].Content != "Thanks Cal, Nat from Acme here." {
+		t.Errorf("messages %+v", msgs)
+	}
+}
+
+func TestApplyMacroOtherTeam(t *testing.T) {
+	st, users := newMacroTest(t)
+	if rec := applyMacro(st, users["network"], "mac-billing"); rec.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403", rec.Code)
+	}
+	tk, _ := st.GetTicket(context.Background(), "tkt-1")
+	if tk.Status != "open" {
+		t.Errorf("ticket status %q, want it unchanged", tk.Status)
+	}
+}
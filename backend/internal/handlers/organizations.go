@@ -1,19 +1,36 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/supporttickr/backend/internal/events"
 	"github.com/supporttickr/backend/internal/middleware"
 	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/purge"
 	"github.com/supporttickr/backend/internal/store"
 )
 
 type OrgHandler struct {
 	Store store.Store
+	// Users is the auth middleware's cache; members are dropped from it when
+	// the organization is restored.
+	Users *middleware.UserCache
+	// Purger starts purge jobs; background runners carry them out.
+	Purger *purge.Purger
 }
 
 func (h *OrgHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("deleted") == "true" {
+		h.listDeleted(w, r)
+		return
+	}
 	role := middleware.GetRole(r.Context())
 	orgID := middleware.GetOrgID(r.Context())
 
@@ -25,38 +42,58 @@ func (h *OrgHandler) List(w http.ResponseWriter, r *http.Request) {
 	if orgs == nil {
 		orgs = []models.Organization{}
 	}
+	for i := range orgs {
+		redactOrg(r.Context(), &orgs[i])
+	}
+	writeJSON(w, http.StatusOK, orgs)
+}
+
+// listDeleted lists soft-deleted organizations that can still be restored.
+func (h *OrgHandler) listDeleted(w http.ResponseWriter, r *http.Request) {
+	if !middleware.HasPermission(r.Context(), policy.OrgManage) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
+	orgs, err := h.Store.ListDeletedOrgs(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query organizations")
+		return
+	}
+	if orgs == nil {
+		orgs = []models.Organization{}
+	}
+	for i := range orgs {
+		redactOrg(r.Context(), &orgs[i])
+	}
 	writeJSON(w, http.StatusOK, orgs)
 }
 
 func (h *OrgHandler) Get(w http.ResponseWriter, r *http.Request) {
 	orgIDParam := r.PathValue("id")
-	role := middleware.GetRole(r.Context())
-	orgID := middleware.GetOrgID(r.Context())
 
-	if role == "client" && orgIDParam != orgID {
+	if !canAccessOrg(r.Context(), orgIDParam) {
 		writeError(w, http.StatusForbidden, "access denied")
 		return
 	}
 
 	o, err := h.Store.GetOrg(r.Context(), orgIDParam)
-	if err != nil || o == nil {
+	if err != nil || o == nil || (o.DeletedAt != nil && !middleware.HasPermission(r.Context(), policy.OrgManage)) {
 		writeError(w, http.StatusNotFound, "organization not found")
 		return
 	}
+	redactOrg(r.Context(), o)
 	writeJSON(w, http.StatusOK, o)
 }
 
 func (h *OrgHandler) Create(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetRole(r.Context())
-	if role != "admin" {
-		writeError(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	var input struct {
-		Name         string `json:"name"`
-		Plan         string `json:"plan"`
-		ContactEmail string `json:"contactEmail"`
+		Name             string            `json:"name"`
+		Plan             string            `json:"plan"`
+		ContactEmail     string            `json:"contactEmail"`
+		ChatWebhookURL   string            `json:"chatWebhookUrl"`
+		RequireTwoFactor bool              `json:"requireTwoFactor"`
+		MemberInvites    bool              `json:"memberInvites"`
+		SSO              *models.SSOConfig `json:"sso"`
 	}
 	if err := decodeJSON(r, &input); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid request body")
@@ -67,51 +104,88 @@ func (h *OrgHandler) Create(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "name and contactEmail are required")
 		return
 	}
+	if input.ChatWebhookURL != "" && !validChatWebhook(input.ChatWebhookURL) {
+		writeError(w, http.StatusBadRequest, "chatWebhookUrl must be an absolute http or https URL")
+		return
+	}
 	if input.Plan == "" {
 		input.Plan = "starter"
 	}
 
 	id := "org-" + generateID()
+	if input.SSO != nil {
+		if msg := h.checkSSO(r.Context(), id, input.SSO, nil); msg != "" {
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+	}
 	o := &models.Organization{
-		ID:           id,
-		Name:         input.Name,
-		Plan:         input.Plan,
-		ContactEmail: input.ContactEmail,
-		CreatedAt:    time.Now().UTC(),
+		ID:               id,
+		Name:             input.Name,
+		Plan:             input.Plan,
+		ContactEmail:     input.ContactEmail,
+		ChatWebhookURL:   input.ChatWebhookURL,
+		RequireTwoFactor: input.RequireTwoFactor,
+		MemberInvites:    input.MemberInvites,
+		SSO:              input.SSO,
+		CreatedAt:        time.Now().UTC(),
 	}
 	if err := h.Store.CreateOrg(r.Context(), o); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create organization")
 		return
 	}
 
+	redactOrg(r.Context(), o)
 	writeJSON(w, http.StatusCreated, o)
 }
 
 func (h *OrgHandler) Update(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetRole(r.Context())
-	if role != "admin" {
-		writeError(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	orgIDParam := r.PathValue("id")
 
 	var input struct {
-		Name         *string `json:"name"`
-		Plan         *string `json:"plan"`
-		ContactEmail *string `json:"contactEmail"`
+		Name             *string `json:"name"`
+		Plan             *string `json:"plan"`
+		ContactEmail     *string `json:"contactEmail"`
+		ChatWebhookURL   *string `json:"chatWebhookUrl"`
+		RequireTwoFactor *bool   `json:"requireTwoFactor"`
+		MemberInvites    *bool   `json:"memberInvites"`
+		// An sso object with an empty issuer removes single sign-on.
+		SSO *models.SSOConfig `json:"sso"`
 	}
 	if err := decodeJSON(r, &input); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
-	if input.Name == nil && input.Plan == nil && input.ContactEmail == nil {
+	if input.Name == nil && input.Plan == nil && input.ContactEmail == nil && input.ChatWebhookURL == nil && input.RequireTwoFactor == nil && input.MemberInvites == nil && input.SSO == nil {
 		writeError(w, http.StatusBadRequest, "no fields to update")
 		return
 	}
+	if input.ChatWebhookURL != nil && *input.ChatWebhookURL != "" && !validChatWebhook(*input.ChatWebhookURL) {
+		writeError(w, http.StatusBadRequest, "chatWebhookUrl must be an absolute http or https URL")
+		return
+	}
 
-	if err := h.Store.UpdateOrg(r.Context(), orgIDParam, input.Name, input.Plan, input.ContactEmail); err != nil {
+	if input.SSO != nil {
+		existing, err := h.Store.GetOrg(r.Context(), orgIDParam)
+		if err != nil || existing == nil {
+			writeError(w, http.StatusNotFound, "organization not found")
+			return
+		}
+		cfg := input.SSO
+		if cfg.Issuer == "" {
+			cfg = nil
+		} else if msg := h.checkSSO(r.Context(), orgIDParam, cfg, existing.SSO); msg != "" {
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+		if err := h.Store.UpdateOrgSSO(r.Context(), orgIDParam, cfg); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to update organization")
+			return
+		}
+	}
+
+	if err := h.Store.UpdateOrg(r.Context(), orgIDParam, input.Name, input.Plan, input.ContactEmail, input.ChatWebhookURL, input.RequireTwoFactor, input.MemberInvites); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to update organization")
 		return
 	}
@@ -121,33 +195,224 @@ func (h *OrgHandler) Update(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "organization not found")
 		return
 	}
+	redactOrg(r.Context(), o)
 	writeJSON(w, http.StatusOK, o)
 }
 
 func (h *OrgHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetRole(r.Context())
-	if role != "admin" {
-		writeError(w, http.StatusForbidden, "admin access required")
+	o, err := h.Store.GetOrg(r.Context(), r.PathValue("id"))
+	if err != nil || o == nil {
+		writeError(w, http.StatusNotFound, "organization not found")
+		return
+	}
+	if o.ID == middleware.GetOrgID(r.Context()) {
+		writeError(w, http.StatusBadRequest, "cannot delete your own organization")
 		return
 	}
 
-	orgIDParam := r.PathValue("id")
-
-	// Cascade: delete tickets (and related), invoices, users for this org, then org
-	tickets, _ := h.Store.ListTickets(r.Context(), "", "", "", orgIDParam, "", "")
-	for _, t := range tickets {
-		// Delete messages, time entries, conversion request for ticket
-		msgs, _ := h.Store.GetMessagesByTicketID(r.Context(), t.ID)
-		for _, m := range msgs {
-			_ = m // messages are deleted when we have a DeleteMessage - we don't have it; DynamoDB we'd need to delete by ticket_id. For now leave orphan messages or add batch delete by ticket.
+	// The organization's members are soft-deleted with the same timestamp in
+	// the background, once the outbox relay picks up the OrgDeleted event
+	// written together with the organization, so Restore brings back exactly
+	// those members. Everything is purged once the retention period is over.
+	// Deleting an organization that already is deleted hands its members to
+	// the background again, with the earlier timestamp.
+	at := time.Now().UTC().Truncate(time.Second)
+	if o.DeletedAt != nil {
+		if job, err := h.Store.GetPurgeJob(r.Context(), o.ID); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load purge job")
+			return
+		} else if job != nil {
+			writeError(w, http.StatusConflict, "organization is being purged")
+			return
 		}
-		// We don't have DeleteMessagesByTicketID; leave as-is for minimal change. Full cascade would require store methods.
+		at = *o.DeletedAt
 	}
-	// Delete org - store.DeleteOrg only deletes the org item; for full cascade we'd add store methods. For now just delete org and leave related data (or implement cascade in store).
-	if err := h.Store.DeleteOrg(r.Context(), orgIDParam); err != nil {
+	msgs := outbox.Messages(events.OrgDeleted{OrganizationID: o.ID, DeletedAt: at, ActorID: middleware.GetUserID(r.Context())})
+	if err := h.Store.SoftDeleteOrg(r.Context(), o.ID, at, msgs...); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to delete organization")
 		return
 	}
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
+
+// Restore undoes a soft delete that has not been purged yet, together with
+// the members that were deleted along with the organization.
+func (h *OrgHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	o, err := h.Store.GetOrg(r.Context(), r.PathValue("id"))
+	if err != nil || o == nil {
+		writeError(w, http.StatusNotFound, "organization not found")
+		return
+	}
+	if o.DeletedAt == nil {
+		writeError(w, http.StatusConflict, "organization is not deleted")
+		return
+	}
+	if job, err := h.Store.GetPurgeJob(r.Context(), o.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load purge job")
+		return
+	} else if job != nil {
+		writeError(w, http.StatusConflict, "organization is being purged")
+		return
+	}
+	users, err := h.Store.ListDeletedUsers(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query users")
+		return
+	}
+	if err := h.Store.RestoreOrg(r.Context(), o.ID); err != nil {
+		if errors.Is(err, store.ErrNotDeleted) {
+			writeError(w, http.StatusConflict, "organization is not deleted")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to restore organization")
+		return
+	}
+	for _, u := range users {
+		if u.OrganizationID == nil || *u.OrganizationID != o.ID || !u.DeletedAt.Equal(*o.DeletedAt) {
+			continue
+		}
+		if err := h.Store.RestoreUser(r.Context(), u.ID); err != nil && !errors.Is(err, store.ErrNotDeleted) {
+			writeError(w, http.StatusInternalServerError, "failed to restore organization members")
+			return
+		}
+		h.Users.Forget(u.ID)
+	}
+	o.DeletedAt = nil
+	redactOrg(r.Context(), o)
+	writeJSON(w, http.StatusOK, o)
+}
+
+// Purge starts permanently deleting a soft-deleted organization without
+// waiting for the retention period. The work happens in the background;
+// PurgeStatus reports its progress.
+func (h *OrgHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	orgID := r.PathValue("id")
+	o, err := h.Store.GetOrg(r.Context(), orgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load organization")
+		return
+	}
+	if o == nil {
+		// Already purged, or never existed.
+		h.PurgeStatus(w, r)
+		return
+	}
+	if o.DeletedAt == nil {
+		writeError(w, http.StatusConflict, "delete the organization before purging it")
+		return
+	}
+	job, err := h.Purger.Start(r.Context(), orgID, middleware.GetUserID(r.Context()))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start purge")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// PurgeStatus returns the organization's purge job.
+func (h *OrgHandler) PurgeStatus(w http.ResponseWriter, r *http.Request) {
+	job, err := h.Store.GetPurgeJob(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load purge job")
+		return
+	}
+	if job == nil {
+		writeError(w, http.StatusNotFound, "purge job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// redactOrg removes credentials before an organization is returned. Only
+// staff, who work on every organization, see the chat webhook and the SSO
+// setup; nobody sees the SSO client secret.
+func redactOrg(ctx context.Context, o *models.Organization) {
+	if !middleware.HasPermission(ctx, policy.AnyOrganization) {
+		o.ChatWebhookURL = ""
+		o.SSO = nil
+	}
+	if o.SSO != nil {
+		sso := *o.SSO
+		sso.ClientSecret = ""
+		o.SSO = &sso
+	}
+}
+
+// checkSSO normalizes an SSO configuration in place and returns a validation
+// message, or "". A missing client secret keeps the current one, so the
+// configuration can be edited without re-entering it.
+func (h *OrgHandler) checkSSO(ctx context.Context, orgID string, cfg, current *models.SSOConfig) string {
+	cfg.Issuer = strings.TrimSuffix(strings.TrimSpace(cfg.Issuer), "/")
+	u, err := url.Parse(cfg.Issuer)
+	if err != nil || u.Host == "" || !(u.Scheme == "https" || (u.Scheme == "http" && isLocalHost(u.Hostname()))) {
+		return "sso.issuer must be an https URL"
+	}
+	if cfg.ClientID == "" {
+		return "sso.clientId is required"
+	}
+	if cfg.ClientSecret == "" && current != nil {
+		cfg.ClientSecret = current.ClientSecret
+	}
+	if cfg.ClientSecret == "" {
+		return "sso.clientSecret is required"
+	}
+
+	var domains []string
+	for _, d := range cfg.Domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "" || strings.ContainsAny(d, "@/ ") || !strings.Contains(d, ".") {
+			return "sso.domains must be email domains such as example.com"
+		}
+		domains = append(domains, d)
+	}
+	if len(domains) == 0 {
+		return "sso.domains must list at least one email domain"
+	}
+	cfg.Domains = domains
+
+	// The identity provider only manages the organization's clients, so it
+	// may hand out the client role or one of the organization's custom roles.
+	if cfg.DefaultRole != "" && cfg.DefaultRole != policy.Client {
+		if checkCustomRole(ctx, h.Store, cfg.DefaultRole, orgID) != "" {
+			return "sso.defaultRole must be client or a custom role of the organization"
+		}
+	}
+	for _, role := range cfg.RoleMapping {
+		if role != policy.Client && checkCustomRole(ctx, h.Store, role, orgID) != "" {
+			return "sso.roleMapping values must be client or custom roles of the organization"
+		}
+	}
+	if len(cfg.RoleMapping) > 0 && cfg.RoleClaim == "" {
+		return "sso.roleClaim is required with sso.roleMapping"
+	}
+
+	// Each domain may only be claimed by one organization, or login could not
+	// tell where to send its users.
+	orgs, err := h.Store.ListOrgs(ctx, policy.Admin, "")
+	if err != nil {
+		return "failed to check sso.domains"
+	}
+	for _, o := range orgs {
+		if o.ID == orgID || o.SSO == nil {
+			continue
+		}
+		for _, d := range domains {
+			if ssoDomainMatch(o.SSO, d) {
+				return "sso domain " + d + " is already used by " + o.Name
+			}
+		}
+	}
+	return ""
+}
+
+func isLocalHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// validChatWebhook reports whether s is usable as an incoming webhook URL.
+// Plain http is allowed for local stub servers.
+func validChatWebhook(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
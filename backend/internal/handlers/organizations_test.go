@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+func deleteOrg(h *OrgHandler, caller *models.User, id string) *httptest.ResponseRecorder {
+	return call(h.Delete, asCaller("DELETE", "/api/organizations/"+id, "", caller), "id", id)
+}
+
+// deletedOrgEvents returns the OrgDeleted events waiting in the outbox.
+func deletedOrgEvents(st *storetest.Memory) []events.OrgDeleted {
+	due, _ := st.ListDueOutbox(context.Background(), time.Now().Add(time.Hour), 0)
+	var out []events.OrgDeleted
+	for _, m := range due {
+		if e, err := events.Decode(m.Event, []byte(m.Payload)); err == nil {
+			if d, ok := e.(events.OrgDeleted); ok {
+				out = append(out, d)
+			}
+		}
+	}
+	return out
+}
+
+func TestDeleteOrgLeavesMembersToBackground(t *testing.T) {
+	ctx := context.Background()
+	admin := newUser("user-admin", policy.Admin)
+	st := newStore(t, admin, newUser("user-jane", policy.Client))
+	h := &OrgHandler{Store: st}
+
+	if rec := deleteOrg(h, admin, testOrg); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	o, _ := st.GetOrg(ctx, testOrg)
+	if o.DeletedAt == nil {
+		t.Fatal("organization was not deleted")
+	}
+	if u, _ := st.GetUser(ctx, "user-jane"); u.DeletedAt != nil {
+		t.Error("member was deleted in the request")
+	}
+	evs := deletedOrgEvents(st)
+	if len(evs) != 1 || evs[0].OrganizationID != testOrg || !evs[0].DeletedAt.Equal(*o.DeletedAt) || evs[0].ActorID != admin.ID {
+		t.Fatalf("outbox holds %+v, want one OrgDeleted for the organization", evs)
+	}
+
+	if rec := deleteOrg(h, admin, "org-missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown organization: status %d, want 404", rec.Code)
+	}
+}
+
+func TestDeleteOrgAgain(t *testing.T) {
+	ctx := context.Background()
+	admin := newUser("user-admin", policy.Admin)
+	st := newStore(t, admin)
+	h := &OrgHandler{Store: st}
+
+	// Deleting again queues the members with the first deletion's timestamp.
+	at := time.Now().UTC().Add(-time.Minute).Truncate(time.Second)
+	st.SoftDeleteOrg(ctx, testOrg, at)
+	if rec := deleteOrg(h, admin, testOrg); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if o, _ := st.GetOrg(ctx, testOrg); !o.DeletedAt.Equal(at) {
+		t.Errorf("organization deleted at %v, want %v", o.DeletedAt, at)
+	}
+	if evs := deletedOrgEvents(st); len(evs) != 1 || !evs[0].DeletedAt.Equal(at) {
+		t.Errorf("outbox holds %+v, want the members queued at %v", evs, at)
+	}
+
+	// Not once the purge has started.
+	st.CreatePurgeJob(ctx, &models.PurgeJob{OrganizationID: testOrg, Status: "running"})
+	if rec := deleteOrg(h, admin, testOrg); rec.Code != http.StatusConflict {
+		t.Errorf("while purging: status %d, want 409", rec.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/password"
+	"github.com/supporttickr/backend/internal/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// checkNewPassword returns why pw cannot become user's password, or "".
+func checkNewPassword(p password.Policy, user *models.User, pw string) string {
+	if err := p.Check(pw, user.Email); err != nil {
+		return err.Error()
+	}
+	if p.Reused(pw, user.PasswordHash, user.PasswordHistory) {
+		return "password was used recently, choose another one"
+	}
+	return ""
+}
+
+// storePassword replaces user's password with pw, which must have passed
+// checkNewPassword, and remembers the old one. It revokes the user's sessions
+// and, with a tokenHash, consumes that single-use token along with it.
+func storePassword(ctx context.Context, st store.Store, p password.Policy, user *models.User, pw, tokenHash string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	history := p.Remember(user.PasswordHash, user.PasswordHistory)
+	if err := st.UpdatePassword(ctx, user.ID, string(hash), history, tokenHash); err != nil {
+		return err
+	}
+	user.PasswordHash = string(hash)
+	user.PasswordHistory = history
+	user.PasswordChangeRequired = false
+	user.TokenVersion++
+	return nil
+}
+
+// SetPassword takes the new password of a user whose login stopped at a
+// PasswordChangeChallenge, then continues the login like Login would.
+func (h *AuthHandler) SetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.SetPasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.PasswordChangeToken == "" || req.NewPassword == "" {
+		writeError(w, http.StatusBadRequest, "token and new password are required")
+		return
+	}
+
+	user, err := h.challengeUser(r.Context(), req.PasswordChangeToken, challengeSetPassword)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+	if user == nil || !user.PasswordChangeRequired {
+		writeError(w, http.StatusUnauthorized, "invalid or expired token, sign in again")
+		return
+	}
+	if msg := checkNewPassword(h.Passwords, user, req.NewPassword); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err := storePassword(r.Context(), h.Store, h.Passwords, user, req.NewPassword, ""); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update password")
+		return
+	}
+	h.finishLogin(w, r, user)
+}
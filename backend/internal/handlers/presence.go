@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/presence"
+	"github.com/supporttickr/backend/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// presenceProtocol is the WebSocket subprotocol spoken by Presence.
+const presenceProtocol = "presence"
+
+// presenceIdleTimeout closes connections that send nothing; clients ping
+// more often than this.
+const presenceIdleTimeout = 60 * time.Second
+
+type PresenceHandler struct {
+	Store store.Store
+	Hub   *presence.Hub
+}
+
+// Presence upgrades to a WebSocket reporting who is viewing or replying to a
+// ticket. Clients send {"type":"typing"} every few seconds while composing,
+// {"type":"viewing"} when they stop and {"type":"ping"} to stay connected;
+// the server pushes a presence snapshot whenever anything changes.
+func (h *PresenceHandler) Presence(w http.ResponseWriter, r *http.Request) {
+	ticketID := r.PathValue("id")
+	userID := middleware.GetUserID(r.Context())
+	t, err := h.Store.GetTicket(r.Context(), ticketID)
+	if err != nil || t == nil {
+		writeError(w, http.StatusNotFound, "ticket not found")
+		return
+	}
+
+	if _, ok := w.(http.Hijacker); !ok {
+		writeError(w, http.StatusNotImplemented, "websockets are not supported by this deployment")
+		return
+	}
+
+	viewer := presence.Viewer{UserID: userID, Role: middleware.GetRole(r.Context())}
+	if u, _ := h.Store.GetUser(r.Context(), userID); u != nil {
+		viewer.Name = u.Name
+	}
+
+	srv := websocket.Server{
+		Handshake: func(cfg *websocket.Config, req *http.Request) error {
+			// Answer with our protocol, never the token subprotocol the
+			// browser used to authenticate.
+			offered := cfg.Protocol
+			cfg.Protocol = nil
+			for _, p := range offered {
+				if p == presenceProtocol {
+					cfg.Protocol = []string{presenceProtocol}
+				}
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			h.serve(ws, ticketID, viewer)
+		},
+	}
+	srv.ServeHTTP(w, r)
+}
+
+func (h *PresenceHandler) serve(ws *websocket.Conn, ticketID string, viewer presence.Viewer) {
+	defer ws.Close()
+	// The hijacked connection keeps the server's read and write timeouts.
+	_ = ws.SetDeadline(time.Time{})
+
+	changed := make(chan struct{}, 1)
+	conn := h.Hub.Join(ticketID, viewer, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer conn.Leave()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_ = ws.SetReadDeadline(time.Now().Add(presenceIdleTimeout))
+			var msg struct {
+				Type string `json:"type"`
+			}
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				return
+			}
+			conn.Set(msg.Type)
+		}
+	}()
+
+	expire := time.NewTicker(2 * time.Second)
+	defer expire.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-changed:
+			_ = ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := websocket.JSON.Send(ws, h.Hub.Snapshot(ticketID)); err != nil {
+				return
+			}
+		case <-expire.C:
+			conn.Expire()
+		}
+	}
+}
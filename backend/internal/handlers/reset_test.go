@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/notify"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+const newPassword = "a much better passphrase"
+
+// newResetTest returns an AuthHandler that mails through the returned
+// mailbox, with newAuthTest's user-jane.
+func newResetTest(t *testing.T) (*AuthHandler, *storetest.Memory, *mailbox) {
+	h, st := newAuthTest(t)
+	mail := &mailbox{}
+	h.Notifier = notify.New(st, mail, "http://app.test", "support@example.test")
+	return h, st, mail
+}
+
+// forgot asks for a reset link for jane and returns its token.
+func forgot(t *testing.T, h *AuthHandler, mail *mailbox) string {
+	t.Helper()
+	if rec := call(h.ForgotPassword, anon("POST", "/api/auth/forgot-password", `{"email":"jane@example.test"}`)); rec.Code != http.StatusOK {
+		t.Fatalf("forgot: status %d: %s", rec.Code, rec.Body)
+	}
+	token := mail.link("jane@example.test", "reset-token")
+	if token == "" {
+		t.Fatal("no reset link was mailed")
+	}
+	return token
+}
+
+func reset(h *AuthHandler, token string) int {
+	body, _ := json.Marshal(models.ResetPasswordRequest{Token: token, NewPassword: newPassword})
+	return call(h.ResetPassword, anon("POST", "/api/auth/reset-password", string(body))).Code
+}
+
+func login(h *AuthHandler, pw string) (int, string) {
+	body, _ := json.Marshal(models.LoginRequest{Email: "jane@example.test", Password: pw})
+	rec := call(h.Login, anon("POST", "/api/auth/login", string(body)))
+	var out models.LoginResponse
+	json.NewDecoder(rec.Body).Decode(&out)
+	return rec.Code, out.Token
+}
+
+func TestResetPassword(t *testing.T) {
+	h, st, mail := newResetTest(t)
+	code, session := login(h, testPassword)
+	if code != http.StatusOK {
+		t.Fatalf("login: status %d", code)
+	}
+	seedFailures(st, emailAttemptKey("jane@example.test"), 3, 0, 0)
+
+	token := forgot(t, h, mail)
+	if code := reset(h, token); code != http.StatusOK {
+		t.Fatalf("reset: status %d", code)
+	}
+	if a, _ := st.GetLoginAttempts(context.Background(), emailAttemptKey("jane@example.test")); a != nil && a.Failures != 0 {
+		t.Errorf("attempts after the reset %+v, want them cleared", a)
+	}
+	if code, _ := login(h, newPassword); code != http.StatusOK {
+		t.Fatalf("login with the new password: status %d", code)
+	}
+	if code, _ := login(h, testPassword); code != http.StatusUnauthorized {
+		t.Fatalf("login with the old password: status %d, want 401", code)
+	}
+
+	// Sessions from before the reset are signed out.
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	r := anon("GET", "/api/auth/me", "")
+	r.Header.Set("Authorization", "Bearer "+session)
+	if rec := call(middleware.Auth(testSecret, st, st)(ok).ServeHTTP, r); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("session from before the reset: status %d, want 401", rec.Code)
+	}
+
+	// The link works once.
+	if code := reset(h, token); code != http.StatusBadRequest {
+		t.Fatalf("second reset: status %d, want 400", code)
+	}
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+	h, st, mail := newResetTest(t)
+	token := forgot(t, h, mail)
+	ctx := context.Background()
+	tok, _ := st.GetUserToken(ctx, hashToken(token))
+	tok.ExpiresAt = time.Now().Add(-time.Minute)
+	st.CreateUserToken(ctx, tok)
+
+	if code := reset(h, token); code != http.StatusBadRequest {
+		t.Fatalf("expired token: status %d, want 400", code)
+	}
+	if code, _ := login(h, testPassword); code != http.StatusOK {
+		t.Fatalf("login with the unchanged password: status %d", code)
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	h, _, mail := newResetTest(t)
+	rec := call(h.ForgotPassword, anon("POST", "/api/auth/forgot-password", `{"email":"nobody@example.test"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want the same answer as for a known address", rec.Code)
+	}
+	if len(mail.mail) != 0 {
+		t.Fatalf("mailed %d messages for an unknown address", len(mail.mail))
+	}
+}
+
+// failingPasswords fails UpdatePassword while down.
+type failingPasswords struct {
+	*storetest.Memory
+	down bool
+}
+
+func (s *failingPasswords) UpdatePassword(ctx context.Context, id string, newHash string, history []string, tokenHash string) error {
+	if s.down {
+		return errors.New("throttled")
+	}
+	return s.Memory.UpdatePassword(ctx, id, newHash, history, tokenHash)
+}
+
+func TestResetPasswordWriteFails(t *testing.T) {
+	h, st, mail := newResetTest(t)
+	token := forgot(t, h, mail)
+	failing := &failingPasswords{Memory: st, down: true}
+	h.Store = failing
+
+	if code := reset(h, token); code != http.StatusInternalServerError {
+		t.Fatalf("reset while the store fails: status %d, want 500", code)
+	}
+	// The link was not used up by the failed attempt.
+	failing.down = false
+	if code := reset(h, token); code != http.StatusOK {
+		t.Fatalf("reset once the store works: status %d", code)
+	}
+}
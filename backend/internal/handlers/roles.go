@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// CustomRoleHandler manages the custom roles of an organization under
+// /api/organizations/{id}/roles. Members can see their organization's roles;
+// only admins change them.
+type CustomRoleHandler struct {
+	Store store.Store
+	Users *middleware.UserCache
+}
+
+func (h *CustomRoleHandler) List(w http.ResponseWriter, r *http.Request) {
+	orgID := r.PathValue("id")
+	if !canAccessOrg(r.Context(), orgID) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	roles, err := h.Store.ListCustomRoles(r.Context(), orgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query roles")
+		return
+	}
+	if roles == nil {
+		roles = []models.CustomRole{}
+	}
+	writeJSON(w, http.StatusOK, roles)
+}
+
+func (h *CustomRoleHandler) Create(w http.ResponseWriter, r *http.Request) {
+	orgID := r.PathValue("id")
+	if o, err := h.Store.GetOrg(r.Context(), orgID); err != nil || o == nil {
+		writeError(w, http.StatusNotFound, "organization not found")
+		return
+	}
+
+	var req models.CustomRoleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if msg := h.validate(r.Context(), orgID, "", &req); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	now := time.Now().UTC()
+	role := &models.CustomRole{
+		ID:             "role-" + uuid.NewString()[:8],
+		OrganizationID: orgID,
+		Name:           req.Name,
+		Description:    req.Description,
+		Permissions:    req.Permissions,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := h.Store.PutCustomRole(r.Context(), role); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create role")
+		return
+	}
+	writeJSON(w, http.StatusCreated, role)
+}
+
+func (h *CustomRoleHandler) Update(w http.ResponseWriter, r *http.Request) {
+	role, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CustomRoleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if msg := h.validate(r.Context(), role.OrganizationID, role.ID, &req); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	role.Name = req.Name
+	role.Description = req.Description
+	role.Permissions = req.Permissions
+	role.UpdatedAt = time.Now().UTC()
+	if err := h.Store.PutCustomRole(r.Context(), role); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update role")
+		return
+	}
+	h.Users.ForgetRole(role.ID)
+	writeJSON(w, http.StatusOK, role)
+}
+
+// Delete removes a role that nobody holds any more; members must be moved to
+// another role, and single sign-on mapped elsewhere, first.
+func (h *CustomRoleHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	role, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+
+	users, err := h.Store.ListUsers(r.Context(), policy.Admin, "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query users")
+		return
+	}
+	holders := 0
+	for _, u := range users {
+		if u.CustomRoleID == role.ID {
+			holders++
+		}
+	}
+	if holders > 0 {
+		writeError(w, http.StatusConflict, fmt.Sprintf("role is assigned to %d user(s)", holders))
+		return
+	}
+	org, err := h.Store.GetOrg(r.Context(), role.OrganizationID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load organization")
+		return
+	}
+	if org != nil && org.SSO != nil && ssoUsesRole(org.SSO, role.ID) {
+		writeError(w, http.StatusConflict, "role is used by the organization's single sign-on")
+		return
+	}
+
+	if err := h.Store.DeleteCustomRole(r.Context(), role.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete role")
+		return
+	}
+	h.Users.ForgetRole(role.ID)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// load returns the role named by the path, which must belong to the
+// organization in the path.
+func (h *CustomRoleHandler) load(w http.ResponseWriter, r *http.Request) (*models.CustomRole, bool) {
+	role, err := h.Store.GetCustomRole(r.Context(), r.PathValue("roleId"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load role")
+		return nil, false
+	}
+	if role == nil || role.OrganizationID != r.PathValue("id") {
+		writeError(w, http.StatusNotFound, "role not found")
+		return nil, false
+	}
+	return role, true
+}
+
+// validate normalizes req in place and returns a validation message, or "".
+// Roles are for organization members, so they can only hold permissions that
+// a custom role may give clients (see policy.Grantable).
+func (h *CustomRoleHandler) validate(ctx context.Context, orgID, roleID string, req *models.CustomRoleRequest) string {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = strings.TrimSpace(req.Description)
+	if req.Name == "" {
+		return "name is required"
+	}
+	for _, p := range req.Permissions {
+		if !policy.Grantable(policy.Client, policy.Permission(p)) {
+			return "permission cannot be given to organization members: " + p
+		}
+	}
+	req.Permissions = slices.Compact(slices.Sorted(slices.Values(req.Permissions)))
+	if req.Permissions == nil {
+		req.Permissions = []string{}
+	}
+
+	roles, err := h.Store.ListCustomRoles(ctx, orgID)
+	if err != nil {
+		return "failed to check role name"
+	}
+	for _, other := range roles {
+		if other.ID != roleID && strings.EqualFold(other.Name, req.Name) {
+			return "a role named " + other.Name + " already exists"
+		}
+	}
+	return ""
+}
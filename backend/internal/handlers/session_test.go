@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+)
+
+// signIn logs jane in and returns her tokens.
+func signIn(t *testing.T, h *AuthHandler) models.LoginResponse {
+	t.Helper()
+	body, _ := json.Marshal(models.LoginRequest{Email: "jane@example.test", Password: testPassword})
+	rec := call(h.Login, anon("POST", "/api/auth/login", string(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+	}
+	var out models.LoginResponse
+	json.NewDecoder(rec.Body).Decode(&out)
+	return out
+}
+
+func refresh(h *AuthHandler, token string) (int, models.LoginResponse) {
+	body, _ := json.Marshal(models.RefreshRequest{RefreshToken: token})
+	rec := call(h.Refresh, anon("POST", "/api/auth/refresh", string(body)))
+	var out models.LoginResponse
+	json.NewDecoder(rec.Body).Decode(&out)
+	return rec.Code, out
+}
+
+func TestRefreshRotates(t *testing.T) {
+	h, _ := newAuthTest(t)
+	first := signIn(t, h)
+
+	code, second := refresh(h, first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("refresh: status %d", code)
+	}
+	if second.Token == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh returned %+v, want a new refresh token", second)
+	}
+	code, third := refresh(h, second.RefreshToken)
+	if code != http.StatusOK || third.RefreshToken == second.RefreshToken {
+		t.Fatalf("refresh with the rotated token: status %d", code)
+	}
+}
+
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	h, st := newAuthTest(t)
+	first := signIn(t, h)
+	_, second := refresh(h, first.RefreshToken)
+
+	// Presenting the rotated token again means it was copied.
+	if code, _ := refresh(h, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("reused token: status %d, want 401", code)
+	}
+	if code, _ := refresh(h, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("current token after reuse: status %d, want the session revoked", code)
+	}
+	sessionID, _, _ := strings.Cut(second.RefreshToken, ".")
+	if sess, _ := st.GetSession(context.Background(), sessionID); sess == nil || sess.RevokedAt == nil {
+		t.Fatalf("session %+v, want it revoked", sess)
+	}
+
+	// Other sessions of the user are not affected.
+	other := signIn(t, h)
+	if code, _ := refresh(h, other.RefreshToken); code != http.StatusOK {
+		t.Fatalf("other session: status %d", code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	h, _ := newAuthTest(t)
+	resp := signIn(t, h)
+	logout := func(token string) {
+		t.Helper()
+		body, _ := json.Marshal(models.RefreshRequest{RefreshToken: token})
+		if rec := call(h.Logout, anon("POST", "/api/auth/logout", string(body))); rec.Code != http.StatusOK {
+			t.Fatalf("logout: status %d", rec.Code)
+		}
+	}
+
+	// A stale refresh token cannot end the session.
+	_, current := refresh(h, resp.RefreshToken)
+	logout(resp.RefreshToken)
+	if code, next := refresh(h, current.RefreshToken); code != http.StatusOK {
+		t.Fatalf("refresh after logout with a stale token: status %d", code)
+	} else {
+		current = next
+	}
+
+	logout(current.RefreshToken)
+	if code, _ := refresh(h, current.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("refresh after logout: status %d, want 401", code)
+	}
+}
+
+func TestAccessTokenAlgorithm(t *testing.T) {
+	h, st := newAuthTest(t)
+	user, _ := st.GetUser(context.Background(), "user-jane")
+	claims := &middleware.Claims{
+		UserID:           user.ID,
+		Role:             user.Role,
+		TokenVersion:     user.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	hs512, _ := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte(testSecret))
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	auth := middleware.Auth(h.JWTSecret, st, st)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"HS256", hs256, http.StatusOK},
+		{"HS512", hs512, http.StatusUnauthorized},
+		{"none", none, http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := anon("GET", "/api/auth/me", "")
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+			if rec := call(auth.ServeHTTP, r); rec.Code != tc.want {
+				t.Errorf("status %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/sso"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// ssoStateTTL is how long the user has to sign in at the identity provider.
+const ssoStateTTL = 10 * time.Minute
+
+// ssoCodeTTL is how long the frontend has to exchange the one-time login code
+// it receives after the callback.
+const ssoCodeTTL = 2 * time.Minute
+
+const ssoStateCookie = "sso_state"
+
+// ssoState is kept in an HttpOnly cookie between the redirect to the identity
+// provider and the callback, so the PKCE verifier never appears in a URL.
+type ssoState struct {
+	OrganizationID string `json:"org"`
+	State          string `json:"state"`
+	Nonce          string `json:"nonce"`
+	Verifier       string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+func (h *AuthHandler) ssoKey() []byte {
+	return []byte(h.JWTSecret + "/sso")
+}
+
+// ssoOrgForEmail returns the organization whose SSO domains include the
+// email's domain, or nil. Only the organization's own clients and unknown
+// addresses are sent there: an organization cannot take over the password
+// sign-in of staff or of other organizations' users by claiming their domain.
+func (h *AuthHandler) ssoOrgForEmail(ctx context.Context, email string) (*models.Organization, error) {
+	_, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	if !ok || domain == "" {
+		return nil, nil
+	}
+	orgs, err := h.Store.ListOrgs(ctx, policy.Admin, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range orgs {
+		if orgs[i].SSO == nil || !ssoDomainMatch(orgs[i].SSO, domain) {
+			continue
+		}
+		user, err := h.Store.GetUserByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil && (user.Role != policy.Client || user.OrganizationID == nil || *user.OrganizationID != orgs[i].ID) {
+			return nil, nil
+		}
+		return &orgs[i], nil
+	}
+	return nil, nil
+}
+
+func ssoDomainMatch(cfg *models.SSOConfig, domain string) bool {
+	for _, d := range cfg.Domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// ssoUsesRole reports whether the configuration hands out the custom role.
+func ssoUsesRole(cfg *models.SSOConfig, roleID string) bool {
+	if cfg.DefaultRole == roleID {
+		return true
+	}
+	for _, role := range cfg.RoleMapping {
+		if role == roleID {
+			return true
+		}
+	}
+	return false
+}
+
+// ssoLoginURL is the path the frontend navigates to to start SSO.
+func ssoLoginURL(orgID, email string) string {
+	v := url.Values{}
+	v.Set("org", orgID)
+	v.Set("login_hint", email)
+	return "/api/auth/sso/start?" + v.Encode()
+}
+
+// SSOStart redirects the browser to the organization's identity provider.
+func (h *AuthHandler) SSOStart(w http.ResponseWriter, r *http.Request) {
+	org, err := h.Store.GetOrg(r.Context(), r.URL.Query().Get("org"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load organization")
+		return
+	}
+	if org == nil || org.SSO == nil || org.DeletedAt != nil {
+		writeError(w, http.StatusNotFound, "single sign-on is not configured")
+		return
+	}
+
+	req := sso.NewAuthRequest()
+	authURL, err := h.SSO.AuthCodeURL(r.Context(), org.SSO.Issuer, org.SSO.ClientID, h.SSOCallbackURL, req, r.URL.Query().Get("login_hint"))
+	if err != nil {
+		log.Printf("SSOStart: %s: %v", org.ID, err)
+		writeError(w, http.StatusBadGateway, "identity provider is unavailable")
+		return
+	}
+	state, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &ssoState{
+		OrganizationID: org.ID,
+		State:          req.State,
+		Nonce:          req.Nonce,
+		Verifier:       req.Verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ssoStateTTL)),
+		},
+	}).SignedString(h.ssoKey())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start sign-in")
+		return
+	}
+	http.SetCookie(w, h.ssoCookie(state, int(ssoStateTTL.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (h *AuthHandler) ssoCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    value,
+		Path:     "/api/auth/sso",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.SSOCallbackURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// SSOCallback handles the identity provider's redirect: it redeems the code,
+// provisions the user and sends the browser back to the app with a one-time
+// code that the frontend exchanges for tokens (see SSOExchange).
+func (h *AuthHandler) SSOCallback(w http.ResponseWriter, r *http.Request) {
+	fail := func(msg string) {
+		http.Redirect(w, r, h.AppURL+"/?sso_error="+url.QueryEscape(msg), http.StatusFound)
+	}
+	http.SetCookie(w, h.ssoCookie("", -1))
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		fail("Sign-in was cancelled or denied by your identity provider.")
+		return
+	}
+	cookie, err := r.Cookie(ssoStateCookie)
+	if err != nil {
+		fail("Sign-in expired, please try again.")
+		return
+	}
+	st := &ssoState{}
+	token, err := jwt.ParseWithClaims(cookie.Value, st, func(t *jwt.Token) (interface{}, error) {
+		return h.ssoKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+	if err != nil || !token.Valid || st.State != q.Get("state") || q.Get("code") == "" {
+		fail("Sign-in expired, please try again.")
+		return
+	}
+
+	org, err := h.Store.GetOrg(r.Context(), st.OrganizationID)
+	if err != nil || org == nil || org.SSO == nil || org.DeletedAt != nil {
+		fail("Single sign-on is not configured.")
+		return
+	}
+	identity, err := h.SSO.Exchange(r.Context(), org.SSO.Issuer, org.SSO.ClientID, org.SSO.ClientSecret, h.SSOCallbackURL, q.Get("code"),
+		sso.AuthRequest{State: st.State, Nonce: st.Nonce, Verifier: st.Verifier})
+	if err != nil {
+		log.Printf("SSOCallback: %s: %v", org.ID, err)
+		fail("Your identity provider's response could not be verified.")
+		return
+	}
+
+	user, err := h.provisionSSOUser(r.Context(), org, identity)
+	if err != nil {
+		log.Printf("SSOCallback: provision %s in %s: %v", identity.Email, org.ID, err)
+		if errors.Is(err, errSSOAccount) {
+			fail("This account cannot sign in through your organization's identity provider.")
+			return
+		}
+		fail("Sign-in failed, please try again.")
+		return
+	}
+
+	code, codeHash := newUserToken()
+	now := time.Now().UTC()
+	if err := h.Store.CreateUserToken(r.Context(), &models.UserToken{
+		TokenHash: codeHash,
+		UserID:    user.ID,
+		Purpose:   "sso-login",
+		ExpiresAt: now.Add(ssoCodeTTL),
+		CreatedAt: now,
+	}); err != nil {
+		fail("Sign-in failed, please try again.")
+		return
+	}
+	http.Redirect(w, r, h.AppURL+"/?sso_code="+url.QueryEscape(code), http.StatusFound)
+}
+
+// SSOExchange trades the one-time code from SSOCallback for a session. The
+// identity provider is responsible for any second factor.
+func (h *AuthHandler) SSOExchange(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	codeHash := hashToken(req.Code)
+	t, err := h.Store.GetUserToken(r.Context(), codeHash)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to verify code")
+		return
+	}
+	if t == nil || t.Purpose != "sso-login" || t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+		writeError(w, http.StatusUnauthorized, "invalid or expired sign-in code")
+		return
+	}
+	if err := h.Store.ConsumeUserToken(r.Context(), codeHash); err != nil {
+		if errors.Is(err, store.ErrTokenUsed) {
+			writeError(w, http.StatusUnauthorized, "invalid or expired sign-in code")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to verify code")
+		return
+	}
+	user, err := h.Store.GetUser(r.Context(), t.UserID)
+	if err != nil || user == nil || user.Invitation != nil || user.DeletedAt != nil {
+		writeError(w, http.StatusUnauthorized, "invalid or expired sign-in code")
+		return
+	}
+	resp, err := h.startSession(r, user)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+var errSSOAccount = errors.New("account is not managed by this identity provider")
+
+// provisionSSOUser returns the user for a verified identity, creating it on
+// first login and applying the role mapping on every login. The identity
+// provider only manages its organization's clients: it picks between the
+// client role and the organization's custom roles, and never reaches staff
+// accounts.
+func (h *AuthHandler) provisionSSOUser(ctx context.Context, org *models.Organization, id *sso.Identity) (*models.User, error) {
+	email := strings.ToLower(id.Email)
+	_, domain, _ := strings.Cut(email, "@")
+	if !ssoDomainMatch(org.SSO, domain) {
+		return nil, errSSOAccount
+	}
+
+	customRole, err := h.ssoCustomRole(ctx, org, id)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.Store.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		name := id.Name
+		if name == "" {
+			name, _, _ = strings.Cut(email, "@")
+		}
+		user = &models.User{
+			ID:             "user-" + generateID(),
+			Name:           name,
+			Email:          email,
+			Role:           policy.Client,
+			OrganizationID: &org.ID,
+			CustomRoleID:   customRole,
+			Avatar:         initialsFromName(name),
+			CreatedAt:      time.Now().UTC(),
+		}
+		if err := h.Store.CreateUser(ctx, user); err != nil {
+			return nil, err
+		}
+		log.Printf("SSO: provisioned %s in %s", user.ID, org.ID)
+		return user, nil
+	}
+
+	// Existing accounts must already be clients of the organization: an IdP
+	// cannot claim another organization's users or change staff accounts.
+	if user.Role != policy.Client || user.OrganizationID == nil || *user.OrganizationID != org.ID ||
+		user.ServiceAccount || user.DeletedAt != nil {
+		return nil, errSSOAccount
+	}
+	// Signing in through the identity provider proves the address as well as
+	// accepting the invitation would, and the invitation can no longer be
+	// revoked afterwards. If it was revoked first, the user is gone.
+	if user.Invitation != nil {
+		if err := h.Store.ClearInvitation(ctx, user.ID); err != nil {
+			if errors.Is(err, store.ErrNotInvited) {
+				return nil, errSSOAccount
+			}
+			return nil, err
+		}
+		log.Printf("SSO: %s accepted their invitation by signing in", user.ID)
+		user.Invitation = nil
+	}
+	if user.CustomRoleID != customRole {
+		if err := h.Store.SetUserCustomRole(ctx, user.ID, customRole); err != nil {
+			return nil, err
+		}
+		log.Printf("SSO: custom role of %s changed from %q to %q", user.ID, user.CustomRoleID, customRole)
+		user.CustomRoleID = customRole
+	}
+	return user, nil
+}
+
+// ssoCustomRole maps the identity's groups to a custom role of the
+// organization, or "" for the plain client role. Mapping values are "client"
+// or custom role IDs (see OrgHandler.checkSSO). Custom roles only take
+// permissions away, so a group mapped to "client" wins; otherwise the mapped
+// role with the most permissions does.
+func (h *AuthHandler) ssoCustomRole(ctx context.Context, org *models.Organization, id *sso.Identity) (string, error) {
+	var mapped []string
+	if org.SSO.RoleClaim != "" {
+		for _, v := range id.ClaimValues(org.SSO.RoleClaim) {
+			if role, ok := org.SSO.RoleMapping[v]; ok {
+				mapped = append(mapped, role)
+			}
+		}
+	}
+	if len(mapped) == 0 {
+		mapped = []string{org.SSO.DefaultRole}
+	}
+
+	var best *models.CustomRole
+	for _, roleID := range mapped {
+		if roleID == "" || roleID == policy.Client {
+			return "", nil
+		}
+		role, err := h.Store.GetCustomRole(ctx, roleID)
+		if err != nil {
+			return "", err
+		}
+		if role == nil || role.OrganizationID != org.ID {
+			continue
+		}
+		if best == nil || len(role.Permissions) > len(best.Permissions) ||
+			(len(role.Permissions) == len(best.Permissions) && role.ID < best.ID) {
+			best = role
+		}
+	}
+	if best == nil {
+		// Every mapped role is gone; falling back to the plain client role
+		// would grant more than the organization configured.
+		return "", errSSOAccount
+	}
+	return best.ID, nil
+}
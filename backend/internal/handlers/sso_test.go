@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/sso"
+	"github.com/supporttickr/backend/internal/sso/mockidp"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+type ssoTest struct {
+	t     *testing.T
+	h     *AuthHandler
+	store *storetest.Memory
+	idp   *mockidp.Provider
+}
+
+func newSSOTest(t *testing.T) *ssoTest {
+	idp, srv := mockidp.NewServer("supportdesk", "secret")
+	t.Cleanup(srv.Close)
+
+	st := newStore(t)
+	ctx := context.Background()
+	st.PutCustomRole(ctx, &models.CustomRole{ID: "role-requester", OrganizationID: testOrg, Name: "Requester",
+		Permissions: []string{string(policy.TicketRead), string(policy.TicketCreate)}})
+	st.CreateOrg(ctx, &models.Organization{ID: testOrg, Name: "Acme", SSO: &models.SSOConfig{
+		Issuer:       idp.Issuer,
+		ClientID:     "supportdesk",
+		ClientSecret: "secret",
+		Domains:      []string{"acme.test"},
+		RoleClaim:    "groups",
+		RoleMapping:  map[string]string{"requesters": "role-requester", "everyone": policy.Client},
+		DefaultRole:  "role-requester",
+	}})
+
+	return &ssoTest{
+		t:     t,
+		store: st,
+		idp:   idp,
+		h: &AuthHandler{
+			Store:          st,
+			JWTSecret:      testSecret,
+			SSO:            sso.NewClient(),
+			SSOCallbackURL: "http://api.test/api/auth/sso/callback",
+			AppURL:         "http://app.test",
+		},
+	}
+}
+
+// signIn runs start, the identity provider and the callback, and returns the
+// app URL the browser is finally sent to.
+func (s *ssoTest) signIn(email string) *url.URL {
+	s.t.Helper()
+
+	rec := call(s.h.SSOStart, anon("GET", ssoLoginURL(testOrg, email), ""))
+	if rec.Code != http.StatusFound {
+		s.t.Fatalf("start: status %d: %s", rec.Code, rec.Body)
+	}
+	cookies := rec.Result().Cookies()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		s.t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		s.t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+
+	req := httptest.NewRequest("GET", resp.Header.Get("Location"), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec = call(s.h.SSOCallback, req)
+	if rec.Code != http.StatusFound {
+		s.t.Fatalf("callback: status %d: %s", rec.Code, rec.Body)
+	}
+	back, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		s.t.Fatalf("callback: %v", err)
+	}
+	return back
+}
+
+func (s *ssoTest) exchange(code string) (*http.Response, *models.LoginResponse) {
+	s.t.Helper()
+	rec := call(s.h.SSOExchange, anon("POST", "/api/auth/sso/exchange", `{"code":"`+code+`"}`))
+	var out models.LoginResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			s.t.Fatalf("exchange: %v", err)
+		}
+	}
+	return rec.Result(), &out
+}
+
+func TestSSOSignIn(t *testing.T) {
+	s := newSSOTest(t)
+	s.idp.Groups["jane@acme.test"] = []string{"requesters"}
+
+	back := s.signIn("Jane@acme.test")
+	code := back.Query().Get("sso_code")
+	if code == "" {
+		t.Fatalf("callback redirected to %s, want an sso_code", back)
+	}
+	resp, login := s.exchange(code)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("exchange: status %d", resp.StatusCode)
+	}
+	if login.Token == "" || login.RefreshToken == "" {
+		t.Fatalf("exchange returned no tokens: %+v", login)
+	}
+	if u := login.User; u.Email != "jane@acme.test" || u.Role != policy.Client || u.OrganizationID == nil || *u.OrganizationID != testOrg {
+		t.Fatalf("exchange returned user %+v", u)
+	}
+	user, _ := s.store.GetUserByEmail(context.Background(), "jane@acme.test")
+	if user == nil || user.CustomRoleID != "role-requester" {
+		t.Fatalf("provisioned user %+v, want custom role role-requester", user)
+	}
+
+	// The code works once.
+	if resp, _ := s.exchange(code); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("second exchange: status %d, want 401", resp.StatusCode)
+	}
+
+	// The mapping is applied again on the next sign-in.
+	s.idp.Groups["jane@acme.test"] = []string{"requesters", "everyone"}
+	if back := s.signIn("jane@acme.test"); back.Query().Get("sso_code") == "" {
+		t.Fatalf("second sign-in redirected to %s", back)
+	}
+	user, _ = s.store.GetUserByEmail(context.Background(), "jane@acme.test")
+	if user.Role != policy.Client || user.CustomRoleID != "" {
+		t.Fatalf("user after remapping %+v, want the plain client role", user)
+	}
+}
+
+func TestSSOLeavesStaffAlone(t *testing.T) {
+	s := newSSOTest(t)
+	staff := newUser("user-staff", policy.SupportLead)
+	staff.Email = "lead@acme.test"
+	s.store.CreateUser(context.Background(), staff)
+	s.idp.Groups["lead@acme.test"] = []string{"everyone"}
+
+	back := s.signIn("lead@acme.test")
+	if back.Query().Get("sso_error") == "" {
+		t.Fatalf("callback redirected to %s, want an sso_error", back)
+	}
+	user, _ := s.store.GetUser(context.Background(), "user-staff")
+	if user.Role != policy.SupportLead || user.OrganizationID != nil {
+		t.Fatalf("staff user changed to %+v", user)
+	}
+}
+
+func TestSSORequiresVerifiedEmail(t *testing.T) {
+	s := newSSOTest(t)
+	ctx := context.Background()
+	acme := testOrg
+	s.store.CreateUser(ctx, &models.User{ID: "user-jane", Email: "jane@acme.test", Role: policy.Client, OrganizationID: &acme})
+	s.idp.Unverified["jane@acme.test"] = true
+	s.idp.Unverified["new@acme.test"] = true
+
+	for _, email := range []string{"jane@acme.test", "new@acme.test"} {
+		if back := s.signIn(email); back.Query().Get("sso_error") == "" || back.Query().Get("sso_code") != "" {
+			t.Errorf("%s without email_verified: redirected to %s, want an sso_error", email, back)
+		}
+	}
+	if u, _ := s.store.GetUserByEmail(ctx, "new@acme.test"); u != nil {
+		t.Errorf("created %+v for an unverified address", u)
+	}
+}
+
+func TestCheckSSORoles(t *testing.T) {
+	s := newSSOTest(t)
+	h := &OrgHandler{Store: s.store}
+	for role, ok := range map[string]bool{
+		policy.Client:        true,
+		"role-requester":     true,
+		policy.SupportStaff:  false,
+		policy.SupportLead:   false,
+		policy.Admin:         false,
+		"role-of-other-orgs": false,
+	} {
+		cfg := &models.SSOConfig{Issuer: "https://idp.acme.test", ClientID: "c", ClientSecret: "s",
+			Domains: []string{"acme.test"}, RoleClaim: "groups", RoleMapping: map[string]string{"g": role}}
+		if msg := h.checkSSO(context.Background(), testOrg, cfg, nil); (msg == "") != ok {
+			t.Errorf("roleMapping to %q: got %q", role, msg)
+		}
+		cfg = &models.SSOConfig{Issuer: "https://idp.acme.test", ClientID: "c", ClientSecret: "s",
+			Domains: []string{"acme.test"}, DefaultRole: role}
+		if msg := h.checkSSO(context.Background(), testOrg, cfg, nil); (msg == "") != ok {
+			t.Errorf("defaultRole %q: got %q", role, msg)
+		}
+	}
+}
+
+func TestLoginSSORedirect(t *testing.T) {
+	s := newSSOTest(t)
+	ctx := context.Background()
+	acme, other := testOrg, "org-other"
+	s.store.CreateUser(ctx, &models.User{ID: "user-lead", Email: "lead@acme.test", Role: policy.SupportLead})
+	s.store.CreateUser(ctx, &models.User{ID: "user-jane", Email: "jane@acme.test", Role: policy.Client, OrganizationID: &acme})
+	s.store.CreateUser(ctx, &models.User{ID: "user-joe", Email: "joe@acme.test", Role: policy.Client, OrganizationID: &other})
+
+	for email, redirect := range map[string]bool{
+		"new@acme.test":  true,
+		"Jane@acme.test": true,
+		"lead@acme.test": false,
+		"joe@acme.test":  false,
+		"jim@other.test": false,
+	} {
+		rec := call(s.h.Login, anon("POST", "/api/auth/login", `{"email":"`+email+`"}`))
+		var out models.SSORedirect
+		json.NewDecoder(rec.Body).Decode(&out)
+		if out.SSORequired != redirect {
+			t.Errorf("login %s: status %d, ssoRequired %v, want %v", email, rec.Code, out.SSORequired, redirect)
+		}
+	}
+}
+
+func TestSSOAcceptsInvitation(t *testing.T) {
+	s := newSSOTest(t)
+	ctx := context.Background()
+	acme := testOrg
+	admin := &models.User{ID: "user-admin", Email: "admin@support.test", Role: policy.Admin}
+	invited := &models.User{ID: "user-jane", Email: "jane@acme.test", Role: policy.Client, OrganizationID: &acme,
+		CustomRoleID: "role-requester", CreatedAt: time.Now()}
+	token := newInvitation(invited, admin.ID, invited.CreatedAt)
+	s.store.CreateUser(ctx, admin)
+	s.store.CreateUser(ctx, invited)
+	s.idp.Groups["jane@acme.test"] = []string{"requesters"}
+
+	back := s.signIn("jane@acme.test")
+	if resp, _ := s.exchange(back.Query().Get("sso_code")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("exchange: status %d", resp.StatusCode)
+	}
+	if u, _ := s.store.GetUser(ctx, invited.ID); u == nil || u.Invitation != nil {
+		t.Fatalf("user after signing in %+v, want the invitation gone", u)
+	}
+
+	// The invitation can no longer be revoked, nor its link used.
+	h := &InvitationHandler{Store: s.store}
+	rec := call(h.Revoke, asCaller("DELETE", "/api/invitations/"+invited.ID, "", admin), "id", invited.ID)
+	if rec.Code == http.StatusOK {
+		t.Fatalf("revoke after signing in: status %d", rec.Code)
+	}
+	if u, _ := s.store.GetUser(ctx, invited.ID); u == nil {
+		t.Fatal("revoke deleted a user who had signed in")
+	}
+	if err := s.store.RevokeInvitation(ctx, invited.ID); err == nil {
+		t.Fatal("RevokeInvitation succeeded for a user who had signed in")
+	}
+	if u, _ := s.h.invitedUser(ctx, token); u != nil {
+		t.Fatal("the invitation link still works")
+	}
+}
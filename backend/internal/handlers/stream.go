@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/realtime"
+)
+
+type StreamHandler struct {
+	Broker realtime.Broker
+	// Users is re-read every Recheck so that role changes, organization moves,
+	// deletions and revoked sessions apply to open streams.
+	Users   middleware.UserLookup
+	Recheck time.Duration
+	// Heartbeat is how often a comment is sent to keep idle connections open.
+	Heartbeat time.Duration
+}
+
+// Stream sends ticket events as Server-Sent Events, filtered to what the
+// caller may see. Clients resume with the Last-Event-ID header (or the
+// lastEventId query parameter); a "reset" event means events were missed and
+// the client should reload. The stream ends once the caller may no longer
+// read tickets.
+func (h *StreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	viewer := streamViewer(r.Context())
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "streaming is not supported by this deployment")
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	sub := h.Broker.Subscribe(lastID)
+	defer sub.Close()
+
+	// The stream outlives the server's write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	if sub.Missed {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for i := range sub.Replay {
+		if sub.Replay[i].VisibleTo(viewer) {
+			writeEvent(w, &sub.Replay[i])
+		}
+	}
+	flusher.Flush()
+
+	interval := h.Heartbeat
+	if interval <= 0 {
+		interval = 25 * time.Second
+	}
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+	recheck := h.Recheck
+	if recheck <= 0 {
+		recheck = 30 * time.Second
+	}
+	reauth := time.NewTicker(recheck)
+	defer reauth.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and replays.
+				return
+			}
+			if !m.VisibleTo(viewer) {
+				continue
+			}
+			writeEvent(w, &m)
+		case <-reauth.C:
+			if h.Users == nil {
+				continue
+			}
+			ctx, err := middleware.Reauthorize(r.Context(), h.Users)
+			if err != nil || !middleware.HasPermission(ctx, policy.TicketRead) {
+				// Reconnecting runs the full authentication again.
+				return
+			}
+			viewer = streamViewer(ctx)
+			continue
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func streamViewer(ctx context.Context) realtime.Viewer {
+	return realtime.Viewer{
+		OrganizationID:  middleware.GetOrgID(ctx),
+		AnyOrganization: middleware.HasPermission(ctx, policy.AnyOrganization),
+		Internal:        middleware.HasPermission(ctx, policy.TicketInternal),
+	}
+}
+
+func writeEvent(w http.ResponseWriter, m *realtime.Message) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", m.ID, m.Type, data)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/realtime"
+)
+
+// streamEvents opens the stream as u, resuming after lastID, and returns the
+// IDs of the events sent before it is closed, with "reset" for a reset.
+func streamEvents(t *testing.T, h *StreamHandler, u *models.User, lastID string) []string {
+	t.Helper()
+	r := asCaller("GET", "/api/stream", "", u)
+	if lastID != "" {
+		r.Header.Set("Last-Event-ID", lastID)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 50*time.Millisecond)
+	defer cancel()
+	rec := call(h.Stream, r.WithContext(ctx))
+
+	var got []string
+	for _, m := range regexp.MustCompile(`(?m)^(?:id: (\S+)|event: (reset))$`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		got = append(got, m[1]+m[2])
+	}
+	return got
+}
+
+func TestStreamReplay(t *testing.T) {
+	broker := realtime.NewMemoryBroker(10)
+	ctx := context.Background()
+	for _, m := range []realtime.Message{
+		{ID: "evt-1", Type: realtime.TicketCreated, OrganizationID: testOrg},
+		{ID: "evt-2", Type: realtime.TicketCreated, OrganizationID: "org-globex"},
+		{ID: "evt-3", Type: realtime.MessageAdded, OrganizationID: testOrg, Internal: true},
+		{ID: "evt-4", Type: realtime.MessageAdded, OrganizationID: testOrg},
+	} {
+		broker.Publish(ctx, m)
+	}
+	h := &StreamHandler{Broker: broker, Heartbeat: time.Hour}
+	client := newUser("user-cal", policy.Client)
+	agent := newUser("user-jane", policy.SupportStaff)
+
+	for _, tc := range []struct {
+		name   string
+		user   *models.User
+		lastID string
+		want   []string
+	}{
+		{"agent resumes", agent, "evt-1", []string{"evt-2", "evt-3", "evt-4"}},
+		{"client sees only public events of their organization", client, "evt-1", []string{"evt-4"}},
+		{"nothing after the last event", agent, "evt-4", nil},
+		{"unknown last event", client, "evt-0", []string{"reset"}},
+		{"new connection", agent, "", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := streamEvents(t, h, tc.user, tc.lastID); !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,23 +1,28 @@
 package handlers
 
 import (
-	"fmt"
+	"log"
+	"mime"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/blob"
+	"github.com/supporttickr/backend/internal/events"
 	"github.com/supporttickr/backend/internal/middleware"
 	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/policy"
 	"github.com/supporttickr/backend/internal/store"
 )
 
 type TicketHandler struct {
-	Store store.Store
+	Store  store.Store
+	Blobs  blob.Storage
+	Outbox *outbox.Relay
 }
 
 func (h *TicketHandler) List(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetRole(r.Context())
-	orgID := middleware.GetOrgID(r.Context())
 	q := r.URL.Query()
 
 	status := q.Get("status")
@@ -27,8 +32,13 @@ func (h *TicketHandler) List(w http.ResponseWriter, r *http.Request) {
 	assignedTo := q.Get("assignedTo")
 	search := q.Get("search")
 
-	if role == "client" && orgID != "" {
-		organizationID = orgID
+	own, ok := orgFilter(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusOK, []models.TicketResponse{})
+		return
+	}
+	if own != "" {
+		organizationID = own
 	}
 
 	tickets, err := h.Store.ListTickets(r.Context(), status, priority, category, organizationID, assignedTo, search)
@@ -49,8 +59,6 @@ func (h *TicketHandler) List(w http.ResponseWriter, r *http.Request) {
 
 func (h *TicketHandler) Get(w http.ResponseWriter, r *http.Request) {
 	ticketID := r.PathValue("id")
-	role := middleware.GetRole(r.Context())
-	orgID := middleware.GetOrgID(r.Context())
 
 	t, err := h.Store.GetTicket(r.Context(), ticketID)
 	if err != nil || t == nil {
@@ -58,7 +66,7 @@ func (h *TicketHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if role == "client" && t.OrganizationID != orgID {
+	if !canAccessOrg(r.Context(), t.OrganizationID) {
 		writeError(w, http.StatusForbidden, "access denied")
 		return
 	}
@@ -66,8 +74,9 @@ func (h *TicketHandler) Get(w http.ResponseWriter, r *http.Request) {
 	resp := t.ToResponse()
 
 	messages, _ := h.Store.GetMessagesByTicketID(r.Context(), ticketID)
+	internalVisible := middleware.HasPermission(r.Context(), policy.TicketInternal)
 	for _, m := range messages {
-		if role == "client" && m.IsInternal {
+		if m.IsInternal && !internalVisible {
 			continue
 		}
 		resp.Messages = append(resp.Messages, m)
@@ -81,13 +90,31 @@ func (h *TicketHandler) Get(w http.ResponseWriter, r *http.Request) {
 		resp.ConversionRequest = cr
 	}
 
+	watchers, _ := h.Store.ListWatchers(r.Context(), ticketID)
+	if watchers != nil {
+		resp.Watchers = watchers
+	}
+
+	attachments, _ := h.Store.ListAttachmentsByTicketID(r.Context(), ticketID)
+	internalMsgs := map[string]bool{}
+	for _, m := range messages {
+		if m.IsInternal {
+			internalMsgs[m.ID] = true
+		}
+	}
+	for _, a := range attachments {
+		if internalMsgs[a.MessageID] && !internalVisible {
+			continue
+		}
+		resp.Attachments = append(resp.Attachments, a)
+	}
+
 	writeJSON(w, http.StatusOK, resp)
 }
 
 func (h *TicketHandler) Create(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r.Context())
 	orgID := middleware.GetOrgID(r.Context())
-	role := middleware.GetRole(r.Context())
 
 	var req models.CreateTicketRequest
 	if err := decodeJSON(r, &req); err != nil {
@@ -100,7 +127,7 @@ func (h *TicketHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if role == "client" {
+	if !middleware.HasPermission(r.Context(), policy.AnyOrganization) {
 		req.OrganizationID = orgID
 	}
 	if req.OrganizationID == "" {
@@ -131,19 +158,14 @@ func (h *TicketHandler) Create(w http.ResponseWriter, r *http.Request) {
 		CreatedAt:      now,
 		UpdatedAt:      now,
 	}
-	if err := h.Store.CreateTicket(r.Context(), t); err != nil {
+	msgs := outbox.Messages(events.TicketCreated{Ticket: t, ActorID: userID, Via: "web"})
+	if err := h.Store.CreateTicket(r.Context(), t, msgs...); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create ticket: "+err.Error())
 		return
 	}
 
-	_ = h.Store.CreateActivity(r.Context(), &models.ActivityItem{
-		ID:          "act-" + uuid.NewString()[:8],
-		Type:        "ticket-created",
-		Description: "New ticket: " + req.Title,
-		UserID:      userID,
-		TicketID:    &ticketID,
-		CreatedAt:   now,
-	})
+	h.watch(r, ticketID, userID, "creator")
+	h.Outbox.Flush(r.Context(), msgs)
 
 	resp := t.ToResponse()
 	writeJSON(w, http.StatusCreated, resp)
@@ -152,15 +174,13 @@ func (h *TicketHandler) Create(w http.ResponseWriter, r *http.Request) {
 func (h *TicketHandler) Update(w http.ResponseWriter, r *http.Request) {
 	ticketID := r.PathValue("id")
 	userID := middleware.GetUserID(r.Context())
-	role := middleware.GetRole(r.Context())
-	orgID := middleware.GetOrgID(r.Context())
 
 	t, err := h.Store.GetTicket(r.Context(), ticketID)
 	if err != nil || t == nil {
 		writeError(w, http.StatusNotFound, "ticket not found")
 		return
 	}
-	if role == "client" && t.OrganizationID != orgID {
+	if !canAccessOrg(r.Context(), t.OrganizationID) {
 		writeError(w, http.StatusForbidden, "access denied")
 		return
 	}
@@ -170,41 +190,64 @@ func (h *TicketHandler) Update(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	if req.AssignTo != nil && !middleware.HasPermission(r.Context(), policy.TicketAssign) {
+		writeError(w, http.StatusForbidden, "you cannot assign tickets")
+		return
+	}
+	if req.AssignTo != nil && *req.AssignTo != "" {
+		u, err := h.Store.GetUser(r.Context(), *req.AssignTo)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load assignee")
+			return
+		}
+		if u == nil || u.DeletedAt != nil || !policy.Allowed(u.Role, policy.TicketAssign) {
+			writeError(w, http.StatusBadRequest, "assignee cannot be assigned tickets")
+			return
+		}
+	}
 
+	after := *t
 	if req.Status != nil {
-		_ = h.Store.UpdateTicket(r.Context(), ticketID, req.Status, nil, nil, nil)
-		actType := "ticket-updated"
-		if *req.Status == "resolved" {
-			actType = "ticket-resolved"
-		}
-		_ = h.Store.CreateActivity(r.Context(), &models.ActivityItem{
-			ID:          "act-" + uuid.NewString()[:8],
-			Type:        actType,
-			Description: fmt.Sprintf("Ticket %s status changed to %s", ticketID, *req.Status),
-			UserID:      userID,
-			TicketID:    &ticketID,
-			CreatedAt:   time.Now().UTC(),
-		})
+		after.Status = *req.Status
 	}
 	if req.Priority != nil {
-		_ = h.Store.UpdateTicket(r.Context(), ticketID, nil, req.Priority, nil, nil)
+		after.Priority = *req.Priority
 	}
 	if req.AssignTo != nil {
-		empty := ""
-		assignTo := *req.AssignTo
-		if assignTo == "" {
-			_ = h.Store.UpdateTicket(r.Context(), ticketID, nil, nil, &empty, nil)
-		} else {
-			_ = h.Store.UpdateTicket(r.Context(), ticketID, nil, nil, &assignTo, nil)
+		after.AssignedTo = nil
+		if *req.AssignTo != "" {
+			assignTo := *req.AssignTo
+			after.AssignedTo = &assignTo
 		}
 	}
+	if req.Tags != nil {
+		after.Tags = normalizeTags(*req.Tags)
+	}
+	after.UpdatedAt = time.Now().UTC()
+
+	// The changes and their events are written together.
+	msgs := outbox.Messages(events.TicketChanges(t, &after, userID)...)
+	if req.Status != nil || req.Priority != nil || req.AssignTo != nil || req.Tags != nil {
+		var tags []string
+		if req.Tags != nil {
+			tags = after.Tags
+		}
+		if err := h.Store.ApplyTicketChanges(r.Context(), ticketID, nil, req.Status, req.Priority, req.AssignTo, tags, msgs...); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to update ticket")
+			return
+		}
+	}
+	// Only a new assignee follows the ticket; one who unwatched stays off.
+	if after.AssignedTo != nil && (t.AssignedTo == nil || *t.AssignedTo != *after.AssignedTo) {
+		h.watch(r, ticketID, *after.AssignedTo, "assignee")
+	}
+	h.Outbox.Flush(r.Context(), msgs)
 
 	updated, _ := h.Store.GetTicket(r.Context(), ticketID)
-	if updated != nil {
-		writeJSON(w, http.StatusOK, updated.ToResponse())
-		return
+	if updated == nil {
+		updated = &after
 	}
-	writeJSON(w, http.StatusOK, t.ToResponse())
+	writeJSON(w, http.StatusOK, updated.ToResponse())
 }
 
 func (h *TicketHandler) AddMessage(w http.ResponseWriter, r *http.Request) {
@@ -216,6 +259,10 @@ func (h *TicketHandler) AddMessage(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "ticket not found")
 		return
 	}
+	if !canAccessOrg(r.Context(), t.OrganizationID) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
 
 	var req models.CreateMessageRequest
 	if err := decodeJSON(r, &req); err != nil {
@@ -227,6 +274,10 @@ func (h *TicketHandler) AddMessage(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "content is required")
 		return
 	}
+	if req.IsInternal && !middleware.HasPermission(r.Context(), policy.TicketInternal) {
+		writeError(w, http.StatusForbidden, "you cannot add internal notes")
+		return
+	}
 
 	msgID := "msg-" + uuid.NewString()[:8]
 	now := time.Now().UTC()
@@ -239,21 +290,18 @@ func (h *TicketHandler) AddMessage(w http.ResponseWriter, r *http.Request) {
 		IsInternal: req.IsInternal,
 		CreatedAt:  now,
 	}
-	if err := h.Store.AddMessage(r.Context(), m); err != nil {
+	msgs := outbox.Messages(events.MessageAdded{Ticket: t, Message: m, Via: "web"})
+	if err := h.Store.AddMessage(r.Context(), m, msgs...); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to add message")
 		return
 	}
 
-	_ = h.Store.UpdateTicket(r.Context(), ticketID, nil, nil, nil, nil) // updates updated_at
+	if err := h.Store.UpdateTicket(r.Context(), ticketID, nil, nil, nil, nil); err != nil { // updates updated_at
+		log.Printf("AddMessage: touch %s: %v", ticketID, err)
+	}
 
-	_ = h.Store.CreateActivity(r.Context(), &models.ActivityItem{
-		ID:          "act-" + uuid.NewString()[:8],
-		Type:        "message-added",
-		Description: "New message on " + ticketID,
-		UserID:      userID,
-		TicketID:    &ticketID,
-		CreatedAt:   now,
-	})
+	h.watch(r, ticketID, userID, "commenter")
+	h.Outbox.Flush(r.Context(), msgs)
 
 	writeJSON(w, http.StatusCreated, map[string]string{"id": msgID})
 }
@@ -267,6 +315,10 @@ func (h *TicketHandler) AddTimeEntry(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "ticket not found")
 		return
 	}
+	if !canAccessOrg(r.Context(), t.OrganizationID) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
 
 	var req models.CreateTimeEntryRequest
 	if err := decodeJSON(r, &req); err != nil {
@@ -297,7 +349,10 @@ func (h *TicketHandler) AddTimeEntry(w http.ResponseWriter, r *http.Request) {
 	}
 
 	newHours := t.HoursWorked + req.Hours
-	_ = h.Store.UpdateTicket(r.Context(), ticketID, nil, nil, nil, &newHours)
+	if err := h.Store.UpdateTicket(r.Context(), ticketID, nil, nil, nil, &newHours); err != nil {
+		writeError(w, http.StatusInternalServerError, "time entry added but the ticket's hours were not updated")
+		return
+	}
 
 	writeJSON(w, http.StatusCreated, map[string]string{"id": teID})
 }
@@ -311,6 +366,10 @@ func (h *TicketHandler) RequestConversion(w http.ResponseWriter, r *http.Request
 		writeError(w, http.StatusNotFound, "ticket not found")
 		return
 	}
+	if !canAccessOrg(r.Context(), t.OrganizationID) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
 
 	var req models.ConversionRequestBody
 	if err := decodeJSON(r, &req); err != nil {
@@ -336,19 +395,65 @@ func (h *TicketHandler) RequestConversion(w http.ResponseWriter, r *http.Request
 		ProposedBy:       userID,
 		CreatedAt:        now,
 	}
-	if err := h.Store.CreateConversionRequest(r.Context(), cr); err != nil {
+	msgs := outbox.Messages(events.ConversionRequested{Ticket: t, Request: cr})
+	if err := h.Store.CreateConversionRequest(r.Context(), cr, msgs...); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create conversion request: "+err.Error())
 		return
 	}
 
-	_ = h.Store.CreateActivity(r.Context(), &models.ActivityItem{
-		ID:          "act-" + uuid.NewString()[:8],
-		Type:        "conversion-requested",
-		Description: fmt.Sprintf("Conversion requested: %s to %s", ticketID, req.ProposedType),
-		UserID:      userID,
-		TicketID:    &ticketID,
-		CreatedAt:   now,
-	})
+	h.Outbox.Flush(r.Context(), msgs)
 
 	writeJSON(w, http.StatusCreated, map[string]string{"id": crID})
 }
+
+func (h *TicketHandler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	ticketID := r.PathValue("id")
+
+	t, err := h.Store.GetTicket(r.Context(), ticketID)
+	if err != nil || t == nil {
+		writeError(w, http.StatusNotFound, "ticket not found")
+		return
+	}
+	if !canAccessOrg(r.Context(), t.OrganizationID) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	a, err := h.Store.GetAttachment(r.Context(), ticketID, r.PathValue("attachmentId"))
+	if err != nil || a == nil {
+		writeError(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	if a.MessageID != "" && !middleware.HasPermission(r.Context(), policy.TicketInternal) {
+		messages, _ := h.Store.GetMessagesByTicketID(r.Context(), ticketID)
+		for _, m := range messages {
+			if m.ID == a.MessageID && m.IsInternal {
+				writeError(w, http.StatusNotFound, "attachment not found")
+				return
+			}
+		}
+	}
+	data, err := h.Blobs.Get(r.Context(), a.StorageKey)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "attachment content not found")
+		return
+	}
+
+	// The content type comes from whoever sent the file, so never let the
+	// browser render it in the app's origin.
+	contentType := a.ContentType
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		contentType = "application/octet-stream"
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Content-Length", itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store"
+	"github.com/supporttickr/backend/internal/totp"
+)
+
+// totpIssuer is the account name shown in authenticator apps.
+const totpIssuer = "SupportFix"
+
+// challengeTTL is how long the user has to enter a code after the password.
+const challengeTTL = 5 * time.Minute
+
+const recoveryCodeCount = 10
+
+// challengeClaims identify a login that passed the password check but still
+// needs a second factor or a new password, as told by Purpose. They are
+// signed with a key derived from the JWT secret so they can never be used as
+// access tokens.
+type challengeClaims struct {
+	TokenVersion int    `json:"tv"`
+	Purpose      string `json:"pur"`
+	jwt.RegisteredClaims
+}
+
+const (
+	challengeTwoFactor   = "two-factor"
+	challengeSetPassword = "set-password"
+)
+
+func (h *AuthHandler) challengeKey() []byte {
+	return []byte(h.JWTSecret + "/two-factor")
+}
+
+// twoFactorRequired reports whether the user's organization requires two-factor.
+func (h *AuthHandler) twoFactorRequired(ctx context.Context, user *models.User) (bool, error) {
+	if user.OrganizationID == nil || *user.OrganizationID == "" {
+		return false, nil
+	}
+	org, err := h.Store.GetOrg(ctx, *user.OrganizationID)
+	if err != nil {
+		return false, err
+	}
+	return org != nil && org.RequireTwoFactor, nil
+}
+
+func (h *AuthHandler) issueChallenge(user *models.User, purpose string) (string, error) {
+	now := time.Now()
+	claims := &challengeClaims{
+		TokenVersion: user.TokenVersion,
+		Purpose:      purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(challengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(h.challengeKey())
+}
+
+// challengeUser returns the user of a valid challenge token for purpose, or nil.
+func (h *AuthHandler) challengeUser(ctx context.Context, tokenStr, purpose string) (*models.User, error) {
+	claims := &challengeClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		return h.challengeKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+	if err != nil || !token.Valid || claims.Purpose != purpose {
+		return nil, nil
+	}
+	user, err := h.Store.GetUser(ctx, claims.Subject)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	if user.DeletedAt != nil || user.TokenVersion != claims.TokenVersion {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// VerifyTwoFactor completes a login with a code from the authenticator app or
+// a recovery code. During required setup it also confirms the new secret and
+// returns the recovery codes.
+func (h *AuthHandler) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req models.TwoFactorVerifyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		writeError(w, http.StatusBadRequest, "code or recoveryCode is required")
+		return
+	}
+	user, err := h.challengeUser(r.Context(), req.TwoFactorToken, challengeTwoFactor)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, "invalid or expired two-factor token")
+		return
+	}
+	if h.twoFactorThrottled(w, r, user) {
+		return
+	}
+
+	var recoveryCodes []string
+	switch {
+	case user.TwoFactorEnabled() && req.RecoveryCode != "":
+		err = h.Store.UseRecoveryCode(r.Context(), user.ID, hashToken(normalizeRecoveryCode(req.RecoveryCode)))
+		if errors.Is(err, store.ErrTokenUsed) {
+			h.twoFactorFailed(r, user)
+			writeError(w, http.StatusUnauthorized, "invalid recovery code")
+			return
+		}
+	case user.TwoFactorEnabled():
+		err = h.useCode(r.Context(), user, req.Code)
+		if errors.Is(err, errInvalidCode) {
+			h.twoFactorFailed(r, user)
+			writeError(w, http.StatusUnauthorized, "invalid code")
+			return
+		}
+	default:
+		recoveryCodes, err = h.confirmEnrollment(r.Context(), user, req.Code)
+		if errors.Is(err, errInvalidCode) {
+			h.twoFactorFailed(r, user)
+			writeError(w, http.StatusUnauthorized, "invalid code")
+			return
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to verify code")
+		return
+	}
+	h.twoFactorPassed(r.Context(), user)
+
+	resp, err := h.startSession(r, user)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	resp.RecoveryCodes = recoveryCodes
+	resp.User.TwoFactorEnabled = true
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// SetupTwoFactor starts enrollment for a user whose organization requires
+// two-factor, using the token Login returned instead of a session.
+func (h *AuthHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req models.TwoFactorVerifyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	user, err := h.challengeUser(r.Context(), req.TwoFactorToken, challengeTwoFactor)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, "invalid or expired two-factor token")
+		return
+	}
+	h.beginEnrollment(w, r, user)
+}
+
+// EnrollTwoFactor starts enrollment for the signed-in user. The returned
+// secret is not active until ConfirmTwoFactor.
+func (h *AuthHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := h.Store.GetUser(r.Context(), middleware.GetUserID(r.Context()))
+	if err != nil || user == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	h.beginEnrollment(w, r, user)
+}
+
+func (h *AuthHandler) beginEnrollment(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if user.TwoFactorEnabled() {
+		writeError(w, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+	secret := totp.NewSecret()
+	if err := h.Store.SetPendingTOTP(r.Context(), user.ID, secret); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start two-factor setup")
+		return
+	}
+	writeJSON(w, http.StatusOK, models.TwoFactorSetup{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor enables two-factor for the signed-in user once they enter a
+// code from the new secret, and returns their recovery codes.
+func (h *AuthHandler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req models.TwoFactorVerifyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	user, err := h.Store.GetUser(r.Context(), middleware.GetUserID(r.Context()))
+	if err != nil || user == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if user.TwoFactorEnabled() {
+		writeError(w, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+	if h.twoFactorThrottled(w, r, user) {
+		return
+	}
+	codes, err := h.confirmEnrollment(r.Context(), user, req.Code)
+	if errors.Is(err, errInvalidCode) {
+		h.twoFactorFailed(r, user)
+		writeError(w, http.StatusBadRequest, "invalid code")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to enable two-factor authentication")
+		return
+	}
+	h.twoFactorPassed(r.Context(), user)
+	writeJSON(w, http.StatusOK, map[string]any{"status": "enabled", "recoveryCodes": codes})
+}
+
+// DisableTwoFactor turns two-factor off for the signed-in user after
+// re-entering their password. Not allowed when the organization requires it.
+func (h *AuthHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	user, err := h.Store.GetUser(r.Context(), middleware.GetUserID(r.Context()))
+	if err != nil || user == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if !h.passwordConfirmed(w, r, user, req.Password, "password is incorrect") {
+		return
+	}
+	required, err := h.twoFactorRequired(r.Context(), user)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load organization")
+		return
+	}
+	if required {
+		writeError(w, http.StatusForbidden, "your organization requires two-factor authentication")
+		return
+	}
+	if err := h.Store.DisableTOTP(r.Context(), user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to disable two-factor authentication")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the signed-in user's recovery codes.
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req models.TwoFactorVerifyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	user, err := h.Store.GetUser(r.Context(), middleware.GetUserID(r.Context()))
+	if err != nil || user == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if !user.TwoFactorEnabled() {
+		writeError(w, http.StatusBadRequest, "two-factor authentication is not enabled")
+		return
+	}
+	if h.twoFactorThrottled(w, r, user) {
+		return
+	}
+	if err := h.useCode(r.Context(), user, req.Code); err != nil {
+		if errors.Is(err, errInvalidCode) {
+			h.twoFactorFailed(r, user)
+			writeError(w, http.StatusBadRequest, "invalid code")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to verify code")
+		return
+	}
+	h.twoFactorPassed(r.Context(), user)
+	codes, hashes := newRecoveryCodes()
+	if err := h.Store.SetRecoveryCodes(r.Context(), user.ID, hashes); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update recovery codes")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"recoveryCodes": codes})
+}
+
+var errInvalidCode = errors.New("invalid code")
+
+// useCode checks a code against the user's active secret. Each code is
+// accepted once.
+func (h *AuthHandler) useCode(ctx context.Context, user *models.User, code string) error {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return errInvalidCode
+	}
+	if err := h.Store.UseTOTPStep(ctx, user.ID, step); err != nil {
+		if errors.Is(err, store.ErrTokenUsed) {
+			return errInvalidCode
+		}
+		return err
+	}
+	return nil
+}
+
+// confirmEnrollment activates the pending secret if code matches it, and
+// returns the new recovery codes.
+func (h *AuthHandler) confirmEnrollment(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.TOTPPendingSecret == "" {
+		return nil, errInvalidCode
+	}
+	step, ok := totp.Validate(user.TOTPPendingSecret, code, time.Now())
+	if !ok {
+		return nil, errInvalidCode
+	}
+	codes, hashes := newRecoveryCodes()
+	if err := h.Store.EnableTOTP(ctx, user.ID, user.TOTPPendingSecret, step, hashes); err != nil {
+		if errors.Is(err, store.ErrTokenUsed) {
+			return nil, errInvalidCode
+		}
+		return nil, err
+	}
+	log.Printf("two-factor enabled for user %s", user.ID)
+	return codes, nil
+}
+
+// newRecoveryCodes returns codes like "4f1c2-9ab07" and their hashes.
+func newRecoveryCodes() (codes, hashes []string) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		rand.Read(b)
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/totp"
+)
+
+// totpCode returns the code an authenticator app shows for secret at time
+// step step (RFC 6238).
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestVerifyTwoFactor(t *testing.T) {
+	secret := totp.NewSecret()
+	step := time.Now().Unix() / 30
+	const recovery = "4f1c2-9ab07"
+
+	for _, tc := range []struct {
+		name      string
+		pending   bool // the secret is still being enrolled
+		deleted   bool
+		lastStep  int64 // step of the last accepted code
+		failures  int
+		ago       time.Duration
+		lockedFor time.Duration
+		token     string // challenge token, if not a valid one
+		code      string
+		recovery  string
+
+		wantCode     int
+		wantFailures int
+		wantLocked   bool
+	}{
+		{name: "valid code", code: totpCode(t, secret, step), wantCode: http.StatusOK},
+		{name: "code too old", code: totpCode(t, secret, step-3), wantCode: http.StatusUnauthorized, wantFailures: 1},
+		{name: "code used before", lastStep: step, code: totpCode(t, secret, step), wantCode: http.StatusUnauthorized, wantFailures: 1},
+		{name: "wrong code", code: "000000", wantCode: http.StatusUnauthorized, wantFailures: 1},
+		{name: "valid code clears failures", failures: 2, code: totpCode(t, secret, step), wantCode: http.StatusOK},
+		{name: "delay after free attempts", failures: 3, code: totpCode(t, secret, step), wantCode: http.StatusTooManyRequests, wantFailures: 3},
+		{name: "lock at limit", failures: 4, ago: time.Minute, code: "000000", wantCode: http.StatusUnauthorized, wantFailures: 5, wantLocked: true},
+		{name: "locked", failures: 5, ago: time.Minute, lockedFor: 10 * time.Minute, code: totpCode(t, secret, step), wantCode: http.StatusTooManyRequests, wantFailures: 5, wantLocked: true},
+		{name: "recovery code", recovery: "4F1C2 9AB07", wantCode: http.StatusOK},
+		{name: "unknown recovery code", recovery: "00000-00000", wantCode: http.StatusUnauthorized, wantFailures: 1},
+		{name: "enrollment", pending: true, code: totpCode(t, secret, step), wantCode: http.StatusOK},
+		{name: "enrollment with wrong code", pending: true, code: "000000", wantCode: http.StatusUnauthorized, wantFailures: 1},
+		{name: "invalid challenge", token: "not-a-token", code: totpCode(t, secret, step), wantCode: http.StatusUnauthorized},
+		{name: "deleted user", deleted: true, code: totpCode(t, secret, step), wantCode: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, st := newAuthTest(t, func(u *models.User) {
+				if tc.deleted {
+					now := time.Now()
+					u.DeletedAt = &now
+				}
+				if tc.pending {
+					u.TOTPPendingSecret = secret
+					return
+				}
+				u.TOTPSecret = secret
+				u.TOTPLastStep = tc.lastStep
+				u.RecoveryCodes = []string{hashToken(normalizeRecoveryCode(recovery))}
+			})
+			ctx := context.Background()
+			user, _ := st.GetUser(ctx, "user-jane")
+			key := twoFactorAttemptKey(user.ID)
+			seedFailures(st, key, tc.failures, tc.ago, tc.lockedFor)
+
+			token := tc.token
+			if token == "" {
+				var err error
+				if token, err = h.issueChallenge(user, challengeTwoFactor); err != nil {
+					t.Fatal(err)
+				}
+			}
+			body, _ := json.Marshal(models.TwoFactorVerifyRequest{TwoFactorToken: token, Code: tc.code, RecoveryCode: tc.recovery})
+			rec := call(h.VerifyTwoFactor, anon("POST", "/api/auth/2fa/verify", string(body)))
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.wantCode, rec.Body)
+			}
+
+			after, _ := st.GetUser(ctx, user.ID)
+			if rec.Code == http.StatusOK {
+				var resp models.LoginResponse
+				json.NewDecoder(rec.Body).Decode(&resp)
+				if resp.Token == "" || !resp.User.TwoFactorEnabled {
+					t.Errorf("response %+v, want a session with two-factor enabled", resp)
+				}
+				if tc.pending && (len(resp.RecoveryCodes) != recoveryCodeCount || after.TOTPSecret != secret) {
+					t.Errorf("enrollment left secret %q and returned %d recovery codes", after.TOTPSecret, len(resp.RecoveryCodes))
+				}
+				if tc.recovery != "" && len(after.RecoveryCodes) != 0 {
+					t.Errorf("recovery code was not used up")
+				}
+			}
+
+			a, _ := st.GetLoginAttempts(ctx, key)
+			var failures int
+			var locked bool
+			if a != nil {
+				failures = a.Failures
+				locked = a.LockedUntil != nil && a.LockedUntil.After(time.Now())
+			}
+			if failures != tc.wantFailures || locked != tc.wantLocked {
+				t.Errorf("attempts %+v, want %d failures, locked %v", a, tc.wantFailures, tc.wantLocked)
+			}
+		})
+	}
+}
+
+func TestVerifyTwoFactorCodeWorksOnce(t *testing.T) {
+	secret := totp.NewSecret()
+	h, st := newAuthTest(t, func(u *models.User) { u.TOTPSecret = secret })
+	user, _ := st.GetUser(context.Background(), "user-jane")
+
+	code := totpCode(t, secret, time.Now().Unix()/30)
+	for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+		token, _ := h.issueChallenge(user, challengeTwoFactor)
+		body := `{"twoFactorToken":"` + token + `","code":"` + code + `"}`
+		rec := call(h.VerifyTwoFactor, anon("POST", "/api/auth/2fa/verify", body))
+		if rec.Code != want {
+			t.Fatalf("attempt %d: status %d, want %d", i+1, rec.Code, want)
+		}
+	}
+}
@@ -1,21 +1,39 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/supporttickr/backend/internal/events"
 	"github.com/supporttickr/backend/internal/middleware"
 	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/notify"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/password"
+	"github.com/supporttickr/backend/internal/policy"
 	"github.com/supporttickr/backend/internal/store"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UserHandler struct {
 	Store store.Store
+	// Users is the auth middleware's cache; changed users are dropped from it
+	// so the change applies to their next request.
+	Users     *middleware.UserCache
+	Outbox    *outbox.Relay
+	Notifier  *notify.Notifier
+	Passwords password.Policy
 }
 
 func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("deleted") == "true" {
+		h.listDeleted(w, r)
+		return
+	}
 	role := middleware.GetRole(r.Context())
 	orgID := middleware.GetOrgID(r.Context())
 
@@ -30,55 +48,118 @@ func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, users)
 }
 
+// listDeleted lists soft-deleted users that can still be restored.
+func (h *UserHandler) listDeleted(w http.ResponseWriter, r *http.Request) {
+	if !middleware.HasPermission(r.Context(), policy.UserManage) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
+	users, err := h.Store.ListDeletedUsers(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query users")
+		return
+	}
+	resp := make([]models.UserResponse, 0, len(users))
+	for _, u := range users {
+		resp = append(resp, u.ToResponse())
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
 func (h *UserHandler) Get(w http.ResponseWriter, r *http.Request) {
 	userIDParam := r.PathValue("id")
 
 	u, err := h.Store.GetUser(r.Context(), userIDParam)
-	if err != nil || u == nil {
+	if err != nil || u == nil || (u.DeletedAt != nil && !middleware.HasPermission(r.Context(), policy.UserManage)) {
 		writeError(w, http.StatusNotFound, "user not found")
 		return
 	}
-	writeJSON(w, http.StatusOK, u.ToResponse())
-}
-
-func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetRole(r.Context())
-	if role != "admin" {
-		writeError(w, http.StatusForbidden, "admin access required")
+	// Like List: clients see their own organization's users and staff.
+	if u.OrganizationID != nil && !canAccessOrg(r.Context(), *u.OrganizationID) {
+		writeError(w, http.StatusForbidden, "access denied")
 		return
 	}
+	resp := u.ToResponse()
+	if middleware.HasPermission(r.Context(), policy.UserManage) {
+		for _, key := range []string{emailAttemptKey(u.Email), twoFactorAttemptKey(u.ID)} {
+			a, _ := h.Store.GetLoginAttempts(r.Context(), key)
+			if a != nil && a.LockedUntil != nil && a.LockedUntil.After(time.Now()) && (resp.LockedUntil == nil || a.LockedUntil.After(*resp.LockedUntil)) {
+				resp.LockedUntil = a.LockedUntil
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
 
+func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name           string  `json:"name"`
 		Email          string  `json:"email"`
 		Role           string  `json:"role"`
 		OrganizationID *string `json:"organizationId"`
-		Password       *string `json:"password"`
+		// Password is a temporary password the user must change at first
+		// sign-in. Without it the user is invited by email instead.
+		Password *string `json:"password"`
+		Team     string  `json:"team"`
+		// Service accounts have no password or mailbox and sign in with
+		// API tokens only.
+		ServiceAccount bool `json:"serviceAccount"`
+		// CustomRoleID narrows the role to one of the organization's custom roles.
+		CustomRoleID string `json:"customRoleId"`
 	}
 	if err := decodeJSON(r, &input); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	input.Email = store.NormalizeEmail(input.Email)
 
-	if input.Name == "" || input.Email == "" || input.Role == "" {
+	if input.Name == "" || (input.Email == "" && !input.ServiceAccount) || input.Role == "" {
 		writeError(w, http.StatusBadRequest, "name, email, and role are required")
 		return
 	}
-
-	// Default password
-	password := "changeme"
-	if input.Password != nil && *input.Password != "" {
-		password = *input.Password
-	}
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to hash password")
+	if !policy.IsRole(input.Role) {
+		writeError(w, http.StatusBadRequest, "unknown role: "+input.Role)
 		return
 	}
+	if input.CustomRoleID != "" {
+		orgID := ""
+		if input.OrganizationID != nil {
+			orgID = *input.OrganizationID
+		}
+		if msg := checkCustomRole(r.Context(), h.Store, input.CustomRoleID, orgID); msg != "" {
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+	}
 
 	avatar := initialsFromName(input.Name)
 	id := "user-" + generateID()
 
+	var passwordHash []byte
+	invite := false
+	if input.ServiceAccount {
+		if input.Password != nil && *input.Password != "" {
+			writeError(w, http.StatusBadRequest, "service accounts cannot have a password")
+			return
+		}
+		if input.Email == "" {
+			input.Email = id + "@service-accounts.invalid"
+		}
+	} else if input.Password != nil && *input.Password != "" {
+		if err := h.Passwords.Check(*input.Password, input.Email); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var err error
+		passwordHash, err = bcrypt.GenerateFromPassword([]byte(*input.Password), bcrypt.DefaultCost)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to hash password")
+			return
+		}
+	} else {
+		invite = true
+	}
+
 	u := &models.User{
 		ID:             id,
 		Name:           input.Name,
@@ -87,22 +168,35 @@ func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 		Role:           input.Role,
 		OrganizationID: input.OrganizationID,
 		Avatar:         avatar,
+		Team:           input.Team,
+		ServiceAccount: input.ServiceAccount,
+		CustomRoleID:   input.CustomRoleID,
+		// Nobody but the user should know their password for long.
+		PasswordChangeRequired: len(passwordHash) > 0,
+		CreatedAt:              time.Now().UTC(),
+	}
+	var token string
+	if invite {
+		token = newInvitation(u, middleware.GetUserID(r.Context()), u.CreatedAt)
 	}
 	if err := h.Store.CreateUser(r.Context(), u); err != nil {
+		if errors.Is(err, store.ErrEmailTaken) {
+			writeError(w, http.StatusConflict, "a user with this email already exists")
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to create user: "+err.Error())
 		return
 	}
+	if invite {
+		if err := h.Notifier.Invitation(r.Context(), u, token, invitationTTL); err != nil {
+			log.Printf("CreateUser: invite %s: %v", u.ID, err)
+		}
+	}
 
 	writeJSON(w, http.StatusCreated, u.ToResponse())
 }
 
 func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetRole(r.Context())
-	if role != "admin" {
-		writeError(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	userIDParam := r.PathValue("id")
 
 	var input struct {
@@ -110,12 +204,45 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 		Email          *string `json:"email"`
 		Role           *string `json:"role"`
 		OrganizationID *string `json:"organizationId"`
+		Team           *string `json:"team"`
+		// An empty customRoleId removes the custom role.
+		CustomRoleID *string `json:"customRoleId"`
 	}
 	if err := decodeJSON(r, &input); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
+	if input.Role != nil && !policy.IsRole(*input.Role) {
+		writeError(w, http.StatusBadRequest, "unknown role: "+*input.Role)
+		return
+	}
+
+	existing, err := h.Store.GetUser(r.Context(), userIDParam)
+	if err != nil || existing == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	// Admins change addresses directly; users confirm their own (see
+	// AuthHandler.RequestEmailChange). The change signs the user out, so it
+	// is made last, once everything else has been accepted.
+	email := existing.Email
+	if input.Email != nil {
+		email = store.NormalizeEmail(*input.Email)
+		if !strings.Contains(email, "@") {
+			writeError(w, http.StatusBadRequest, "a valid email is required")
+			return
+		}
+		if other, err := h.Store.GetUserByEmail(r.Context(), email); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to look up email")
+			return
+		} else if other != nil && other.ID != existing.ID {
+			writeError(w, http.StatusConflict, "a user with this email already exists")
+			return
+		}
+	}
+
 	var avatar *string
 	if input.Name != nil {
 		a := initialsFromName(*input.Name)
@@ -125,7 +252,7 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 	// Determine organization_id update: clear when role is internal, or when client has none
 	orgIDToUpdate := input.OrganizationID
 	if input.Role != nil {
-		if *input.Role != "client" {
+		if *input.Role != policy.Client {
 			empty := ""
 			orgIDToUpdate = &empty // clear org for admin/support-staff/support-lead
 		} else if input.OrganizationID == nil {
@@ -134,12 +261,62 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if err := h.Store.UpdateUser(r.Context(), userIDParam, input.Name, input.Email, input.Role, orgIDToUpdate, avatar); err != nil {
+	// Custom roles belong to an organization: a requested one must be the
+	// user's (new) organization's, and a user moved elsewhere loses theirs.
+	newOrgID := ""
+	if existing.OrganizationID != nil {
+		newOrgID = *existing.OrganizationID
+	}
+	if orgIDToUpdate != nil {
+		newOrgID = *orgIDToUpdate
+	}
+	customRoleID := existing.CustomRoleID
+	if input.CustomRoleID != nil {
+		customRoleID = *input.CustomRoleID
+		if customRoleID != "" {
+			if msg := checkCustomRole(r.Context(), h.Store, customRoleID, newOrgID); msg != "" {
+				writeError(w, http.StatusBadRequest, msg)
+				return
+			}
+		}
+	} else if customRoleID != "" && checkCustomRole(r.Context(), h.Store, customRoleID, newOrgID) != "" {
+		customRoleID = ""
+	}
+
+	if err := h.Store.UpdateUser(r.Context(), userIDParam, input.Name, input.Role, orgIDToUpdate, avatar); err != nil {
 		log.Printf("UpdateUser error: %v", err)
 		writeError(w, http.StatusInternalServerError, "failed to update user: "+err.Error())
 		return
 	}
 
+	if input.Team != nil {
+		if err := h.Store.UpdateUserTeam(r.Context(), userIDParam, *input.Team); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to update user team")
+			return
+		}
+	}
+	if customRoleID != existing.CustomRoleID {
+		if err := h.Store.SetUserCustomRole(r.Context(), userIDParam, customRoleID); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to update user role")
+			return
+		}
+	}
+	if email != existing.Email {
+		if err := h.Store.ChangeEmail(r.Context(), userIDParam, email, ""); err != nil {
+			h.Users.Forget(userIDParam)
+			if errors.Is(err, store.ErrEmailTaken) {
+				writeError(w, http.StatusConflict, "a user with this email already exists")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "failed to update email")
+			return
+		}
+		if err := h.Notifier.EmailChanged(r.Context(), existing, email); err != nil {
+			log.Printf("UpdateUser: notify %s of the new email: %v", existing.ID, err)
+		}
+	}
+	h.Users.Forget(userIDParam)
+
 	u, err := h.Store.GetUser(r.Context(), userIDParam)
 	if err != nil || u == nil {
 		writeError(w, http.StatusNotFound, "user not found")
@@ -149,12 +326,6 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetRole(r.Context())
-	if role != "admin" {
-		writeError(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	userIDParam := r.PathValue("id")
 	currentUserID := middleware.GetUserID(r.Context())
 	if userIDParam == currentUserID {
@@ -162,21 +333,131 @@ func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Unassign tickets assigned to this user
-	tickets, _ := h.Store.ListTickets(r.Context(), "", "", "", "", userIDParam, "")
-	empty := ""
-	for _, t := range tickets {
-		_ = h.Store.UpdateTicket(r.Context(), t.ID, nil, nil, &empty, nil)
+	u, err := h.Store.GetUser(r.Context(), userIDParam)
+	if err != nil || u == nil || u.DeletedAt != nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
 	}
 
-	if err := h.Store.DeleteUser(r.Context(), userIDParam); err != nil {
+	// Soft delete: the user is signed out and hidden until restored, and
+	// purged with their tickets unassigned once the retention period is over.
+	if err := h.Store.SoftDeleteUser(r.Context(), u.ID, time.Now().UTC().Truncate(time.Second)); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to delete user")
 		return
 	}
+	h.Users.Forget(u.ID)
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
 
+// Restore undoes a soft delete that has not been purged yet. Members of a
+// deleted organization come back with the organization instead.
+func (h *UserHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	u, err := h.Store.GetUser(r.Context(), r.PathValue("id"))
+	if err != nil || u == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if u.OrganizationID != nil {
+		org, err := h.Store.GetOrg(r.Context(), *u.OrganizationID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load organization")
+			return
+		}
+		if org != nil && org.DeletedAt != nil {
+			writeError(w, http.StatusConflict, "the user's organization is deleted, restore it first")
+			return
+		}
+	}
+	if err := h.Store.RestoreUser(r.Context(), u.ID); err != nil {
+		if errors.Is(err, store.ErrNotDeleted) {
+			writeError(w, http.StatusConflict, "user is not deleted")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to restore user")
+		return
+	}
+	h.Users.Forget(u.ID)
+	u.DeletedAt = nil
+	writeJSON(w, http.StatusOK, u.ToResponse())
+}
+
+// RevokeSessions signs the user out everywhere: existing access tokens stop
+// validating, their refresh tokens can no longer be used and their API tokens
+// stop working.
+func (h *UserHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	userIDParam := r.PathValue("id")
+	u, err := h.Store.GetUser(r.Context(), userIDParam)
+	if err != nil || u == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err := h.Store.BumpTokenVersion(r.Context(), u.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to revoke sessions")
+		return
+	}
+	h.Users.Forget(u.ID)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
+
+// Unlock lifts a sign-in lockout (and the delays) caused by failed attempts
+// on the user's email address or invalid two-factor codes.
+func (h *UserHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	u, err := h.Store.GetUser(r.Context(), r.PathValue("id"))
+	if err != nil || u == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	msgs := outbox.Messages(events.AccountUnlocked{UserID: u.ID, ActorID: middleware.GetUserID(r.Context())})
+	if err := h.Store.ClearLoginAttempts(r.Context(), twoFactorAttemptKey(u.ID)); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to unlock user")
+		return
+	}
+	if err := h.Store.ClearLoginAttempts(r.Context(), emailAttemptKey(u.Email), msgs...); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to unlock user")
+		return
+	}
+	h.Outbox.Flush(r.Context(), msgs)
+	log.Printf("sign-in unlocked for user %s by %s", u.ID, middleware.GetUserID(r.Context()))
+	writeJSON(w, http.StatusOK, map[string]string{"status": "unlocked"})
+}
+
+// ResetTwoFactor removes a user's two-factor setup, e.g. after they lost their
+// device and recovery codes, and signs them out everywhere. If their
+// organization requires two-factor they set it up again at the next login.
+func (h *UserHandler) ResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userIDParam := r.PathValue("id")
+	u, err := h.Store.GetUser(r.Context(), userIDParam)
+	if err != nil || u == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err := h.Store.DisableTOTP(r.Context(), u.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to reset two-factor authentication")
+		return
+	}
+	if err := h.Store.BumpTokenVersion(r.Context(), u.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to revoke sessions")
+		return
+	}
+	h.Users.Forget(u.ID)
+	log.Printf("two-factor reset for user %s by %s", u.ID, middleware.GetUserID(r.Context()))
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reset"})
+}
+
+// checkCustomRole returns a validation message, or "", for giving custom role
+// roleID to a member of organization orgID.
+func checkCustomRole(ctx context.Context, st store.Store, roleID, orgID string) string {
+	role, err := st.GetCustomRole(ctx, roleID)
+	if err != nil {
+		return "failed to load custom role"
+	}
+	if role == nil || orgID == "" || role.OrganizationID != orgID {
+		return "customRoleId must be a role of the user's organization"
+	}
+	return ""
+}
+
 func initialsFromName(name string) string {
 	words := splitWords(name)
 	avatar := ""
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+)
+
+// watch adds userID to the ticket's watcher list. A failure is logged rather
+// than returned: the primary action (create, assign, reply) has already been
+// written and is not undone because of it.
+func (h *TicketHandler) watch(r *http.Request, ticketID, userID, source string) {
+	if userID == "" {
+		return
+	}
+	err := h.Store.AddWatcher(r.Context(), &models.TicketWatcher{
+		TicketID:  ticketID,
+		UserID:    userID,
+		Source:    source,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("watch: add %s to %s as %s: %v", userID, ticketID, source, err)
+	}
+}
+
+func (h *TicketHandler) ListWatchers(w http.ResponseWriter, r *http.Request) {
+	ticketID := r.PathValue("id")
+
+	t, err := h.Store.GetTicket(r.Context(), ticketID)
+	if err != nil || t == nil {
+		writeError(w, http.StatusNotFound, "ticket not found")
+		return
+	}
+	if !canAccessOrg(r.Context(), t.OrganizationID) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	watchers, err := h.Store.ListWatchers(r.Context(), ticketID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query watchers")
+		return
+	}
+	if watchers == nil {
+		watchers = []models.TicketWatcher{}
+	}
+	writeJSON(w, http.StatusOK, watchers)
+}
+
+// Follow adds the caller (or, for admins and support leads, any user) as a watcher.
+func (h *TicketHandler) Follow(w http.ResponseWriter, r *http.Request) {
+	ticketID := r.PathValue("id")
+	userID := middleware.GetUserID(r.Context())
+
+	t, err := h.Store.GetTicket(r.Context(), ticketID)
+	if err != nil || t == nil {
+		writeError(w, http.StatusNotFound, "ticket not found")
+		return
+	}
+	if !canAccessOrg(r.Context(), t.OrganizationID) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	var req models.AddWatcherRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	target := userID
+	if req.UserID != "" && req.UserID != userID {
+		if !middleware.HasPermission(r.Context(), policy.WatcherManage) {
+			writeError(w, http.StatusForbidden, "you can only follow tickets yourself")
+			return
+		}
+		u, err := h.Store.GetUser(r.Context(), req.UserID)
+		if err != nil || u == nil || u.DeletedAt != nil {
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if !policy.Allowed(u.Role, policy.AnyOrganization) && (u.OrganizationID == nil || *u.OrganizationID != t.OrganizationID) {
+			writeError(w, http.StatusBadRequest, "user does not belong to the ticket's organization")
+			return
+		}
+		target = req.UserID
+	}
+
+	wt := &models.TicketWatcher{
+		TicketID:  ticketID,
+		UserID:    target,
+		Source:    "manual",
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := h.Store.AddWatcher(r.Context(), wt); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to add watcher")
+		return
+	}
+	writeJSON(w, http.StatusCreated, wt)
+}
+
+// Unfollow removes a watcher. Users can always remove themselves; admins and
+// support leads can remove anyone.
+func (h *TicketHandler) Unfollow(w http.ResponseWriter, r *http.Request) {
+	ticketID := r.PathValue("id")
+	target := r.PathValue("userId")
+	userID := middleware.GetUserID(r.Context())
+
+	t, err := h.Store.GetTicket(r.Context(), ticketID)
+	if err != nil || t == nil {
+		writeError(w, http.StatusNotFound, "ticket not found")
+		return
+	}
+	if !canAccessOrg(r.Context(), t.OrganizationID) {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
+	if target != userID && !middleware.HasPermission(r.Context(), policy.WatcherManage) {
+		writeError(w, http.StatusForbidden, "you can only unfollow tickets yourself")
+		return
+	}
+
+	if err := h.Store.RemoveWatcher(r.Context(), ticketID, target); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to remove watcher")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+}
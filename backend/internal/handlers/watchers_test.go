@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/notify"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/subscribers"
+)
+
+// sentTo returns the recipients of the mail sent since the last call.
+func (m *mailbox) sentTo() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var to []string
+	for _, e := range m.mail {
+		to = append(to, e.To...)
+	}
+	m.mail = nil
+	slices.Sort(to)
+	return to
+}
+
+func TestWatchers(t *testing.T) {
+	ctx := context.Background()
+	cal := newUser("user-cal", policy.Client)
+	lead := newUser("user-lead", policy.SupportLead)
+	sam := newUser("user-sam", policy.SupportStaff)
+	st := newStore(t, cal, lead, sam)
+	mail := &mailbox{}
+	bus := events.NewBus()
+	bus.Subscribe("notifications", (&subscribers.Notifications{Notifier: notify.New(st, mail, "http://app.test", "support@example.test")}).Handle)
+	h := &TicketHandler{Store: st, Outbox: &outbox.Relay{Store: st, Bus: bus}}
+
+	watchers := func(id string) []string {
+		var ids []string
+		list, _ := st.ListWatchers(ctx, id)
+		for _, w := range list {
+			ids = append(ids, w.UserID+"/"+w.Source)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	check := func(step string, got, want []string) {
+		t.Helper()
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: %v, want %v", step, got, want)
+		}
+	}
+
+	// The creator follows the ticket and gets the confirmation.
+	rec := call(h.Create, asCaller("POST", "/api/tickets", `{"title":"Printer on fire","description":"Send help."}`, cal))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	list, _ := st.ListTickets(ctx, "", "", "", "", "", "")
+	id := list[0].ID
+	check("watchers after create", watchers(id), []string{"user-cal/creator"})
+	check("mailed on create", mail.sentTo(), []string{"cal@example.test"})
+
+	// The assignee follows it once assigned.
+	call(h.Update, asCaller("PUT", "/api/tickets/"+id, `{"assignedTo":"user-sam"}`, lead), "id", id)
+	check("watchers after assignment", watchers(id), []string{"user-cal/creator", "user-sam/assignee"})
+	check("mailed on assignment", mail.sentTo(), []string{"sam@example.test"})
+
+	// A reply goes to every watcher but its author, who now follows too.
+	rec = call(h.AddMessage, asCaller("POST", "/api/tickets/"+id+"/messages", `{"content":"On it."}`, lead), "id", id)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("reply: %d %s", rec.Code, rec.Body)
+	}
+	check("mailed on the lead's reply", mail.sentTo(), []string{"cal@example.test", "sam@example.test"})
+	check("watchers after the reply", watchers(id), []string{"user-cal/creator", "user-lead/commenter", "user-sam/assignee"})
+
+	// Clients only unfollow for themselves; a lead can remove anyone.
+	if rec := call(h.Unfollow, asCaller("DELETE", "/api/tickets/"+id+"/watchers/user-sam", "", cal), "id", id, "userId", "user-sam"); rec.Code != http.StatusForbidden {
+		t.Errorf("client removing another watcher: %d, want 403", rec.Code)
+	}
+	if rec := call(h.Unfollow, asCaller("DELETE", "/api/tickets/"+id+"/watchers/user-sam", "", lead), "id", id, "userId", "user-sam"); rec.Code != http.StatusOK {
+		t.Errorf("lead removing a watcher: %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(h.Unfollow, asCaller("DELETE", "/api/tickets/"+id+"/watchers/user-cal", "", cal), "id", id, "userId", "user-cal"); rec.Code != http.StatusOK {
+		t.Errorf("client unfollowing: %d %s", rec.Code, rec.Body)
+	}
+	check("watchers after unfollowing", watchers(id), []string{"user-lead/commenter"})
+
+	// Clients only follow for themselves; a lead can add staff.
+	if rec := call(h.Follow, asCaller("POST", "/api/tickets/"+id+"/watchers", `{"userId":"user-sam"}`, cal), "id", id); rec.Code != http.StatusForbidden {
+		t.Errorf("client adding another watcher: %d, want 403", rec.Code)
+	}
+	if rec := call(h.Follow, asCaller("POST", "/api/tickets/"+id+"/watchers", "", cal), "id", id); rec.Code != http.StatusCreated {
+		t.Errorf("client following: %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(h.Follow, asCaller("POST", "/api/tickets/"+id+"/watchers", `{"userId":"user-sam"}`, lead), "id", id); rec.Code != http.StatusCreated {
+		t.Errorf("lead adding a watcher: %d %s", rec.Code, rec.Body)
+	}
+	check("watchers after following", watchers(id), []string{"user-cal/manual", "user-lead/commenter", "user-sam/manual"})
+
+	// Those who unfollowed hear nothing more; the current watchers do.
+	call(h.Unfollow, asCaller("DELETE", "/api/tickets/"+id+"/watchers/user-cal", "", cal), "id", id, "userId", "user-cal")
+	call(h.AddMessage, asCaller("POST", "/api/tickets/"+id+"/messages", `{"content":"Fixed."}`, sam), "id", id)
+	check("mailed on sam's reply", mail.sentTo(), []string{"lead@example.test"})
+
+	// Internal notes are never mailed.
+	call(h.AddMessage, asCaller("POST", "/api/tickets/"+id+"/messages", `{"content":"Toner again.","isInternal":true}`, lead), "id", id)
+	check("mailed on an internal note", mail.sentTo(), nil)
+
+	// Another organization's client cannot follow or see the watchers.
+	other := &models.User{ID: "user-gil", Email: "gil@globex.test", Role: policy.Client}
+	globex := "org-globex"
+	other.OrganizationID = &globex
+	if rec := call(h.Follow, asCaller("POST", "/api/tickets/"+id+"/watchers", "", other), "id", id); rec.Code != http.StatusForbidden {
+		t.Errorf("foreign client following: %d, want 403", rec.Code)
+	}
+	if rec := call(h.ListWatchers, asCaller("GET", "/api/tickets/"+id+"/watchers", "", other), "id", id); rec.Code != http.StatusForbidden {
+		t.Errorf("foreign client listing watchers: %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store"
+	"github.com/supporttickr/backend/internal/webhooks"
+)
+
+type WebhookHandler struct {
+	Store    store.Store
+	Webhooks *webhooks.Dispatcher
+}
+
+// webhookWithSecret is returned on create so the caller can verify signatures.
+// The secret is never shown again.
+type webhookWithSecret struct {
+	models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.Store.ListWebhooks(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query webhooks")
+		return
+	}
+	orgFilter := r.URL.Query().Get("organizationId")
+	subs := []models.WebhookSubscription{}
+	for _, s := range list {
+		if orgFilter != "" && s.OrganizationID != orgFilter {
+			continue
+		}
+		subs = append(subs, s)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	writeJSON(w, http.StatusOK, subs)
+}
+
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	var req models.WebhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.URL == nil {
+		writeError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+
+	now := time.Now().UTC()
+	sub := &models.WebhookSubscription{
+		ID:        "whk-" + uuid.NewString()[:8],
+		Events:    []string{},
+		Secret:    webhooks.NewSecret(),
+		Active:    true,
+		CreatedBy: userID,
+		CreatedAt: now,
+	}
+	if msg := h.apply(r, sub, &req, now); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err := h.Store.PutWebhook(r.Context(), sub); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create webhook: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, webhookWithSecret{WebhookSubscription: *sub, Secret: sub.Secret})
+}
+
+func (h *WebhookHandler) Update(w http.ResponseWriter, r *http.Request) {
+	sub, err := h.Store.GetWebhook(r.Context(), r.PathValue("id"))
+	if err != nil || sub == nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+
+	var req models.WebhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if msg := h.apply(r, sub, &req, time.Now().UTC()); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if err := h.Store.PutWebhook(r.Context(), sub); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update webhook")
+		return
+	}
+	writeJSON(w, http.StatusOK, sub)
+}
+
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.Store.DeleteWebhook(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete webhook")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// Deliveries returns the subscription's delivery log, newest first.
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	list, err := h.Store.ListWebhookDeliveries(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query deliveries")
+		return
+	}
+	if list == nil {
+		list = []models.WebhookDelivery{}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	if len(list) > 100 {
+		list = list[:100]
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// Redeliver sends a logged delivery again and returns the new delivery.
+func (h *WebhookHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	sub, err := h.Store.GetWebhook(r.Context(), r.PathValue("id"))
+	if err != nil || sub == nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	dlv, err := h.Store.GetWebhookDelivery(r.Context(), sub.ID, r.PathValue("deliveryId"))
+	if err != nil || dlv == nil {
+		writeError(w, http.StatusNotFound, "delivery not found")
+		return
+	}
+
+	redelivered, err := h.Webhooks.Redeliver(r.Context(), sub, dlv)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to redeliver")
+		return
+	}
+	writeJSON(w, http.StatusOK, redelivered)
+}
+
+// apply validates req and copies the fields it sets onto sub. It returns an
+// error message for the client, or "".
+func (h *WebhookHandler) apply(r *http.Request, sub *models.WebhookSubscription, req *models.WebhookRequest, now time.Time) string {
+	if req.URL != nil {
+		if err := webhooks.CheckURL(r.Context(), *req.URL); err != nil {
+			return err.Error()
+		}
+		sub.URL = *req.URL
+	}
+	if req.OrganizationID != nil {
+		if *req.OrganizationID != "" {
+			if o, _ := h.Store.GetOrg(r.Context(), *req.OrganizationID); o == nil {
+				return "organization not found"
+			}
+		}
+		sub.OrganizationID = *req.OrganizationID
+	}
+	if req.Events != nil {
+		for _, e := range req.Events {
+			if !webhooks.ValidEvent(e) {
+				return "unknown event: " + e
+			}
+		}
+		sub.Events = req.Events
+	}
+	if req.Active != nil {
+		sub.Active = *req.Active
+	}
+	if req.Description != nil {
+		sub.Description = *req.Description
+	}
+	sub.UpdatedAt = now
+	return ""
+}
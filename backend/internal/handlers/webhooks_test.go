@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/supporttickr/backend/internal/policy"
+)
+
+func TestWebhookURLMustBePublic(t *testing.T) {
+	admin := newUser("user-admin", policy.Admin)
+	h := &WebhookHandler{Store: newStore(t, admin)}
+
+	for _, tc := range []struct {
+		url  string
+		want int
+	}{
+		{"https://93.184.215.14/hooks", http.StatusCreated},
+		{"http://169.254.169.254/latest/meta-data/", http.StatusBadRequest},
+		{"http://localhost:8080/hooks", http.StatusBadRequest},
+		{"http://10.0.0.5/hooks", http.StatusBadRequest},
+	} {
+		rec := call(h.Create, asCaller("POST", "/api/webhooks", `{"url":"`+tc.url+`"}`, admin))
+		if rec.Code != tc.want {
+			t.Errorf("create with %s: status %d, want %d: %s", tc.url, rec.Code, tc.want, rec.Body)
+		}
+	}
+
+	// Updates are checked too.
+	list, _ := h.Store.ListWebhooks(context.Background())
+	if len(list) != 1 {
+		t.Fatalf("%d subscriptions saved, want 1", len(list))
+	}
+	rec := call(h.Update, asCaller("PUT", "/api/webhooks/"+list[0].ID, `{"url":"http://127.0.0.1/hooks"}`, admin), "id", list[0].ID)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("update to loopback: status %d, want 400", rec.Code)
+	}
+}
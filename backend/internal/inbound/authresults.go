@@ -0,0 +1,80 @@
+package inbound
+
+import (
+	"regexp"
+	"strings"
+)
+
+var commentRe = regexp.MustCompile(`\([^()]*\)`)
+
+// authenticated reports whether the relay identified by authServID vouched
+// for the From domain in its Authentication-Results header (RFC 8601): DMARC
+// passed, or DKIM or SPF passed for the From domain or a parent of it.
+//
+// Only the topmost header carrying authServID is read. The relay adds its own
+// above anything the sender wrote and removes forged copies of its id, so
+// older ones further down are not trusted. An empty authServID trusts nothing.
+func authenticated(e *parsedEmail, authServID string) bool {
+	if authServID == "" {
+		return false
+	}
+	from := e.From[strings.LastIndex(e.From, "@")+1:]
+	for _, h := range e.Headers["Authentication-Results"] {
+		parts := strings.Split(commentRe.ReplaceAllString(h, ""), ";")
+		if id := strings.Fields(parts[0]); len(id) == 0 || !strings.EqualFold(id[0], authServID) {
+			continue
+		}
+		for _, res := range parts[1:] {
+			method, result, props := parseResult(res)
+			if result != "pass" {
+				continue
+			}
+			switch method {
+			case "dmarc":
+				if strings.EqualFold(props["header.from"], from) {
+					return true
+				}
+			case "dkim":
+				d := props["header.d"]
+				if d == "" { // some relays only report the signing identity
+					d = props["header.i"][strings.LastIndex(props["header.i"], "@")+1:]
+				}
+				if aligned(from, d) {
+					return true
+				}
+			case "spf":
+				mailFrom := props["smtp.mailfrom"]
+				if aligned(from, mailFrom[strings.LastIndex(mailFrom, "@")+1:]) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// parseResult splits one "method=result prop=value ..." entry.
+func parseResult(s string) (method, result string, props map[string]string) {
+	props = map[string]string{}
+	for i, f := range strings.Fields(s) {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			continue
+		}
+		k, v = strings.ToLower(k), strings.ToLower(strings.Trim(v, `"`))
+		if i == 0 {
+			method, _, _ = strings.Cut(k, "/")
+			result = v
+			continue
+		}
+		props[k] = v
+	}
+	return method, result, props
+}
+
+// aligned reports whether the authenticated domain d is the From domain or
+// one of its parents.
+func aligned(from, d string) bool {
+	return d != "" && (from == d || strings.HasSuffix(from, "."+d))
+}
@@ -0,0 +1,77 @@
+package inbound
+
+import (
+	"net/mail"
+	"testing"
+)
+
+func TestAuthenticated(t *testing.T) {
+	const relay = "mx.support.test"
+	for _, tc := range []struct {
+		name    string
+		from    string
+		results []string // Authentication-Results headers, topmost first
+		want    bool
+	}{
+		{"no results", "cal@acme.test", nil, false},
+		{"dmarc pass", "cal@acme.test", []string{"mx.support.test; dmarc=pass header.from=acme.test"}, true},
+		{"dmarc pass for another domain", "cal@acme.test", []string{"mx.support.test; dmarc=pass header.from=evil.test"}, false},
+		{"dkim pass", "cal@acme.test", []string{"mx.support.test; dkim=pass header.d=acme.test"}, true},
+		{"dkim pass by the signing identity", "cal@acme.test", []string{"mx.support.test; dkim=pass header.i=@acme.test"}, true},
+		{"dkim pass for a parent domain", "cal@eu.acme.test", []string{"mx.support.test; dkim=pass header.d=acme.test"}, true},
+		{"dkim pass for a subdomain", "cal@acme.test", []string{"mx.support.test; dkim=pass header.d=eu.acme.test"}, false},
+		{"dkim pass for a lookalike", "cal@notacme.test", []string{"mx.support.test; dkim=pass header.d=acme.test"}, false},
+		{"dkim pass for another domain", "cal@acme.test", []string{"mx.support.test; dkim=pass header.d=evil.test"}, false},
+		{"spf pass", "cal@acme.test", []string{"mx.support.test; spf=pass smtp.mailfrom=bounces@acme.test"}, true},
+		{"spf pass for another domain", "cal@acme.test", []string{"mx.support.test; spf=pass smtp.mailfrom=bounces@evil.test"}, false},
+		{
+			"everything failing", "cal@acme.test",
+			[]string{"mx.support.test; dmarc=fail header.from=acme.test; dkim=fail header.d=acme.test; spf=softfail smtp.mailfrom=cal@acme.test"},
+			false,
+		},
+		{
+			"one method passing", "cal@acme.test",
+			[]string{"mx.support.test; spf=fail smtp.mailfrom=cal@acme.test; dkim=pass header.d=acme.test"},
+			true,
+		},
+		{
+			"comments and case", "cal@acme.test",
+			[]string{"MX.Support.Test (relay 1.2); DKIM=Pass (good signature) header.d=Acme.Test"},
+			true,
+		},
+		{"another relay", "cal@acme.test", []string{"mx.evil.test; dmarc=pass header.from=acme.test"}, false},
+		{"relay id in a comment", "cal@acme.test", []string{"mx.evil.test (mx.support.test); dmarc=pass header.from=acme.test"}, false},
+		{
+			"spoofed pass below a failing result", "cal@acme.test",
+			[]string{
+				"mx.support.test; dmarc=fail header.from=acme.test",
+				"mx.support.test; dmarc=pass header.from=acme.test",
+			},
+			false,
+		},
+		{
+			"pass from the relay below foreign results", "cal@acme.test",
+			[]string{
+				"mx.evil.test; dmarc=fail header.from=acme.test",
+				"mx.support.test; dmarc=pass header.from=acme.test",
+			},
+			true,
+		},
+	} {
+		e := &parsedEmail{From: tc.from, Headers: mail.Header{}}
+		if tc.results != nil {
+			e.Headers["Authentication-Results"] = tc.results
+		}
+		if got := authenticated(e, relay); got != tc.want {
+			t.Errorf("%s: authenticated = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// Without a configured relay nothing is trusted.
+	e := &parsedEmail{From: "cal@acme.test", Headers: mail.Header{
+		"Authentication-Results": {"mx.support.test; dmarc=pass header.from=acme.test"},
+	}}
+	if authenticated(e, "") {
+		t.Error("authenticated with no authserv-id configured")
+	}
+}
@@ -0,0 +1,190 @@
+package inbound
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// maxAttachmentSize caps each stored attachment; larger parts are skipped.
+const maxAttachmentSize = 10 << 20
+
+// parsedEmail is the subset of an RFC 5322 message the processor needs.
+type parsedEmail struct {
+	From        string // lower-cased address
+	FromName    string
+	Subject     string
+	MessageID   string
+	Body        string
+	Headers     mail.Header
+	Attachments []parsedAttachment
+}
+
+type parsedAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+func parseEmail(raw []byte) (*parsedEmail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parse message: %w", err)
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("parse From header: %w", err)
+	}
+	subject, err := wordDecoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	e := &parsedEmail{
+		From:      strings.ToLower(from.Address),
+		FromName:  from.Name,
+		Subject:   strings.TrimSpace(subject),
+		MessageID: strings.TrimSpace(msg.Header.Get("Message-ID")),
+		Headers:   msg.Header,
+	}
+	var text, html string
+	if err := walkPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), "", msg.Body, e, &text, &html); err != nil {
+		return nil, err
+	}
+	if text == "" && html != "" {
+		text = htmlToText(html)
+	}
+	e.Body = strings.TrimSpace(stripQuotedReply(text))
+	return e, nil
+}
+
+// walkPart collects the first text/plain and text/html bodies and every
+// attachment from a (possibly nested multipart) MIME part.
+func walkPart(contentType, encoding, disposition string, body io.Reader, e *parsedEmail, text, html *string) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("read multipart: %w", err)
+			}
+			if err := walkPart(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p.Header.Get("Content-Disposition"), p, e, text, html); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(decodeTransfer(encoding, body), maxAttachmentSize+1))
+	if err != nil {
+		return fmt.Errorf("read part: %w", err)
+	}
+
+	filename := ""
+	if d, dp, err := mime.ParseMediaType(disposition); err == nil {
+		filename = dp["filename"]
+		if d == "attachment" && filename == "" {
+			filename = "attachment"
+		}
+	}
+	if filename == "" {
+		filename = params["name"]
+	}
+	if filename != "" {
+		if dec, err := wordDecoder.DecodeHeader(filename); err == nil {
+			filename = dec
+		}
+		if len(data) <= maxAttachmentSize {
+			e.Attachments = append(e.Attachments, parsedAttachment{Filename: filename, ContentType: mediaType, Data: data})
+		}
+		return nil
+	}
+
+	switch {
+	case mediaType == "text/plain" && *text == "":
+		*text = string(data)
+	case mediaType == "text/html" && *html == "":
+		*html = string(data)
+	}
+	return nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// newlineStripper drops CR/LF so base64 line wrapping does not break decoding.
+type newlineStripper struct{ r io.Reader }
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		c, err := n.r.Read(p)
+		j := 0
+		for _, b := range p[:c] {
+			if b != '\r' && b != '\n' {
+				p[j] = b
+				j++
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
+
+var (
+	tagRe        = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<[^>]+>`)
+	blankLinesRe = regexp.MustCompile(`\n{3,}`)
+	breakRe      = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/tr)[^>]*>`)
+)
+
+func htmlToText(h string) string {
+	h = breakRe.ReplaceAllString(h, "\n$0")
+	t := tagRe.ReplaceAllString(h, "")
+	t = strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'").Replace(t)
+	return blankLinesRe.ReplaceAllString(t, "\n\n")
+}
+
+var replyHeaderRe = regexp.MustCompile(`(?i)^(on .+ wrote:|-+\s*original message\s*-+|from: .+)$`)
+
+// stripQuotedReply drops the quoted history most mail clients append below a reply.
+func stripQuotedReply(body string) string {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if replyHeaderRe.MatchString(trimmed) && i > 0 {
+			return strings.Join(lines[:i], "\n")
+		}
+	}
+	var kept []string
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
@@ -0,0 +1,148 @@
+package inbound
+
+import (
+	"strings"
+	"testing"
+)
+
+// email joins header and body lines with CRLF.
+func email(lines ...string) []byte {
+	return []byte(strings.Join(lines, "\r\n"))
+}
+
+func TestParseEmail(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		raw         []byte
+		from        string
+		subject     string
+		body        string
+		attachments []string // filename/content type: data
+		err         bool
+	}{
+		{
+			name: "plain text reply",
+			raw: email(
+				`From: "Cal Client" <Cal@Acme.TEST>`,
+				"Subject: =?UTF-8?Q?Caf=C3=A9_printer?=",
+				"Message-ID: <a1@acme.test>",
+				"",
+				"It is on fire again.",
+				"",
+				"On Mon, 6 Jan 2025, Support wrote:",
+				"> Have you tried turning it off?",
+			),
+			from:    "cal@acme.test",
+			subject: "Café printer",
+			body:    "It is on fire again.",
+		},
+		{
+			name: "quoted-printable",
+			raw: email(
+				"From: cal@acme.test",
+				"Subject: Menu",
+				"Content-Type: text/plain; charset=utf-8",
+				"Content-Transfer-Encoding: quoted-printable",
+				"",
+				"caf=C3=A9 au lait, soft=",
+				" wrapped",
+			),
+			from:    "cal@acme.test",
+			subject: "Menu",
+			body:    "café au lait, soft wrapped",
+		},
+		{
+			name: "base64",
+			raw: email(
+				"From: cal@acme.test",
+				"Subject: Encoded",
+				"Content-Type: text/plain",
+				"Content-Transfer-Encoding: base64",
+				"",
+				"SGVsbG8gZnJv",
+				"bSBiYXNlNjQ=",
+			),
+			from:    "cal@acme.test",
+			subject: "Encoded",
+			body:    "Hello from base64",
+		},
+		{
+			name: "html only",
+			raw: email(
+				"From: cal@acme.test",
+				"Subject: Styled",
+				"Content-Type: text/html",
+				"",
+				"<style>p{color:red}</style><p>Hello&nbsp;there &amp; welcome</p><script>alert(1)</script>",
+			),
+			from:    "cal@acme.test",
+			subject: "Styled",
+			body:    "Hello there & welcome",
+		},
+		{
+			name: "nested multipart with attachments",
+			raw: email(
+				"From: cal@acme.test",
+				"Subject: Report",
+				`Content-Type: multipart/mixed; boundary="outer"`,
+				"",
+				"--outer",
+				`Content-Type: multipart/alternative; boundary="inner"`,
+				"",
+				"--inner",
+				"Content-Type: text/plain",
+				"",
+				"See the report.",
+				"--inner",
+				"Content-Type: text/html",
+				"",
+				"<p>See the <b>report</b>.</p>",
+				"--inner--",
+				"--outer",
+				`Content-Type: application/pdf; name="ignored.pdf"`,
+				`Content-Disposition: attachment; filename="report.pdf"`,
+				"Content-Transfer-Encoding: base64",
+				"",
+				"JVBERi0xLjQ=",
+				"--outer",
+				"Content-Type: image/png",
+				"Content-Disposition: attachment",
+				"",
+				"png",
+				"--outer--",
+			),
+			from:        "cal@acme.test",
+			subject:     "Report",
+			body:        "See the report.",
+			attachments: []string{"report.pdf/application/pdf: %PDF-1.4", "attachment/image/png: png"},
+		},
+		{
+			name: "invalid From",
+			raw:  email("From: not an address", "Subject: Hi", "", "Hello"),
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := parseEmail(tc.raw)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("parsed %+v, want an error", e)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.From != tc.from || e.Subject != tc.subject || e.Body != tc.body {
+				t.Errorf("from %q, subject %q, body %q, want %q, %q, %q", e.From, e.Subject, e.Body, tc.from, tc.subject, tc.body)
+			}
+			var atts []string
+			for _, a := range e.Attachments {
+				atts = append(atts, a.Filename+"/"+a.ContentType+": "+string(a.Data))
+			}
+			if strings.Join(atts, "\n") != strings.Join(tc.attachments, "\n") {
+				t.Errorf("attachments %q, want %q", atts, tc.attachments)
+			}
+		})
+	}
+}
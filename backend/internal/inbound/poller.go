@@ -0,0 +1,84 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Poller processes messages delivered to a maildir. New messages are read from
+// Dir/new; processed ones move to Dir/cur and failures to Dir/failed so they
+// can be inspected and re-queued by moving them back.
+type Poller struct {
+	Dir       string
+	Processor *Processor
+	Interval  time.Duration
+}
+
+// Run polls until ctx is cancelled.
+func (p *Poller) Run(ctx context.Context) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	for _, sub := range []string{"new", "cur", "tmp", "failed"} {
+		if err := os.MkdirAll(filepath.Join(p.Dir, sub), 0o755); err != nil {
+			log.Printf("inbound: maildir %s: %v", p.Dir, err)
+			return
+		}
+	}
+	log.Printf("inbound: polling maildir %s every %s", p.Dir, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.PollOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PollOnce processes every message currently in Dir/new.
+func (p *Poller) PollOnce(ctx context.Context) {
+	entries, err := os.ReadDir(filepath.Join(p.Dir, "new"))
+	if err != nil {
+		log.Printf("inbound: read maildir: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || ctx.Err() != nil {
+			continue
+		}
+		src := filepath.Join(p.Dir, "new", entry.Name())
+		raw, err := os.ReadFile(src)
+		if err != nil {
+			log.Printf("inbound: read %s: %v", src, err)
+			continue
+		}
+
+		dest := filepath.Join(p.Dir, "cur", entry.Name()+":2,S")
+		res, err := p.Processor.Process(ctx, raw)
+		if errors.Is(err, ErrDuplicate) {
+			// Processed on an earlier run that failed to move it.
+			log.Printf("inbound: %s was already processed", entry.Name())
+		} else if err != nil {
+			if errors.Is(err, ErrUnknownSender) || errors.Is(err, ErrUnauthenticated) {
+				log.Printf("inbound: rejected %s: %v", entry.Name(), err)
+			} else {
+				log.Printf("inbound: failed %s: %v", entry.Name(), err)
+			}
+			dest = filepath.Join(p.Dir, "failed", entry.Name())
+		} else {
+			log.Printf("inbound: %s -> ticket %s (created=%t)", entry.Name(), res.TicketID, res.Created)
+		}
+		if err := os.Rename(src, dest); err != nil {
+			log.Printf("inbound: move %s: %v", src, err)
+		}
+	}
+}
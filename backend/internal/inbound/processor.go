@@ -0,0 +1,238 @@
+// Package inbound turns raw support emails into tickets and ticket messages.
+package inbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/blob"
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// ErrUnknownSender is returned when the sender is not an active client user.
+var ErrUnknownSender = errors.New("sender is not a known client user")
+
+// ErrDuplicate is returned for an email whose Message-ID was processed
+// before, such as a provider retry; it was already turned into a ticket or
+// message.
+var ErrDuplicate = errors.New("email was already processed")
+
+// ErrUnauthenticated is returned when the relay did not vouch for the From
+// address with a passing DMARC, DKIM or SPF result.
+var ErrUnauthenticated = errors.New("sender is not authenticated by the inbound relay")
+
+var (
+	subjectRefRe = regexp.MustCompile(`(?i)\[(tkt-[0-9a-z]+)\]`)
+	headerRefRe  = regexp.MustCompile(`(?i)<(tkt-[0-9a-z]+)[.@]`)
+)
+
+// Result describes what a processed email turned into.
+type Result struct {
+	TicketID  string `json:"ticketId"`
+	MessageID string `json:"messageId,omitempty"`
+	Created   bool   `json:"created"`
+}
+
+// Processor creates a ticket for each new email thread and appends replies
+// to the ticket referenced in the subject or threading headers.
+//
+// AuthServID is the authserv-id the receiving relay stamps on its
+// Authentication-Results header; messages without a passing result from it
+// are rejected.
+type Processor struct {
+	Store      store.Store
+	Blobs      blob.Storage
+	Outbox     *outbox.Relay
+	AuthServID string
+}
+
+func (p *Processor) Process(ctx context.Context, raw []byte) (*Result, error) {
+	e, err := parseEmail(raw)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := p.resolveSender(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+
+	if ticketID := findTicketRef(e); ticketID != "" {
+		t, err := p.Store.GetTicket(ctx, ticketID)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil && canReply(sender, t) {
+			return p.appendReply(ctx, e, sender, t)
+		}
+		// Unknown or foreign ticket reference: treat as a new thread.
+	}
+	return p.createTicket(ctx, e, sender)
+}
+
+func canReply(u *models.User, t *models.Ticket) bool {
+	return u.OrganizationID != nil && *u.OrganizationID == t.OrganizationID
+}
+
+// findTicketRef looks for a ticket ID in the subject ("[tkt-1234abcd]"), our own
+// X-SupportFix-Ticket header, then In-Reply-To/References set by notify.
+func findTicketRef(e *parsedEmail) string {
+	if m := subjectRefRe.FindStringSubmatch(e.Subject); m != nil {
+		return strings.ToLower(m[1])
+	}
+	if v := strings.TrimSpace(e.Headers.Get("X-SupportFix-Ticket")); v != "" {
+		return strings.ToLower(v)
+	}
+	for _, h := range []string{"In-Reply-To", "References"} {
+		if m := headerRefRe.FindStringSubmatch(e.Headers.Get(h)); m != nil {
+			return strings.ToLower(m[1])
+		}
+	}
+	return ""
+}
+
+// resolveSender maps the From address to the client user who sent the message.
+// The From header is only trusted once the relay has authenticated it. Staff,
+// service accounts, pending invitees and deleted users cannot file tickets by
+// email, and unknown senders are not provisioned: anyone can claim an address
+// at a customer's domain.
+func (p *Processor) resolveSender(ctx context.Context, e *parsedEmail) (*models.User, error) {
+	if !authenticated(e, p.AuthServID) {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, e.From)
+	}
+	u, err := p.Store.GetUserByEmail(ctx, e.From)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil || u.DeletedAt != nil || u.ServiceAccount || u.Invitation != nil || u.Role != policy.Client {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSender, e.From)
+	}
+	return u, nil
+}
+
+func (p *Processor) createTicket(ctx context.Context, e *parsedEmail, sender *models.User) (*Result, error) {
+	if sender.OrganizationID == nil || *sender.OrganizationID == "" {
+		return nil, fmt.Errorf("sender %s has no organization to file a ticket under", e.From)
+	}
+	title := subjectRefRe.ReplaceAllString(e.Subject, "")
+	title = strings.TrimSpace(title)
+	if title == "" {
+		title = "(no subject)"
+	}
+	description := e.Body
+	if description == "" {
+		description = title
+	}
+
+	now := time.Now().UTC()
+	ticketID := "tkt-" + uuid.NewString()[:8]
+	t := &models.Ticket{
+		ID:             ticketID,
+		Title:          title,
+		Description:    description,
+		Status:         "open",
+		Priority:       "medium",
+		Category:       "support",
+		OrganizationID: *sender.OrganizationID,
+		CreatedBy:      sender.ID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	msgs := outbox.Messages(events.TicketCreated{Ticket: t, ActorID: sender.ID, Via: "email"})
+	if err := p.Store.CreateTicketFromEmail(ctx, t, e.MessageID, msgs...); err != nil {
+		if errors.Is(err, store.ErrDuplicateEmail) {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicate, e.MessageID)
+		}
+		return nil, err
+	}
+	p.watch(ctx, ticketID, sender.ID, "creator")
+	p.storeAttachments(ctx, ticketID, "", e.Attachments)
+
+	p.Outbox.Flush(ctx, msgs)
+
+	return &Result{TicketID: ticketID, Created: true}, nil
+}
+
+func (p *Processor) appendReply(ctx context.Context, e *parsedEmail, sender *models.User, t *models.Ticket) (*Result, error) {
+	content := e.Body
+	if content == "" && len(e.Attachments) == 0 {
+		return &Result{TicketID: t.ID}, nil
+	}
+	if content == "" {
+		content = "(attachment only)"
+	}
+
+	now := time.Now().UTC()
+	m := &models.Message{
+		ID:        "msg-" + uuid.NewString()[:8],
+		TicketID:  t.ID,
+		UserID:    sender.ID,
+		Content:   content,
+		CreatedAt: now,
+	}
+	msgs := outbox.Messages(events.MessageAdded{Ticket: t, Message: m, Via: "email"})
+	if err := p.Store.AddMessageFromEmail(ctx, m, e.MessageID, msgs...); err != nil {
+		if errors.Is(err, store.ErrDuplicateEmail) {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicate, e.MessageID)
+		}
+		return nil, err
+	}
+	if err := p.Store.UpdateTicket(ctx, t.ID, nil, nil, nil, nil); err != nil { // updates updated_at
+		log.Printf("inbound: touch %s: %v", t.ID, err)
+	}
+	p.watch(ctx, t.ID, sender.ID, "commenter")
+	p.storeAttachments(ctx, t.ID, m.ID, e.Attachments)
+
+	p.Outbox.Flush(ctx, msgs)
+
+	return &Result{TicketID: t.ID, MessageID: m.ID}, nil
+}
+
+// watch adds the sender as a watcher; like a failed attachment, a failure is
+// logged rather than losing the email.
+func (p *Processor) watch(ctx context.Context, ticketID, userID, source string) {
+	err := p.Store.AddWatcher(ctx, &models.TicketWatcher{
+		TicketID:  ticketID,
+		UserID:    userID,
+		Source:    source,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("inbound: watch %s as %s: %v", ticketID, source, err)
+	}
+}
+
+// storeAttachments saves each attachment; a failed attachment is logged and
+// skipped rather than losing the whole email.
+func (p *Processor) storeAttachments(ctx context.Context, ticketID, messageID string, atts []parsedAttachment) {
+	for _, a := range atts {
+		id := "att-" + uuid.NewString()[:8]
+		key := "attachments/" + ticketID + "/" + id
+		if err := p.Blobs.Put(ctx, key, a.ContentType, a.Data); err != nil {
+			log.Printf("inbound: store attachment %q for %s: %v", a.Filename, ticketID, err)
+			continue
+		}
+		err := p.Store.AddAttachment(ctx, &models.Attachment{
+			ID:          id,
+			TicketID:    ticketID,
+			MessageID:   messageID,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        len(a.Data),
+			StorageKey:  key,
+			CreatedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			log.Printf("inbound: record attachment %q for %s: %v", a.Filename, ticketID, err)
+		}
+	}
+}
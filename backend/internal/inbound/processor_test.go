@@ -0,0 +1,143 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+const testRelay = "mx.support.test"
+
+// newProcessor returns a processor over a memory store holding client
+// cal@acme.test and staff member jane@acme.test.
+func newProcessor(t *testing.T) (*Processor, *storetest.Memory) {
+	t.Helper()
+	ctx := context.Background()
+	st := storetest.New()
+	org := "org-acme"
+	for _, u := range []*models.User{
+		{ID: "user-cal", Email: "cal@acme.test", Role: policy.Client, OrganizationID: &org},
+		{ID: "user-jane", Email: "jane@acme.test", Role: policy.SupportStaff},
+	} {
+		if err := st.CreateUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Processor{Store: st, AuthServID: testRelay}, st
+}
+
+// authedEmail returns a message from addr that the relay authenticated.
+func authedEmail(addr, messageID, subject, body string) []byte {
+	return email(
+		"Authentication-Results: "+testRelay+"; dmarc=pass header.from=acme.test",
+		"From: "+addr,
+		"Message-ID: "+messageID,
+		"Subject: "+subject,
+		"",
+		body,
+	)
+}
+
+func TestProcessDedupe(t *testing.T) {
+	ctx := context.Background()
+	p, st := newProcessor(t)
+
+	first := authedEmail("cal@acme.test", "<m1@acme.test>", "Printer on fire", "Send help.")
+	res, err := p.Process(ctx, first)
+	if err != nil || !res.Created {
+		t.Fatalf("first email: %+v, %v", res, err)
+	}
+	tk, _ := st.GetTicket(ctx, res.TicketID)
+	if tk == nil || tk.Title != "Printer on fire" || tk.Description != "Send help." || tk.CreatedBy != "user-cal" {
+		t.Fatalf("ticket %+v", tk)
+	}
+
+	// A provider retry of the same message does not file a second ticket.
+	if res, err := p.Process(ctx, first); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("redelivered email: %+v, %v, want ErrDuplicate", res, err)
+	}
+	if list, _ := st.ListTickets(ctx, "", "", "", "", "", ""); len(list) != 1 {
+		t.Fatalf("%d tickets after a redelivery, want 1", len(list))
+	}
+
+	reply := authedEmail("cal@acme.test", "<m2@acme.test>", "Re: ["+res.TicketID+"] Printer on fire", "Still burning.")
+	if got, err := p.Process(ctx, reply); err != nil || got.TicketID != res.TicketID || got.MessageID == "" {
+		t.Fatalf("reply: %+v, %v", got, err)
+	}
+	if _, err := p.Process(ctx, reply); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("redelivered reply: %v, want ErrDuplicate", err)
+	}
+	if msgs, _ := st.GetMessagesByTicketID(ctx, res.TicketID); len(msgs) != 1 || msgs[0].Content != "Still burning." {
+		t.Fatalf("messages %+v, want the reply once", msgs)
+	}
+
+	// Nor does a duplicate write events for the subscribers.
+	if due, _ := st.ListDueOutbox(ctx, time.Now().Add(time.Hour), 0); len(due) != 2 {
+		t.Fatalf("%d outbox messages, want one for the ticket and one for the reply", len(due))
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		want error
+	}{
+		{
+			"no authentication results",
+			email("From: cal@acme.test", "Message-ID: <r1@acme.test>", "Subject: Hi", "", "Hello"),
+			ErrUnauthenticated,
+		},
+		{
+			"failing results",
+			email(
+				"Authentication-Results: "+testRelay+"; dmarc=fail header.from=acme.test; dkim=fail header.d=acme.test; spf=fail smtp.mailfrom=cal@acme.test",
+				"From: cal@acme.test", "Message-ID: <r2@acme.test>", "Subject: Hi", "", "Hello",
+			),
+			ErrUnauthenticated,
+		},
+		{
+			"results the sender wrote",
+			email(
+				"Authentication-Results: mx.evil.test; dmarc=pass header.from=acme.test",
+				"From: cal@acme.test", "Message-ID: <r3@acme.test>", "Subject: Hi", "", "Hello",
+			),
+			ErrUnauthenticated,
+		},
+		{
+			"spoofed pass below the relay's failing result",
+			email(
+				"Authentication-Results: "+testRelay+"; dmarc=fail header.from=acme.test",
+				"Authentication-Results: "+testRelay+"; dmarc=pass header.from=acme.test",
+				"From: cal@acme.test", "Message-ID: <r4@acme.test>", "Subject: Hi", "", "Hello",
+			),
+			ErrUnauthenticated,
+		},
+		{
+			"pass for another domain",
+			email(
+				"Authentication-Results: "+testRelay+"; dkim=pass header.d=evil.test",
+				"From: cal@acme.test", "Message-ID: <r5@acme.test>", "Subject: Hi", "", "Hello",
+			),
+			ErrUnauthenticated,
+		},
+		{"unknown sender", authedEmail("mallory@acme.test", "<r6@acme.test>", "Hi", "Hello"), ErrUnknownSender},
+		{"staff sender", authedEmail("jane@acme.test", "<r7@acme.test>", "Hi", "Hello"), ErrUnknownSender},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			p, st := newProcessor(t)
+			if res, err := p.Process(ctx, tc.raw); !errors.Is(err, tc.want) {
+				t.Fatalf("Process = %+v, %v, want %v", res, err, tc.want)
+			}
+			if list, _ := st.ListTickets(ctx, "", "", "", "", "", ""); len(list) != 0 {
+				t.Fatalf("rejected email filed %+v", list)
+			}
+		})
+	}
+}
@@ -2,10 +2,14 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
 )
 
 type contextKey string
@@ -14,19 +18,47 @@ const (
 	UserIDKey contextKey = "userID"
 	RoleKey   contextKey = "role"
 	OrgIDKey  contextKey = "orgID"
+	ScopesKey contextKey = "scopes" // set only for API tokens
+	// PermissionsKey holds the caller's permissions: their role's, narrowed by
+	// their custom role if they have one.
+	PermissionsKey contextKey = "permissions"
+	// TokenVersionKey holds the token version the credential was issued at.
+	TokenVersionKey contextKey = "tokenVersion"
 )
 
+// ErrSessionEnded is returned by Reauthorize once the caller's user is
+// deleted or their sessions have been revoked.
+var ErrSessionEnded = errors.New("session is no longer valid")
+
+// Claims identify the user. Role and OrganizationID are informational only:
+// Auth takes both from the current user record, so role changes, org moves
+// and deletions apply to tokens that were already issued.
 type Claims struct {
 	UserID         string `json:"userId"`
 	Role           string `json:"role"`
 	OrganizationID string `json:"organizationId"`
+	TokenVersion   int    `json:"tv"`
 	jwt.RegisteredClaims
 }
 
-func Auth(jwtSecret string) func(http.Handler) http.Handler {
+// UserLookup loads the current user record so tokens can be checked against
+// it, and the custom role that narrows the user's permissions.
+type UserLookup interface {
+	GetUser(ctx context.Context, id string) (*models.User, error)
+	GetCustomRole(ctx context.Context, id string) (*models.CustomRole, error)
+}
+
+// Auth validates the bearer JWT or API token and resolves its user through
+// users (usually a UserCache). Tokens whose user no longer exists or whose
+// token version is older than the user's (e.g. after a password reset) are
+// rejected. API tokens additionally carry their scopes in the context.
+func Auth(jwtSecret string, users UserLookup, tokens TokenLookup) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				authHeader = websocketAuth(r)
+			}
 			if authHeader == "" {
 				http.Error(w, `{"error":"missing authorization header"}`, http.StatusUnauthorized)
 				return
@@ -39,27 +71,144 @@ func Auth(jwtSecret string) func(http.Handler) http.Handler {
 			}
 
 			tokenStr := parts[1]
+			if strings.HasPrefix(tokenStr, APITokenPrefix) {
+				t, user, err := authenticateAPIToken(r.Context(), tokens, users, tokenStr)
+				if err != nil {
+					http.Error(w, `{"error":"failed to load user"}`, http.StatusInternalServerError)
+					return
+				}
+				if t == nil {
+					http.Error(w, `{"error":"invalid or expired token"}`, http.StatusUnauthorized)
+					return
+				}
+				ctx, err := userContext(r.Context(), users, user)
+				if err != nil {
+					http.Error(w, `{"error":"failed to load user"}`, http.StatusInternalServerError)
+					return
+				}
+				ctx = context.WithValue(ctx, TokenVersionKey, t.TokenVersion)
+				next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, ScopesKey, t.Scopes)))
+				return
+			}
+
 			claims := &Claims{}
 
 			token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
 				return []byte(jwtSecret), nil
-			})
+			}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
 
 			if err != nil || !token.Valid {
 				http.Error(w, `{"error":"invalid or expired token"}`, http.StatusUnauthorized)
 				return
 			}
 
-			// Add claims to context
-			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
-			ctx = context.WithValue(ctx, RoleKey, claims.Role)
-			ctx = context.WithValue(ctx, OrgIDKey, claims.OrganizationID)
+			user, err := loadUser(r.Context(), users, claims.UserID, claims.TokenVersion)
+			if err != nil {
+				http.Error(w, `{"error":"failed to load user"}`, http.StatusInternalServerError)
+				return
+			}
+			if user == nil || user.TokenVersion != claims.TokenVersion {
+				http.Error(w, `{"error":"session is no longer valid"}`, http.StatusUnauthorized)
+				return
+			}
 
-			next.ServeHTTP(w, r.WithContext(ctx))
+			ctx, err := userContext(r.Context(), users, user)
+			if err != nil {
+				http.Error(w, `{"error":"failed to load user"}`, http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, TokenVersionKey, claims.TokenVersion)))
 		})
 	}
 }
 
+// loadUser returns the user for a credential issued at tokenVersion. A
+// credential newer than the cached user (issued after a password change)
+// means the cache is stale, so the user is reloaded. Deleted users are
+// reported as missing.
+func loadUser(ctx context.Context, users UserLookup, id string, tokenVersion int) (*models.User, error) {
+	user, err := users.GetUser(ctx, id)
+	if c, ok := users.(*UserCache); ok && err == nil && user != nil && user.TokenVersion < tokenVersion {
+		c.Forget(id)
+		user, err = users.GetUser(ctx, id)
+	}
+	if user != nil && user.DeletedAt != nil {
+		return nil, err
+	}
+	return user, err
+}
+
+// Reauthorize reloads the caller's user for connections that stay open long
+// after Auth ran, and returns ctx with their current role, organization and
+// permissions. It returns ErrSessionEnded once the user is deleted or their
+// sessions have been revoked.
+func Reauthorize(ctx context.Context, users UserLookup) (context.Context, error) {
+	version, _ := ctx.Value(TokenVersionKey).(int)
+	user, err := loadUser(ctx, users, GetUserID(ctx), version)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.TokenVersion != version {
+		return nil, ErrSessionEnded
+	}
+	return userContext(ctx, users, user)
+}
+
+func userContext(ctx context.Context, users UserLookup, user *models.User) (context.Context, error) {
+	orgID := ""
+	if user.OrganizationID != nil {
+		orgID = *user.OrganizationID
+	}
+	perms, err := UserPermissions(ctx, users, user)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, UserIDKey, user.ID)
+	ctx = context.WithValue(ctx, RoleKey, user.Role)
+	ctx = context.WithValue(ctx, PermissionsKey, perms)
+	return context.WithValue(ctx, OrgIDKey, orgID), nil
+}
+
+// UserPermissions returns the permissions of user: their role's, narrowed by
+// their custom role if they have one. It is what Auth puts in the context,
+// for code that checks a user other than the caller.
+func UserPermissions(ctx context.Context, users UserLookup, user *models.User) ([]policy.Permission, error) {
+	if user.CustomRoleID == "" {
+		return policy.Permissions(user.Role), nil
+	}
+	role, err := users.GetCustomRole(ctx, user.CustomRoleID)
+	if err != nil {
+		return nil, err
+	}
+	// A role that is gone or belongs to another organization grants nothing.
+	var allowed []policy.Permission
+	if role != nil && user.OrganizationID != nil && role.OrganizationID == *user.OrganizationID {
+		for _, p := range role.Permissions {
+			allowed = append(allowed, policy.Permission(p))
+		}
+	}
+	return policy.Restrict(user.Role, allowed), nil
+}
+
+// WebsocketTokenPrefix marks the subprotocol that carries the JWT on WebSocket
+// upgrades, since browsers cannot set the Authorization header there:
+// new WebSocket(url, ["presence", "bearer." + token]).
+const WebsocketTokenPrefix = "bearer."
+
+// websocketAuth returns an Authorization header value built from the token
+// subprotocol of a WebSocket upgrade request, or "".
+func websocketAuth(r *http.Request) string {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return ""
+	}
+	for _, p := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		if token, ok := strings.CutPrefix(strings.TrimSpace(p), WebsocketTokenPrefix); ok {
+			return "Bearer " + token
+		}
+	}
+	return ""
+}
+
 // Helper functions to extract values from context
 func GetUserID(ctx context.Context) string {
 	if v, ok := ctx.Value(UserIDKey).(string); ok {
@@ -82,17 +231,24 @@ func GetOrgID(ctx context.Context) string {
 	return ""
 }
 
-// RequireRoles creates middleware that restricts access to specific roles
-func RequireRoles(roles ...string) func(http.Handler) http.Handler {
-	roleSet := make(map[string]bool)
-	for _, r := range roles {
-		roleSet[r] = true
-	}
+// GetPermissions returns the caller's permissions, sorted.
+func GetPermissions(ctx context.Context) []policy.Permission {
+	perms, _ := ctx.Value(PermissionsKey).([]policy.Permission)
+	return perms
+}
+
+// HasPermission reports whether the caller holds p. Handlers use it rather
+// than policy.Allowed so that custom roles are taken into account.
+func HasPermission(ctx context.Context, p policy.Permission) bool {
+	return slices.Contains(GetPermissions(ctx), p)
+}
 
+// RequirePermission creates middleware that restricts access to callers
+// holding at least one of perms (see package policy).
+func RequirePermission(perms ...policy.Permission) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userRole := GetRole(r.Context())
-			if !roleSet[userRole] {
+			if !slices.ContainsFunc(perms, func(p policy.Permission) bool { return HasPermission(r.Context(), p) }) {
 				http.Error(w, `{"error":"insufficient permissions"}`, http.StatusForbidden)
 				return
 			}
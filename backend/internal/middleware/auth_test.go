@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+func TestUserCacheRevalidatesTokenVersion(t *testing.T) {
+	ctx := context.Background()
+	st := storetest.New()
+	st.CreateUser(ctx, &models.User{ID: "user-ann", Email: "ann@example.test", Role: policy.Admin})
+	cache := NewUserCache(st, time.Hour)
+
+	var role string
+	handler := Auth("test-secret", cache, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role = GetRole(r.Context())
+	}))
+	request := func(version int) int {
+		t.Helper()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+			UserID:       "user-ann",
+			Role:         policy.Admin, // ignored in favour of the user record
+			TokenVersion: version,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}).SignedString([]byte("test-secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		role = ""
+		r := httptest.NewRequest("GET", "/api/tickets", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	if code := request(0); code != http.StatusOK || role != policy.Admin {
+		t.Fatalf("first request: %d as %q", code, role)
+	}
+
+	// Ann is demoted and signs in again, which revokes her older tokens. The
+	// cache still holds her old record, but a token newer than it reloads her.
+	demoted := policy.Client
+	st.UpdateUser(ctx, "user-ann", nil, &demoted, nil, nil)
+	st.BumpTokenVersion(ctx, "user-ann")
+	if code := request(1); code != http.StatusOK || role != policy.Client {
+		t.Fatalf("token issued after the change: %d as %q, want 200 as the new role", code, role)
+	}
+	if code := request(0); code != http.StatusUnauthorized {
+		t.Fatalf("revoked token: %d, want 401 once the cache has reloaded", code)
+	}
+
+	// A token from the future does not make a deleted user valid again.
+	st.SoftDeleteUser(ctx, "user-ann", time.Now())
+	if code := request(3); code != http.StatusUnauthorized {
+		t.Fatalf("deleted user: %d, want 401", code)
+	}
+}
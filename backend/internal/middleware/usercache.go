@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+)
+
+// UserCache is a UserLookup that remembers users and custom roles for a short
+// time, so that authenticating every request does not cost a database read.
+// Changes to a user (role, organization, deletion, revoked sessions) or to a
+// custom role take effect within ttl.
+type UserCache struct {
+	users UserLookup
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   any // *models.User or *models.CustomRole; nil if it does not exist
+	expires time.Time
+}
+
+// maxCachedUsers bounds the cache; expired entries are swept once it is reached.
+const maxCachedUsers = 10000
+
+// rolePrefix keeps custom roles apart from users in the cache.
+const rolePrefix = "role:"
+
+func NewUserCache(users UserLookup, ttl time.Duration) *UserCache {
+	return &UserCache{users: users, ttl: ttl, entries: map[string]cacheEntry{}}
+}
+
+func (c *UserCache) GetUser(ctx context.Context, id string) (*models.User, error) {
+	v, err := c.get(id, func() (any, error) {
+		u, err := c.users.GetUser(ctx, id)
+		if u == nil {
+			return nil, err
+		}
+		return u, err
+	})
+	u, _ := v.(*models.User)
+	return u, err
+}
+
+func (c *UserCache) GetCustomRole(ctx context.Context, id string) (*models.CustomRole, error) {
+	v, err := c.get(rolePrefix+id, func() (any, error) {
+		role, err := c.users.GetCustomRole(ctx, id)
+		if role == nil {
+			return nil, err
+		}
+		return role, err
+	})
+	role, _ := v.(*models.CustomRole)
+	return role, err
+}
+
+func (c *UserCache) get(key string, load func() (any, error)) (any, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.value, nil
+	}
+
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= maxCachedUsers {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCachedUsers {
+			c.entries = map[string]cacheEntry{}
+		}
+	}
+	c.entries[key] = cacheEntry{value: v, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return v, nil
+}
+
+// Forget drops a cached user so the next request reloads it. It is a no-op on
+// a nil cache.
+func (c *UserCache) Forget(id string) {
+	c.forget(id)
+}
+
+// ForgetRole drops a cached custom role. It is a no-op on a nil cache.
+func (c *UserCache) ForgetRole(id string) {
+	c.forget(rolePrefix + id)
+}
+
+func (c *UserCache) forget(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
@@ -4,46 +4,248 @@ import "time"
 
 // Organization represents a client organization
 type Organization struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	Plan         string    `json:"plan"`
-	ContactEmail string    `json:"contactEmail"`
-	CreatedAt    time.Time `json:"createdAt"`
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Plan         string `json:"plan"`
+	ContactEmail string `json:"contactEmail"`
+	// ChatWebhookURL is a Slack-compatible incoming webhook for the org's
+	// channel. It is a credential and only shown to staff.
+	ChatWebhookURL string `json:"chatWebhookUrl,omitempty"`
+	// RequireTwoFactor makes members set up two-factor authentication before
+	// they can sign in.
+	RequireTwoFactor bool `json:"requireTwoFactor"`
+	// MemberInvites lets members whose custom role grants user.invite invite
+	// colleagues into the organization themselves.
+	MemberInvites bool `json:"memberInvites"`
+	// SSO lets members sign in through the organization's identity provider.
+	SSO *SSOConfig `json:"sso,omitempty"`
+	// DeletedAt is set while the organization and its members are
+	// soft-deleted; they are purged for good after the retention period.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
 }
 
-// User represents a system user
-type User struct {
+// SSOConfig is an organization's OpenID Connect identity provider. Users with
+// an email in one of Domains are sent to it instead of entering a password,
+// and are created on first login.
+type SSOConfig struct {
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"` // write-only; never returned
+	// Domains are the email domains discovered at login, e.g. "example.com".
+	Domains []string `json:"domains"`
+	// RoleClaim names the ID token claim holding the user's groups (e.g.
+	// "groups"); RoleMapping maps its values to "client" or to custom role
+	// IDs of the organization. Users matching no mapping get DefaultRole,
+	// "client" when empty.
+	RoleClaim   string            `json:"roleClaim,omitempty"`
+	RoleMapping map[string]string `json:"roleMapping,omitempty"`
+	DefaultRole string            `json:"defaultRole,omitempty"`
+}
+
+// CustomRole is a role an organization defines for its members, such as
+// "client admin" or "client requester". Members keep their built-in role and
+// hold only those of its permissions that are listed in Permissions.
+type CustomRole struct {
 	ID             string    `json:"id"`
+	OrganizationID string    `json:"organizationId"`
 	Name           string    `json:"name"`
-	Email          string    `json:"email"`
-	PasswordHash   string    `json:"-"`
-	Role           string    `json:"role"`
-	OrganizationID *string   `json:"organizationId"`
-	Avatar         string    `json:"avatar"`
-	Phone          string    `json:"phone"`
+	Description    string    `json:"description,omitempty"`
+	Permissions    []string  `json:"permissions"`
 	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
 }
 
-// UserResponse is the JSON-safe version of User
-type UserResponse struct {
+type CustomRoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// User represents a system user
+type User struct {
 	ID             string  `json:"id"`
 	Name           string  `json:"name"`
 	Email          string  `json:"email"`
+	PasswordHash   string  `json:"-"`
 	Role           string  `json:"role"`
 	OrganizationID *string `json:"organizationId"`
 	Avatar         string  `json:"avatar"`
 	Phone          string  `json:"phone"`
+	Team           string  `json:"team"`
+	TokenVersion   int     `json:"-"` // embedded in JWTs; bumping it revokes existing sessions
+	// ServiceAccount users belong to automation: they have no password and
+	// authenticate only with API tokens.
+	ServiceAccount bool `json:"-"`
+	// CustomRoleID optionally narrows Role to one of the organization's
+	// custom roles.
+	CustomRoleID string `json:"-"`
+	// PasswordHistory holds the hashes of previous passwords, newest first,
+	// so they are not chosen again. PasswordChangeRequired makes the next
+	// sign-in set a new password before it gets a session.
+	PasswordHistory        []string `json:"-"`
+	PasswordChangeRequired bool     `json:"-"`
+	// Invitation is set until an invited user accepts; they cannot sign in
+	// before that.
+	Invitation *Invitation `json:"-"`
+	// DeletedAt is set while the user is soft-deleted: they cannot sign in and
+	// are left out of lists until restored or purged for good.
+	DeletedAt *time.Time `json:"-"`
+	// Two-factor authentication. TOTPSecret is set once enrollment is
+	// confirmed; until then the secret waits in TOTPPendingSecret.
+	TOTPSecret        string    `json:"-"`
+	TOTPPendingSecret string    `json:"-"`
+	TOTPLastStep      int64     `json:"-"` // last accepted time step, so codes are single-use
+	RecoveryCodes     []string  `json:"-"` // SHA-256 hashes of unused recovery codes
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+func (u *User) TwoFactorEnabled() bool { return u.TOTPSecret != "" }
+
+// Invitation is a pending user's invitation. The link sent by email carries
+// the user ID and a secret whose SHA-256 hash is TokenHash; resending the
+// invitation replaces it, so only the newest link works.
+type Invitation struct {
+	InvitedBy string    `json:"invitedBy"`
+	InvitedAt time.Time `json:"invitedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	TokenHash string    `json:"-"`
+}
+
+type InvitationRequest struct {
+	Email          string `json:"email"`
+	Name           string `json:"name"`
+	Role           string `json:"role"` // defaults to client
+	OrganizationID string `json:"organizationId"`
+	CustomRoleID   string `json:"customRoleId"`
+	Team           string `json:"team"`
+}
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// InvitationInfo is what an invitation link shows before it is accepted.
+type InvitationInfo struct {
+	Email            string    `json:"email"`
+	Name             string    `json:"name"`
+	OrganizationName string    `json:"organizationName,omitempty"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+}
+
+// UserToken is a single-use secret sent to a user by email (e.g. a password
+// reset link). Only the SHA-256 hash of the token is stored.
+type UserToken struct {
+	TokenHash string     `json:"-"`
+	UserID    string     `json:"userId"`
+	Purpose   string     `json:"purpose"` // "password-reset", "sso-login", "email-change"
+	NewEmail  string     `json:"-"`       // the address an "email-change" token confirms
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// LoginAttempts counts recent failed sign-ins for one key: an email address
+// ("email:<address>") or a client IP ("ip:<address>"). Counting by email
+// rather than user ID treats unknown addresses like real ones.
+type LoginAttempts struct {
+	Key           string     `json:"-"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil,omitempty"`
+}
+
+// Session is a login on one device. The client holds a refresh token of the
+// form "<session id>.<secret>"; only the SHA-256 hash of the current secret is
+// stored, and it changes on every refresh. PreviousHash is kept so a replayed
+// (stolen) refresh token can be detected and the session revoked.
+type Session struct {
+	ID           string     `json:"id"`
+	UserID       string     `json:"userId"`
+	TokenHash    string     `json:"-"`
+	PreviousHash string     `json:"-"`
+	TokenVersion int        `json:"-"` // the user's token version at login
+	UserAgent    string     `json:"userAgent"`
+	IP           string     `json:"ip"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   time.Time  `json:"lastUsedAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+}
+
+// APIToken is a long-lived credential for scripts, sent as a bearer token of
+// the form "sfx_<id>_<secret>". Only the SHA-256 hash of the secret is stored.
+// A token acts as its user but only for the scopes it was granted, and stops
+// working when the user's sessions are revoked (TokenVersion).
+type APIToken struct {
+	ID           string     `json:"id"`
+	UserID       string     `json:"userId"`
+	Name         string     `json:"name"`
+	SecretHash   string     `json:"-"`
+	Scopes       []string   `json:"scopes"`
+	TokenVersion int        `json:"-"`
+	CreatedBy    string     `json:"createdBy"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+}
+
+// CreateAPITokenRequest creates a token; ExpiresInDays defaults to 90.
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// CreatedAPIToken is returned once, when the token is created; the secret
+// cannot be retrieved again.
+type CreatedAPIToken struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+// UserResponse is the JSON-safe version of User
+type UserResponse struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	Email            string  `json:"email"`
+	Role             string  `json:"role"`
+	OrganizationID   *string `json:"organizationId"`
+	Avatar           string  `json:"avatar"`
+	Phone            string  `json:"phone"`
+	Team             string  `json:"team,omitempty"`
+	TwoFactorEnabled bool    `json:"twoFactorEnabled"`
+	ServiceAccount   bool    `json:"serviceAccount,omitempty"`
+	CustomRoleID     string  `json:"customRoleId,omitempty"`
+	// Permissions are only returned for the current user (GET /api/auth/me).
+	Permissions []string `json:"permissions,omitempty"`
+	// LockedUntil is set by GET /api/users/{id} while sign-in is locked after
+	// failed attempts.
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+	// Invitation is set while the user has not accepted their invitation.
+	Invitation *Invitation `json:"invitation,omitempty"`
+	// DeletedAt is set for soft-deleted users (GET /api/users?deleted=true).
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 func (u *User) ToResponse() UserResponse {
 	r := UserResponse{
-		ID:             u.ID,
-		Name:           u.Name,
-		Email:          u.Email,
-		Role:           u.Role,
-		Avatar:         u.Avatar,
-		OrganizationID: u.OrganizationID,
-		Phone:          u.Phone,
+		ID:               u.ID,
+		Name:             u.Name,
+		Email:            u.Email,
+		Role:             u.Role,
+		Avatar:           u.Avatar,
+		OrganizationID:   u.OrganizationID,
+		Phone:            u.Phone,
+		Team:             u.Team,
+		TwoFactorEnabled: u.TwoFactorEnabled(),
+		ServiceAccount:   u.ServiceAccount,
+		CustomRoleID:     u.CustomRoleID,
+		Invitation:       u.Invitation,
+		DeletedAt:        u.DeletedAt,
 	}
 	return r
 }
@@ -60,27 +262,31 @@ type Ticket struct {
 	CreatedBy      string    `json:"createdBy"`
 	AssignedTo     *string   `json:"assignedTo"`
 	HoursWorked    float64   `json:"hoursWorked"`
+	Tags           []string  `json:"tags"`
 	CreatedAt      time.Time `json:"createdAt"`
 	UpdatedAt      time.Time `json:"updatedAt"`
 }
 
 // TicketResponse is the JSON-safe version
 type TicketResponse struct {
-	ID                string              `json:"id"`
-	Title             string              `json:"title"`
-	Description       string              `json:"description"`
-	Status            string              `json:"status"`
-	Priority          string              `json:"priority"`
-	Category          string              `json:"category"`
-	OrganizationID    string              `json:"organizationId"`
-	CreatedBy         string              `json:"createdBy"`
-	AssignedTo        *string             `json:"assignedTo"`
-	HoursWorked       float64             `json:"hoursWorked"`
-	CreatedAt         time.Time           `json:"createdAt"`
-	UpdatedAt         time.Time           `json:"updatedAt"`
-	Messages          []Message           `json:"messages"`
-	TimeEntries       []TimeEntry         `json:"timeEntries"`
-	ConversionRequest *ConversionRequest  `json:"conversionRequest,omitempty"`
+	ID                string             `json:"id"`
+	Title             string             `json:"title"`
+	Description       string             `json:"description"`
+	Status            string             `json:"status"`
+	Priority          string             `json:"priority"`
+	Category          string             `json:"category"`
+	OrganizationID    string             `json:"organizationId"`
+	CreatedBy         string             `json:"createdBy"`
+	AssignedTo        *string            `json:"assignedTo"`
+	HoursWorked       float64            `json:"hoursWorked"`
+	Tags              []string           `json:"tags"`
+	CreatedAt         time.Time          `json:"createdAt"`
+	UpdatedAt         time.Time          `json:"updatedAt"`
+	Messages          []Message          `json:"messages"`
+	TimeEntries       []TimeEntry        `json:"timeEntries"`
+	ConversionRequest *ConversionRequest `json:"conversionRequest,omitempty"`
+	Watchers          []TicketWatcher    `json:"watchers"`
+	Attachments       []Attachment       `json:"attachments"`
 }
 
 func (t *Ticket) ToResponse() TicketResponse {
@@ -95,10 +301,16 @@ func (t *Ticket) ToResponse() TicketResponse {
 		CreatedBy:      t.CreatedBy,
 		AssignedTo:     t.AssignedTo,
 		HoursWorked:    t.HoursWorked,
+		Tags:           t.Tags,
 		CreatedAt:      t.CreatedAt,
 		UpdatedAt:      t.UpdatedAt,
 		Messages:       []Message{},
 		TimeEntries:    []TimeEntry{},
+		Watchers:       []TicketWatcher{},
+		Attachments:    []Attachment{},
+	}
+	if r.Tags == nil {
+		r.Tags = []string{}
 	}
 	return r
 }
@@ -113,6 +325,123 @@ type Message struct {
 	CreatedAt  time.Time `json:"createdAt"`
 }
 
+// Attachment is file metadata; the content lives in blob storage under StorageKey.
+type Attachment struct {
+	ID          string    `json:"id"`
+	TicketID    string    `json:"ticketId"`
+	MessageID   string    `json:"messageId,omitempty"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int       `json:"size"`
+	StorageKey  string    `json:"-"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// WebhookSubscription posts signed event payloads to an external URL. An
+// empty OrganizationID subscribes to events from every organization.
+type WebhookSubscription struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organizationId,omitempty"`
+	URL            string    `json:"url"`
+	Events         []string  `json:"events"` // empty means all events
+	Secret         string    `json:"-"`
+	Active         bool      `json:"active"`
+	Description    string    `json:"description,omitempty"`
+	CreatedBy      string    `json:"createdBy"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// WebhookDelivery is one event sent to one subscription, with its retry state.
+type WebhookDelivery struct {
+	ID             string     `json:"id"`
+	SubscriptionID string     `json:"subscriptionId"`
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"` // "pending", "succeeded" or "failed"
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"responseStatus,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// OutboxMessage is a serialized domain event written in the same transaction
+// as the change that caused it, and deleted once it has been dispatched.
+type OutboxMessage struct {
+	ID        string `json:"id"`
+	Event     string `json:"event"`
+	Payload   string `json:"payload"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"lastError,omitempty"`
+	// Subscribers that have handled the event; retries skip them.
+	Delivered     []string  `json:"delivered,omitempty"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// StreamEvent is a message of the real-time event stream. It is kept for a
+// short while so that every API instance can pass it on to its own clients.
+type StreamEvent struct {
+	ID             string `json:"id"`
+	Type           string `json:"type"`
+	TicketID       string `json:"ticketId"`
+	OrganizationID string `json:"organizationId"`
+	Internal       bool   `json:"internal"`
+	// Data is the JSON sent to clients.
+	Data      string    `json:"data"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PurgeJob tracks the permanent deletion of a soft-deleted organization,
+// which is too much work for one request. The job runs in steps (see
+// PurgeSteps) and saves its progress after every batch, so a run that is cut
+// short resumes where it stopped.
+type PurgeJob struct {
+	OrganizationID string `json:"organizationId"`
+	Status         string `json:"status"` // "pending", "running", "done" or "failed"
+	Step           string `json:"step"`
+	// Deleted counts the records deleted so far, by step.
+	Deleted     map[string]int `json:"deleted"`
+	Failures    int            `json:"failures"` // consecutive failed batches
+	LastError   string         `json:"lastError,omitempty"`
+	RequestedBy string         `json:"requestedBy,omitempty"` // empty when the retention period ran out
+	LeaseUntil  time.Time      `json:"-"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+	CompletedAt *time.Time     `json:"completedAt,omitempty"`
+}
+
+// PurgeSteps are the steps of a PurgeJob, in order. Tickets are deleted with
+// their activities, webhook subscriptions with their delivery logs.
+var PurgeSteps = []string{"tickets", "invoices", "roles", "webhooks", "members", "organization"}
+
+// TicketWatcher links a user to a ticket they follow
+type TicketWatcher struct {
+	TicketID  string    `json:"ticketId"`
+	UserID    string    `json:"userId"`
+	Source    string    `json:"source"` // "creator", "assignee", "commenter" or "manual"
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Macro is a canned response that agents can apply to a ticket. Applying it
+// posts the rendered content and optionally changes status, priority and tags.
+type Macro struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Content     string    `json:"content"`
+	IsInternal  bool      `json:"isInternal"`
+	Scope       string    `json:"scope"` // "global" or "team"
+	Team        string    `json:"team,omitempty"`
+	SetStatus   *string   `json:"setStatus,omitempty"`
+	SetPriority *string   `json:"setPriority,omitempty"`
+	AddTags     []string  `json:"addTags"`
+	CreatedBy   string    `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
 // TimeEntry represents logged time on a ticket
 type TimeEntry struct {
 	ID          string    `json:"id"`
@@ -190,8 +519,58 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refreshToken"`
+	ExpiresAt    time.Time    `json:"expiresAt"` // when Token expires
+	User         UserResponse `json:"user"`
+	// RecoveryCodes are returned once, when two-factor setup completes at login.
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// TwoFactorChallenge is returned by Login instead of a LoginResponse when the
+// user must complete a second step. Token identifies the half-finished login
+// to the two-factor endpoints for a few minutes. SetupRequired means the
+// user's organization requires two-factor but it is not set up yet.
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	SetupRequired     bool   `json:"setupRequired"`
+	Token             string `json:"twoFactorToken"`
+}
+
+// PasswordChangeChallenge is returned by Login instead of a LoginResponse
+// when the user has to choose a new password first (see
+// User.PasswordChangeRequired). Token is sent back with the new password to
+// SetPassword, which then continues the login.
+type PasswordChangeChallenge struct {
+	PasswordChangeRequired bool   `json:"passwordChangeRequired"`
+	Token                  string `json:"passwordChangeToken"`
+}
+
+type SetPasswordRequest struct {
+	PasswordChangeToken string `json:"passwordChangeToken"`
+	NewPassword         string `json:"newPassword"`
+}
+
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// provisioning URI, shown as a QR code
+}
+
+type TwoFactorVerifyRequest struct {
+	TwoFactorToken string `json:"twoFactorToken"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+// SSORedirect is returned by Login when the email's domain signs in through
+// an identity provider. The frontend navigates to LoginURL on the API.
+type SSORedirect struct {
+	SSORequired bool   `json:"ssoRequired"`
+	LoginURL    string `json:"loginUrl"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 type CreateTicketRequest struct {
@@ -203,9 +582,10 @@ type CreateTicketRequest struct {
 }
 
 type UpdateTicketRequest struct {
-	Status   *string `json:"status,omitempty"`
-	Priority *string `json:"priority,omitempty"`
-	AssignTo *string `json:"assignedTo,omitempty"`
+	Status   *string   `json:"status,omitempty"`
+	Priority *string   `json:"priority,omitempty"`
+	AssignTo *string   `json:"assignedTo,omitempty"`
+	Tags     *[]string `json:"tags,omitempty"`
 }
 
 type CreateMessageRequest struct {
@@ -213,6 +593,33 @@ type CreateMessageRequest struct {
 	IsInternal bool   `json:"isInternal"`
 }
 
+type AddWatcherRequest struct {
+	UserID string `json:"userId,omitempty"` // defaults to the caller
+}
+
+type MacroRequest struct {
+	Name        string   `json:"name"`
+	Content     string   `json:"content"`
+	IsInternal  bool     `json:"isInternal"`
+	Scope       string   `json:"scope"`
+	Team        string   `json:"team"`
+	SetStatus   *string  `json:"setStatus,omitempty"`
+	SetPriority *string  `json:"setPriority,omitempty"`
+	AddTags     []string `json:"addTags"`
+}
+
+type ApplyMacroRequest struct {
+	MacroID string `json:"macroId"`
+}
+
+type WebhookRequest struct {
+	OrganizationID *string  `json:"organizationId,omitempty"`
+	URL            *string  `json:"url,omitempty"`
+	Events         []string `json:"events,omitempty"`
+	Active         *bool    `json:"active,omitempty"`
+	Description    *string  `json:"description,omitempty"`
+}
+
 type CreateTimeEntryRequest struct {
 	Hours       float64 `json:"hours"`
 	Description string  `json:"description"`
@@ -271,3 +678,19 @@ type UpdateMyProfileRequest struct {
 type ForgotPasswordRequest struct {
 	Email string `json:"email"`
 }
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// EmailChangeRequest asks to move the current user to a new address, which
+// only happens once the link sent there is followed (VerifyEmailRequest).
+type EmailChangeRequest struct {
+	NewEmail        string `json:"newEmail"`
+	CurrentPassword string `json:"currentPassword"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
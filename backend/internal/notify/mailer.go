@@ -0,0 +1,199 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/supporttickr/backend/internal/config"
+)
+
+// Email is a rendered outbound message.
+type Email struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+	// Headers holds extra headers such as Message-ID, In-Reply-To and References.
+	Headers map[string]string
+}
+
+// Mailer delivers rendered emails.
+type Mailer interface {
+	Send(ctx context.Context, e *Email) error
+}
+
+// NewMailer picks a Mailer implementation from config.MailDriver:
+// "smtp" sends through SMTPHost, "file" writes .eml files to MailDir and
+// anything else (the default, "log") only logs the message.
+func NewMailer(cfg *config.Config) Mailer {
+	switch cfg.MailDriver {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+	case "file":
+		return &FileMailer{Dir: cfg.MailDir, From: cfg.MailFrom}
+	default:
+		return &LogMailer{From: cfg.MailFrom}
+	}
+}
+
+// SMTPMailer sends mail through an SMTP relay using STARTTLS when offered.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, e *Email) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address %q: %w", m.From, err)
+	}
+	raw, err := buildMessage(m.From, e)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, from.Address, e.To, raw)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileMailer writes each message as an .eml file, for local development.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, e *Email) error {
+	raw, err := buildMessage(m.From, e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), randomToken(4))
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		return err
+	}
+	log.Printf("mail: wrote %q to %s", e.Subject, path)
+	return nil
+}
+
+// LogMailer only logs recipients and subject. It is the default so that
+// nothing is sent by accident from a developer machine.
+type LogMailer struct {
+	From string
+}
+
+func (m *LogMailer) Send(ctx context.Context, e *Email) error {
+	log.Printf("mail: to=%s subject=%q", strings.Join(e.To, ","), e.Subject)
+	return nil
+}
+
+// buildMessage renders an RFC 5322 message with text and HTML alternatives.
+func buildMessage(from string, e *Email) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	headers := map[string]string{
+		"From":         from,
+		"To":           strings.Join(e.To, ", "),
+		"Subject":      mime.QEncoding.Encode("utf-8", e.Subject),
+		"Date":         time.Now().UTC().Format(time.RFC1123Z),
+		"MIME-Version": "1.0",
+		"Content-Type": `multipart/alternative; boundary="` + mw.Boundary() + `"`,
+	}
+	if _, ok := e.Headers["Message-ID"]; !ok {
+		headers["Message-ID"] = "<" + randomToken(12) + "@" + domainOf(from) + ">"
+	}
+	for k, v := range e.Headers {
+		headers[k] = v
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var head bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&head, "%s: %s\r\n", k, headers[k])
+	}
+	head.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", e.Text},
+		{"text/html; charset=utf-8", e.HTML},
+	} {
+		if part.body == "" {
+			continue
+		}
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		qp.Close()
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return append(head.Bytes(), buf.Bytes()...), nil
+}
+
+func domainOf(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		addr = a.Address
+	}
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return addr[i+1:]
+	}
+	return "localhost"
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,327 @@
+// Package notify sends email notifications for ticket and invoice activity.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// Notifier renders and sends notification emails. Ticket notifications go to
+// the ticket's watchers, excluding the user who caused the change. A nil
+// *Notifier is valid and sends nothing.
+type Notifier struct {
+	Store  store.Store
+	Mailer Mailer
+	// AppURL is the frontend base URL used to build links.
+	AppURL string
+	// MailDomain is used to build Message-ID and References headers so that
+	// replies can be threaded back to the ticket.
+	MailDomain string
+}
+
+func New(st store.Store, m Mailer, appURL, mailFrom string) *Notifier {
+	return &Notifier{Store: st, Mailer: m, AppURL: appURL, MailDomain: domainOf(mailFrom)}
+}
+
+func (n *Notifier) TicketCreated(ctx context.Context, t *models.Ticket, actorID string) error {
+	if n == nil {
+		return nil
+	}
+	// The creator is included so they get a confirmation with the ticket reference.
+	recipients, err := n.watcherEmails(ctx, t, "")
+	if err != nil {
+		return err
+	}
+	return n.send(ctx, "ticket_created", recipients, n.ticketHeaders(t, ""), templateData{
+		Ticket: t,
+		Actor:  n.user(ctx, actorID),
+		Link:   n.ticketLink(t.ID),
+	})
+}
+
+// PublicReply notifies watchers of a new message. Internal notes are never emailed.
+func (n *Notifier) PublicReply(ctx context.Context, t *models.Ticket, m *models.Message) error {
+	if n == nil || m.IsInternal {
+		return nil
+	}
+	recipients, err := n.watcherEmails(ctx, t, m.UserID)
+	if err != nil {
+		return err
+	}
+	return n.send(ctx, "public_reply", recipients, n.ticketHeaders(t, m.ID), templateData{
+		Ticket:  t,
+		Message: m,
+		Actor:   n.user(ctx, m.UserID),
+		Link:    n.ticketLink(t.ID),
+	})
+}
+
+func (n *Notifier) StatusChanged(ctx context.Context, t *models.Ticket, oldStatus, newStatus, actorID string) error {
+	if n == nil || oldStatus == newStatus {
+		return nil
+	}
+	recipients, err := n.watcherEmails(ctx, t, actorID)
+	if err != nil {
+		return err
+	}
+	return n.send(ctx, "status_changed", recipients, n.ticketHeaders(t, ""), templateData{
+		Ticket:    t,
+		Actor:     n.user(ctx, actorID),
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		Link:      n.ticketLink(t.ID),
+	})
+}
+
+func (n *Notifier) Assigned(ctx context.Context, t *models.Ticket, assigneeID, actorID string) error {
+	if n == nil || assigneeID == "" || assigneeID == actorID {
+		return nil
+	}
+	assignee := n.recipient(ctx, assigneeID)
+	if assignee == nil {
+		return nil
+	}
+	if ok, err := n.canRead(ctx, assignee, t); err != nil || !ok {
+		return err
+	}
+	return n.send(ctx, "assigned", []string{assignee.Email}, n.ticketHeaders(t, ""), templateData{
+		Ticket: t,
+		Actor:  n.user(ctx, actorID),
+		Link:   n.ticketLink(t.ID),
+	})
+}
+
+// InvoiceSent emails the organization's billing contact.
+func (n *Notifier) InvoiceSent(ctx context.Context, inv *models.Invoice) error {
+	if n == nil {
+		return nil
+	}
+	org, err := n.Store.GetOrg(ctx, inv.OrganizationID)
+	if err != nil {
+		return fmt.Errorf("load organization %s: %w", inv.OrganizationID, err)
+	}
+	if org == nil || org.ContactEmail == "" {
+		return nil
+	}
+	period := fmt.Sprintf("%d-%02d", inv.Year, inv.Month)
+	if inv.Month >= 1 && inv.Month <= 12 {
+		period = time.Month(inv.Month).String() + " " + fmt.Sprint(inv.Year)
+	}
+	return n.send(ctx, "invoice_sent", []string{org.ContactEmail}, nil, templateData{
+		Invoice:   inv,
+		Org:       org,
+		Period:    period,
+		Link:      n.AppURL,
+		LinkLabel: "View invoice",
+	})
+}
+
+// PasswordReset emails a reset link containing the raw token.
+func (n *Notifier) PasswordReset(ctx context.Context, u *models.User, token string, ttl time.Duration) error {
+	if n == nil {
+		return nil
+	}
+	return n.send(ctx, "password_reset", []string{u.Email}, nil, templateData{
+		Recipient: u,
+		ExpiresIn: ttl.String(),
+		Link:      n.AppURL + "/?reset-token=" + token,
+		LinkLabel: "Reset password",
+	})
+}
+
+// Invitation emails an invited user the link to accept their invitation.
+func (n *Notifier) Invitation(ctx context.Context, u *models.User, token string, ttl time.Duration) error {
+	if n == nil {
+		return nil
+	}
+	return n.send(ctx, "invitation", []string{u.Email}, nil, templateData{
+		Recipient: u,
+		ExpiresIn: ttl.String(),
+		Link:      n.AppURL + "/?invitation-token=" + token,
+		LinkLabel: "Accept invitation",
+	})
+}
+
+// EmailChange emails the link that confirms u's new address to that address,
+// and tells the current address about the request.
+func (n *Notifier) EmailChange(ctx context.Context, u *models.User, newEmail, token string, ttl time.Duration) error {
+	if n == nil {
+		return nil
+	}
+	err := n.send(ctx, "email_change", []string{newEmail}, nil, templateData{
+		Recipient: u,
+		NewEmail:  newEmail,
+		ExpiresIn: ttl.String(),
+		Link:      n.AppURL + "/?verify-email-token=" + token,
+		LinkLabel: "Confirm email address",
+	})
+	return errors.Join(err, n.send(ctx, "email_change_notice", []string{u.Email}, nil, templateData{
+		Recipient: u,
+		NewEmail:  newEmail,
+	}))
+}
+
+// EmailChanged tells u's previous address that their address is now
+// newEmail. u is the user as it was before the change.
+func (n *Notifier) EmailChanged(ctx context.Context, u *models.User, newEmail string) error {
+	if n == nil {
+		return nil
+	}
+	return n.send(ctx, "email_changed", []string{u.Email}, nil, templateData{
+		Recipient: u,
+		NewEmail:  newEmail,
+	})
+}
+
+// send mails the named template to each address in to. It returns the
+// failures so that the outbox retries the event; addresses that were mailed
+// are skipped on the retry.
+func (n *Notifier) send(ctx context.Context, name string, to []string, headers map[string]string, data templateData) error {
+	if len(to) == 0 {
+		return nil
+	}
+	e, err := render(name, data)
+	if err != nil {
+		return fmt.Errorf("render %s: %w", name, err)
+	}
+	e.Headers = headers
+	// One message per recipient so addresses are not disclosed to each other.
+	var errs []error
+	for _, addr := range to {
+		key := name + "/" + addr
+		done, err := n.sent(ctx, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if done {
+			continue
+		}
+		msg := *e
+		msg.To = []string{addr}
+		if err := n.Mailer.Send(ctx, &msg); err != nil {
+			errs = append(errs, fmt.Errorf("send %s to %s: %w", name, addr, err))
+			continue
+		}
+		n.markSent(ctx, key)
+	}
+	return errors.Join(errs...)
+}
+
+// sent reports whether the email identified by key already went out for the
+// event being dispatched, so a redelivered event does not mail anyone twice.
+// Mail sent outside of an event is not tracked.
+func (n *Notifier) sent(ctx context.Context, key string) (bool, error) {
+	id := events.ID(ctx)
+	if id == "" {
+		return false, nil
+	}
+	done, err := n.Store.WasSent(ctx, id+"/"+key)
+	if err != nil {
+		return false, fmt.Errorf("look up %s for %s: %w", key, id, err)
+	}
+	return done, nil
+}
+
+// markSent records that the email identified by key went out for the event
+// being dispatched. Failing to record it only risks a duplicate if the event
+// is redelivered, so the error is logged.
+func (n *Notifier) markSent(ctx context.Context, key string) {
+	id := events.ID(ctx)
+	if id == "" {
+		return
+	}
+	err := n.Store.MarkSent(ctx, id+"/"+key)
+	if err != nil && !errors.Is(err, store.ErrAlreadySent) {
+		log.Printf("notify: record %s for %s: %v", key, id, err)
+	}
+}
+
+// ticketHeaders threads all mail about a ticket under a stable root Message-ID.
+func (n *Notifier) ticketHeaders(t *models.Ticket, messageID string) map[string]string {
+	root := "<" + t.ID + "@" + n.MailDomain + ">"
+	h := map[string]string{
+		"References":          root,
+		"In-Reply-To":         root,
+		"X-SupportFix-Ticket": t.ID,
+	}
+	if messageID != "" {
+		h["Message-ID"] = "<" + t.ID + "." + messageID + "@" + n.MailDomain + ">"
+	}
+	return h
+}
+
+func (n *Notifier) ticketLink(ticketID string) string {
+	return n.AppURL + "/?ticket=" + ticketID
+}
+
+func (n *Notifier) user(ctx context.Context, id string) *models.User {
+	if id == "" {
+		return nil
+	}
+	u, _ := n.Store.GetUser(ctx, id)
+	return u
+}
+
+// recipient is user, skipping soft-deleted users, who no longer get email.
+func (n *Notifier) recipient(ctx context.Context, id string) *models.User {
+	u := n.user(ctx, id)
+	if u == nil || u.DeletedAt != nil {
+		return nil
+	}
+	return u
+}
+
+// canRead reports whether u may still read t, with the permission check the
+// ticket handlers make: ticket.read from their role as narrowed by their
+// custom role, and either access to every organization or membership of t's.
+// Watchers are not removed when a user moves organization or loses a role, so
+// this is checked on every send.
+func (n *Notifier) canRead(ctx context.Context, u *models.User, t *models.Ticket) (bool, error) {
+	perms, err := middleware.UserPermissions(ctx, n.Store, u)
+	if err != nil {
+		return false, fmt.Errorf("load permissions of %s: %w", u.ID, err)
+	}
+	if !slices.Contains(perms, policy.TicketRead) {
+		return false, nil
+	}
+	if slices.Contains(perms, policy.AnyOrganization) {
+		return true, nil
+	}
+	return u.OrganizationID != nil && *u.OrganizationID == t.OrganizationID, nil
+}
+
+// watcherEmails resolves the ticket's watchers to email addresses, skipping
+// excludeID and anyone who can no longer read the ticket.
+func (n *Notifier) watcherEmails(ctx context.Context, t *models.Ticket, excludeID string) ([]string, error) {
+	watchers, err := n.Store.ListWatchers(ctx, t.ID)
+	if err != nil {
+		return nil, fmt.Errorf("list watchers for %s: %w", t.ID, err)
+	}
+	var emails []string
+	for _, w := range watchers {
+		if w.UserID == excludeID {
+			continue
+		}
+		u := n.recipient(ctx, w.UserID)
+		if u == nil || u.Email == "" {
+			continue
+		}
+		if ok, err := n.canRead(ctx, u, t); err != nil {
+			return nil, err
+		} else if ok {
+			emails = append(emails, u.Email)
+		}
+	}
+	return emails, nil
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+// recorder is a Mailer that keeps the recipients of what it is sent.
+type recorder struct {
+	mu sync.Mutex
+	to []string
+}
+
+func (r *recorder) Send(ctx context.Context, e *Email) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.to = append(r.to, e.To...)
+	return nil
+}
+
+func TestWatchersNeedTicketRead(t *testing.T) {
+	ctx := context.Background()
+	st := storetest.New()
+	acme, globex := "org-acme", "org-globex"
+	st.PutCustomRole(ctx, &models.CustomRole{ID: "role-requester", OrganizationID: acme, Permissions: []string{string(policy.TicketRead), string(policy.TicketCreate)}})
+	st.PutCustomRole(ctx, &models.CustomRole{ID: "role-billing", OrganizationID: acme, Permissions: []string{string(policy.InvoiceRead)}})
+	st.PutCustomRole(ctx, &models.CustomRole{ID: "role-globex", OrganizationID: globex, Permissions: []string{string(policy.TicketRead)}})
+
+	tk := &models.Ticket{ID: "tkt-1", Title: "Printer on fire", Status: "open", OrganizationID: acme}
+	for _, u := range []*models.User{
+		{ID: "user-cal", Email: "cal@acme.test", Role: policy.Client, OrganizationID: &acme},
+		{ID: "user-req", Email: "req@acme.test", Role: policy.Client, OrganizationID: &acme, CustomRoleID: "role-requester"},
+		{ID: "user-bill", Email: "bill@acme.test", Role: policy.Client, OrganizationID: &acme, CustomRoleID: "role-billing"},
+		{ID: "user-gone", Email: "gone@acme.test", Role: policy.Client, OrganizationID: &acme, CustomRoleID: "role-deleted"},
+		{ID: "user-mole", Email: "mole@acme.test", Role: policy.Client, OrganizationID: &acme, CustomRoleID: "role-globex"},
+		{ID: "user-gil", Email: "gil@globex.test", Role: policy.Client, OrganizationID: &globex},
+		{ID: "user-jane", Email: "jane@support.test", Role: policy.SupportStaff},
+	} {
+		st.CreateUser(ctx, u)
+		st.AddWatcher(ctx, &models.TicketWatcher{TicketID: tk.ID, UserID: u.ID})
+	}
+
+	mail := &recorder{}
+	n := New(st, mail, "http://app.test", "support@support.test")
+	if err := n.StatusChanged(ctx, tk, "open", "resolved", ""); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(mail.to)
+	if want := []string{"cal@acme.test", "jane@support.test", "req@acme.test"}; !slices.Equal(mail.to, want) {
+		t.Errorf("mailed %v, want %v", mail.to, want)
+	}
+
+	// An assignee who cannot read the ticket is not told about it either.
+	mail.to = nil
+	if err := n.Assigned(ctx, tk, "user-bill", "user-jane"); err != nil {
+		t.Fatal(err)
+	}
+	if len(mail.to) != 0 {
+		t.Errorf("mailed %v about an assignment they cannot read", mail.to)
+	}
+}
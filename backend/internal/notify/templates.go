@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/supporttickr/backend/internal/models"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+// templateData is the value passed to every email template. Fields that do not
+// apply to a given notification are left nil or empty.
+type templateData struct {
+	Ticket    *models.Ticket
+	Message   *models.Message
+	Actor     *models.User
+	Invoice   *models.Invoice
+	Org       *models.Organization
+	Recipient *models.User
+	ExpiresIn string
+	NewEmail  string
+	OldStatus string
+	NewStatus string
+	Period    string
+	Link      string
+	LinkLabel string
+}
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var templates = map[string]*emailTemplate{}
+
+func init() {
+	for _, name := range []string{
+		"ticket_created",
+		"public_reply",
+		"status_changed",
+		"assigned",
+		"invoice_sent",
+		"password_reset",
+		"invitation",
+		"email_change",
+		"email_change_notice",
+		"email_changed",
+	} {
+		templates[name] = &emailTemplate{
+			text: texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/"+name+".txt")),
+			html: htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")),
+		}
+	}
+}
+
+// render executes the named template and returns subject, text and HTML bodies.
+func render(name string, data templateData) (*Email, error) {
+	t := templates[name]
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := t.text.ExecuteTemplate(&text, "body", data); err != nil {
+		return nil, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return nil, err
+	}
+	return &Email{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
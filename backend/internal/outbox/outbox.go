@@ -0,0 +1,144 @@
+// Package outbox dispatches domain events that were stored atomically with
+// the change that produced them. Handlers pass Messages(...) to a store write
+// and then Flush them; anything left behind (a crash, a failed publish) is
+// picked up by Drain, so every event is published at least once.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// lease is how long Drain leaves a new message alone, giving the request
+// that wrote it time to Flush it first. Dispatching claims a message for as
+// long again, so two runners never publish it at the same time.
+const lease = 30 * time.Second
+
+// maxBackoff caps the retry delay for messages that cannot be dispatched.
+const maxBackoff = time.Hour
+
+// Messages serializes events into outbox messages, in order.
+func Messages(evs ...events.Event) []models.OutboxMessage {
+	now := time.Now().UTC()
+	var msgs []models.OutboxMessage
+	for i, e := range evs {
+		payload, err := events.Encode(e)
+		if err != nil {
+			log.Printf("outbox: encode %s: %v", e.Name(), err)
+			continue
+		}
+		created := now.Add(time.Duration(i))
+		msgs = append(msgs, models.OutboxMessage{
+			ID:            "obx-" + uuid.NewString()[:8],
+			Event:         e.Name(),
+			Payload:       string(payload),
+			NextAttemptAt: created.Add(lease),
+			CreatedAt:     created,
+		})
+	}
+	return msgs
+}
+
+// Relay publishes outbox messages to the event bus and removes them once
+// every subscriber has handled them. A nil *Relay is valid and leaves
+// messages for a later Drain.
+type Relay struct {
+	Store store.Store
+	Bus   *events.Bus
+}
+
+// Flush dispatches messages that were just written. It is called after the
+// store write succeeds and is not affected by the request being cancelled.
+func (r *Relay) Flush(ctx context.Context, msgs []models.OutboxMessage) {
+	if r == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	for i := range msgs {
+		r.dispatch(ctx, &msgs[i])
+	}
+}
+
+// Drain dispatches messages that are due and returns how many it handled.
+func (r *Relay) Drain(ctx context.Context) (int, error) {
+	msgs, err := r.Store.ListDueOutbox(ctx, time.Now().UTC(), 100)
+	if err != nil {
+		return 0, err
+	}
+	for i := range msgs {
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		r.dispatch(ctx, &msgs[i])
+	}
+	return len(msgs), nil
+}
+
+// Run drains the outbox until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Drain(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("outbox: drain: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Relay) dispatch(ctx context.Context, m *models.OutboxMessage) {
+	until := time.Now().UTC().Add(lease)
+	if err := r.Store.ClaimOutbox(ctx, m, until); err != nil {
+		if !errors.Is(err, store.ErrJobBusy) {
+			log.Printf("outbox: claim %s: %v", m.ID, err)
+		}
+		return
+	}
+	m.NextAttemptAt = until
+
+	e, err := events.Decode(m.Event, []byte(m.Payload))
+	if err != nil {
+		r.retryLater(ctx, m, err)
+		return
+	}
+	handled, err := r.Bus.Publish(events.WithID(ctx, m.ID), e, m.Delivered...)
+	if err != nil {
+		// Keep the message for the subscribers that failed; the others are
+		// not called again.
+		m.Delivered = append(m.Delivered, handled...)
+		r.retryLater(ctx, m, err)
+		return
+	}
+	if err := r.Store.DeleteOutbox(ctx, m.ID); err != nil {
+		// The message will be published again by Drain; subscribers tolerate that.
+		log.Printf("outbox: delete %s: %v", m.ID, err)
+	}
+}
+
+func (r *Relay) retryLater(ctx context.Context, m *models.OutboxMessage, cause error) {
+	log.Printf("outbox: dispatch %s (%s): %v", m.ID, m.Event, cause)
+	m.Attempts++
+	m.LastError = cause.Error()
+	wait := lease << min(m.Attempts, 7)
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	m.NextAttemptAt = time.Now().UTC().Add(wait)
+	if err := r.Store.PutOutbox(ctx, m); err != nil {
+		log.Printf("outbox: update %s: %v", m.ID, err)
+	}
+}
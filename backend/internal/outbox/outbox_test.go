@@ -0,0 +1,177 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+// counter is a subscriber that counts the events it handles by outbox
+// message ID and fails while failing is set.
+type counter struct {
+	mu      sync.Mutex
+	seen    map[string]int
+	failing bool
+}
+
+func (c *counter) handle(ctx context.Context, e events.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = map[string]int{}
+	}
+	c.seen[events.ID(ctx)]++
+	if c.failing {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func (c *counter) count(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seen[id]
+}
+
+// write stores one event the way a handler does, without flushing it.
+func write(t *testing.T, st *storetest.Memory) models.OutboxMessage {
+	t.Helper()
+	msgs := Messages(events.AccountUnlocked{UserID: "user-jane"})
+	if err := st.LockLogin(context.Background(), "email:jane@example.test", time.Now(), msgs...); err != nil {
+		t.Fatal(err)
+	}
+	return msgs[0]
+}
+
+// pending returns the messages still in the outbox, due or not.
+func pending(st *storetest.Memory) []models.OutboxMessage {
+	due, _ := st.ListDueOutbox(context.Background(), time.Now().Add(24*time.Hour), 0)
+	return due
+}
+
+func TestRelayLease(t *testing.T) {
+	ctx := context.Background()
+	st := storetest.New()
+	c := &counter{}
+	bus := events.NewBus()
+	bus.Subscribe("counter", c.handle)
+	relay := &Relay{Store: st, Bus: bus}
+
+	// A message that was just written is left to the request that wrote it.
+	m := write(t, st)
+	if n, _ := relay.Drain(ctx); n != 0 || c.count(m.ID) != 0 {
+		t.Fatalf("drain dispatched %d messages within the lease", n)
+	}
+
+	// Runners that listed the same message publish it once between them.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			relay.Flush(ctx, []models.OutboxMessage{m})
+		}()
+	}
+	wg.Wait()
+	if n := c.count(m.ID); n != 1 {
+		t.Fatalf("published %d times, want once", n)
+	}
+	if left := pending(st); len(left) != 0 {
+		t.Fatalf("outbox after dispatch %+v, want it empty", left)
+	}
+
+	// A delivered message is not sent again, even from a stale copy.
+	relay.Flush(ctx, []models.OutboxMessage{m})
+	if n := c.count(m.ID); n != 1 {
+		t.Fatalf("stale copy published again: %d times", n)
+	}
+}
+
+func TestRelayRedeliversAfterCrash(t *testing.T) {
+	ctx := context.Background()
+	st := storetest.New()
+	c := &counter{}
+	bus := events.NewBus()
+	bus.Subscribe("counter", c.handle)
+	relay := &Relay{Store: st, Bus: bus}
+
+	// A runner claimed the message and died before publishing it. Once its
+	// lease is over, Drain publishes the message.
+	m := write(t, st)
+	claimed := m
+	if err := st.ClaimOutbox(ctx, &claimed, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := relay.Drain(ctx); err != nil || n != 1 {
+		t.Fatalf("drain: %d, %v", n, err)
+	}
+	if n := c.count(m.ID); n != 1 {
+		t.Fatalf("published %d times after the crash, want once", n)
+	}
+	if n, _ := relay.Drain(ctx); n != 0 || c.count(m.ID) != 1 {
+		t.Fatal("published again after it was delivered")
+	}
+}
+
+func TestRelayRetryBackoff(t *testing.T) {
+	ctx := context.Background()
+	st := storetest.New()
+	c := &counter{failing: true}
+	bus := events.NewBus()
+	bus.Subscribe("counter", c.handle)
+	relay := &Relay{Store: st, Bus: bus}
+
+	m := write(t, st)
+	relay.Flush(ctx, []models.OutboxMessage{m})
+	for attempt, want := range []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute,
+		16 * time.Minute, 32 * time.Minute, maxBackoff, maxBackoff,
+	} {
+		left := pending(st)
+		if len(left) != 1 {
+			t.Fatalf("attempt %d: %d messages left, want the failed one kept", attempt+1, len(left))
+		}
+		got := left[0]
+		if got.Attempts != attempt+1 || got.LastError == "" {
+			t.Fatalf("attempt %d: %d attempts recorded, error %q", attempt+1, got.Attempts, got.LastError)
+		}
+		if wait := time.Until(got.NextAttemptAt); wait > want || wait < want-time.Minute {
+			t.Fatalf("attempt %d: next attempt in %v, want %v", attempt+1, wait.Round(time.Second), want)
+		}
+		relay.Flush(ctx, left)
+	}
+}
+
+func TestRelayTracksDelivered(t *testing.T) {
+	ctx := context.Background()
+	st := storetest.New()
+	ok, flaky := &counter{}, &counter{failing: true}
+	bus := events.NewBus()
+	bus.Subscribe("ok", ok.handle)
+	bus.Subscribe("flaky", flaky.handle)
+	relay := &Relay{Store: st, Bus: bus}
+
+	m := write(t, st)
+	relay.Flush(ctx, []models.OutboxMessage{m})
+	left := pending(st)
+	if len(left) != 1 || !slices.Equal(left[0].Delivered, []string{"ok"}) {
+		t.Fatalf("outbox after a partial failure %+v, want ok recorded as delivered", left)
+	}
+
+	// The retry only goes to the subscriber that failed.
+	flaky.failing = false
+	relay.Flush(ctx, left)
+	if ok.count(m.ID) != 1 || flaky.count(m.ID) != 2 {
+		t.Fatalf("ok saw the event %d times and flaky %d, want 1 and 2", ok.count(m.ID), flaky.count(m.ID))
+	}
+	if left := pending(st); len(left) != 0 {
+		t.Fatalf("outbox after the retry %+v, want it empty", left)
+	}
+}
@@ -0,0 +1,120 @@
+// Package password decides which passwords users may choose: a minimum length,
+// a mix of character classes, no commonly used passwords and no reuse of
+// recent ones.
+package password
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxLength is the most bcrypt hashes; longer passwords are rejected rather
+// than silently truncated.
+const maxLength = 72
+
+//go:embed common.txt
+var commonList string
+
+var common = func() map[string]bool {
+	m := map[string]bool{}
+	for _, line := range strings.Split(commonList, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			m[line] = true
+		}
+	}
+	return m
+}()
+
+// Policy holds the configurable rules. MinClasses counts lowercase letters,
+// uppercase letters, digits and everything else. History is how many of the
+// user's most recent passwords, including the current one, cannot be used
+// again; 0 or 1 only rules out the current one.
+type Policy struct {
+	MinLength  int
+	MinClasses int
+	History    int
+}
+
+// Check returns an error explaining why pw is not acceptable for the account
+// with the given email address, or nil.
+func (p Policy) Check(pw, email string) error {
+	if n := len([]rune(pw)); n < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if len(pw) > maxLength {
+		return fmt.Errorf("password must be at most %d bytes", maxLength)
+	}
+	if classes(pw) < p.MinClasses {
+		return fmt.Errorf("password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses)
+	}
+	if IsCommon(pw) {
+		return errors.New("password is too common, choose another one")
+	}
+	if local, _, _ := strings.Cut(strings.ToLower(email), "@"); len(local) >= 4 && strings.Contains(strings.ToLower(pw), local) {
+		return errors.New("password must not contain your email address")
+	}
+	return nil
+}
+
+// IsCommon reports whether pw is on the bundled list of passwords seen most
+// often in breaches, ignoring case and digits or symbols added at the end.
+func IsCommon(pw string) bool {
+	pw = strings.ToLower(pw)
+	if common[pw] {
+		return true
+	}
+	base := strings.TrimRightFunc(pw, func(r rune) bool { return !unicode.IsLetter(r) })
+	return base != "" && common[base]
+}
+
+// Reused reports whether pw matches the current hash or one of the previous
+// hashes the policy still remembers.
+func (p Policy) Reused(pw, current string, previous []string) bool {
+	for _, h := range append([]string{current}, p.trim(previous)...) {
+		if h != "" && bcrypt.CompareHashAndPassword([]byte(h), []byte(pw)) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Remember returns the previous hashes to store once current is replaced.
+func (p Policy) Remember(current string, previous []string) []string {
+	if current == "" {
+		return p.trim(previous)
+	}
+	return p.trim(append([]string{current}, previous...))
+}
+
+// trim keeps the History-1 most recent previous hashes; the current hash
+// makes up the rest.
+func (p Policy) trim(previous []string) []string {
+	n := max(p.History-1, 0)
+	if len(previous) > n {
+		previous = previous[:n]
+	}
+	return previous
+}
+
+func classes(pw string) int {
+	var lower, upper, digit, other int
+	for _, r := range pw {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
@@ -0,0 +1,24 @@
+package password
+
+import "testing"
+
+func TestIsCommon(t *testing.T) {
+	if len(common) < 5000 {
+		t.Fatalf("%d common passwords loaded, want the whole list", len(common))
+	}
+	for pw, want := range map[string]bool{
+		"password":         true,
+		"Password1!":       true,
+		"trustno1":         true,
+		"Sunshine2024":     true,
+		"qazwsx":           true,
+		"supportfix":       true,
+		"1q2w3e4r":         true,
+		"Kx9#mTq2vLp8":     false,
+		"correct horse 42": false,
+	} {
+		if got := IsCommon(pw); got != want {
+			t.Errorf("IsCommon(%q) = %v, want %v", pw, got, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package policy is the permission model: each role is granted a fixed set of
+// permissions, and routes and handlers ask whether a role holds a permission
+// instead of comparing role names.
+//
+// Permissions say what a role may do, not to which records. Handlers check
+// ownership separately: callers without AnyOrganization (clients) only reach
+// their own organization's records.
+package policy
+
+import "slices"
+
+type Permission string
+
+const (
+	// Tickets
+	TicketRead     Permission = "ticket.read"
+	TicketCreate   Permission = "ticket.create"
+	TicketUpdate   Permission = "ticket.update" // status, priority and tags
+	TicketAssign   Permission = "ticket.assign"
+	TicketReply    Permission = "ticket.reply"
+	TicketInternal Permission = "ticket.internal" // read and write internal notes
+	TicketLogTime  Permission = "ticket.log-time"
+	TicketConvert  Permission = "ticket.convert" // propose a category conversion
+	TicketWatch    Permission = "ticket.watch"   // follow and unfollow yourself
+	WatcherManage  Permission = "ticket.watchers.manage"
+	PresenceView   Permission = "ticket.presence"
+
+	// Macros
+	MacroUse      Permission = "macro.use"
+	MacroManage   Permission = "macro.manage"
+	MacroAllTeams Permission = "macro.all-teams" // use other teams' macros
+
+	// Conversion approvals
+	ApprovalRead           Permission = "approval.read"
+	ApprovalDecideInternal Permission = "approval.decide-internal"
+	ApprovalDecideClient   Permission = "approval.decide-client"
+
+	// Billing
+	InvoiceRead   Permission = "invoice.read"
+	InvoiceCreate Permission = "invoice.create"
+	InvoiceUpdate Permission = "invoice.update"
+
+	// Organizations and users. AnyOrganization lets a role work on every
+	// organization's records; without it, only on its own organization's.
+	AnyOrganization Permission = "organization.any"
+	OrgRead         Permission = "organization.read"
+	OrgManage       Permission = "organization.manage"
+	UserRead        Permission = "user.read"
+	UserManage      Permission = "user.manage" // create, edit, delete, sign out, reset 2FA
+	// UserInvite invites users. Without UserManage only clients into the
+	// caller's own organization, and only if it allows member invites.
+	UserInvite Permission = "user.invite"
+
+	// Integrations and reporting
+	WebhookManage Permission = "webhook.manage"
+	DashboardRead Permission = "dashboard.read"
+	MetricsRead   Permission = "metrics.read"
+
+	// ProfileManage covers the caller's own account: profile, password,
+	// two-factor and API tokens.
+	ProfileManage Permission = "profile.manage"
+)
+
+// Roles
+const (
+	Admin        = "admin"
+	SupportLead  = "support-lead"
+	SupportStaff = "support-staff"
+	Client       = "client"
+)
+
+// everyone holds these permissions.
+var everyone = []Permission{
+	ProfileManage,
+	TicketRead, TicketCreate, TicketUpdate, TicketReply, TicketWatch,
+	ApprovalRead,
+	OrgRead, UserRead,
+	DashboardRead,
+}
+
+// agent is every support role's baseline.
+var agent = append(slices.Clone(everyone),
+	AnyOrganization,
+	TicketAssign, TicketInternal, TicketLogTime, TicketConvert, PresenceView,
+	MacroUse,
+	ApprovalDecideInternal,
+)
+
+var grants = map[string][]Permission{
+	Client: append(slices.Clone(everyone),
+		ApprovalDecideClient,
+		InvoiceRead,
+	),
+	SupportStaff: agent,
+	SupportLead: append(slices.Clone(agent),
+		WatcherManage,
+		MacroManage,
+	),
+	Admin: append(slices.Clone(agent),
+		WatcherManage,
+		MacroManage, MacroAllTeams,
+		ApprovalDecideClient,
+		InvoiceRead, InvoiceCreate, InvoiceUpdate,
+		OrgManage, UserManage, UserInvite,
+		WebhookManage,
+		MetricsRead,
+	),
+}
+
+// optional lists permissions a role does not hold by itself but that an
+// organization's custom role may give it: clients only invite colleagues when
+// their organization has picked who may.
+var optional = map[string][]Permission{
+	Client: {UserInvite},
+}
+
+// Allowed reports whether role holds p. Unknown roles hold nothing.
+func Allowed(role string, p Permission) bool {
+	return slices.Contains(grants[role], p)
+}
+
+// AllowedAny reports whether role holds at least one of ps.
+func AllowedAny(role string, ps ...Permission) bool {
+	for _, p := range ps {
+		if Allowed(role, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRole reports whether role is one of the built-in roles.
+func IsRole(role string) bool {
+	_, ok := grants[role]
+	return ok
+}
+
+// Permissions returns the permissions of role, sorted.
+func Permissions(role string) []Permission {
+	return slices.Sorted(slices.Values(grants[role]))
+}
+
+// Grantable reports whether a custom role may give p to members whose
+// built-in role is role.
+func Grantable(role string, p Permission) bool {
+	return Allowed(role, p) || slices.Contains(optional[role], p)
+}
+
+// Restrict returns the permissions of role that are also in allowed, sorted,
+// for members of an organization's custom role. A custom role can take
+// permissions away and add role's optional ones; ProfileManage is always kept
+// so members can still manage their own account.
+func Restrict(role string, allowed []Permission) []Permission {
+	var ps []Permission
+	for _, p := range slices.Sorted(slices.Values(append(slices.Clone(grants[role]), optional[role]...))) {
+		if p == ProfileManage || slices.Contains(allowed, p) {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{Client, TicketRead, true},
+		{Client, ApprovalDecideClient, true},
+		{Client, InvoiceRead, true},
+		{Client, AnyOrganization, false},
+		{Client, TicketInternal, false},
+		{Client, UserManage, false},
+		{Client, UserInvite, false},
+		{SupportStaff, AnyOrganization, true},
+		{SupportStaff, TicketInternal, true},
+		{SupportStaff, MacroManage, false},
+		{SupportStaff, InvoiceRead, false},
+		{SupportLead, MacroManage, true},
+		{SupportLead, WatcherManage, true},
+		{SupportLead, MacroAllTeams, false},
+		{SupportLead, OrgManage, false},
+		{Admin, MacroAllTeams, true},
+		{Admin, UserManage, true},
+		{Admin, MetricsRead, true},
+		{"", TicketRead, false},
+		{"owner", ProfileManage, false},
+	}
+	for _, tt := range tests {
+		if got := Allowed(tt.role, tt.perm); got != tt.want {
+			t.Errorf("Allowed(%q, %s) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedAny(t *testing.T) {
+	if !AllowedAny(Client, ApprovalDecideInternal, ApprovalDecideClient) {
+		t.Error("client should decide one side of approvals")
+	}
+	if AllowedAny(Client, OrgManage, UserManage) {
+		t.Error("client should not manage organizations or users")
+	}
+	if AllowedAny(Admin) {
+		t.Error("no permissions should allow nothing")
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	for _, role := range []string{Client, SupportStaff, SupportLead, Admin} {
+		ps := Permissions(role)
+		if !slices.IsSorted(ps) {
+			t.Errorf("Permissions(%q) is not sorted: %v", role, ps)
+		}
+		if len(slices.Compact(slices.Clone(ps))) != len(ps) {
+			t.Errorf("Permissions(%q) has duplicates: %v", role, ps)
+		}
+		for _, p := range everyone {
+			if !slices.Contains(ps, p) {
+				t.Errorf("Permissions(%q) is missing %s", role, p)
+			}
+		}
+		if !IsRole(role) {
+			t.Errorf("IsRole(%q) = false", role)
+		}
+	}
+
+	// Each support role extends the one below it.
+	for _, pair := range [][2]string{{SupportStaff, SupportLead}, {SupportLead, Admin}} {
+		for _, p := range Permissions(pair[0]) {
+			if !Allowed(pair[1], p) {
+				t.Errorf("%s holds %s but %s does not", pair[0], p, pair[1])
+			}
+		}
+	}
+
+	if ps := Permissions("owner"); len(ps) != 0 || IsRole("owner") {
+		t.Errorf("unknown role has permissions %v", ps)
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	got := Restrict(Client, []Permission{TicketRead, TicketCreate, UserManage})
+	want := []Permission{ProfileManage, TicketCreate, TicketRead}
+	if !slices.Equal(got, want) {
+		t.Errorf("Restrict(client, ...) = %v, want %v", got, want)
+	}
+
+	// Nothing allowed still keeps the caller's own account.
+	if got := Restrict(Client, nil); !slices.Equal(got, []Permission{ProfileManage}) {
+		t.Errorf("Restrict(client, nil) = %v", got)
+	}
+
+	// A custom role cannot grant what the built-in role lacks.
+	if got := Restrict(Client, []Permission{AnyOrganization, OrgManage}); !slices.Equal(got, []Permission{ProfileManage}) {
+		t.Errorf("Restrict(client, staff permissions) = %v", got)
+	}
+
+	// Only a custom role lets clients invite colleagues.
+	if got := Restrict(Client, []Permission{TicketRead, UserInvite}); !slices.Equal(got, []Permission{ProfileManage, TicketRead, UserInvite}) {
+		t.Errorf("Restrict(client, invite) = %v", got)
+	}
+	if !Grantable(Client, UserInvite) || Grantable(Client, UserManage) || Grantable(SupportStaff, UserInvite) {
+		t.Error("Grantable: only clients may be given user.invite by a custom role")
+	}
+
+	if got := Restrict("owner", []Permission{ProfileManage, TicketRead}); len(got) != 0 {
+		t.Errorf("Restrict(unknown role) = %v", got)
+	}
+}
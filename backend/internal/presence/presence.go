@@ -0,0 +1,163 @@
+// Package presence tracks which agents are viewing or replying to each ticket
+// so the UI can warn before two people answer the same customer.
+//
+// State is kept in memory per server process, so every agent working on a
+// ticket must be connected to the same instance to see each other.
+package presence
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Presence states.
+const (
+	Viewing = "viewing"
+	Typing  = "typing"
+)
+
+// typingTimeout is how long a typing state lasts without being refreshed by
+// the client, so a closed compose box does not leave a stale indicator.
+const typingTimeout = 8 * time.Second
+
+// Viewer is one connection's presence on a ticket.
+type Viewer struct {
+	UserID string    `json:"userId"`
+	Name   string    `json:"name"`
+	Role   string    `json:"role"`
+	State  string    `json:"state"`
+	Since  time.Time `json:"since"`
+}
+
+// Snapshot is the presence on a ticket as sent to clients.
+type Snapshot struct {
+	Type     string   `json:"type"`
+	TicketID string   `json:"ticketId"`
+	Viewers  []Viewer `json:"viewers"`
+}
+
+// Conn is a registered connection.
+type Conn struct {
+	hub      *Hub
+	ticketID string
+	notify   func()
+	viewer   Viewer
+	typedAt  time.Time
+}
+
+// Hub holds presence for all tickets.
+type Hub struct {
+	mu      sync.Mutex
+	tickets map[string]map[*Conn]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{tickets: map[string]map[*Conn]struct{}{}}
+}
+
+// Join registers a connection viewing ticketID. notify is called whenever the
+// ticket's presence changes, until Leave; the caller then fetches a Snapshot.
+// It is called without the hub's lock held and must not block.
+func (h *Hub) Join(ticketID string, v Viewer, notify func()) *Conn {
+	v.State = Viewing
+	v.Since = time.Now().UTC()
+	c := &Conn{hub: h, ticketID: ticketID, notify: notify, viewer: v}
+
+	h.mu.Lock()
+	if h.tickets[ticketID] == nil {
+		h.tickets[ticketID] = map[*Conn]struct{}{}
+	}
+	h.tickets[ticketID][c] = struct{}{}
+	h.mu.Unlock()
+
+	h.broadcast(ticketID)
+	return c
+}
+
+// Set changes the connection's state. Typing must be refreshed by the client
+// at least every few seconds; it falls back to viewing otherwise.
+func (c *Conn) Set(state string) {
+	if state != Viewing && state != Typing {
+		return
+	}
+	now := time.Now().UTC()
+
+	c.hub.mu.Lock()
+	changed := c.viewer.State != state
+	if changed {
+		c.viewer.State = state
+		c.viewer.Since = now
+	}
+	if state == Typing {
+		c.typedAt = now
+	}
+	c.hub.mu.Unlock()
+
+	if changed {
+		c.hub.broadcast(c.ticketID)
+	}
+}
+
+// Expire drops a typing state that has not been refreshed.
+func (c *Conn) Expire() {
+	c.hub.mu.Lock()
+	expired := c.viewer.State == Typing && time.Since(c.typedAt) > typingTimeout
+	if expired {
+		c.viewer.State = Viewing
+		c.viewer.Since = time.Now().UTC()
+	}
+	c.hub.mu.Unlock()
+
+	if expired {
+		c.hub.broadcast(c.ticketID)
+	}
+}
+
+// Leave unregisters the connection and tells the others.
+func (c *Conn) Leave() {
+	h := c.hub
+	h.mu.Lock()
+	delete(h.tickets[c.ticketID], c)
+	if len(h.tickets[c.ticketID]) == 0 {
+		delete(h.tickets, c.ticketID)
+	}
+	h.mu.Unlock()
+
+	h.broadcast(c.ticketID)
+}
+
+// Snapshot returns the current presence on a ticket, one entry per user. A
+// user with several tabs open counts as typing if any of them is.
+func (h *Hub) Snapshot(ticketID string) Snapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	byUser := map[string]Viewer{}
+	for c := range h.tickets[ticketID] {
+		v := c.viewer
+		prev, ok := byUser[v.UserID]
+		if !ok || (v.State == Typing && prev.State != Typing) || (v.State == prev.State && v.Since.Before(prev.Since)) {
+			byUser[v.UserID] = v
+		}
+	}
+	viewers := []Viewer{}
+	for _, v := range byUser {
+		viewers = append(viewers, v)
+	}
+	sort.Slice(viewers, func(i, j int) bool { return viewers[i].Since.Before(viewers[j].Since) })
+	return Snapshot{Type: "presence", TicketID: ticketID, Viewers: viewers}
+}
+
+func (h *Hub) broadcast(ticketID string) {
+	h.mu.Lock()
+	var conns []*Conn
+	for c := range h.tickets[ticketID] {
+		conns = append(conns, c)
+	}
+	h.mu.Unlock()
+
+	for _, c := range conns {
+		c.notify()
+	}
+}
@@ -0,0 +1,83 @@
+package presence
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// counted joins ticketID as userID and returns the connection with the number
+// of times it has been notified.
+func counted(h *Hub, ticketID, userID string) (*Conn, *int) {
+	n := new(int)
+	return h.Join(ticketID, Viewer{UserID: userID}, func() { *n++ }), n
+}
+
+// states returns "user:state" for everyone on the ticket.
+func states(h *Hub, ticketID string) []string {
+	var s []string
+	for _, v := range h.Snapshot(ticketID).Viewers {
+		s = append(s, v.UserID+":"+v.State)
+	}
+	slices.Sort(s)
+	return s
+}
+
+func TestPresence(t *testing.T) {
+	h := NewHub()
+	alice, aliceN := counted(h, "tkt-1", "user-alice")
+	bob, bobN := counted(h, "tkt-1", "user-bob")
+	_, carolN := counted(h, "tkt-2", "user-carol")
+	if *aliceN != 2 || *bobN != 1 || *carolN != 1 {
+		t.Fatalf("notified alice %d, bob %d, carol %d times on join, want 2, 1, 1", *aliceN, *bobN, *carolN)
+	}
+	if got := states(h, "tkt-1"); !slices.Equal(got, []string{"user-alice:viewing", "user-bob:viewing"}) {
+		t.Fatalf("presence %v", got)
+	}
+
+	// A second tab does not add a second entry; typing in either one shows.
+	aliceTab, _ := counted(h, "tkt-1", "user-alice")
+	aliceTab.Set(Typing)
+	if got := states(h, "tkt-1"); !slices.Equal(got, []string{"user-alice:typing", "user-bob:viewing"}) {
+		t.Fatalf("presence with two tabs %v", got)
+	}
+
+	// Only changes are broadcast, and only to the ticket's connections.
+	*bobN, *carolN = 0, 0
+	aliceTab.Set(Typing)
+	alice.Set("shouting")
+	if *bobN != 0 {
+		t.Errorf("bob notified %d times of no change", *bobN)
+	}
+	bob.Set(Typing)
+	if *bobN != 1 || *carolN != 0 {
+		t.Errorf("notified bob %d and carol %d times of bob typing, want 1 and 0", *bobN, *carolN)
+	}
+
+	// Typing lapses once it is not refreshed.
+	bob.Expire()
+	if got := states(h, "tkt-1"); !slices.Contains(got, "user-bob:typing") {
+		t.Fatalf("fresh typing expired: %v", got)
+	}
+	bob.typedAt = time.Now().Add(-typingTimeout - time.Second)
+	*aliceN = 0
+	bob.Expire()
+	if got := states(h, "tkt-1"); !slices.Contains(got, "user-bob:viewing") || *aliceN != 1 {
+		t.Fatalf("presence after the timeout %v, alice notified %d times", got, *aliceN)
+	}
+
+	// Leaving tells those who stay, and not the one who left.
+	*bobN, *aliceN = 0, 0
+	bob.Leave()
+	if got := states(h, "tkt-1"); !slices.Equal(got, []string{"user-alice:typing"}) || *aliceN != 1 || *bobN != 0 {
+		t.Fatalf("presence after bob left %v, notified alice %d and bob %d times", got, *aliceN, *bobN)
+	}
+	aliceTab.Leave()
+	if got := states(h, "tkt-1"); !slices.Equal(got, []string{"user-alice:viewing"}) {
+		t.Fatalf("presence after the typing tab closed %v", got)
+	}
+	alice.Leave()
+	if got := states(h, "tkt-1"); len(got) != 0 || len(h.tickets) != 1 {
+		t.Fatalf("presence after everyone left %v, %d tickets tracked", got, len(h.tickets))
+	}
+}
@@ -0,0 +1,212 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+const (
+	// lease is how long a runner holds a job between saves; a runner that
+	// dies releases it when the lease runs out.
+	lease = 2 * time.Minute
+	// defaultBudget is the Budget when none is set.
+	defaultBudget = 45 * time.Second
+	// ticketBatch and memberBatch are how many tickets and members one
+	// batch deletes.
+	ticketBatch = 25
+	memberBatch = 10
+	// maxFailures is how many consecutive failed batches fail the job.
+	maxFailures = 5
+)
+
+// RunJobs advances the active purge jobs, oldest first, until they are done
+// or the budget is used up. It returns how many jobs completed.
+func (p *Purger) RunJobs(ctx context.Context) (int, error) {
+	budget := p.Budget
+	if budget <= 0 {
+		budget = defaultBudget
+	}
+	deadline := time.Now().Add(budget)
+	if d, ok := ctx.Deadline(); ok && d.Add(-10*time.Second).Before(deadline) {
+		deadline = d.Add(-10 * time.Second)
+	}
+
+	jobs, err := p.Store.ListActivePurgeJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	done := 0
+	for i := range jobs {
+		if time.Now().After(deadline) {
+			break
+		}
+		r := &jobRun{p: p, job: &jobs[i]}
+		if err := r.run(ctx, deadline); err != nil {
+			if errors.Is(err, store.ErrJobBusy) {
+				continue
+			}
+			log.Printf("purge: organization %s: %v", jobs[i].OrganizationID, err)
+			continue
+		}
+		if jobs[i].Status == "done" {
+			done++
+		}
+	}
+	return done, nil
+}
+
+// jobRun is one runner's turn at a job.
+type jobRun struct {
+	p   *Purger
+	job *models.PurgeJob
+}
+
+// run claims the job and works through its batches until it is done, fails
+// or the deadline passes, saving progress after every batch.
+func (r *jobRun) run(ctx context.Context, deadline time.Time) error {
+	now := time.Now().UTC()
+	if err := r.p.Store.ClaimPurgeJob(ctx, r.job.OrganizationID, now, now.Add(lease)); err != nil {
+		return err
+	}
+	// Reload: another runner may have saved progress since the job was listed.
+	j, err := r.p.Store.GetPurgeJob(ctx, r.job.OrganizationID)
+	if err != nil || j == nil {
+		return err
+	}
+	*r.job = *j
+	j = r.job
+	j.Status = "running"
+	if j.Deleted == nil {
+		j.Deleted = map[string]int{}
+	}
+
+	for time.Now().Before(deadline) {
+		n, stepDone, err := r.step(ctx)
+		now := time.Now().UTC()
+		j.UpdatedAt = now
+		if err != nil {
+			j.Failures++
+			j.LastError = err.Error()
+			if j.Failures >= maxFailures {
+				j.Status = "failed"
+			}
+			// Back off before the next attempt.
+			j.LeaseUntil = now.Add(time.Duration(j.Failures) * time.Minute)
+			if perr := r.p.Store.PutPurgeJob(ctx, j); perr != nil {
+				log.Printf("purge: save job %s: %v", j.OrganizationID, perr)
+			}
+			return err
+		}
+		j.Failures, j.LastError = 0, ""
+		j.Deleted[j.Step] += n
+		if stepDone {
+			if next := nextStep(j.Step); next != "" {
+				j.Step = next
+			} else {
+				j.Status = "done"
+				j.CompletedAt = &now
+			}
+		}
+		j.LeaseUntil = now.Add(lease)
+		if j.Status == "done" {
+			j.LeaseUntil = now
+		}
+		if err := r.p.Store.PutPurgeJob(ctx, j); err != nil {
+			return err
+		}
+		if j.Status == "done" {
+			log.Printf("purge: organization %s purged", j.OrganizationID)
+			return nil
+		}
+	}
+
+	// Out of time: let the next runner pick the job up right away.
+	j.LeaseUntil = time.Now().UTC()
+	return r.p.Store.PutPurgeJob(ctx, j)
+}
+
+// step deletes the next batch of the current step and returns how many
+// records it deleted and whether the step is complete.
+func (r *jobRun) step(ctx context.Context) (int, bool, error) {
+	st, j := r.p.Store, r.job
+	orgID := j.OrganizationID
+	switch j.Step {
+	case "tickets":
+		ids, err := st.ListOrgTicketIDs(ctx, orgID, ticketBatch)
+		if err != nil {
+			return 0, false, err
+		}
+		for i, id := range ids {
+			if err := r.deleteBlobs(ctx, id); err != nil {
+				return i, false, err
+			}
+			if err := st.PurgeTicket(ctx, id); err != nil {
+				return i, false, err
+			}
+		}
+		return len(ids), len(ids) < ticketBatch, nil
+
+	case "invoices":
+		n, err := st.PurgeOrgInvoices(ctx, orgID)
+		return n, err == nil, err
+
+	case "roles":
+		n, err := st.PurgeOrgRoles(ctx, orgID)
+		return n, err == nil, err
+
+	case "webhooks":
+		n, err := st.PurgeOrgWebhooks(ctx, orgID)
+		return n, err == nil, err
+
+	case "members":
+		ids, err := st.ListOrgMemberIDs(ctx, orgID)
+		if err != nil {
+			return 0, false, err
+		}
+		if len(ids) > memberBatch {
+			ids = ids[:memberBatch]
+		}
+		for i, id := range ids {
+			if err := st.PurgeUser(ctx, id); err != nil {
+				return i, false, err
+			}
+		}
+		return len(ids), len(ids) < memberBatch, nil
+
+	case "organization":
+		if err := st.DeleteOrg(ctx, orgID); err != nil {
+			return 0, false, err
+		}
+		return 1, true, nil
+	}
+	return 0, false, errors.New("unknown step " + j.Step)
+}
+
+// deleteBlobs removes the content of a ticket's attachments, whose records
+// PurgeTicket deletes.
+func (r *jobRun) deleteBlobs(ctx context.Context, ticketID string) error {
+	atts, err := r.p.Store.ListAttachmentsByTicketID(ctx, ticketID)
+	if err != nil {
+		return err
+	}
+	for _, a := range atts {
+		if err := r.p.Blobs.Delete(ctx, a.StorageKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nextStep(step string) string {
+	for i, s := range models.PurgeSteps {
+		if s == step && i+1 < len(models.PurgeSteps) {
+			return models.PurgeSteps[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/blob"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+// dyingStore records which steps a runner works on, and stops the runner's
+// goroutine for good the crashAt'th time it lists members, as if its process
+// died there.
+type dyingStore struct {
+	*storetest.Memory
+
+	mu      sync.Mutex
+	calls   []string
+	crashAt int
+}
+
+func (s *dyingStore) record(call string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+	n := 0
+	for _, c := range s.calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *dyingStore) ListOrgTicketIDs(ctx context.Context, orgID string, limit int) ([]string, error) {
+	s.record("tickets")
+	return s.Memory.ListOrgTicketIDs(ctx, orgID, limit)
+}
+
+func (s *dyingStore) PurgeOrgInvoices(ctx context.Context, orgID string) (int, error) {
+	s.record("invoices")
+	return s.Memory.PurgeOrgInvoices(ctx, orgID)
+}
+
+func (s *dyingStore) ListOrgMemberIDs(ctx context.Context, orgID string) ([]string, error) {
+	if s.record("members") == s.crashAt {
+		runtime.Goexit()
+	}
+	return s.Memory.ListOrgMemberIDs(ctx, orgID)
+}
+
+func TestJobResumesAfterLease(t *testing.T) {
+	ctx := context.Background()
+	mem := storetest.New()
+	orgID := "org-acme"
+	at := time.Now().UTC().Add(-48 * time.Hour)
+	mem.CreateOrg(ctx, &models.Organization{ID: orgID, Name: "Acme", DeletedAt: &at})
+	for i := range ticketBatch + 5 {
+		mem.CreateTicket(ctx, &models.Ticket{ID: fmt.Sprintf("tkt-%02d", i), OrganizationID: orgID})
+	}
+	mem.CreateInvoice(ctx, &models.Invoice{ID: "inv-1", OrganizationID: orgID})
+	for i := range memberBatch + 2 {
+		mem.CreateUser(ctx, &models.User{ID: fmt.Sprintf("user-%02d", i), Email: fmt.Sprintf("m%d@acme.test", i),
+			Role: policy.Client, OrganizationID: &orgID, DeletedAt: &at})
+	}
+	st := &dyingStore{Memory: mem, crashAt: 2}
+	p := &Purger{Store: st, Blobs: &blob.DirStorage{Dir: t.TempDir()}, Budget: time.Minute}
+	if _, err := p.Start(ctx, orgID, "user-admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first runner deletes a batch of members, saves, and dies while
+	// listing the next batch.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.RunJobs(ctx)
+	}()
+	<-done
+	j, _ := mem.GetPurgeJob(ctx, orgID)
+	if j.Status != "running" || j.Step != "members" || j.Deleted["members"] != memberBatch {
+		t.Fatalf("job after the crash %+v, want running at members with one batch deleted", j)
+	}
+	if !j.LeaseUntil.After(time.Now()) {
+		t.Fatalf("lease until %v, want it still held", j.LeaseUntil)
+	}
+
+	// Nobody takes the job over while the lease holds.
+	st.calls, st.crashAt = nil, 0
+	if n, err := p.RunJobs(ctx); n != 0 || err != nil || len(st.calls) != 0 {
+		t.Fatalf("RunJobs during the lease = %d, %v and called %v, want nothing done", n, err, st.calls)
+	}
+
+	// Once it runs out, the next runner continues at the members.
+	j.LeaseUntil = time.Now().UTC().Add(-time.Second)
+	mem.PutPurgeJob(ctx, j)
+	if n, err := p.RunJobs(ctx); n != 1 || err != nil {
+		t.Fatalf("RunJobs after the lease = %d, %v, want the job done", n, err)
+	}
+	if want := []string{"members"}; !slices.Equal(st.calls, want) {
+		t.Errorf("resumed runner called %v, want only %v", st.calls, want)
+	}
+	j, _ = mem.GetPurgeJob(ctx, orgID)
+	want := map[string]int{"tickets": ticketBatch + 5, "invoices": 1, "roles": 0, "webhooks": 0, "members": memberBatch + 2, "organization": 1}
+	if j.Status != "done" || fmt.Sprint(j.Deleted) != fmt.Sprint(want) {
+		t.Errorf("job %s with deleted %v, want done with %v", j.Status, j.Deleted, want)
+	}
+	if o, _ := mem.GetOrg(ctx, orgID); o != nil {
+		t.Error("organization still exists")
+	}
+	if ids, _ := mem.ListOrgMemberIDs(ctx, orgID); len(ids) != 0 {
+		t.Errorf("members %v still exist", ids)
+	}
+}
@@ -0,0 +1,149 @@
+// Package purge permanently removes users and organizations once they have
+// been soft-deleted for longer than the retention period. Organizations are
+// purged by resumable jobs (see models.PurgeJob), which admins can also start
+// early.
+package purge
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/supporttickr/backend/internal/blob"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// dueInterval is how often Tick looks for records whose retention is over.
+const dueInterval = time.Hour
+
+// Purger deletes soft-deleted records. Purging an organization cascades to
+// its members, tickets and everything under them, including attachment
+// content in blob storage.
+type Purger struct {
+	Store     store.Store
+	Blobs     blob.Storage
+	Retention time.Duration
+	// Budget bounds how long RunJobs works before leaving the rest to the
+	// next call.
+	Budget time.Duration
+
+	lastDue time.Time
+}
+
+// Start creates a purge job for a soft-deleted organization, or returns its
+// existing job. A failed job is started over from where it stopped.
+func (p *Purger) Start(ctx context.Context, orgID, requestedBy string) (*models.PurgeJob, error) {
+	now := time.Now().UTC()
+	j := &models.PurgeJob{
+		OrganizationID: orgID,
+		Status:         "pending",
+		Step:           models.PurgeSteps[0],
+		Deleted:        map[string]int{},
+		RequestedBy:    requestedBy,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	err := p.Store.CreatePurgeJob(ctx, j)
+	if !errors.Is(err, store.ErrPurgeJobExists) {
+		return j, err
+	}
+	if j, err = p.Store.GetPurgeJob(ctx, orgID); err != nil || j == nil {
+		return nil, err
+	}
+	if j.Status == "failed" {
+		j.Status, j.Failures, j.LastError, j.UpdatedAt = "pending", 0, "", now
+		if err := p.Store.PutPurgeJob(ctx, j); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// PurgeDue starts a job for every organization deleted more than Retention
+// ago and purges users deleted that long ago outside of one. It returns how
+// many jobs it started and users it purged.
+func (p *Purger) PurgeDue(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-p.Retention)
+	n := 0
+
+	orgs, err := p.Store.ListDeletedOrgs(ctx)
+	if err != nil {
+		return n, err
+	}
+	deletedOrgs := map[string]bool{}
+	for _, o := range orgs {
+		deletedOrgs[o.ID] = true
+		if o.DeletedAt == nil || o.DeletedAt.After(cutoff) {
+			continue
+		}
+		if j, err := p.Store.GetPurgeJob(ctx, o.ID); err != nil {
+			return n, err
+		} else if j != nil {
+			continue
+		}
+		if _, err := p.Start(ctx, o.ID, ""); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	users, err := p.Store.ListDeletedUsers(ctx)
+	if err != nil {
+		return n, err
+	}
+	for _, u := range users {
+		if u.DeletedAt == nil || u.DeletedAt.After(cutoff) {
+			continue
+		}
+		// Members of deleted organizations go with the organization.
+		if u.OrganizationID != nil && deletedOrgs[*u.OrganizationID] {
+			continue
+		}
+		if err := p.Store.PurgeUser(ctx, u.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Tick looks for records due for purging at most every dueInterval, then
+// advances the purge jobs.
+func (p *Purger) Tick(ctx context.Context) error {
+	if time.Since(p.lastDue) >= dueInterval {
+		n, err := p.PurgeDue(ctx)
+		if err != nil {
+			return err
+		}
+		p.lastDue = time.Now()
+		if n > 0 {
+			log.Printf("purge: %d deleted users and organizations were due", n)
+		}
+	}
+	n, err := p.RunJobs(ctx)
+	if n > 0 {
+		log.Printf("purge: purged %d organizations", n)
+	}
+	return err
+}
+
+// Run calls Tick every interval until ctx is done.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Tick(ctx); err != nil {
+			log.Printf("purge: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
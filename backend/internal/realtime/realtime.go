@@ -0,0 +1,150 @@
+// Package realtime fans ticket events out to connected browser clients.
+//
+// The Broker interface is what the stream handler depends on. MemoryBroker
+// only reaches clients connected to the same process. StoreBroker, which the
+// API uses, shares events between instances through the store: any instance
+// (or the worker) may publish an event, and every instance polls for them and
+// serves its own clients and their replays. Each instance orders events as it
+// reads them, so a client that resumes on another instance may be sent an
+// event it already had, and an event written more than a few seconds late is
+// missed.
+package realtime
+
+import (
+	"context"
+	"sync"
+)
+
+// Message types pushed to clients.
+const (
+	TicketCreated = "ticket.created"
+	TicketUpdated = "ticket.updated"
+	MessageAdded  = "message.added"
+)
+
+// Message is one event on the stream. OrganizationID and Internal are used
+// to filter what each client may see and are not sent.
+type Message struct {
+	ID             string `json:"id"`
+	Type           string `json:"type"`
+	TicketID       string `json:"ticketId"`
+	OrganizationID string `json:"-"`
+	Internal       bool   `json:"-"`
+	Data           any    `json:"data"`
+}
+
+// Viewer is what a subscriber may see, from their permissions.
+type Viewer struct {
+	OrganizationID string
+	// AnyOrganization sees every organization's tickets (policy.AnyOrganization).
+	AnyOrganization bool
+	// Internal sees internal notes (policy.TicketInternal).
+	Internal bool
+}
+
+// VisibleTo reports whether v may receive m: tickets of their own
+// organization unless they may see every one, and internal notes only if
+// they may read them.
+func (m *Message) VisibleTo(v Viewer) bool {
+	if m.Internal && !v.Internal {
+		return false
+	}
+	return v.AnyOrganization || (v.OrganizationID != "" && m.OrganizationID == v.OrganizationID)
+}
+
+// Broker distributes messages to every subscriber.
+type Broker interface {
+	Publish(ctx context.Context, m Message) error
+	// Subscribe starts a subscription. When lastID is set, messages published
+	// after it are returned in Replay.
+	Subscribe(lastID string) *Subscription
+}
+
+// Subscription receives messages published after it was created. C is closed
+// when the subscriber falls too far behind; the client then reconnects and
+// catches up from its last event ID.
+type Subscription struct {
+	C      <-chan Message
+	Replay []Message
+	// Missed is set when lastID is no longer known to the broker, so messages
+	// may have been lost and the client should reload what it shows.
+	Missed bool
+
+	close func()
+}
+
+// Close releases the subscription.
+func (s *Subscription) Close() {
+	if s.close != nil {
+		s.close()
+	}
+}
+
+// subscriberBuffer is how many messages a subscriber may lag behind before it
+// is disconnected.
+const subscriberBuffer = 64
+
+// MemoryBroker is an in-process Broker that keeps the most recent messages
+// for replay.
+type MemoryBroker struct {
+	mu      sync.Mutex
+	history []Message
+	size    int
+	subs    map[chan Message]struct{}
+}
+
+// NewMemoryBroker returns a broker that remembers the last size messages.
+func NewMemoryBroker(size int) *MemoryBroker {
+	if size <= 0 {
+		size = 500
+	}
+	return &MemoryBroker{size: size, subs: map[chan Message]struct{}{}}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, m Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.history = append(b.history, m)
+	if len(b.history) > b.size {
+		b.history = append([]Message(nil), b.history[len(b.history)-b.size:]...)
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- m:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(lastID string) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Message, subscriberBuffer)
+	b.subs[ch] = struct{}{}
+	sub := &Subscription{C: ch}
+	sub.close = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+
+	if lastID != "" {
+		sub.Missed = true
+		for i := len(b.history) - 1; i >= 0; i-- {
+			if b.history[i].ID == lastID {
+				sub.Replay = append([]Message(nil), b.history[i+1:]...)
+				sub.Missed = false
+				break
+			}
+		}
+	}
+	return sub
+}
@@ -0,0 +1,127 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+)
+
+// EventLog is the shared, short-lived record of stream events that
+// StoreBroker writes to and reads from.
+type EventLog interface {
+	AppendStreamEvent(ctx context.Context, e *models.StreamEvent) error
+	// ListStreamEvents returns the events created after since, oldest first.
+	ListStreamEvents(ctx context.Context, since time.Time) ([]models.StreamEvent, error)
+}
+
+// lookback is how far before the newest event seen each poll reads again, so
+// that events written late or stamped by a clock running behind are not
+// skipped. Events seen before are not published twice.
+const lookback = 10 * time.Second
+
+// replayWindow is how far back the first poll reads, so that clients
+// reconnecting to a freshly started instance can still replay.
+const replayWindow = 10 * time.Minute
+
+// StoreBroker is a Broker shared by every instance through an EventLog.
+// Publish only writes to the log; Run polls the log and fans new events out
+// to this instance's subscribers through a MemoryBroker, which also serves
+// replay. Clients therefore see an event up to one poll interval after it
+// was published, from whichever instance published it.
+type StoreBroker struct {
+	log   EventLog
+	local *MemoryBroker
+
+	mu    sync.Mutex
+	since time.Time            // creation time of the newest event seen
+	seen  map[string]time.Time // IDs published within lookback of since
+}
+
+// NewStoreBroker returns a broker over events that keeps the last size
+// messages for replay.
+func NewStoreBroker(events EventLog, size int) *StoreBroker {
+	return &StoreBroker{
+		log:   events,
+		local: NewMemoryBroker(size),
+		since: time.Now().Add(-replayWindow),
+		seen:  map[string]time.Time{},
+	}
+}
+
+func (b *StoreBroker) Publish(ctx context.Context, m Message) error {
+	data, err := json.Marshal(m.Data)
+	if err != nil {
+		return err
+	}
+	return b.log.AppendStreamEvent(ctx, &models.StreamEvent{
+		ID:             m.ID,
+		Type:           m.Type,
+		TicketID:       m.TicketID,
+		OrganizationID: m.OrganizationID,
+		Internal:       m.Internal,
+		Data:           string(data),
+		CreatedAt:      time.Now().UTC(),
+	})
+}
+
+func (b *StoreBroker) Subscribe(lastID string) *Subscription {
+	return b.local.Subscribe(lastID)
+}
+
+// Poll reads the events published since the last poll, by any instance, and
+// passes them to this instance's subscribers.
+func (b *StoreBroker) Poll(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	evs, err := b.log.ListStreamEvents(ctx, b.since.Add(-lookback))
+	if err != nil {
+		return err
+	}
+	for _, e := range evs {
+		if _, ok := b.seen[e.ID]; ok {
+			continue
+		}
+		b.seen[e.ID] = e.CreatedAt
+		if e.CreatedAt.After(b.since) {
+			b.since = e.CreatedAt
+		}
+		b.local.Publish(ctx, Message{
+			ID:             e.ID,
+			Type:           e.Type,
+			TicketID:       e.TicketID,
+			OrganizationID: e.OrganizationID,
+			Internal:       e.Internal,
+			Data:           json.RawMessage(e.Data),
+		})
+	}
+	for id, at := range b.seen {
+		if at.Before(b.since.Add(-2 * lookback)) {
+			delete(b.seen, id)
+		}
+	}
+	return nil
+}
+
+// Run polls the log every interval until ctx is cancelled.
+func (b *StoreBroker) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := b.Poll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("realtime: poll: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
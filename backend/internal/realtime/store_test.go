@@ -0,0 +1,94 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+func ids(msgs []Message) []string {
+	var out []string
+	for _, m := range msgs {
+		out = append(out, m.ID)
+	}
+	return out
+}
+
+// received returns the messages waiting on sub.
+func received(sub *Subscription) []Message {
+	var out []Message
+	for {
+		select {
+		case m := <-sub.C:
+			out = append(out, m)
+		default:
+			return out
+		}
+	}
+}
+
+func TestStoreBrokerSharesEvents(t *testing.T) {
+	ctx := context.Background()
+	st := storetest.New()
+	a, b := NewStoreBroker(st, 10), NewStoreBroker(st, 10)
+	sub := b.Subscribe("")
+	defer sub.Close()
+
+	a.Publish(ctx, Message{ID: "evt-1", Type: TicketCreated, TicketID: "tkt-1", OrganizationID: "org-acme", Data: map[string]string{"id": "tkt-1"}})
+	a.Publish(ctx, Message{ID: "evt-2", Type: MessageAdded, TicketID: "tkt-1", OrganizationID: "org-acme", Internal: true})
+	if got := received(sub); len(got) != 0 {
+		t.Fatalf("received %v before polling", ids(got))
+	}
+
+	if err := b.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := received(sub)
+	if len(got) != 2 || got[0].ID != "evt-1" || got[1].ID != "evt-2" {
+		t.Fatalf("received %v, want evt-1 and evt-2", ids(got))
+	}
+	if data, _ := json.Marshal(got[0].Data); string(data) != `{"id":"tkt-1"}` {
+		t.Errorf("data %s", data)
+	}
+	if got[0].OrganizationID != "org-acme" || got[0].Internal || !got[1].Internal {
+		t.Errorf("lost the fields clients are filtered on: %+v", got)
+	}
+
+	// Events are read again for a while but only passed on once.
+	b.Poll(ctx)
+	if got := received(sub); len(got) != 0 {
+		t.Fatalf("second poll passed on %v again", ids(got))
+	}
+
+	// An event stamped a little before the newest one seen still arrives.
+	st.AppendStreamEvent(ctx, &models.StreamEvent{ID: "evt-late", Type: TicketUpdated, CreatedAt: time.Now().Add(-2 * time.Second)})
+	b.Poll(ctx)
+	if got := received(sub); len(got) != 1 || got[0].ID != "evt-late" {
+		t.Fatalf("received %v, want the late event", ids(got))
+	}
+}
+
+func TestStoreBrokerReplay(t *testing.T) {
+	ctx := context.Background()
+	st := storetest.New()
+	a := NewStoreBroker(st, 10)
+	for _, id := range []string{"evt-1", "evt-2", "evt-3"} {
+		a.Publish(ctx, Message{ID: id, Type: TicketUpdated})
+	}
+
+	// An instance started after the events were published can replay them.
+	b := NewStoreBroker(st, 10)
+	b.Poll(ctx)
+	sub := b.Subscribe("evt-1")
+	defer sub.Close()
+	if got := ids(sub.Replay); sub.Missed || len(got) != 2 || got[0] != "evt-2" || got[1] != "evt-3" {
+		t.Fatalf("replay after evt-1: %v, missed %v", got, sub.Missed)
+	}
+	if unknown := b.Subscribe("evt-0"); !unknown.Missed || len(unknown.Replay) != 0 {
+		t.Fatalf("replay after an unknown event: %v, missed %v", ids(unknown.Replay), unknown.Missed)
+	}
+}
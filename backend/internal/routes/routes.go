@@ -2,31 +2,106 @@ package routes
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/supporttickr/backend/internal/blob"
+	"github.com/supporttickr/backend/internal/chat"
 	"github.com/supporttickr/backend/internal/config"
 	"github.com/supporttickr/backend/internal/handlers"
+	"github.com/supporttickr/backend/internal/inbound"
 	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/password"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/purge"
+	"github.com/supporttickr/backend/internal/sso"
 	"github.com/supporttickr/backend/internal/store"
+	"github.com/supporttickr/backend/internal/subscribers"
 )
 
-func Setup(st store.Store, cfg *config.Config) http.Handler {
+// Setup returns the API for a long-running server.
+func Setup(st store.Store, blobs blob.Storage, subs *subscribers.Set, cfg *config.Config) http.Handler {
+	return build(st, blobs, subs, cfg, true)
+}
+
+// SetupServerless returns the API without the event stream and ticket
+// presence, which hold connections open and share state in memory, so they
+// do not work behind API Gateway and Lambda.
+func SetupServerless(st store.Store, blobs blob.Storage, subs *subscribers.Set, cfg *config.Config) http.Handler {
+	return build(st, blobs, subs, cfg, false)
+}
+
+func build(st store.Store, blobs blob.Storage, subs *subscribers.Set, cfg *config.Config, longLived bool) http.Handler {
 	mux := http.NewServeMux()
 
 	// Initialize handlers
-	authH := &handlers.AuthHandler{Store: st, JWTSecret: cfg.JWTSecret}
-	ticketH := &handlers.TicketHandler{Store: st}
-	orgH := &handlers.OrgHandler{Store: st}
-	userH := &handlers.UserHandler{Store: st}
-	approvalH := &handlers.ApprovalHandler{Store: st}
-	invoiceH := &handlers.InvoiceHandler{Store: st}
-	dashboardH := &handlers.DashboardHandler{Store: st}
+	passwords := password.Policy{MinLength: cfg.PasswordMinLength, MinClasses: cfg.PasswordMinClasses, History: cfg.PasswordHistory}
+	authH := &handlers.AuthHandler{
+		Store:          st,
+		JWTSecret:      cfg.JWTSecret,
+		Notifier:       subs.Notifier,
+		Outbox:         subs.Outbox,
+		Passwords:      passwords,
+		SSO:            sso.NewClient(),
+		SSOCallbackURL: cfg.SSOCallbackURL,
+		AppURL:         cfg.AppURL(),
+		TrustProxy:     cfg.TrustProxy,
+	}
+	ticketH := &handlers.TicketHandler{Store: st, Blobs: blobs, Outbox: subs.Outbox}
+	// Users are re-read at most every 30s to pick up role, org and session changes.
+	users := middleware.NewUserCache(st, 30*time.Second)
+	orgH := &handlers.OrgHandler{Store: st, Users: users, Purger: &purge.Purger{Store: st, Blobs: blobs}}
+	userH := &handlers.UserHandler{Store: st, Users: users, Outbox: subs.Outbox, Notifier: subs.Notifier, Passwords: passwords}
+	tokenH := &handlers.APITokenHandler{Store: st}
+	roleH := &handlers.CustomRoleHandler{Store: st, Users: users}
+	invitationH := &handlers.InvitationHandler{Store: st, Notifier: subs.Notifier}
+	approvalH := &handlers.ApprovalHandler{Store: st, Outbox: subs.Outbox}
+	invoiceH := &handlers.InvoiceHandler{Store: st, Outbox: subs.Outbox}
+	dashboardH := &handlers.DashboardHandler{Store: st, Metrics: subs.Metrics}
+	macroH := &handlers.MacroHandler{Store: st}
+	webhookH := &handlers.WebhookHandler{Store: st, Webhooks: subs.Webhooks}
+	streamH := &handlers.StreamHandler{Broker: subs.Broker, Users: users}
+	presenceH := &handlers.PresenceHandler{Store: st, Hub: subs.Presence}
+	chatH := &handlers.ChatHandler{
+		Commands:      &chat.Commands{Store: st, Outbox: subs.Outbox, AppURL: cfg.AppURL(), UserDomain: cfg.ChatUserDomain},
+		SigningSecret: cfg.ChatSigningSecret,
+	}
+	inboundH := &handlers.InboundHandler{
+		Processor: &inbound.Processor{Store: st, Blobs: blobs, Outbox: subs.Outbox, AuthServID: cfg.InboundAuthServID},
+		Secret:    cfg.InboundEmailSecret,
+	}
 
-	// Auth middleware
-	authMW := middleware.Auth(cfg.JWTSecret)
+	// Auth middleware. Every protected route names the permission it needs
+	// (see package policy). Routes with a scope also accept API tokens granted
+	// that scope; session routes need a login.
+	authMW := middleware.Auth(cfg.JWTSecret, users, st)
+	scoped := func(scope string, perm policy.Permission, h http.HandlerFunc) http.Handler {
+		return authMW(middleware.RequireScope(scope)(middleware.RequirePermission(perm)(h)))
+	}
+	session := func(perm policy.Permission, h http.HandlerFunc) http.Handler {
+		return authMW(middleware.SessionOnly(middleware.RequirePermission(perm)(h)))
+	}
 
 	// Public routes
 	mux.HandleFunc("POST /api/auth/login", authH.Login)
+	mux.HandleFunc("POST /api/auth/refresh", authH.Refresh)
+	mux.HandleFunc("POST /api/auth/logout", authH.Logout)
+	mux.HandleFunc("POST /api/auth/2fa/verify", authH.VerifyTwoFactor)
+	mux.HandleFunc("POST /api/auth/2fa/setup", authH.SetupTwoFactor)
+	mux.HandleFunc("POST /api/auth/set-password", authH.SetPassword)
+	mux.HandleFunc("GET /api/auth/sso/start", authH.SSOStart)
+	mux.HandleFunc("GET /api/auth/sso/callback", authH.SSOCallback)
+	mux.HandleFunc("POST /api/auth/sso/exchange", authH.SSOExchange)
 	mux.HandleFunc("POST /api/auth/forgot-password", authH.ForgotPassword)
+	mux.HandleFunc("POST /api/auth/reset-password", authH.ResetPassword)
+	mux.HandleFunc("POST /api/auth/verify-email", authH.VerifyEmail)
+	mux.HandleFunc("GET /api/invitations/accept", authH.Invitation)
+	mux.HandleFunc("POST /api/invitations/accept", authH.AcceptInvitation)
+
+	// Inbound email webhook (authenticated by shared secret, not JWT)
+	mux.HandleFunc("POST /api/inbound/email", inboundH.Email)
+
+	// Chat slash commands (authenticated by the workspace signing secret)
+	mux.HandleFunc("POST /api/chat/commands", chatH.Command)
 
 	// Health check
 	mux.HandleFunc("GET /api/health", func(w http.ResponseWriter, r *http.Request) {
@@ -35,39 +110,92 @@ func Setup(st store.Store, cfg *config.Config) http.Handler {
 	})
 
 	// Protected routes - wrap with auth middleware
-	mux.Handle("GET /api/auth/me", authMW(http.HandlerFunc(authH.Me)))
-	mux.Handle("PUT /api/auth/change-password", authMW(http.HandlerFunc(authH.ChangePassword)))
-	mux.Handle("PUT /api/auth/me", authMW(http.HandlerFunc(authH.UpdateMyProfile)))
-
-	mux.Handle("GET /api/users", authMW(http.HandlerFunc(userH.List)))
-	mux.Handle("GET /api/users/{id}", authMW(http.HandlerFunc(userH.Get)))
-	mux.Handle("POST /api/users", authMW(http.HandlerFunc(userH.Create)))
-	mux.Handle("PUT /api/users/{id}", authMW(http.HandlerFunc(userH.Update)))
-	mux.Handle("DELETE /api/users/{id}", authMW(http.HandlerFunc(userH.Delete)))
-
-	mux.Handle("GET /api/organizations", authMW(http.HandlerFunc(orgH.List)))
-	mux.Handle("GET /api/organizations/{id}", authMW(http.HandlerFunc(orgH.Get)))
-	mux.Handle("POST /api/organizations", authMW(http.HandlerFunc(orgH.Create)))
-	mux.Handle("PUT /api/organizations/{id}", authMW(http.HandlerFunc(orgH.Update)))
-	mux.Handle("DELETE /api/organizations/{id}", authMW(http.HandlerFunc(orgH.Delete)))
-
-	mux.Handle("GET /api/tickets", authMW(http.HandlerFunc(ticketH.List)))
-	mux.Handle("GET /api/tickets/{id}", authMW(http.HandlerFunc(ticketH.Get)))
-	mux.Handle("POST /api/tickets", authMW(http.HandlerFunc(ticketH.Create)))
-	mux.Handle("PUT /api/tickets/{id}", authMW(http.HandlerFunc(ticketH.Update)))
-	mux.Handle("POST /api/tickets/{id}/messages", authMW(http.HandlerFunc(ticketH.AddMessage)))
-	mux.Handle("POST /api/tickets/{id}/time-entries", authMW(http.HandlerFunc(ticketH.AddTimeEntry)))
-	mux.Handle("POST /api/tickets/{id}/convert", authMW(http.HandlerFunc(ticketH.RequestConversion)))
-
-	mux.Handle("GET /api/approvals", authMW(http.HandlerFunc(approvalH.List)))
-	mux.Handle("PUT /api/approvals/{id}", authMW(http.HandlerFunc(approvalH.Update)))
-
-	mux.Handle("GET /api/invoices", authMW(http.HandlerFunc(invoiceH.List)))
-	mux.Handle("POST /api/invoices", authMW(http.HandlerFunc(invoiceH.Create)))
-	mux.Handle("PUT /api/invoices/{id}", authMW(http.HandlerFunc(invoiceH.UpdateStatus)))
-
-	mux.Handle("GET /api/dashboard/stats", authMW(http.HandlerFunc(dashboardH.Stats)))
-	mux.Handle("GET /api/dashboard/activities", authMW(http.HandlerFunc(dashboardH.Activities)))
+	mux.Handle("GET /api/auth/me", session(policy.ProfileManage, authH.Me))
+	mux.Handle("PUT /api/auth/change-password", session(policy.ProfileManage, authH.ChangePassword))
+	mux.Handle("PUT /api/auth/me", session(policy.ProfileManage, authH.UpdateMyProfile))
+	mux.Handle("POST /api/auth/me/email", session(policy.ProfileManage, authH.RequestEmailChange))
+	mux.Handle("POST /api/auth/2fa/enroll", session(policy.ProfileManage, authH.EnrollTwoFactor))
+	mux.Handle("POST /api/auth/2fa/confirm", session(policy.ProfileManage, authH.ConfirmTwoFactor))
+	mux.Handle("POST /api/auth/2fa/disable", session(policy.ProfileManage, authH.DisableTwoFactor))
+	mux.Handle("POST /api/auth/2fa/recovery-codes", session(policy.ProfileManage, authH.RegenerateRecoveryCodes))
+	mux.Handle("GET /api/auth/tokens", session(policy.ProfileManage, tokenH.ListMine))
+	mux.Handle("POST /api/auth/tokens", session(policy.ProfileManage, tokenH.CreateMine))
+	mux.Handle("DELETE /api/auth/tokens/{id}", session(policy.ProfileManage, tokenH.RevokeMine))
+
+	mux.Handle("GET /api/users", scoped("users:read", policy.UserRead, userH.List))
+	mux.Handle("GET /api/users/{id}", scoped("users:read", policy.UserRead, userH.Get))
+	mux.Handle("POST /api/users", scoped("users:write", policy.UserManage, userH.Create))
+	mux.Handle("PUT /api/users/{id}", scoped("users:write", policy.UserManage, userH.Update))
+	mux.Handle("DELETE /api/users/{id}", scoped("users:write", policy.UserManage, userH.Delete))
+	mux.Handle("POST /api/users/{id}/restore", scoped("users:write", policy.UserManage, userH.Restore))
+	mux.Handle("POST /api/users/{id}/revoke-sessions", session(policy.UserManage, userH.RevokeSessions))
+	mux.Handle("POST /api/users/{id}/reset-2fa", session(policy.UserManage, userH.ResetTwoFactor))
+	mux.Handle("POST /api/users/{id}/unlock", session(policy.UserManage, userH.Unlock))
+	mux.Handle("GET /api/invitations", session(policy.UserInvite, invitationH.List))
+	mux.Handle("POST /api/invitations", session(policy.UserInvite, invitationH.Create))
+	mux.Handle("POST /api/invitations/{id}/resend", session(policy.UserInvite, invitationH.Resend))
+	mux.Handle("DELETE /api/invitations/{id}", session(policy.UserInvite, invitationH.Revoke))
+	mux.Handle("GET /api/users/{id}/tokens", session(policy.UserManage, tokenH.List))
+	mux.Handle("POST /api/users/{id}/tokens", session(policy.UserManage, tokenH.Create))
+	mux.Handle("DELETE /api/users/{id}/tokens/{tokenId}", session(policy.UserManage, tokenH.Revoke))
+
+	mux.Handle("GET /api/organizations", scoped("organizations:read", policy.OrgRead, orgH.List))
+	mux.Handle("GET /api/organizations/{id}", scoped("organizations:read", policy.OrgRead, orgH.Get))
+	mux.Handle("POST /api/organizations", scoped("organizations:write", policy.OrgManage, orgH.Create))
+	mux.Handle("PUT /api/organizations/{id}", scoped("organizations:write", policy.OrgManage, orgH.Update))
+	mux.Handle("DELETE /api/organizations/{id}", scoped("organizations:write", policy.OrgManage, orgH.Delete))
+	mux.Handle("POST /api/organizations/{id}/restore", scoped("organizations:write", policy.OrgManage, orgH.Restore))
+	mux.Handle("POST /api/organizations/{id}/purge", scoped("organizations:write", policy.OrgManage, orgH.Purge))
+	mux.Handle("GET /api/organizations/{id}/purge", scoped("organizations:write", policy.OrgManage, orgH.PurgeStatus))
+	mux.Handle("GET /api/organizations/{id}/roles", scoped("organizations:read", policy.OrgRead, roleH.List))
+	mux.Handle("POST /api/organizations/{id}/roles", scoped("organizations:write", policy.OrgManage, roleH.Create))
+	mux.Handle("PUT /api/organizations/{id}/roles/{roleId}", scoped("organizations:write", policy.OrgManage, roleH.Update))
+	mux.Handle("DELETE /api/organizations/{id}/roles/{roleId}", scoped("organizations:write", policy.OrgManage, roleH.Delete))
+
+	mux.Handle("GET /api/tickets", scoped("tickets:read", policy.TicketRead, ticketH.List))
+	mux.Handle("GET /api/tickets/{id}", scoped("tickets:read", policy.TicketRead, ticketH.Get))
+	mux.Handle("POST /api/tickets", scoped("tickets:write", policy.TicketCreate, ticketH.Create))
+	mux.Handle("PUT /api/tickets/{id}", scoped("tickets:write", policy.TicketUpdate, ticketH.Update))
+	mux.Handle("POST /api/tickets/{id}/messages", scoped("tickets:write", policy.TicketReply, ticketH.AddMessage))
+	mux.Handle("POST /api/tickets/{id}/time-entries", scoped("tickets:write", policy.TicketLogTime, ticketH.AddTimeEntry))
+	mux.Handle("POST /api/tickets/{id}/convert", scoped("tickets:write", policy.TicketConvert, ticketH.RequestConversion))
+	mux.Handle("GET /api/tickets/{id}/watchers", scoped("tickets:read", policy.TicketRead, ticketH.ListWatchers))
+	mux.Handle("POST /api/tickets/{id}/watchers", scoped("tickets:write", policy.TicketWatch, ticketH.Follow))
+	mux.Handle("DELETE /api/tickets/{id}/watchers/{userId}", scoped("tickets:write", policy.TicketWatch, ticketH.Unfollow))
+	mux.Handle("GET /api/tickets/{id}/attachments/{attachmentId}", scoped("tickets:read", policy.TicketRead, ticketH.DownloadAttachment))
+	mux.Handle("POST /api/tickets/{id}/apply-macro", scoped("tickets:write", policy.MacroUse, ticketH.ApplyMacro))
+	if longLived {
+		mux.Handle("GET /api/tickets/{id}/presence", session(policy.PresenceView, presenceH.Presence))
+	}
+
+	mux.Handle("GET /api/macros", scoped("macros:read", policy.MacroUse, macroH.List))
+	mux.Handle("POST /api/macros", scoped("macros:write", policy.MacroManage, macroH.Create))
+	mux.Handle("PUT /api/macros/{id}", scoped("macros:write", policy.MacroManage, macroH.Update))
+	mux.Handle("DELETE /api/macros/{id}", scoped("macros:write", policy.MacroManage, macroH.Delete))
+
+	mux.Handle("GET /api/approvals", scoped("approvals:read", policy.ApprovalRead, approvalH.List))
+	// Either side of an approval; Update checks the side against the role.
+	mux.Handle("PUT /api/approvals/{id}", authMW(middleware.RequireScope("approvals:write")(
+		middleware.RequirePermission(policy.ApprovalDecideInternal, policy.ApprovalDecideClient)(http.HandlerFunc(approvalH.Update)))))
+
+	mux.Handle("GET /api/invoices", scoped("invoices:read", policy.InvoiceRead, invoiceH.List))
+	mux.Handle("POST /api/invoices", scoped("invoices:write", policy.InvoiceCreate, invoiceH.Create))
+	mux.Handle("PUT /api/invoices/{id}", scoped("invoices:write", policy.InvoiceUpdate, invoiceH.UpdateStatus))
+
+	mux.Handle("GET /api/webhooks", scoped("webhooks:read", policy.WebhookManage, webhookH.List))
+	mux.Handle("POST /api/webhooks", scoped("webhooks:write", policy.WebhookManage, webhookH.Create))
+	mux.Handle("PUT /api/webhooks/{id}", scoped("webhooks:write", policy.WebhookManage, webhookH.Update))
+	mux.Handle("DELETE /api/webhooks/{id}", scoped("webhooks:write", policy.WebhookManage, webhookH.Delete))
+	mux.Handle("GET /api/webhooks/{id}/deliveries", scoped("webhooks:read", policy.WebhookManage, webhookH.Deliveries))
+	mux.Handle("POST /api/webhooks/{id}/deliveries/{deliveryId}/redeliver", scoped("webhooks:write", policy.WebhookManage, webhookH.Redeliver))
+
+	if longLived {
+		mux.Handle("GET /api/stream", session(policy.TicketRead, streamH.Stream))
+	}
+
+	mux.Handle("GET /api/dashboard/stats", scoped("dashboard:read", policy.DashboardRead, dashboardH.Stats))
+	mux.Handle("GET /api/dashboard/activities", scoped("dashboard:read", policy.DashboardRead, dashboardH.Activities))
+	mux.Handle("GET /api/dashboard/event-metrics", scoped("dashboard:read", policy.MetricsRead, dashboardH.EventMetrics))
 
 	corsHandler := middleware.CORS(cfg.FrontendURL)(mux)
 	return corsHandler
@@ -0,0 +1,382 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/supporttickr/backend/internal/blob"
+	"github.com/supporttickr/backend/internal/config"
+	"github.com/supporttickr/backend/internal/middleware"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+	"github.com/supporttickr/backend/internal/subscribers"
+)
+
+const testSecret = "test-secret"
+
+// route is one protected route: the API token scope it accepts ("" for
+// session-only routes) and the permissions of which it needs one.
+type route struct {
+	pattern string
+	scope   string
+	perms   []policy.Permission
+}
+
+func r(pattern, scope string, perms ...policy.Permission) route {
+	return route{pattern, scope, perms}
+}
+
+var protected = []route{
+	r("GET /api/auth/me", "", policy.ProfileManage),
+	r("PUT /api/auth/change-password", "", policy.ProfileManage),
+	r("PUT /api/auth/me", "", policy.ProfileManage),
+	r("POST /api/auth/me/email", "", policy.ProfileManage),
+	r("POST /api/auth/2fa/enroll", "", policy.ProfileManage),
+	r("POST /api/auth/2fa/confirm", "", policy.ProfileManage),
+	r("POST /api/auth/2fa/disable", "", policy.ProfileManage),
+	r("POST /api/auth/2fa/recovery-codes", "", policy.ProfileManage),
+	r("GET /api/auth/tokens", "", policy.ProfileManage),
+	r("POST /api/auth/tokens", "", policy.ProfileManage),
+	r("DELETE /api/auth/tokens/{id}", "", policy.ProfileManage),
+
+	r("GET /api/users", "users:read", policy.UserRead),
+	r("GET /api/users/{id}", "users:read", policy.UserRead),
+	r("POST /api/users", "users:write", policy.UserManage),
+	r("PUT /api/users/{id}", "users:write", policy.UserManage),
+	r("DELETE /api/users/{id}", "users:write", policy.UserManage),
+	r("POST /api/users/{id}/restore", "users:write", policy.UserManage),
+	r("POST /api/users/{id}/revoke-sessions", "", policy.UserManage),
+	r("POST /api/users/{id}/reset-2fa", "", policy.UserManage),
+	r("POST /api/users/{id}/unlock", "", policy.UserManage),
+	r("GET /api/invitations", "", policy.UserInvite),
+	r("POST /api/invitations", "", policy.UserInvite),
+	r("POST /api/invitations/{id}/resend", "", policy.UserInvite),
+	r("DELETE /api/invitations/{id}", "", policy.UserInvite),
+	r("GET /api/users/{id}/tokens", "", policy.UserManage),
+	r("POST /api/users/{id}/tokens", "", policy.UserManage),
+	r("DELETE /api/users/{id}/tokens/{tokenId}", "", policy.UserManage),
+
+	r("GET /api/organizations", "organizations:read", policy.OrgRead),
+	r("GET /api/organizations/{id}", "organizations:read", policy.OrgRead),
+	r("POST /api/organizations", "organizations:write", policy.OrgManage),
+	r("PUT /api/organizations/{id}", "organizations:write", policy.OrgManage),
+	r("DELETE /api/organizations/{id}", "organizations:write", policy.OrgManage),
+	r("POST /api/organizations/{id}/restore", "organizations:write", policy.OrgManage),
+	r("POST /api/organizations/{id}/purge", "organizations:write", policy.OrgManage),
+	r("GET /api/organizations/{id}/purge", "organizations:write", policy.OrgManage),
+	r("GET /api/organizations/{id}/roles", "organizations:read", policy.OrgRead),
+	r("POST /api/organizations/{id}/roles", "organizations:write", policy.OrgManage),
+	r("PUT /api/organizations/{id}/roles/{roleId}", "organizations:write", policy.OrgManage),
+	r("DELETE /api/organizations/{id}/roles/{roleId}", "organizations:write", policy.OrgManage),
+
+	r("GET /api/tickets", "tickets:read", policy.TicketRead),
+	r("GET /api/tickets/{id}", "tickets:read", policy.TicketRead),
+	r("POST /api/tickets", "tickets:write", policy.TicketCreate),
+	r("PUT /api/tickets/{id}", "tickets:write", policy.TicketUpdate),
+	r("POST /api/tickets/{id}/messages", "tickets:write", policy.TicketReply),
+	r("POST /api/tickets/{id}/time-entries", "tickets:write", policy.TicketLogTime),
+	r("POST /api/tickets/{id}/convert", "tickets:write", policy.TicketConvert),
+	r("GET /api/tickets/{id}/watchers", "tickets:read", policy.TicketRead),
+	r("POST /api/tickets/{id}/watchers", "tickets:write", policy.TicketWatch),
+	r("DELETE /api/tickets/{id}/watchers/{userId}", "tickets:write", policy.TicketWatch),
+	r("GET /api/tickets/{id}/attachments/{attachmentId}", "tickets:read", policy.TicketRead),
+	r("POST /api/tickets/{id}/apply-macro", "tickets:write", policy.MacroUse),
+	r("GET /api/tickets/{id}/presence", "", policy.PresenceView),
+
+	r("GET /api/macros", "macros:read", policy.MacroUse),
+	r("POST /api/macros", "macros:write", policy.MacroManage),
+	r("PUT /api/macros/{id}", "macros:write", policy.MacroManage),
+	r("DELETE /api/macros/{id}", "macros:write", policy.MacroManage),
+
+	r("GET /api/approvals", "approvals:read", policy.ApprovalRead),
+	r("PUT /api/approvals/{id}", "approvals:write", policy.ApprovalDecideInternal, policy.ApprovalDecideClient),
+
+	r("GET /api/invoices", "invoices:read", policy.InvoiceRead),
+	r("POST /api/invoices", "invoices:write", policy.InvoiceCreate),
+	r("PUT /api/invoices/{id}", "invoices:write", policy.InvoiceUpdate),
+
+	r("GET /api/webhooks", "webhooks:read", policy.WebhookManage),
+	r("POST /api/webhooks", "webhooks:write", policy.WebhookManage),
+	r("PUT /api/webhooks/{id}", "webhooks:write", policy.WebhookManage),
+	r("DELETE /api/webhooks/{id}", "webhooks:write", policy.WebhookManage),
+	r("GET /api/webhooks/{id}/deliveries", "webhooks:read", policy.WebhookManage),
+	r("POST /api/webhooks/{id}/deliveries/{deliveryId}/redeliver", "webhooks:write", policy.WebhookManage),
+
+	r("GET /api/stream", "", policy.TicketRead),
+
+	r("GET /api/dashboard/stats", "dashboard:read", policy.DashboardRead),
+	r("GET /api/dashboard/activities", "dashboard:read", policy.DashboardRead),
+	r("GET /api/dashboard/event-metrics", "dashboard:read", policy.MetricsRead),
+}
+
+// principal is a caller: a signed-in user, or an API token when scopes is
+// not nil.
+type principal struct {
+	name   string
+	user   *models.User
+	perms  []policy.Permission
+	scopes []string
+	bearer string
+}
+
+func (p *principal) allowed(rt route) bool {
+	if p.scopes != nil && (rt.scope == "" || !slices.Contains(p.scopes, rt.scope)) {
+		return false
+	}
+	return slices.ContainsFunc(rt.perms, func(perm policy.Permission) bool { return slices.Contains(p.perms, perm) })
+}
+
+func sessionToken(t *testing.T, u *models.User) string {
+	t.Helper()
+	orgID := ""
+	if u.OrganizationID != nil {
+		orgID = *u.OrganizationID
+	}
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &middleware.Claims{
+		UserID:         u.ID,
+		Role:           u.Role,
+		OrganizationID: orgID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + s
+}
+
+func setup(t *testing.T) (http.Handler, []*principal, *storetest.Memory) {
+	ctx := context.Background()
+	st := storetest.New()
+	orgID := "org-acme"
+	st.CreateOrg(ctx, &models.Organization{ID: orgID, Name: "Acme"})
+	requester := []policy.Permission{policy.TicketRead, policy.TicketCreate, policy.TicketReply}
+	st.PutCustomRole(ctx, &models.CustomRole{ID: "role-requester", OrganizationID: orgID, Name: "Requester",
+		Permissions: []string{string(policy.TicketRead), string(policy.TicketCreate), string(policy.TicketReply)}})
+
+	var principals []*principal
+	for _, role := range []string{policy.Admin, policy.SupportLead, policy.SupportStaff, policy.Client} {
+		u := &models.User{ID: "user-" + role, Email: role + "@example.test", Role: role}
+		if role == policy.Client {
+			u.OrganizationID = &orgID
+		}
+		st.CreateUser(ctx, u)
+		principals = append(principals, &principal{name: role, user: u, perms: policy.Permissions(role), bearer: sessionToken(t, u)})
+	}
+
+	custom := &models.User{ID: "user-requester", Email: "requester@example.test", Role: policy.Client,
+		OrganizationID: &orgID, CustomRoleID: "role-requester"}
+	st.CreateUser(ctx, custom)
+	principals = append(principals, &principal{name: "custom role", user: custom,
+		perms: policy.Restrict(policy.Client, requester), bearer: sessionToken(t, custom)})
+
+	bot := &models.User{ID: "user-bot", Email: "bot@example.test", Role: policy.Admin, ServiceAccount: true}
+	st.CreateUser(ctx, bot)
+	scopes := []string{"tickets:read", "tickets:write", "users:read"}
+	st.CreateAPIToken(ctx, &models.APIToken{ID: "tok1", UserID: bot.ID, SecretHash: middleware.HashAPITokenSecret("secret"),
+		Scopes: scopes, ExpiresAt: time.Now().Add(time.Hour)})
+	principals = append(principals, &principal{name: "API token", user: bot, perms: policy.Permissions(policy.Admin),
+		scopes: scopes, bearer: "Bearer " + middleware.FormatAPIToken("tok1", "secret")})
+
+	cfg := &config.Config{JWTSecret: testSecret, FrontendURL: "http://app.test"}
+	return Setup(st, &blob.DirStorage{Dir: t.TempDir()}, subscribers.New(st, cfg), cfg), principals, st
+}
+
+// serve runs one request with an empty JSON object as the body and returns
+// the response. Path parameters in pattern are replaced with "x".
+func serve(h http.Handler, pattern, bearer string) *httptest.ResponseRecorder {
+	return serveBody(h, pattern, "{}", bearer)
+}
+
+func serveBody(h http.Handler, pattern, body, bearer string) *httptest.ResponseRecorder {
+	method, path, _ := strings.Cut(pattern, " ")
+	path = regexp.MustCompile(`\{[^}]+\}`).ReplaceAllString(path, "x")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx)
+	if bearer != "" {
+		req.Header.Set("Authorization", bearer)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// denied reports whether the auth middleware turned the request away, as
+// opposed to the handler refusing a particular record.
+func denied(rec *httptest.ResponseRecorder) bool {
+	body := rec.Body.String()
+	switch rec.Code {
+	case http.StatusUnauthorized:
+		return strings.Contains(body, "authorization") ||
+			strings.Contains(body, "invalid or expired token") ||
+			strings.Contains(body, "session is no longer valid")
+	case http.StatusForbidden:
+		return strings.Contains(body, "insufficient permissions") ||
+			strings.Contains(body, "API token is missing") ||
+			strings.Contains(body, "API tokens cannot be used")
+	}
+	return false
+}
+
+// TestAccessMatrix checks that every route lets in exactly the callers holding
+// its permission and scope. Requests that get in reach the handler, which
+// must answer them without failing.
+func TestAccessMatrix(t *testing.T) {
+	h, principals, _ := setup(t)
+	for _, rt := range protected {
+		if rec := serve(h, rt.pattern, ""); rec.Code != http.StatusUnauthorized || !denied(rec) {
+			t.Errorf("%s without credentials: status %d, want 401", rt.pattern, rec.Code)
+		}
+		for _, p := range principals {
+			rec := serve(h, rt.pattern, p.bearer)
+			if want := p.allowed(rt); denied(rec) == want {
+				t.Errorf("%s as %s: status %d %s, want allowed=%v", rt.pattern, p.name, rec.Code, strings.TrimSpace(rec.Body.String()), want)
+			}
+			if rec.Code >= 500 {
+				t.Errorf("%s as %s: status %d %s", rt.pattern, p.name, rec.Code, strings.TrimSpace(rec.Body.String()))
+			}
+		}
+	}
+}
+
+func TestAccessMatrixCoversRoutes(t *testing.T) {
+	src, err := os.ReadFile("routes.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := map[string]bool{}
+	for _, rt := range protected {
+		listed[rt.pattern] = true
+	}
+	for _, m := range regexp.MustCompile(`mux\.Handle\("([^"]+)"`).FindAllStringSubmatch(string(src), -1) {
+		if !listed[m[1]] {
+			t.Errorf("route %s is missing from the access matrix", m[1])
+		}
+		delete(listed, m[1])
+	}
+	for pattern := range listed {
+		t.Errorf("access matrix lists %s, which routes.go does not register", pattern)
+	}
+}
+
+func TestInvalidCredentials(t *testing.T) {
+	h, principals, _ := setup(t)
+	for _, bearer := range []string{
+		"Bearer " + middleware.FormatAPIToken("tok1", "wrong"),
+		"Bearer " + middleware.FormatAPIToken("missing", "secret"),
+		"Bearer not-a-jwt",
+		strings.TrimPrefix(principals[0].bearer, "Bearer "),
+	} {
+		if rec := serve(h, "GET /api/tickets", bearer); rec.Code != http.StatusUnauthorized || !denied(rec) {
+			t.Errorf("GET /api/tickets with %.20q: status %d, want 401", bearer, rec.Code)
+		}
+	}
+}
+
+func TestRevokedCredentials(t *testing.T) {
+	h, principals, _ := setup(t)
+	byName := map[string]*principal{}
+	for _, p := range principals {
+		byName[p.name] = p
+	}
+	admin, staff, bot := byName[policy.Admin], byName[policy.SupportStaff], byName["API token"]
+
+	// Both work, and their users are now cached.
+	for _, p := range []*principal{staff, bot} {
+		if rec := serve(h, "GET /api/tickets", p.bearer); rec.Code != http.StatusOK {
+			t.Fatalf("%s before revocation: status %d", p.name, rec.Code)
+		}
+	}
+
+	for _, pattern := range []string{
+		"POST /api/users/" + staff.user.ID + "/revoke-sessions",
+		"DELETE /api/users/" + bot.user.ID + "/tokens/tok1",
+	} {
+		if rec := serve(h, pattern, admin.bearer); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d %s", pattern, rec.Code, rec.Body)
+		}
+	}
+	for _, p := range []*principal{staff, bot} {
+		if rec := serve(h, "GET /api/tickets", p.bearer); rec.Code != http.StatusUnauthorized || !denied(rec) {
+			t.Errorf("%s after revocation: status %d, want 401", p.name, rec.Code)
+		}
+	}
+	if rec := serve(h, "GET /api/tickets", admin.bearer); rec.Code != http.StatusOK {
+		t.Errorf("admin after revoking others: status %d", rec.Code)
+	}
+}
+
+// TestOtherOrganization checks that a client reaches none of another
+// organization's records, and cannot issue or settle invoices at all.
+func TestOtherOrganization(t *testing.T) {
+	h, principals, st := setup(t)
+	var client, admin *principal
+	for _, p := range principals {
+		switch p.name {
+		case policy.Client:
+			client = p
+		case policy.Admin:
+			admin = p
+		}
+	}
+
+	ctx := context.Background()
+	other := "org-globex"
+	st.CreateOrg(ctx, &models.Organization{ID: other, Name: "Globex"})
+	st.CreateUser(ctx, &models.User{ID: "user-globex", Email: "hank@globex.test", Role: policy.Client, OrganizationID: &other})
+	st.CreateTicket(ctx, &models.Ticket{ID: "tkt-globex", Title: "Globex ticket", Status: "open", Category: "support", OrganizationID: other})
+	st.CreateConversionRequest(ctx, &models.ConversionRequest{ID: "conv-globex", TicketID: "tkt-globex", ProposedType: "project",
+		InternalApproval: "approved", ClientApproval: "pending"})
+	st.CreateInvoice(ctx, &models.Invoice{ID: "inv-acme", OrganizationID: *client.user.OrganizationID, Status: "sent"})
+
+	for _, tc := range []struct {
+		pattern, body string
+		want          int
+	}{
+		{"GET /api/users/user-globex", "", http.StatusForbidden},
+		{"GET /api/tickets/tkt-globex", "", http.StatusForbidden},
+		{"PUT /api/approvals/conv-globex", `{"side":"client","status":"approved"}`, http.StatusForbidden},
+		{"PUT /api/approvals/conv-missing", `{"side":"client","status":"approved"}`, http.StatusNotFound},
+		{"POST /api/invoices", `{"organizationId":"org-acme","month":1,"year":2026,"totalAmount":1}`, http.StatusForbidden},
+		{"PUT /api/invoices/inv-acme", `{"status":"paid"}`, http.StatusForbidden},
+	} {
+		if rec := serveBody(h, tc.pattern, tc.body, client.bearer); rec.Code != tc.want {
+			t.Errorf("%s as client: status %d %s, want %d", tc.pattern, rec.Code, strings.TrimSpace(rec.Body.String()), tc.want)
+		}
+	}
+
+	if cr, _ := st.GetConversionByID(ctx, "conv-globex"); cr.ClientApproval != "pending" {
+		t.Errorf("client approval %q, want it still pending", cr.ClientApproval)
+	}
+	if tk, _ := st.GetTicket(ctx, "tkt-globex"); tk.Category != "support" {
+		t.Errorf("ticket category %q, want it unchanged", tk.Category)
+	}
+	if inv, _ := st.GetInvoice(ctx, "inv-acme"); inv.Status != "sent" {
+		t.Errorf("invoice status %q, want it unchanged", inv.Status)
+	}
+	if list, _ := st.ListInvoices(ctx, "", ""); len(list) != 1 {
+		t.Errorf("%d invoices, want only the one seeded", len(list))
+	}
+
+	// The same requests from staff reach the records.
+	if rec := serve(h, "GET /api/users/user-globex", admin.bearer); rec.Code != http.StatusOK {
+		t.Errorf("GET /api/users/user-globex as admin: status %d", rec.Code)
+	}
+	if rec := serveBody(h, "PUT /api/approvals/conv-globex", `{"side":"client","status":"approved"}`, admin.bearer); rec.Code != http.StatusOK {
+		t.Errorf("PUT /api/approvals/conv-globex as admin: status %d %s", rec.Code, rec.Body)
+	}
+	if tk, _ := st.GetTicket(ctx, "tkt-globex"); tk.Category != "project" {
+		t.Errorf("ticket category %q after both approvals, want project", tk.Category)
+	}
+}
@@ -0,0 +1,318 @@
+// Package sso implements the relying-party side of the OpenID Connect
+// authorization code flow (with PKCE) used for organization single sign-on.
+//
+// Provider metadata and signing keys are discovered from the issuer and
+// cached; ID tokens are verified against them before their claims are used.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// metadataTTL is how long discovery documents and keys are cached. Keys are
+// also refetched when a token names an unknown key ID.
+const metadataTTL = time.Hour
+
+// Metadata is the part of the discovery document the flow needs.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Identity is the verified subject of an ID token.
+type Identity struct {
+	Subject string
+	Email   string
+	Name    string
+	// Claims holds every claim, for role mapping.
+	Claims jwt.MapClaims
+}
+
+// Client talks to identity providers. It is safe for concurrent use.
+type Client struct {
+	HTTP *http.Client
+
+	mu        sync.Mutex
+	providers map[string]*provider
+}
+
+type provider struct {
+	meta      *Metadata
+	metaAt    time.Time
+	keys      map[string]*rsa.PublicKey
+	keysAt    time.Time
+	keysMu    sync.Mutex
+	fetchMu   sync.Mutex
+	issuerURL string
+}
+
+func NewClient() *Client {
+	return &Client{HTTP: &http.Client{Timeout: 10 * time.Second}, providers: map[string]*provider{}}
+}
+
+func (c *Client) provider(issuer string) *provider {
+	issuer = strings.TrimSuffix(issuer, "/")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.providers[issuer]
+	if p == nil {
+		p = &provider{issuerURL: issuer}
+		c.providers[issuer] = p
+	}
+	return p
+}
+
+// Discover returns the issuer's metadata from its discovery document.
+func (c *Client) Discover(ctx context.Context, issuer string) (*Metadata, error) {
+	p := c.provider(issuer)
+	p.fetchMu.Lock()
+	defer p.fetchMu.Unlock()
+	if p.meta != nil && time.Since(p.metaAt) < metadataTTL {
+		return p.meta, nil
+	}
+
+	var meta Metadata
+	if err := c.getJSON(ctx, p.issuerURL+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, fmt.Errorf("discover %s: %w", p.issuerURL, err)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.issuerURL {
+		return nil, fmt.Errorf("discover %s: document is for issuer %q", p.issuerURL, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("discover %s: incomplete discovery document", p.issuerURL)
+	}
+	p.meta, p.metaAt = &meta, time.Now()
+	return p.meta, nil
+}
+
+// AuthRequest is one login attempt. Verifier and Nonce must be kept by the
+// caller (not sent to the browser in the clear) and checked on the callback.
+type AuthRequest struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewAuthRequest returns fresh random state, nonce and PKCE verifier.
+func NewAuthRequest() AuthRequest {
+	return AuthRequest{State: random(), Nonce: random(), Verifier: random()}
+}
+
+// AuthCodeURL returns the provider URL to send the browser to.
+func (c *Client) AuthCodeURL(ctx context.Context, issuer, clientID, redirectURI string, req AuthRequest, loginHint string) (string, error) {
+	meta, err := c.Discover(ctx, issuer)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(req.Verifier))
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", clientID)
+	v.Set("redirect_uri", redirectURI)
+	v.Set("scope", "openid email profile")
+	v.Set("state", req.State)
+	v.Set("nonce", req.Nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+	if loginHint != "" {
+		v.Set("login_hint", loginHint)
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified identity.
+func (c *Client) Exchange(ctx context.Context, issuer, clientID, clientSecret, redirectURI, code string, req AuthRequest) (*Identity, error) {
+	meta, err := c.Discover(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", req.Verifier)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+
+	resp, err := c.HTTP.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil || tok.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return c.Verify(ctx, issuer, clientID, tok.IDToken, req.Nonce)
+}
+
+// Verify checks an ID token's signature, issuer, audience, expiry and nonce.
+func (c *Client) Verify(ctx context.Context, issuer, clientID, rawIDToken, nonce string) (*Identity, error) {
+	meta, err := c.Discover(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return c.key(ctx, issuer, meta.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("invalid id token: nonce mismatch")
+	}
+
+	id := &Identity{Claims: claims}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.Name, _ = claims["name"].(string)
+	// Accounts are created and linked by email, so an address the provider
+	// does not vouch for, including one without the claim, is refused.
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return nil, errors.New("email address is not verified by the identity provider")
+	}
+	if id.Subject == "" || id.Email == "" {
+		return nil, errors.New("id token has no subject or email")
+	}
+	return id, nil
+}
+
+// key returns the signing key kid, refetching the key set when it is stale
+// or does not contain kid (the provider rotated its keys).
+func (c *Client) key(ctx context.Context, issuer, jwksURI, kid string) (*rsa.PublicKey, error) {
+	p := c.provider(issuer)
+	p.keysMu.Lock()
+	defer p.keysMu.Unlock()
+
+	if k := pickKey(p.keys, kid); k != nil && time.Since(p.keysAt) < metadataTTL {
+		return k, nil
+	}
+	keys, err := c.fetchKeys(ctx, jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys, p.keysAt = keys, time.Now()
+	if k := pickKey(keys, kid); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func pickKey(keys map[string]*rsa.PublicKey, kid string) *rsa.PublicKey {
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k
+		}
+	}
+	return keys[kid]
+}
+
+func (c *Client) fetchKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := c.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("fetch signing keys: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// ClaimValues returns a claim as a list of strings; a single string claim is
+// returned as one value.
+func (id *Identity) ClaimValues(name string) []string {
+	switch v := id.Claims[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func random() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,29 @@
+package sso
+
+import (
+	"context"
+	"testing"
+
+	"github.com/supporttickr/backend/internal/sso/mockidp"
+)
+
+func TestVerifyRequiresVerifiedEmail(t *testing.T) {
+	idp, srv := mockidp.NewServer("supportdesk", "secret")
+	defer srv.Close()
+	idp.Unverified["jane@acme.test"] = true
+	c := NewClient()
+
+	for email, ok := range map[string]bool{"joe@acme.test": true, "jane@acme.test": false} {
+		raw, err := idp.IDToken(email, "n-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := c.Verify(context.Background(), idp.Issuer, "supportdesk", raw, "n-1")
+		if (err == nil) != ok {
+			t.Errorf("Verify for %s: %v", email, err)
+		}
+		if ok && (id == nil || id.Email != email) {
+			t.Errorf("Verify for %s returned %+v", email, id)
+		}
+	}
+}
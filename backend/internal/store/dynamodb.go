@@ -2,7 +2,10 @@ package store
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -12,18 +15,34 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/supporttickr/backend/internal/config"
 	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
 )
 
 type DynamoStore struct {
-	client            *dynamodb.Client
-	usersTable        string
-	orgsTable         string
-	ticketsTable      string
-	messagesTable     string
-	timeEntriesTable  string
-	conversionTable   string
-	invoicesTable     string
-	activitiesTable   string
+	client                 *dynamodb.Client
+	usersTable             string
+	orgsTable              string
+	ticketsTable           string
+	messagesTable          string
+	timeEntriesTable       string
+	conversionTable        string
+	invoicesTable          string
+	activitiesTable        string
+	watchersTable          string
+	userTokensTable        string
+	macrosTable            string
+	attachmentsTable       string
+	webhooksTable          string
+	webhookDeliveriesTable string
+	outboxTable            string
+	sessionsTable          string
+	apiTokensTable         string
+	rolesTable             string
+	loginAttemptsTable     string
+	purgeJobsTable         string
+	userEmailsTable        string
+	sentTable              string
+	streamTable            string
 }
 
 // NewStore creates a DynamoDB store from app config (uses default AWS config).
@@ -34,15 +53,30 @@ func NewStore(ctx context.Context, cfg *config.Config) (Store, error) {
 	}
 	client := dynamodb.NewFromConfig(awsCfg)
 	return &DynamoStore{
-		client:            client,
-		usersTable:        cfg.UsersTable,
-		orgsTable:         cfg.OrgsTable,
-		ticketsTable:      cfg.TicketsTable,
-		messagesTable:     cfg.MessagesTable,
-		timeEntriesTable:  cfg.TimeEntriesTable,
-		conversionTable:   cfg.ConversionRequestsTable,
-		invoicesTable:     cfg.InvoicesTable,
-		activitiesTable:   cfg.ActivitiesTable,
+		client:                 client,
+		usersTable:             cfg.UsersTable,
+		orgsTable:              cfg.OrgsTable,
+		ticketsTable:           cfg.TicketsTable,
+		messagesTable:          cfg.MessagesTable,
+		timeEntriesTable:       cfg.TimeEntriesTable,
+		conversionTable:        cfg.ConversionRequestsTable,
+		invoicesTable:          cfg.InvoicesTable,
+		activitiesTable:        cfg.ActivitiesTable,
+		watchersTable:          cfg.WatchersTable,
+		userTokensTable:        cfg.UserTokensTable,
+		macrosTable:            cfg.MacrosTable,
+		attachmentsTable:       cfg.AttachmentsTable,
+		webhooksTable:          cfg.WebhooksTable,
+		webhookDeliveriesTable: cfg.WebhookDeliveriesTable,
+		outboxTable:            cfg.OutboxTable,
+		sessionsTable:          cfg.SessionsTable,
+		apiTokensTable:         cfg.APITokensTable,
+		rolesTable:             cfg.RolesTable,
+		loginAttemptsTable:     cfg.LoginAttemptsTable,
+		purgeJobsTable:         cfg.PurgeJobsTable,
+		userEmailsTable:        cfg.UserEmailsTable,
+		sentTable:              cfg.SentTable,
+		streamTable:            cfg.StreamTable,
 	}, nil
 }
 
@@ -52,29 +86,59 @@ func NewDynamoStore(cfg *DynamoConfig) (*DynamoStore, error) {
 		return nil, err
 	}
 	return &DynamoStore{
-		client:            client,
-		usersTable:        cfg.UsersTable,
-		orgsTable:         cfg.OrgsTable,
-		ticketsTable:      cfg.TicketsTable,
-		messagesTable:     cfg.MessagesTable,
-		timeEntriesTable:  cfg.TimeEntriesTable,
-		conversionTable:   cfg.ConversionRequestsTable,
-		invoicesTable:     cfg.InvoicesTable,
-		activitiesTable:   cfg.ActivitiesTable,
+		client:                 client,
+		usersTable:             cfg.UsersTable,
+		orgsTable:              cfg.OrgsTable,
+		ticketsTable:           cfg.TicketsTable,
+		messagesTable:          cfg.MessagesTable,
+		timeEntriesTable:       cfg.TimeEntriesTable,
+		conversionTable:        cfg.ConversionRequestsTable,
+		invoicesTable:          cfg.InvoicesTable,
+		activitiesTable:        cfg.ActivitiesTable,
+		watchersTable:          cfg.WatchersTable,
+		userTokensTable:        cfg.UserTokensTable,
+		macrosTable:            cfg.MacrosTable,
+		attachmentsTable:       cfg.AttachmentsTable,
+		webhooksTable:          cfg.WebhooksTable,
+		webhookDeliveriesTable: cfg.WebhookDeliveriesTable,
+		outboxTable:            cfg.OutboxTable,
+		sessionsTable:          cfg.SessionsTable,
+		apiTokensTable:         cfg.APITokensTable,
+		rolesTable:             cfg.RolesTable,
+		loginAttemptsTable:     cfg.LoginAttemptsTable,
+		purgeJobsTable:         cfg.PurgeJobsTable,
+		userEmailsTable:        cfg.UserEmailsTable,
+		sentTable:              cfg.SentTable,
+		streamTable:            cfg.StreamTable,
 	}, nil
 }
 
 type DynamoConfig struct {
-	UsersTable             string
-	OrgsTable              string
-	TicketsTable           string
-	MessagesTable          string
-	TimeEntriesTable       string
+	UsersTable              string
+	OrgsTable               string
+	TicketsTable            string
+	MessagesTable           string
+	TimeEntriesTable        string
 	ConversionRequestsTable string
-	InvoicesTable          string
-	ActivitiesTable        string
-	Region                 string
-	DynamoDBClient         func(context.Context) (*dynamodb.Client, error)
+	InvoicesTable           string
+	ActivitiesTable         string
+	WatchersTable           string
+	UserTokensTable         string
+	MacrosTable             string
+	AttachmentsTable        string
+	WebhooksTable           string
+	WebhookDeliveriesTable  string
+	OutboxTable             string
+	SessionsTable           string
+	APITokensTable          string
+	RolesTable              string
+	LoginAttemptsTable      string
+	PurgeJobsTable          string
+	UserEmailsTable         string
+	SentTable               string
+	StreamTable             string
+	Region                  string
+	DynamoDBClient          func(context.Context) (*dynamodb.Client, error)
 }
 
 func timeToStr(t time.Time) string { return t.UTC().Format(time.RFC3339) }
@@ -87,7 +151,7 @@ func (s *DynamoStore) GetUserByEmail(ctx context.Context, email string) (*models
 		IndexName:              aws.String("email-index"),
 		KeyConditionExpression: aws.String("email = :email"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":email": &types.AttributeValueMemberS{Value: email},
+			":email": &types.AttributeValueMemberS{Value: NormalizeEmail(email)},
 		},
 	})
 	if err != nil {
@@ -123,10 +187,10 @@ func (s *DynamoStore) ListUsers(ctx context.Context, role, orgID string) ([]mode
 	var users []models.UserResponse
 	for _, item := range out.Items {
 		u, err := itemToUser(item)
-		if err != nil {
+		if err != nil || u.DeletedAt != nil {
 			continue
 		}
-		if role == "client" && orgID != "" {
+		if role == policy.Client {
 			uOrg := ""
 			if u.OrganizationID != nil {
 				uOrg = *u.OrganizationID
@@ -140,7 +204,10 @@ func (s *DynamoStore) ListUsers(ctx context.Context, role, orgID string) ([]mode
 	return users, nil
 }
 
+// CreateUser stores u together with the lock on its email address, which it
+// normalizes in place.
 func (s *DynamoStore) CreateUser(ctx context.Context, u *models.User) error {
+	u.Email = NormalizeEmail(u.Email)
 	item := map[string]types.AttributeValue{
 		"id":            &types.AttributeValueMemberS{Value: u.ID},
 		"name":          &types.AttributeValueMemberS{Value: u.Name},
@@ -148,7 +215,7 @@ func (s *DynamoStore) CreateUser(ctx context.Context, u *models.User) error {
 		"password_hash": &types.AttributeValueMemberS{Value: u.PasswordHash},
 		"role":          &types.AttributeValueMemberS{Value: u.Role},
 		"avatar":        &types.AttributeValueMemberS{Value: u.Avatar},
-		"created_at":   &types.AttributeValueMemberS{Value: timeToStr(u.CreatedAt)},
+		"created_at":    &types.AttributeValueMemberS{Value: timeToStr(u.CreatedAt)},
 	}
 	if u.OrganizationID != nil && *u.OrganizationID != "" {
 		item["organization_id"] = &types.AttributeValueMemberS{Value: *u.OrganizationID}
@@ -156,14 +223,114 @@ func (s *DynamoStore) CreateUser(ctx context.Context, u *models.User) error {
 	if u.Phone != "" {
 		item["phone"] = &types.AttributeValueMemberS{Value: u.Phone}
 	}
-	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(s.usersTable),
-		Item:      item,
-	})
+	if u.Team != "" {
+		item["team"] = &types.AttributeValueMemberS{Value: u.Team}
+	}
+	if u.ServiceAccount {
+		item["service_account"] = &types.AttributeValueMemberS{Value: "true"}
+	}
+	if u.CustomRoleID != "" {
+		item["custom_role_id"] = &types.AttributeValueMemberS{Value: u.CustomRoleID}
+	}
+	if u.PasswordChangeRequired {
+		item["password_change_required"] = &types.AttributeValueMemberS{Value: "true"}
+	}
+	if inv := u.Invitation; inv != nil {
+		item["invited_by"] = &types.AttributeValueMemberS{Value: inv.InvitedBy}
+		item["invited_at"] = &types.AttributeValueMemberS{Value: timeToStr(inv.InvitedAt)}
+		item["invite_expires_at"] = &types.AttributeValueMemberS{Value: timeToStr(inv.ExpiresAt)}
+		item["invite_token_hash"] = &types.AttributeValueMemberS{Value: inv.TokenHash}
+	}
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName:           aws.String(s.usersTable),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+		}},
+		s.lockEmail(u.Email, u.ID),
+	}})
+	if isConditionFailed(err) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// ChangeEmail moves the user to a new email address, releasing the old one,
+// and signs them out everywhere.
+func (s *DynamoStore) ChangeEmail(ctx context.Context, id, email, tokenHash string) error {
+	u, err := s.GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return errors.New("store: user not found")
+	}
+	email = NormalizeEmail(email)
+	if email == u.Email {
+		if tokenHash != "" {
+			return s.ConsumeUserToken(ctx, tokenHash)
+		}
+		return nil
+	}
+	items := []types.TransactWriteItem{
+		{Update: &types.Update{
+			TableName: aws.String(s.usersTable),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			},
+			UpdateExpression: aws.String("SET email = :new ADD token_version :one"),
+			// Fails if the address changed since it was read.
+			ConditionExpression: aws.String("email = :old"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":new": &types.AttributeValueMemberS{Value: email},
+				":old": &types.AttributeValueMemberS{Value: u.Email},
+				":one": &types.AttributeValueMemberN{Value: "1"},
+			},
+		}},
+		s.releaseEmail(u.Email, id),
+		s.lockEmail(email, id),
+	}
+	if tokenHash != "" {
+		items = append(items, s.consumeUserToken(tokenHash))
+	}
+	_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if canceledBy(err, 3) {
+		return ErrTokenUsed
+	}
+	if canceledBy(err, 2) {
+		return ErrEmailTaken
+	}
 	return err
 }
 
-func (s *DynamoStore) UpdateUser(ctx context.Context, id string, name, email, role, orgID, avatar *string) error {
+// lockEmail claims email for the user; the write fails if it is taken.
+func (s *DynamoStore) lockEmail(email, userID string) types.TransactWriteItem {
+	return types.TransactWriteItem{Put: &types.Put{
+		TableName: aws.String(s.userEmailsTable),
+		Item: map[string]types.AttributeValue{
+			"email":   &types.AttributeValueMemberS{Value: email},
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(email)"),
+	}}
+}
+
+// releaseEmail frees the user's lock on email. Users created before email
+// locks existed may have none, which is fine.
+func (s *DynamoStore) releaseEmail(email, userID string) types.TransactWriteItem {
+	return types.TransactWriteItem{Delete: &types.Delete{
+		TableName: aws.String(s.userEmailsTable),
+		Key: map[string]types.AttributeValue{
+			"email": &types.AttributeValueMemberS{Value: email},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(email) OR user_id = :uid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid": &types.AttributeValueMemberS{Value: userID},
+		},
+	}}
+}
+
+func (s *DynamoStore) UpdateUser(ctx context.Context, id string, name, role, orgID, avatar *string) error {
 	var setParts []string
 	attrs := map[string]types.AttributeValue{}
 	names := map[string]string{"#n": "name", "#r": "role"}
@@ -182,10 +349,6 @@ func (s *DynamoStore) UpdateUser(ctx context.Context, id string, name, email, ro
 		}
 		attrs[":avatar"] = &types.AttributeValueMemberS{Value: strings.ToUpper(initials)}
 	}
-	if email != nil {
-		setParts = append(setParts, "email = :email")
-		attrs[":email"] = &types.AttributeValueMemberS{Value: *email}
-	}
 	if role != nil {
 		setParts = append(setParts, "#r = :role")
 		attrs[":role"] = &types.AttributeValueMemberS{Value: *role}
@@ -280,408 +443,1950 @@ func (s *DynamoStore) UpdateMyProfile(ctx context.Context, id string, name, phon
 	return err
 }
 
-func (s *DynamoStore) UpdatePassword(ctx context.Context, id string, newHash string) error {
-	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+// UpdatePassword sets a new hash and the previous ones to remember, clears a
+// pending forced change and bumps token_version so that every JWT issued
+// before the change stops validating.
+func (s *DynamoStore) UpdatePassword(ctx context.Context, id string, newHash string, history []string, tokenHash string) error {
+	list := make([]types.AttributeValue, len(history))
+	for i, h := range history {
+		list[i] = &types.AttributeValueMemberS{Value: h}
+	}
+	update := &types.Update{
 		TableName: aws.String(s.usersTable),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
 		},
-		UpdateExpression: aws.String("SET password_hash = :ph"),
+		UpdateExpression: aws.String("SET password_hash = :ph, password_history = :hist ADD token_version :one REMOVE password_change_required"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":ph": &types.AttributeValueMemberS{Value: newHash},
+			":ph":   &types.AttributeValueMemberS{Value: newHash},
+			":hist": &types.AttributeValueMemberL{Value: list},
+			":one":  &types.AttributeValueMemberN{Value: "1"},
 		},
-	})
+	}
+	if tokenHash == "" {
+		_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 update.TableName,
+			Key:                       update.Key,
+			UpdateExpression:          update.UpdateExpression,
+			ExpressionAttributeValues: update.ExpressionAttributeValues,
+		})
+		return err
+	}
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Update: update},
+		s.consumeUserToken(tokenHash),
+	}})
+	if canceledBy(err, 1) {
+		return ErrTokenUsed
+	}
 	return err
 }
 
-func (s *DynamoStore) DeleteUser(ctx context.Context, id string) error {
-	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+func (s *DynamoStore) UpdateUserTeam(ctx context.Context, id, team string) error {
+	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String(s.usersTable),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
 		},
-	})
+		UpdateExpression: aws.String("REMOVE team"),
+	}
+	if team != "" {
+		input.UpdateExpression = aws.String("SET team = :team")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":team": &types.AttributeValueMemberS{Value: team},
+		}
+	}
+	_, err := s.client.UpdateItem(ctx, input)
 	return err
 }
 
-func itemToUser(item map[string]types.AttributeValue) (*models.User, error) {
-	var orgID *string
-	if v, ok := item["organization_id"]; ok {
-		if s, ok := v.(*types.AttributeValueMemberS); ok {
-			orgID = &s.Value
-		}
+// SetUserCustomRole assigns a custom role to the user; "" removes it.
+func (s *DynamoStore) SetUserCustomRole(ctx context.Context, id, roleID string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("REMOVE custom_role_id"),
 	}
-	createdAt := time.Time{}
-	if v, ok := item["created_at"]; ok {
-		if s, ok := v.(*types.AttributeValueMemberS); ok {
-			t, _ := time.Parse(time.RFC3339, s.Value)
-			createdAt = t
+	if roleID != "" {
+		input.UpdateExpression = aws.String("SET custom_role_id = :role")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":role": &types.AttributeValueMemberS{Value: roleID},
 		}
 	}
-	return &models.User{
-		ID:             getStr(item, "id"),
-		Name:           getStr(item, "name"),
-		Email:          getStr(item, "email"),
-		PasswordHash:   getStr(item, "password_hash"),
-		Role:           getStr(item, "role"),
-		OrganizationID: orgID,
-		Avatar:         getStr(item, "avatar"),
-		Phone:          getStr(item, "phone"),
-		CreatedAt:      createdAt,
-	}, nil
+	_, err := s.client.UpdateItem(ctx, input)
+	return err
 }
 
-func getStr(item map[string]types.AttributeValue, key string) string {
-	if v, ok := item[key]; ok {
-		if s, ok := v.(*types.AttributeValueMemberS); ok {
-			return s.Value
-		}
+// DeleteUser deletes the user and releases their email address.
+func (s *DynamoStore) DeleteUser(ctx context.Context, id string) error {
+	u, err := s.GetUser(ctx, id)
+	if err != nil || u == nil {
+		return err
 	}
-	return ""
+	return s.deleteUser(ctx, u, "")
 }
 
-func getNum(item map[string]types.AttributeValue, key string) float64 {
-	if v, ok := item[key]; ok {
-		if n, ok := v.(*types.AttributeValueMemberN); ok {
-			var f float64
-			fmt.Sscanf(n.Value, "%f", &f)
-			return f
-		}
-	}
-	return 0
+// deleteUser deletes u with their email lock if condition holds ("" for
+// none). It fails if the user's email address changed since u was read.
+func (s *DynamoStore) deleteUser(ctx context.Context, u *models.User, condition string) error {
+	cond := "email = :email"
+	if condition != "" {
+		cond = "(" + condition + ") AND " + cond
+	}
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Delete: &types.Delete{
+			TableName: aws.String(s.usersTable),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: u.ID},
+			},
+			ConditionExpression: aws.String(cond),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":email": &types.AttributeValueMemberS{Value: u.Email},
+			},
+		}},
+		s.releaseEmail(u.Email, u.ID),
+	}})
+	return err
 }
 
-func getInt(item map[string]types.AttributeValue, key string) int {
-	if v, ok := item[key]; ok {
-		if n, ok := v.(*types.AttributeValueMemberN); ok {
-			var i int
-			fmt.Sscanf(n.Value, "%d", &i)
-			return i
-		}
+// SoftDeleteUser marks the user deleted at at and revokes their sessions.
+func (s *DynamoStore) SoftDeleteUser(ctx context.Context, id string, at time.Time) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET deleted_at = :at ADD token_version :one"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at":  &types.AttributeValueMemberS{Value: timeToStr(at)},
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
+	})
+	return err
+}
+
+func (s *DynamoStore) RestoreUser(ctx context.Context, id string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("REMOVE deleted_at"),
+		ConditionExpression: aws.String("attribute_exists(deleted_at)"),
+	})
+	if isConditionFailed(err) {
+		return ErrNotDeleted
 	}
-	return 0
+	return err
 }
 
-// --- Orgs ---
-func (s *DynamoStore) ListOrgs(ctx context.Context, role, orgID string) ([]models.Organization, error) {
-	out, err := s.client.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(s.orgsTable)})
+func (s *DynamoStore) ListDeletedUsers(ctx context.Context) ([]models.User, error) {
+	out, err := s.client.Scan(ctx, &dynamodb.ScanInput{
+		TableName:        aws.String(s.usersTable),
+		FilterExpression: aws.String("attribute_exists(deleted_at)"),
+	})
 	if err != nil {
 		return nil, err
 	}
-	var list []models.Organization
+	var users []models.User
 	for _, item := range out.Items {
-		o, err := itemToOrg(item)
-		if err != nil {
-			continue
-		}
-		if role == "client" && orgID != "" && o.ID != orgID {
-			continue
+		if u, err := itemToUser(item); err == nil {
+			users = append(users, *u)
 		}
-		list = append(list, *o)
 	}
-	return list, nil
+	return users, nil
 }
 
-func (s *DynamoStore) GetOrg(ctx context.Context, id string) (*models.Organization, error) {
-	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(s.orgsTable),
+// RenewInvitation replaces the link of a pending invitation.
+func (s *DynamoStore) RenewInvitation(ctx context.Context, id, tokenHash string, expiresAt time.Time) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
 		},
+		UpdateExpression:    aws.String("SET invite_token_hash = :h, invite_expires_at = :exp"),
+		ConditionExpression: aws.String("attribute_exists(invite_token_hash)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":h":   &types.AttributeValueMemberS{Value: tokenHash},
+			":exp": &types.AttributeValueMemberS{Value: timeToStr(expiresAt)},
+		},
 	})
-	if err != nil {
-		return nil, err
-	}
-	if out.Item == nil {
-		return nil, nil
+	if isConditionFailed(err) {
+		return ErrNotInvited
 	}
-	return itemToOrg(out.Item)
+	return err
 }
 
-func (s *DynamoStore) CreateOrg(ctx context.Context, o *models.Organization) error {
-	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(s.orgsTable),
-		Item: map[string]types.AttributeValue{
-			"id":            &types.AttributeValueMemberS{Value: o.ID},
-			"name":          &types.AttributeValueMemberS{Value: o.Name},
-			"plan":          &types.AttributeValueMemberS{Value: o.Plan},
-			"contact_email": &types.AttributeValueMemberS{Value: o.ContactEmail},
-			"created_at":    &types.AttributeValueMemberS{Value: timeToStr(o.CreatedAt)},
+// AcceptInvitation turns a pending user into a regular one, provided
+// tokenHash is still their invitation's link and they were not deleted.
+func (s *DynamoStore) AcceptInvitation(ctx context.Context, id, tokenHash, name, avatar, passwordHash string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:         aws.String("SET #n = :name, avatar = :avatar, password_hash = :ph REMOVE invited_by, invited_at, invite_expires_at, invite_token_hash"),
+		ConditionExpression:      aws.String("invite_token_hash = :h AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeNames: map[string]string{"#n": "name"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":name":   &types.AttributeValueMemberS{Value: name},
+			":avatar": &types.AttributeValueMemberS{Value: avatar},
+			":ph":     &types.AttributeValueMemberS{Value: passwordHash},
+			":h":      &types.AttributeValueMemberS{Value: tokenHash},
 		},
 	})
+	if isConditionFailed(err) {
+		return ErrTokenUsed
+	}
 	return err
 }
 
-func (s *DynamoStore) UpdateOrg(ctx context.Context, id string, name, plan, contactEmail *string) error {
-	expr := "SET "
-	attrs := map[string]types.AttributeValue{}
-	if name != nil {
-		expr += " #n = :name, "
-		attrs[":name"] = &types.AttributeValueMemberS{Value: *name}
+func (s *DynamoStore) ClearInvitation(ctx context.Context, id string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("REMOVE invited_by, invited_at, invite_expires_at, invite_token_hash"),
+		ConditionExpression: aws.String("attribute_exists(id) AND attribute_not_exists(deleted_at)"),
+	})
+	if isConditionFailed(err) {
+		return ErrNotInvited
 	}
-	if plan != nil {
-		expr += " plan = :plan, "
-		attrs[":plan"] = &types.AttributeValueMemberS{Value: *plan}
+	return err
+}
+
+// RevokeInvitation deletes a user who has not accepted their invitation yet.
+func (s *DynamoStore) RevokeInvitation(ctx context.Context, id string) error {
+	u, err := s.GetUser(ctx, id)
+	if err != nil {
+		return err
 	}
-	if contactEmail != nil {
-		expr += " contact_email = :ce, "
-		attrs[":ce"] = &types.AttributeValueMemberS{Value: *contactEmail}
+	if u == nil || u.Invitation == nil {
+		return ErrNotInvited
 	}
-	expr = strings.TrimSuffix(strings.TrimSuffix(expr, ", "), ", ")
-	if expr == "SET " {
-		return nil
+	err = s.deleteUser(ctx, u, "attribute_exists(invite_token_hash)")
+	if canceledBy(err, 0) {
+		return ErrNotInvited
 	}
+	return err
+}
+
+func (s *DynamoStore) BumpTokenVersion(ctx context.Context, id string) error {
 	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(s.orgsTable),
+		TableName: aws.String(s.usersTable),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
 		},
-		UpdateExpression:          aws.String(expr),
-		ExpressionAttributeNames:  map[string]string{"#n": "name"},
-		ExpressionAttributeValues: attrs,
+		UpdateExpression:    aws.String("ADD token_version :one"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
 	})
 	return err
 }
 
-func (s *DynamoStore) DeleteOrg(ctx context.Context, id string) error {
-	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-		TableName: aws.String(s.orgsTable),
+// --- Two-factor ---
+func (s *DynamoStore) SetPendingTOTP(ctx context.Context, id, secret string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
 		},
+		UpdateExpression:    aws.String("SET totp_pending_secret = :s"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberS{Value: secret},
+		},
 	})
 	return err
 }
 
-func itemToOrg(item map[string]types.AttributeValue) (*models.Organization, error) {
-	createdAt := time.Time{}
-	if v, ok := item["created_at"]; ok {
-		if s, ok := v.(*types.AttributeValueMemberS); ok {
-			t, _ := time.Parse(time.RFC3339, s.Value)
-			createdAt = t
-		}
-	}
-	return &models.Organization{
-		ID:           getStr(item, "id"),
-		Name:         getStr(item, "name"),
-		Plan:         getStr(item, "plan"),
-		ContactEmail: getStr(item, "contact_email"),
-		CreatedAt:    createdAt,
-	}, nil
-}
-
-// --- Tickets ---
-func (s *DynamoStore) ListTickets(ctx context.Context, status, priority, category, organizationID, assignedTo, search string) ([]models.Ticket, error) {
-	out, err := s.client.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(s.ticketsTable)})
-	if err != nil {
-		return nil, err
-	}
-	var list []models.Ticket
-	for _, item := range out.Items {
-		t, err := itemToTicket(item)
-		if err != nil {
-			continue
-		}
-		if status != "" && t.Status != status {
-			continue
-		}
-		if priority != "" && t.Priority != priority {
-			continue
-		}
-		if category != "" && t.Category != category {
-			continue
-		}
-		if organizationID != "" && t.OrganizationID != organizationID {
-			continue
-		}
-		if assignedTo != "" {
-			a := ""
-			if t.AssignedTo != nil {
-				a = *t.AssignedTo
-			}
-			if a != assignedTo {
-				continue
-			}
-		}
-		if search != "" {
-			if !strings.Contains(strings.ToLower(t.Title), strings.ToLower(search)) &&
-				!strings.Contains(strings.ToLower(t.Description), strings.ToLower(search)) {
-				continue
-			}
-		}
-		list = append(list, *t)
-	}
-	return list, nil
-}
-
-func (s *DynamoStore) GetTicket(ctx context.Context, id string) (*models.Ticket, error) {
-	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(s.ticketsTable),
+func (s *DynamoStore) EnableTOTP(ctx context.Context, id, secret string, step int64, recoveryCodeHashes []string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
 		},
+		UpdateExpression:    aws.String("SET totp_secret = :s, totp_last_step = :step, recovery_codes = :rc REMOVE totp_pending_secret"),
+		ConditionExpression: aws.String("totp_pending_secret = :s"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s":    &types.AttributeValueMemberS{Value: secret},
+			":step": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", step)},
+			":rc":   &types.AttributeValueMemberSS{Value: recoveryCodeHashes},
+		},
 	})
-	if err != nil {
+	if isConditionFailed(err) {
+		return ErrTokenUsed
+	}
+	return err
+}
+
+func (s *DynamoStore) DisableTOTP(ctx context.Context, id string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("REMOVE totp_secret, totp_pending_secret, totp_last_step, recovery_codes"),
+	})
+	return err
+}
+
+func (s *DynamoStore) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET totp_last_step = :step"),
+		ConditionExpression: aws.String("attribute_not_exists(totp_last_step) OR totp_last_step < :step"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":step": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", step)},
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrTokenUsed
+	}
+	return err
+}
+
+func (s *DynamoStore) UseRecoveryCode(ctx context.Context, id, codeHash string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("DELETE recovery_codes :set"),
+		ConditionExpression: aws.String("contains(recovery_codes, :code)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":set":  &types.AttributeValueMemberSS{Value: []string{codeHash}},
+			":code": &types.AttributeValueMemberS{Value: codeHash},
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrTokenUsed
+	}
+	return err
+}
+
+func (s *DynamoStore) SetRecoveryCodes(ctx context.Context, id string, codeHashes []string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.usersTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET recovery_codes = :rc"),
+		ConditionExpression: aws.String("attribute_exists(totp_secret)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":rc": &types.AttributeValueMemberSS{Value: codeHashes},
+		},
+	})
+	return err
+}
+
+// --- User tokens ---
+func (s *DynamoStore) CreateUserToken(ctx context.Context, t *models.UserToken) error {
+	item := map[string]types.AttributeValue{
+		"token_hash": &types.AttributeValueMemberS{Value: t.TokenHash},
+		"user_id":    &types.AttributeValueMemberS{Value: t.UserID},
+		"purpose":    &types.AttributeValueMemberS{Value: t.Purpose},
+		"expires_at": &types.AttributeValueMemberS{Value: timeToStr(t.ExpiresAt)},
+		"created_at": &types.AttributeValueMemberS{Value: timeToStr(t.CreatedAt)},
+		// DynamoDB TTL removes expired tokens; keep them a day for auditing.
+		"ttl": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", t.ExpiresAt.Add(24*time.Hour).Unix())},
+	}
+	if t.NewEmail != "" {
+		item["new_email"] = &types.AttributeValueMemberS{Value: t.NewEmail}
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.userTokensTable),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *DynamoStore) GetUserToken(ctx context.Context, tokenHash string) (*models.UserToken, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.userTokensTable),
+		Key: map[string]types.AttributeValue{
+			"token_hash": &types.AttributeValueMemberS{Value: tokenHash},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	expiresAt, _ := time.Parse(time.RFC3339, getStr(out.Item, "expires_at"))
+	createdAt, _ := time.Parse(time.RFC3339, getStr(out.Item, "created_at"))
+	t := &models.UserToken{
+		TokenHash: getStr(out.Item, "token_hash"),
+		UserID:    getStr(out.Item, "user_id"),
+		Purpose:   getStr(out.Item, "purpose"),
+		NewEmail:  getStr(out.Item, "new_email"),
+		ExpiresAt: expiresAt,
+		CreatedAt: createdAt,
+	}
+	if v := getStr(out.Item, "used_at"); v != "" {
+		usedAt, _ := time.Parse(time.RFC3339, v)
+		t.UsedAt = &usedAt
+	}
+	return t, nil
+}
+
+func (s *DynamoStore) ConsumeUserToken(ctx context.Context, tokenHash string) error {
+	u := s.consumeUserToken(tokenHash).Update
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 u.TableName,
+		Key:                       u.Key,
+		UpdateExpression:          u.UpdateExpression,
+		ConditionExpression:       u.ConditionExpression,
+		ExpressionAttributeValues: u.ExpressionAttributeValues,
+	})
+	if isConditionFailed(err) {
+		return ErrTokenUsed
+	}
+	return err
+}
+
+// consumeUserToken marks a single-use token used; the write fails if it does
+// not exist or already was.
+func (s *DynamoStore) consumeUserToken(tokenHash string) types.TransactWriteItem {
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName: aws.String(s.userTokensTable),
+		Key: map[string]types.AttributeValue{
+			"token_hash": &types.AttributeValueMemberS{Value: tokenHash},
+		},
+		UpdateExpression:    aws.String("SET used_at = :now"),
+		ConditionExpression: aws.String("attribute_exists(token_hash) AND attribute_not_exists(used_at)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberS{Value: timeToStr(time.Now().UTC())},
+		},
+	}}
+}
+
+// --- Sessions ---
+func (s *DynamoStore) CreateSession(ctx context.Context, sess *models.Session) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.sessionsTable),
+		Item: map[string]types.AttributeValue{
+			"id":            &types.AttributeValueMemberS{Value: sess.ID},
+			"user_id":       &types.AttributeValueMemberS{Value: sess.UserID},
+			"token_hash":    &types.AttributeValueMemberS{Value: sess.TokenHash},
+			"token_version": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", sess.TokenVersion)},
+			"user_agent":    &types.AttributeValueMemberS{Value: sess.UserAgent},
+			"ip":            &types.AttributeValueMemberS{Value: sess.IP},
+			"created_at":    &types.AttributeValueMemberS{Value: timeToStr(sess.CreatedAt)},
+			"last_used_at":  &types.AttributeValueMemberS{Value: timeToStr(sess.LastUsedAt)},
+			"expires_at":    &types.AttributeValueMemberS{Value: timeToStr(sess.ExpiresAt)},
+			// DynamoDB TTL removes expired sessions; keep them a day for auditing.
+			"ttl": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", sess.ExpiresAt.Add(24*time.Hour).Unix())},
+		},
+	})
+	return err
+}
+
+func (s *DynamoStore) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.sessionsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	createdAt, _ := time.Parse(time.RFC3339, getStr(out.Item, "created_at"))
+	lastUsedAt, _ := time.Parse(time.RFC3339, getStr(out.Item, "last_used_at"))
+	expiresAt, _ := time.Parse(time.RFC3339, getStr(out.Item, "expires_at"))
+	sess := &models.Session{
+		ID:           getStr(out.Item, "id"),
+		UserID:       getStr(out.Item, "user_id"),
+		TokenHash:    getStr(out.Item, "token_hash"),
+		PreviousHash: getStr(out.Item, "previous_hash"),
+		TokenVersion: getInt(out.Item, "token_version"),
+		UserAgent:    getStr(out.Item, "user_agent"),
+		IP:           getStr(out.Item, "ip"),
+		CreatedAt:    createdAt,
+		LastUsedAt:   lastUsedAt,
+		ExpiresAt:    expiresAt,
+	}
+	if v := getStr(out.Item, "revoked_at"); v != "" {
+		revokedAt, _ := time.Parse(time.RFC3339, v)
+		sess.RevokedAt = &revokedAt
+	}
+	return sess, nil
+}
+
+func (s *DynamoStore) RotateSession(ctx context.Context, id, oldHash, newHash string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.sessionsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET token_hash = :new, previous_hash = :old, last_used_at = :now"),
+		ConditionExpression: aws.String("token_hash = :old AND attribute_not_exists(revoked_at)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":new": &types.AttributeValueMemberS{Value: newHash},
+			":old": &types.AttributeValueMemberS{Value: oldHash},
+			":now": &types.AttributeValueMemberS{Value: timeToStr(time.Now().UTC())},
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrTokenUsed
+	}
+	return err
+}
+
+func (s *DynamoStore) RevokeSession(ctx context.Context, id string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.sessionsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET revoked_at = if_not_exists(revoked_at, :now)"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberS{Value: timeToStr(time.Now().UTC())},
+		},
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+// --- Login attempts ---
+func (s *DynamoStore) GetLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.loginAttemptsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return itemToLoginAttempts(out.Item), nil
+}
+
+// RecordLoginFailure increments the count while the previous failure is
+// within window, and otherwise starts over at one. Items expire a window
+// after the last failure.
+func (s *DynamoStore) RecordLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (*models.LoginAttempts, error) {
+	ttl := &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", at.Add(window).Unix())}
+	out, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.loginAttemptsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression:         aws.String("ADD failures :one SET last_failure_at = :at, #ttl = :ttl"),
+		ConditionExpression:      aws.String("last_failure_at >= :cutoff"),
+		ExpressionAttributeNames: map[string]string{"#ttl": "ttl"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":    &types.AttributeValueMemberN{Value: "1"},
+			":at":     &types.AttributeValueMemberS{Value: timeToStr(at)},
+			":ttl":    ttl,
+			":cutoff": &types.AttributeValueMemberS{Value: timeToStr(at.Add(-window))},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err == nil {
+		return itemToLoginAttempts(out.Attributes), nil
+	}
+	if !isConditionFailed(err) {
+		return nil, err
+	}
+
+	// No recent failures (an expired lockout goes with them).
+	item := map[string]types.AttributeValue{
+		"id":              &types.AttributeValueMemberS{Value: key},
+		"failures":        &types.AttributeValueMemberN{Value: "1"},
+		"last_failure_at": &types.AttributeValueMemberS{Value: timeToStr(at)},
+		"ttl":             ttl,
+	}
+	if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.loginAttemptsTable),
+		Item:      item,
+	}); err != nil {
+		return nil, err
+	}
+	return itemToLoginAttempts(item), nil
+}
+
+func (s *DynamoStore) LockLogin(ctx context.Context, key string, until time.Time, outbox ...models.OutboxMessage) error {
+	return s.write(ctx, types.TransactWriteItem{Update: &types.Update{
+		TableName:                aws.String(s.loginAttemptsTable),
+		Key:                      map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: key}},
+		UpdateExpression:         aws.String("SET locked_until = :until, #ttl = :ttl"),
+		ExpressionAttributeNames: map[string]string{"#ttl": "ttl"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":until": &types.AttributeValueMemberS{Value: timeToStr(until)},
+			":ttl":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", until.Add(24*time.Hour).Unix())},
+		},
+	}}, outbox)
+}
+
+// ClearLoginAttempts forgets the failures and lockout of key, e.g. after a
+// successful sign-in or when an admin unlocks an account.
+func (s *DynamoStore) ClearLoginAttempts(ctx context.Context, key string, outbox ...models.OutboxMessage) error {
+	return s.write(ctx, types.TransactWriteItem{Delete: &types.Delete{
+		TableName: aws.String(s.loginAttemptsTable),
+		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: key}},
+	}}, outbox)
+}
+
+func itemToLoginAttempts(item map[string]types.AttributeValue) *models.LoginAttempts {
+	lastFailureAt, _ := time.Parse(time.RFC3339, getStr(item, "last_failure_at"))
+	a := &models.LoginAttempts{
+		Key:           getStr(item, "id"),
+		Failures:      getInt(item, "failures"),
+		LastFailureAt: lastFailureAt,
+	}
+	if v := getStr(item, "locked_until"); v != "" {
+		t, _ := time.Parse(time.RFC3339, v)
+		a.LockedUntil = &t
+	}
+	return a
+}
+
+// --- API tokens ---
+func (s *DynamoStore) CreateAPIToken(ctx context.Context, t *models.APIToken) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.apiTokensTable),
+		Item: map[string]types.AttributeValue{
+			"id":            &types.AttributeValueMemberS{Value: t.ID},
+			"user_id":       &types.AttributeValueMemberS{Value: t.UserID},
+			"name":          &types.AttributeValueMemberS{Value: t.Name},
+			"secret_hash":   &types.AttributeValueMemberS{Value: t.SecretHash},
+			"scopes":        &types.AttributeValueMemberSS{Value: t.Scopes},
+			"token_version": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", t.TokenVersion)},
+			"created_by":    &types.AttributeValueMemberS{Value: t.CreatedBy},
+			"created_at":    &types.AttributeValueMemberS{Value: timeToStr(t.CreatedAt)},
+			"expires_at":    &types.AttributeValueMemberS{Value: timeToStr(t.ExpiresAt)},
+			// DynamoDB TTL removes expired tokens; keep them a month so they
+			// still show up (as expired) in the owner's list.
+			"ttl": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", t.ExpiresAt.Add(30*24*time.Hour).Unix())},
+		},
+	})
+	return err
+}
+
+func (s *DynamoStore) GetAPIToken(ctx context.Context, id string) (*models.APIToken, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.apiTokensTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return itemToAPIToken(out.Item), nil
+}
+
+// ListAPITokens returns the user's tokens, newest first.
+func (s *DynamoStore) ListAPITokens(ctx context.Context, userID string) ([]models.APIToken, error) {
+	out, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.apiTokensTable),
+		IndexName:              aws.String("user-id-index"),
+		KeyConditionExpression: aws.String("user_id = :uid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]models.APIToken, 0, len(out.Items))
+	for _, item := range out.Items {
+		list = append(list, *itemToAPIToken(item))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (s *DynamoStore) TouchAPIToken(ctx context.Context, id string, at time.Time) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.apiTokensTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET last_used_at = :at"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at": &types.AttributeValueMemberS{Value: timeToStr(at)},
+		},
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *DynamoStore) RevokeAPIToken(ctx context.Context, id string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.apiTokensTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET revoked_at = if_not_exists(revoked_at, :now)"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberS{Value: timeToStr(time.Now().UTC())},
+		},
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+func itemToAPIToken(item map[string]types.AttributeValue) *models.APIToken {
+	createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
+	expiresAt, _ := time.Parse(time.RFC3339, getStr(item, "expires_at"))
+	t := &models.APIToken{
+		ID:           getStr(item, "id"),
+		UserID:       getStr(item, "user_id"),
+		Name:         getStr(item, "name"),
+		SecretHash:   getStr(item, "secret_hash"),
+		Scopes:       getStrSet(item, "scopes"),
+		TokenVersion: getInt(item, "token_version"),
+		CreatedBy:    getStr(item, "created_by"),
+		CreatedAt:    createdAt,
+		ExpiresAt:    expiresAt,
+	}
+	if v := getStr(item, "last_used_at"); v != "" {
+		lastUsedAt, _ := time.Parse(time.RFC3339, v)
+		t.LastUsedAt = &lastUsedAt
+	}
+	if v := getStr(item, "revoked_at"); v != "" {
+		revokedAt, _ := time.Parse(time.RFC3339, v)
+		t.RevokedAt = &revokedAt
+	}
+	return t
+}
+
+func itemToUser(item map[string]types.AttributeValue) (*models.User, error) {
+	var orgID *string
+	if v, ok := item["organization_id"]; ok {
+		if s, ok := v.(*types.AttributeValueMemberS); ok {
+			orgID = &s.Value
+		}
+	}
+	createdAt := time.Time{}
+	if v, ok := item["created_at"]; ok {
+		if s, ok := v.(*types.AttributeValueMemberS); ok {
+			t, _ := time.Parse(time.RFC3339, s.Value)
+			createdAt = t
+		}
+	}
+	var invitation *models.Invitation
+	if h := getStr(item, "invite_token_hash"); h != "" {
+		invitation = &models.Invitation{InvitedBy: getStr(item, "invited_by"), TokenHash: h}
+		invitation.InvitedAt, _ = time.Parse(time.RFC3339, getStr(item, "invited_at"))
+		invitation.ExpiresAt, _ = time.Parse(time.RFC3339, getStr(item, "invite_expires_at"))
+	}
+	var deletedAt *time.Time
+	if v, err := strToTime(getStr(item, "deleted_at")); err == nil {
+		deletedAt = &v
+	}
+	return &models.User{
+		ID:                     getStr(item, "id"),
+		Name:                   getStr(item, "name"),
+		Email:                  getStr(item, "email"),
+		PasswordHash:           getStr(item, "password_hash"),
+		Role:                   getStr(item, "role"),
+		OrganizationID:         orgID,
+		Avatar:                 getStr(item, "avatar"),
+		Phone:                  getStr(item, "phone"),
+		Team:                   getStr(item, "team"),
+		TokenVersion:           getInt(item, "token_version"),
+		ServiceAccount:         getStr(item, "service_account") == "true",
+		CustomRoleID:           getStr(item, "custom_role_id"),
+		PasswordHistory:        getStrList(item, "password_history"),
+		PasswordChangeRequired: getStr(item, "password_change_required") == "true",
+		Invitation:             invitation,
+		DeletedAt:              deletedAt,
+		TOTPSecret:             getStr(item, "totp_secret"),
+		TOTPPendingSecret:      getStr(item, "totp_pending_secret"),
+		TOTPLastStep:           int64(getNum(item, "totp_last_step")),
+		RecoveryCodes:          getStrSet(item, "recovery_codes"),
+		CreatedAt:              createdAt,
+	}, nil
+}
+
+func getStr(item map[string]types.AttributeValue, key string) string {
+	if v, ok := item[key]; ok {
+		if s, ok := v.(*types.AttributeValueMemberS); ok {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+func getStrSet(item map[string]types.AttributeValue, key string) []string {
+	if v, ok := item[key]; ok {
+		if ss, ok := v.(*types.AttributeValueMemberSS); ok {
+			return ss.Value
+		}
+	}
+	return nil
+}
+
+func getStrList(item map[string]types.AttributeValue, key string) []string {
+	var out []string
+	if v, ok := item[key]; ok {
+		if l, ok := v.(*types.AttributeValueMemberL); ok {
+			for _, e := range l.Value {
+				if s, ok := e.(*types.AttributeValueMemberS); ok {
+					out = append(out, s.Value)
+				}
+			}
+		}
+	}
+	return out
+}
+
+func getNum(item map[string]types.AttributeValue, key string) float64 {
+	if v, ok := item[key]; ok {
+		if n, ok := v.(*types.AttributeValueMemberN); ok {
+			var f float64
+			fmt.Sscanf(n.Value, "%f", &f)
+			return f
+		}
+	}
+	return 0
+}
+
+func getInt(item map[string]types.AttributeValue, key string) int {
+	if v, ok := item[key]; ok {
+		if n, ok := v.(*types.AttributeValueMemberN); ok {
+			var i int
+			fmt.Sscanf(n.Value, "%d", &i)
+			return i
+		}
+	}
+	return 0
+}
+
+// --- Orgs ---
+func (s *DynamoStore) ListOrgs(ctx context.Context, role, orgID string) ([]models.Organization, error) {
+	out, err := s.client.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(s.orgsTable)})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.Organization
+	for _, item := range out.Items {
+		o, err := itemToOrg(item)
+		if err != nil || o.DeletedAt != nil {
+			continue
+		}
+		if role == policy.Client && o.ID != orgID {
+			continue
+		}
+		list = append(list, *o)
+	}
+	return list, nil
+}
+
+func (s *DynamoStore) GetOrg(ctx context.Context, id string) (*models.Organization, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.orgsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return itemToOrg(out.Item)
+}
+
+func (s *DynamoStore) CreateOrg(ctx context.Context, o *models.Organization) error {
+	item := map[string]types.AttributeValue{
+		"id":            &types.AttributeValueMemberS{Value: o.ID},
+		"name":          &types.AttributeValueMemberS{Value: o.Name},
+		"plan":          &types.AttributeValueMemberS{Value: o.Plan},
+		"contact_email": &types.AttributeValueMemberS{Value: o.ContactEmail},
+		"created_at":    &types.AttributeValueMemberS{Value: timeToStr(o.CreatedAt)},
+	}
+	if o.ChatWebhookURL != "" {
+		item["chat_webhook_url"] = &types.AttributeValueMemberS{Value: o.ChatWebhookURL}
+	}
+	if o.RequireTwoFactor {
+		item["require_2fa"] = &types.AttributeValueMemberS{Value: "true"}
+	}
+	if o.MemberInvites {
+		item["member_invites"] = &types.AttributeValueMemberS{Value: "true"}
+	}
+	if o.SSO != nil {
+		b, err := json.Marshal(o.SSO)
+		if err != nil {
+			return err
+		}
+		item["sso_config"] = &types.AttributeValueMemberS{Value: string(b)}
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.orgsTable),
+		Item:      item,
+	})
+	return err
+}
+
+// UpdateOrg sets the given fields. An empty chatWebhookURL removes it.
+func (s *DynamoStore) UpdateOrg(ctx context.Context, id string, name, plan, contactEmail, chatWebhookURL *string, requireTwoFactor, memberInvites *bool) error {
+	expr := "SET "
+	attrs := map[string]types.AttributeValue{}
+	var names map[string]string
+	remove := ""
+	if name != nil {
+		expr += " #n = :name, "
+		attrs[":name"] = &types.AttributeValueMemberS{Value: *name}
+		names = map[string]string{"#n": "name"}
+	}
+	if plan != nil {
+		expr += " plan = :plan, "
+		attrs[":plan"] = &types.AttributeValueMemberS{Value: *plan}
+	}
+	if contactEmail != nil {
+		expr += " contact_email = :ce, "
+		attrs[":ce"] = &types.AttributeValueMemberS{Value: *contactEmail}
+	}
+	if chatWebhookURL != nil {
+		if *chatWebhookURL == "" {
+			remove = " REMOVE chat_webhook_url"
+		} else {
+			expr += " chat_webhook_url = :cw, "
+			attrs[":cw"] = &types.AttributeValueMemberS{Value: *chatWebhookURL}
+		}
+	}
+	if requireTwoFactor != nil {
+		expr += " require_2fa = :r2fa, "
+		attrs[":r2fa"] = &types.AttributeValueMemberS{Value: fmt.Sprintf("%t", *requireTwoFactor)}
+	}
+	if memberInvites != nil {
+		expr += " member_invites = :mi, "
+		attrs[":mi"] = &types.AttributeValueMemberS{Value: fmt.Sprintf("%t", *memberInvites)}
+	}
+	expr = strings.TrimSuffix(strings.TrimSuffix(expr, ", "), ", ")
+	if expr == "SET " {
+		if remove == "" {
+			return nil
+		}
+		expr = ""
+	}
+	if len(attrs) == 0 {
+		attrs = nil
+	}
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.orgsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:          aws.String(strings.TrimSpace(expr + remove)),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: attrs,
+	})
+	return err
+}
+
+func (s *DynamoStore) UpdateOrgSSO(ctx context.Context, id string, sso *models.SSOConfig) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.orgsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("REMOVE sso_config"),
+	}
+	if sso != nil {
+		b, err := json.Marshal(sso)
+		if err != nil {
+			return err
+		}
+		input.UpdateExpression = aws.String("SET sso_config = :sso")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":sso": &types.AttributeValueMemberS{Value: string(b)},
+		}
+	}
+	_, err := s.client.UpdateItem(ctx, input)
+	return err
+}
+
+// SoftDeleteOrg marks the organization deleted at at; its members are
+// soft-deleted separately.
+func (s *DynamoStore) SoftDeleteOrg(ctx context.Context, id string, at time.Time, outbox ...models.OutboxMessage) error {
+	return s.write(ctx, types.TransactWriteItem{Update: &types.Update{
+		TableName: aws.String(s.orgsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET deleted_at = :at"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at": &types.AttributeValueMemberS{Value: timeToStr(at)},
+		},
+	}}, outbox)
+}
+
+func (s *DynamoStore) RestoreOrg(ctx context.Context, id string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.orgsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("REMOVE deleted_at"),
+		ConditionExpression: aws.String("attribute_exists(deleted_at)"),
+	})
+	if isConditionFailed(err) {
+		return ErrNotDeleted
+	}
+	return err
+}
+
+func (s *DynamoStore) ListDeletedOrgs(ctx context.Context) ([]models.Organization, error) {
+	out, err := s.client.Scan(ctx, &dynamodb.ScanInput{
+		TableName:        aws.String(s.orgsTable),
+		FilterExpression: aws.String("attribute_exists(deleted_at)"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.Organization
+	for _, item := range out.Items {
+		if o, err := itemToOrg(item); err == nil {
+			list = append(list, *o)
+		}
+	}
+	return list, nil
+}
+
+func (s *DynamoStore) DeleteOrg(ctx context.Context, id string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.orgsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	return err
+}
+
+func itemToOrg(item map[string]types.AttributeValue) (*models.Organization, error) {
+	createdAt := time.Time{}
+	if v, ok := item["created_at"]; ok {
+		if s, ok := v.(*types.AttributeValueMemberS); ok {
+			t, _ := time.Parse(time.RFC3339, s.Value)
+			createdAt = t
+		}
+	}
+	var sso *models.SSOConfig
+	if v := getStr(item, "sso_config"); v != "" {
+		sso = &models.SSOConfig{}
+		if err := json.Unmarshal([]byte(v), sso); err != nil {
+			return nil, err
+		}
+	}
+	var deletedAt *time.Time
+	if v, err := strToTime(getStr(item, "deleted_at")); err == nil {
+		deletedAt = &v
+	}
+	return &models.Organization{
+		ID:               getStr(item, "id"),
+		Name:             getStr(item, "name"),
+		Plan:             getStr(item, "plan"),
+		ContactEmail:     getStr(item, "contact_email"),
+		ChatWebhookURL:   getStr(item, "chat_webhook_url"),
+		RequireTwoFactor: getStr(item, "require_2fa") == "true",
+		MemberInvites:    getStr(item, "member_invites") == "true",
+		SSO:              sso,
+		DeletedAt:        deletedAt,
+		CreatedAt:        createdAt,
+	}, nil
+}
+
+// --- Custom roles ---
+func (s *DynamoStore) ListCustomRoles(ctx context.Context, orgID string) ([]models.CustomRole, error) {
+	out, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.rolesTable),
+		IndexName:              aws.String("organization-id-index"),
+		KeyConditionExpression: aws.String("organization_id = :org"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":org": &types.AttributeValueMemberS{Value: orgID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.CustomRole
+	for _, item := range out.Items {
+		list = append(list, *itemToCustomRole(item))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (s *DynamoStore) GetCustomRole(ctx context.Context, id string) (*models.CustomRole, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.rolesTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return itemToCustomRole(out.Item), nil
+}
+
+// PutCustomRole creates or fully replaces a custom role.
+func (s *DynamoStore) PutCustomRole(ctx context.Context, role *models.CustomRole) error {
+	item := map[string]types.AttributeValue{
+		"id":              &types.AttributeValueMemberS{Value: role.ID},
+		"organization_id": &types.AttributeValueMemberS{Value: role.OrganizationID},
+		"name":            &types.AttributeValueMemberS{Value: role.Name},
+		"created_at":      &types.AttributeValueMemberS{Value: timeToStr(role.CreatedAt)},
+		"updated_at":      &types.AttributeValueMemberS{Value: timeToStr(role.UpdatedAt)},
+	}
+	if role.Description != "" {
+		item["description"] = &types.AttributeValueMemberS{Value: role.Description}
+	}
+	// String sets cannot be empty; a role without permissions has no attribute.
+	if len(role.Permissions) > 0 {
+		item["permissions"] = &types.AttributeValueMemberSS{Value: role.Permissions}
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.rolesTable),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *DynamoStore) DeleteCustomRole(ctx context.Context, id string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.rolesTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	return err
+}
+
+func itemToCustomRole(item map[string]types.AttributeValue) *models.CustomRole {
+	createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
+	updatedAt, _ := time.Parse(time.RFC3339, getStr(item, "updated_at"))
+	role := &models.CustomRole{
+		ID:             getStr(item, "id"),
+		OrganizationID: getStr(item, "organization_id"),
+		Name:           getStr(item, "name"),
+		Description:    getStr(item, "description"),
+		Permissions:    getStrSet(item, "permissions"),
+		CreatedAt:      createdAt,
+		UpdatedAt:      updatedAt,
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	sort.Strings(role.Permissions)
+	return role
+}
+
+// --- Tickets ---
+func (s *DynamoStore) ListTickets(ctx context.Context, status, priority, category, organizationID, assignedTo, search string) ([]models.Ticket, error) {
+	out, err := s.client.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(s.ticketsTable)})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.Ticket
+	for _, item := range out.Items {
+		t, err := itemToTicket(item)
+		if err != nil {
+			continue
+		}
+		if status != "" && t.Status != status {
+			continue
+		}
+		if priority != "" && t.Priority != priority {
+			continue
+		}
+		if category != "" && t.Category != category {
+			continue
+		}
+		if organizationID != "" && t.OrganizationID != organizationID {
+			continue
+		}
+		if assignedTo != "" {
+			a := ""
+			if t.AssignedTo != nil {
+				a = *t.AssignedTo
+			}
+			if a != assignedTo {
+				continue
+			}
+		}
+		if search != "" {
+			if !strings.Contains(strings.ToLower(t.Title), strings.ToLower(search)) &&
+				!strings.Contains(strings.ToLower(t.Description), strings.ToLower(search)) {
+				continue
+			}
+		}
+		list = append(list, *t)
+	}
+	return list, nil
+}
+
+func (s *DynamoStore) GetTicket(ctx context.Context, id string) (*models.Ticket, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.ticketsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return itemToTicket(out.Item)
+}
+
+func (s *DynamoStore) CreateTicket(ctx context.Context, t *models.Ticket, outbox ...models.OutboxMessage) error {
+	return s.write(ctx, types.TransactWriteItem{Put: s.ticketPut(t)}, outbox)
+}
+
+// CreateTicketFromEmail creates the ticket and records the email's Message-ID
+// in one transaction, so a redelivered email cannot create it twice.
+func (s *DynamoStore) CreateTicketFromEmail(ctx context.Context, t *models.Ticket, messageID string, outbox ...models.OutboxMessage) error {
+	return s.writeFromEmail(ctx, messageID, types.TransactWriteItem{Put: s.ticketPut(t)}, outbox)
+}
+
+func (s *DynamoStore) ticketPut(t *models.Ticket) *types.Put {
+	item := map[string]types.AttributeValue{
+		"id":              &types.AttributeValueMemberS{Value: t.ID},
+		"title":           &types.AttributeValueMemberS{Value: t.Title},
+		"description":     &types.AttributeValueMemberS{Value: t.Description},
+		"status":          &types.AttributeValueMemberS{Value: t.Status},
+		"priority":        &types.AttributeValueMemberS{Value: t.Priority},
+		"category":        &types.AttributeValueMemberS{Value: t.Category},
+		"organization_id": &types.AttributeValueMemberS{Value: t.OrganizationID},
+		"created_by":      &types.AttributeValueMemberS{Value: t.CreatedBy},
+		"hours_worked":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", t.HoursWorked)},
+		"created_at":      &types.AttributeValueMemberS{Value: timeToStr(t.CreatedAt)},
+		"updated_at":      &types.AttributeValueMemberS{Value: timeToStr(t.UpdatedAt)},
+	}
+	if t.AssignedTo != nil && *t.AssignedTo != "" {
+		item["assigned_to"] = &types.AttributeValueMemberS{Value: *t.AssignedTo}
+	}
+	if len(t.Tags) > 0 {
+		item["tags"] = &types.AttributeValueMemberSS{Value: t.Tags}
+	}
+	return &types.Put{
+		TableName: aws.String(s.ticketsTable),
+		Item:      item,
+	}
+}
+
+func (s *DynamoStore) UpdateTicket(ctx context.Context, id string, status, priority, assignedTo *string, hoursWorked *float64, outbox ...models.OutboxMessage) error {
+	return s.write(ctx, types.TransactWriteItem{Update: s.ticketUpdate(id, status, priority, assignedTo, hoursWorked, nil)}, outbox)
+}
+
+// ApplyTicketChanges writes the message and the ticket update together so a
+// failure leaves neither behind.
+func (s *DynamoStore) ApplyTicketChanges(ctx context.Context, id string, msg *models.Message, status, priority, assignedTo *string, tags []string, outbox ...models.OutboxMessage) error {
+	update := types.TransactWriteItem{Update: s.ticketUpdate(id, status, priority, assignedTo, nil, tags)}
+	if msg == nil {
+		return s.write(ctx, update, outbox)
+	}
+	return s.writeAll(ctx, []types.TransactWriteItem{{Put: s.messagePut(msg)}, update}, outbox)
+}
+
+// ticketUpdate sets updated_at and whichever fields are not nil. An empty
+// assignedTo unassigns the ticket; tags replace the current ones.
+func (s *DynamoStore) ticketUpdate(id string, status, priority, assignedTo *string, hoursWorked *float64, tags []string) *types.Update {
+	set := []string{"updated_at = :ua"}
+	var remove []string
+	attrs := map[string]types.AttributeValue{
+		":ua": &types.AttributeValueMemberS{Value: timeToStr(time.Now().UTC())},
+	}
+	if status != nil {
+		set = append(set, "#st = :status")
+		attrs[":status"] = &types.AttributeValueMemberS{Value: *status}
+	}
+	if priority != nil {
+		set = append(set, "priority = :priority")
+		attrs[":priority"] = &types.AttributeValueMemberS{Value: *priority}
+	}
+	if assignedTo != nil {
+		if *assignedTo == "" {
+			remove = append(remove, "assigned_to")
+		} else {
+			set = append(set, "assigned_to = :assigned_to")
+			attrs[":assigned_to"] = &types.AttributeValueMemberS{Value: *assignedTo}
+		}
+	}
+	if hoursWorked != nil {
+		set = append(set, "hours_worked = :hw")
+		attrs[":hw"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", *hoursWorked)}
+	}
+	if tags != nil {
+		// DynamoDB string sets cannot be empty.
+		if len(tags) == 0 {
+			remove = append(remove, "tags")
+		} else {
+			set = append(set, "tags = :tags")
+			attrs[":tags"] = &types.AttributeValueMemberSS{Value: tags}
+		}
+	}
+	expr := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		expr += " REMOVE " + strings.Join(remove, ", ")
+	}
+	// DynamoDB rejects expression attribute names that the expression does not use.
+	var names map[string]string
+	if status != nil {
+		names = map[string]string{"#st": "status"}
+	}
+	return &types.Update{
+		TableName:                 aws.String(s.ticketsTable),
+		Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		UpdateExpression:          aws.String(expr),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: attrs,
+	}
+}
+
+func itemToTicket(item map[string]types.AttributeValue) (*models.Ticket, error) {
+	var assignedTo *string
+	if v, ok := item["assigned_to"]; ok {
+		if s, ok := v.(*types.AttributeValueMemberS); ok {
+			assignedTo = &s.Value
+		}
+	}
+	createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
+	updatedAt, _ := time.Parse(time.RFC3339, getStr(item, "updated_at"))
+	return &models.Ticket{
+		ID:             getStr(item, "id"),
+		Title:          getStr(item, "title"),
+		Description:    getStr(item, "description"),
+		Status:         getStr(item, "status"),
+		Priority:       getStr(item, "priority"),
+		Category:       getStr(item, "category"),
+		OrganizationID: getStr(item, "organization_id"),
+		CreatedBy:      getStr(item, "created_by"),
+		AssignedTo:     assignedTo,
+		HoursWorked:    getNum(item, "hours_worked"),
+		Tags:           getStrSet(item, "tags"),
+		CreatedAt:      createdAt,
+		UpdatedAt:      updatedAt,
+	}, nil
+}
+
+// --- Messages ---
+func (s *DynamoStore) GetMessagesByTicketID(ctx context.Context, ticketID string) ([]models.Message, error) {
+	out, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.messagesTable),
+		KeyConditionExpression: aws.String("ticket_id = :tid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tid": &types.AttributeValueMemberS{Value: ticketID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.Message
+	for _, item := range out.Items {
+		m, err := itemToMessage(item)
+		if err != nil {
+			continue
+		}
+		list = append(list, *m)
+	}
+	return list, nil
+}
+
+func (s *DynamoStore) AddMessage(ctx context.Context, m *models.Message, outbox ...models.OutboxMessage) error {
+	return s.write(ctx, types.TransactWriteItem{Put: s.messagePut(m)}, outbox)
+}
+
+// AddMessageFromEmail adds the message and records the email's Message-ID in
+// one transaction, so a redelivered email cannot add it twice.
+func (s *DynamoStore) AddMessageFromEmail(ctx context.Context, m *models.Message, messageID string, outbox ...models.OutboxMessage) error {
+	return s.writeFromEmail(ctx, messageID, types.TransactWriteItem{Put: s.messagePut(m)}, outbox)
+}
+
+// inboundEmailTTL is how long processed Message-IDs are remembered, well past
+// the retries of mail providers and the maildir poller.
+const inboundEmailTTL = 30 * 24 * time.Hour
+
+// writeFromEmail performs op with the outbox messages, conditioned on the
+// email's Message-ID not having been recorded in the sent table before.
+func (s *DynamoStore) writeFromEmail(ctx context.Context, messageID string, op types.TransactWriteItem, outbox []models.OutboxMessage) error {
+	if messageID == "" {
+		return s.write(ctx, op, outbox)
+	}
+	seen := types.TransactWriteItem{Put: &types.Put{
+		TableName: aws.String(s.sentTable),
+		Item: map[string]types.AttributeValue{
+			"id":  &types.AttributeValueMemberS{Value: "inbound:" + messageID},
+			"ttl": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Add(inboundEmailTTL).Unix())},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	}}
+	err := s.writeAll(ctx, []types.TransactWriteItem{seen, op}, outbox)
+	if canceledBy(err, 0) {
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+func (s *DynamoStore) messagePut(m *models.Message) *types.Put {
+	internal := "false"
+	if m.IsInternal {
+		internal = "true"
+	}
+	return &types.Put{
+		TableName: aws.String(s.messagesTable),
+		Item: map[string]types.AttributeValue{
+			"ticket_id":   &types.AttributeValueMemberS{Value: m.TicketID},
+			"id":          &types.AttributeValueMemberS{Value: m.ID},
+			"user_id":     &types.AttributeValueMemberS{Value: m.UserID},
+			"content":     &types.AttributeValueMemberS{Value: m.Content},
+			"is_internal": &types.AttributeValueMemberS{Value: internal},
+			"created_at":  &types.AttributeValueMemberS{Value: timeToStr(m.CreatedAt)},
+		},
+	}
+}
+
+func itemToMessage(item map[string]types.AttributeValue) (*models.Message, error) {
+	createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
+	internal := getStr(item, "is_internal") == "true"
+	return &models.Message{
+		ID:         getStr(item, "id"),
+		TicketID:   getStr(item, "ticket_id"),
+		UserID:     getStr(item, "user_id"),
+		Content:    getStr(item, "content"),
+		IsInternal: internal,
+		CreatedAt:  createdAt,
+	}, nil
+}
+
+// --- Attachments ---
+func (s *DynamoStore) ListAttachmentsByTicketID(ctx context.Context, ticketID string) ([]models.Attachment, error) {
+	out, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.attachmentsTable),
+		KeyConditionExpression: aws.String("ticket_id = :tid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tid": &types.AttributeValueMemberS{Value: ticketID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.Attachment
+	for _, item := range out.Items {
+		list = append(list, *itemToAttachment(item))
+	}
+	return list, nil
+}
+
+func (s *DynamoStore) GetAttachment(ctx context.Context, ticketID, id string) (*models.Attachment, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.attachmentsTable),
+		Key: map[string]types.AttributeValue{
+			"ticket_id": &types.AttributeValueMemberS{Value: ticketID},
+			"id":        &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return itemToAttachment(out.Item), nil
+}
+
+func (s *DynamoStore) AddAttachment(ctx context.Context, a *models.Attachment) error {
+	item := map[string]types.AttributeValue{
+		"ticket_id":    &types.AttributeValueMemberS{Value: a.TicketID},
+		"id":           &types.AttributeValueMemberS{Value: a.ID},
+		"filename":     &types.AttributeValueMemberS{Value: a.Filename},
+		"content_type": &types.AttributeValueMemberS{Value: a.ContentType},
+		"size":         &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", a.Size)},
+		"storage_key":  &types.AttributeValueMemberS{Value: a.StorageKey},
+		"created_at":   &types.AttributeValueMemberS{Value: timeToStr(a.CreatedAt)},
+	}
+	if a.MessageID != "" {
+		item["message_id"] = &types.AttributeValueMemberS{Value: a.MessageID}
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.attachmentsTable),
+		Item:      item,
+	})
+	return err
+}
+
+func itemToAttachment(item map[string]types.AttributeValue) *models.Attachment {
+	createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
+	return &models.Attachment{
+		ID:          getStr(item, "id"),
+		TicketID:    getStr(item, "ticket_id"),
+		MessageID:   getStr(item, "message_id"),
+		Filename:    getStr(item, "filename"),
+		ContentType: getStr(item, "content_type"),
+		Size:        getInt(item, "size"),
+		StorageKey:  getStr(item, "storage_key"),
+		CreatedAt:   createdAt,
+	}
+}
+
+// --- Watchers ---
+func (s *DynamoStore) ListWatchers(ctx context.Context, ticketID string) ([]models.TicketWatcher, error) {
+	out, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.watchersTable),
+		KeyConditionExpression: aws.String("ticket_id = :tid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tid": &types.AttributeValueMemberS{Value: ticketID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.TicketWatcher
+	for _, item := range out.Items {
+		createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
+		list = append(list, models.TicketWatcher{
+			TicketID:  getStr(item, "ticket_id"),
+			UserID:    getStr(item, "user_id"),
+			Source:    getStr(item, "source"),
+			CreatedAt: createdAt,
+		})
+	}
+	return list, nil
+}
+
+// AddWatcher is idempotent: an existing watcher keeps its original source.
+func (s *DynamoStore) AddWatcher(ctx context.Context, w *models.TicketWatcher) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.watchersTable),
+		Item: map[string]types.AttributeValue{
+			"ticket_id":  &types.AttributeValueMemberS{Value: w.TicketID},
+			"user_id":    &types.AttributeValueMemberS{Value: w.UserID},
+			"source":     &types.AttributeValueMemberS{Value: w.Source},
+			"created_at": &types.AttributeValueMemberS{Value: timeToStr(w.CreatedAt)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(user_id)"),
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *DynamoStore) RemoveWatcher(ctx context.Context, ticketID, userID string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.watchersTable),
+		Key: map[string]types.AttributeValue{
+			"ticket_id": &types.AttributeValueMemberS{Value: ticketID},
+			"user_id":   &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	return err
+}
+
+// isConditionFailed reports whether a write, or a transaction, failed on a
+// condition.
+func isConditionFailed(err error) bool {
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return true
+	}
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		for _, r := range tce.CancellationReasons {
+			if aws.ToString(r.Code) == "ConditionalCheckFailed" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canceledBy reports whether a transaction was canceled because the
+// condition of its i-th item failed.
+func canceledBy(err error, i int) bool {
+	var tce *types.TransactionCanceledException
+	return errors.As(err, &tce) && i < len(tce.CancellationReasons) &&
+		aws.ToString(tce.CancellationReasons[i].Code) == "ConditionalCheckFailed"
+}
+
+// --- Macros ---
+func (s *DynamoStore) ListMacros(ctx context.Context) ([]models.Macro, error) {
+	out, err := s.client.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(s.macrosTable)})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.Macro
+	for _, item := range out.Items {
+		list = append(list, *itemToMacro(item))
+	}
+	return list, nil
+}
+
+func (s *DynamoStore) GetMacro(ctx context.Context, id string) (*models.Macro, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.macrosTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
 		return nil, err
 	}
-	if out.Item == nil {
-		return nil, nil
+	if out.Item == nil {
+		return nil, nil
+	}
+	return itemToMacro(out.Item), nil
+}
+
+// PutMacro creates or fully replaces a macro.
+func (s *DynamoStore) PutMacro(ctx context.Context, m *models.Macro) error {
+	internal := "false"
+	if m.IsInternal {
+		internal = "true"
+	}
+	item := map[string]types.AttributeValue{
+		"id":          &types.AttributeValueMemberS{Value: m.ID},
+		"name":        &types.AttributeValueMemberS{Value: m.Name},
+		"content":     &types.AttributeValueMemberS{Value: m.Content},
+		"is_internal": &types.AttributeValueMemberS{Value: internal},
+		"scope":       &types.AttributeValueMemberS{Value: m.Scope},
+		"created_by":  &types.AttributeValueMemberS{Value: m.CreatedBy},
+		"created_at":  &types.AttributeValueMemberS{Value: timeToStr(m.CreatedAt)},
+		"updated_at":  &types.AttributeValueMemberS{Value: timeToStr(m.UpdatedAt)},
+	}
+	if m.Team != "" {
+		item["team"] = &types.AttributeValueMemberS{Value: m.Team}
+	}
+	if m.SetStatus != nil {
+		item["set_status"] = &types.AttributeValueMemberS{Value: *m.SetStatus}
+	}
+	if m.SetPriority != nil {
+		item["set_priority"] = &types.AttributeValueMemberS{Value: *m.SetPriority}
+	}
+	if len(m.AddTags) > 0 {
+		item["add_tags"] = &types.AttributeValueMemberSS{Value: m.AddTags}
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.macrosTable),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *DynamoStore) DeleteMacro(ctx context.Context, id string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.macrosTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	return err
+}
+
+func itemToMacro(item map[string]types.AttributeValue) *models.Macro {
+	createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
+	updatedAt, _ := time.Parse(time.RFC3339, getStr(item, "updated_at"))
+	m := &models.Macro{
+		ID:         getStr(item, "id"),
+		Name:       getStr(item, "name"),
+		Content:    getStr(item, "content"),
+		IsInternal: getStr(item, "is_internal") == "true",
+		Scope:      getStr(item, "scope"),
+		Team:       getStr(item, "team"),
+		AddTags:    getStrSet(item, "add_tags"),
+		CreatedBy:  getStr(item, "created_by"),
+		CreatedAt:  createdAt,
+		UpdatedAt:  updatedAt,
+	}
+	if v := getStr(item, "set_status"); v != "" {
+		m.SetStatus = &v
 	}
-	return itemToTicket(out.Item)
+	if v := getStr(item, "set_priority"); v != "" {
+		m.SetPriority = &v
+	}
+	if m.AddTags == nil {
+		m.AddTags = []string{}
+	}
+	return m
 }
 
-func (s *DynamoStore) CreateTicket(ctx context.Context, t *models.Ticket) error {
-	item := map[string]types.AttributeValue{
-		"id":              &types.AttributeValueMemberS{Value: t.ID},
-		"title":           &types.AttributeValueMemberS{Value: t.Title},
-		"description":     &types.AttributeValueMemberS{Value: t.Description},
-		"status":          &types.AttributeValueMemberS{Value: t.Status},
-		"priority":        &types.AttributeValueMemberS{Value: t.Priority},
-		"category":        &types.AttributeValueMemberS{Value: t.Category},
-		"organization_id": &types.AttributeValueMemberS{Value: t.OrganizationID},
-		"created_by":      &types.AttributeValueMemberS{Value: t.CreatedBy},
-		"hours_worked":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", t.HoursWorked)},
-		"created_at":      &types.AttributeValueMemberS{Value: timeToStr(t.CreatedAt)},
-		"updated_at":      &types.AttributeValueMemberS{Value: timeToStr(t.UpdatedAt)},
+// --- Webhooks ---
+func (s *DynamoStore) ListWebhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+	out, err := s.client.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(s.webhooksTable)})
+	if err != nil {
+		return nil, err
 	}
-	if t.AssignedTo != nil {
-		item["assigned_to"] = &types.AttributeValueMemberS{Value: *t.AssignedTo}
+	var list []models.WebhookSubscription
+	for _, item := range out.Items {
+		list = append(list, *itemToWebhook(item))
 	}
-	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(s.ticketsTable),
-		Item:      item,
+	return list, nil
+}
+
+func (s *DynamoStore) GetWebhook(ctx context.Context, id string) (*models.WebhookSubscription, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.webhooksTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
 	})
-	return err
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return itemToWebhook(out.Item), nil
 }
 
-func (s *DynamoStore) UpdateTicket(ctx context.Context, id string, status, priority, assignedTo *string, hoursWorked *float64) error {
-	expr := "SET updated_at = :ua"
-	attrs := map[string]types.AttributeValue{
-		":ua": &types.AttributeValueMemberS{Value: timeToStr(time.Now().UTC())},
+// PutWebhook creates or fully replaces a webhook subscription.
+func (s *DynamoStore) PutWebhook(ctx context.Context, w *models.WebhookSubscription) error {
+	active := "false"
+	if w.Active {
+		active = "true"
 	}
-	if status != nil {
-		expr += ", #st = :status"
-		attrs[":status"] = &types.AttributeValueMemberS{Value: *status}
+	item := map[string]types.AttributeValue{
+		"id":         &types.AttributeValueMemberS{Value: w.ID},
+		"url":        &types.AttributeValueMemberS{Value: w.URL},
+		"secret":     &types.AttributeValueMemberS{Value: w.Secret},
+		"active":     &types.AttributeValueMemberS{Value: active},
+		"created_by": &types.AttributeValueMemberS{Value: w.CreatedBy},
+		"created_at": &types.AttributeValueMemberS{Value: timeToStr(w.CreatedAt)},
+		"updated_at": &types.AttributeValueMemberS{Value: timeToStr(w.UpdatedAt)},
 	}
-	if priority != nil {
-		expr += ", priority = :priority"
-		attrs[":priority"] = &types.AttributeValueMemberS{Value: *priority}
+	if w.OrganizationID != "" {
+		item["organization_id"] = &types.AttributeValueMemberS{Value: w.OrganizationID}
 	}
-	if assignedTo != nil {
-		if *assignedTo == "" {
-			expr = strings.TrimSuffix(expr, ", ")
-			expr += " REMOVE assigned_to"
-		} else {
-			expr += ", assigned_to = :assigned_to"
-			attrs[":assigned_to"] = &types.AttributeValueMemberS{Value: *assignedTo}
-		}
+	if w.Description != "" {
+		item["description"] = &types.AttributeValueMemberS{Value: w.Description}
 	}
-	if hoursWorked != nil {
-		expr += ", hours_worked = :hw"
-		attrs[":hw"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", *hoursWorked)}
+	if len(w.Events) > 0 {
+		item["events"] = &types.AttributeValueMemberSS{Value: w.Events}
 	}
-	names := map[string]string{"#st": "status"}
-	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName:                 aws.String(s.ticketsTable),
-		Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
-		UpdateExpression:          aws.String(expr),
-		ExpressionAttributeNames:  names,
-		ExpressionAttributeValues: attrs,
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.webhooksTable),
+		Item:      item,
 	})
 	return err
 }
 
-func (s *DynamoStore) UpdateTicketCategory(ctx context.Context, id, category string) error {
-	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(s.ticketsTable),
-		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
-		UpdateExpression: aws.String("SET category = :cat, updated_at = :ua"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":cat": &types.AttributeValueMemberS{Value: category},
-			":ua":  &types.AttributeValueMemberS{Value: timeToStr(time.Now().UTC())},
+func (s *DynamoStore) DeleteWebhook(ctx context.Context, id string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.webhooksTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
 		},
 	})
 	return err
 }
 
-func itemToTicket(item map[string]types.AttributeValue) (*models.Ticket, error) {
-	var assignedTo *string
-	if v, ok := item["assigned_to"]; ok {
-		if s, ok := v.(*types.AttributeValueMemberS); ok {
-			assignedTo = &s.Value
-		}
-	}
+func itemToWebhook(item map[string]types.AttributeValue) *models.WebhookSubscription {
 	createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
 	updatedAt, _ := time.Parse(time.RFC3339, getStr(item, "updated_at"))
-	return &models.Ticket{
+	w := &models.WebhookSubscription{
 		ID:             getStr(item, "id"),
-		Title:          getStr(item, "title"),
-		Description:    getStr(item, "description"),
-		Status:         getStr(item, "status"),
-		Priority:       getStr(item, "priority"),
-		Category:       getStr(item, "category"),
 		OrganizationID: getStr(item, "organization_id"),
+		URL:            getStr(item, "url"),
+		Events:         getStrSet(item, "events"),
+		Secret:         getStr(item, "secret"),
+		Active:         getStr(item, "active") == "true",
+		Description:    getStr(item, "description"),
 		CreatedBy:      getStr(item, "created_by"),
-		AssignedTo:     assignedTo,
-		HoursWorked:    getNum(item, "hours_worked"),
 		CreatedAt:      createdAt,
 		UpdatedAt:      updatedAt,
-	}, nil
+	}
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+	return w
 }
 
-// --- Messages ---
-func (s *DynamoStore) GetMessagesByTicketID(ctx context.Context, ticketID string) ([]models.Message, error) {
+// --- Webhook deliveries ---
+func (s *DynamoStore) ListWebhookDeliveries(ctx context.Context, subscriptionID string) ([]models.WebhookDelivery, error) {
 	out, err := s.client.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(s.messagesTable),
-		KeyConditionExpression: aws.String("ticket_id = :tid"),
+		TableName:              aws.String(s.webhookDeliveriesTable),
+		KeyConditionExpression: aws.String("subscription_id = :sid"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":tid": &types.AttributeValueMemberS{Value: ticketID},
+			":sid": &types.AttributeValueMemberS{Value: subscriptionID},
 		},
 	})
 	if err != nil {
 		return nil, err
 	}
-	var list []models.Message
+	var list []models.WebhookDelivery
 	for _, item := range out.Items {
-		m, err := itemToMessage(item)
-		if err != nil {
-			continue
-		}
-		list = append(list, *m)
+		list = append(list, *itemToWebhookDelivery(item))
 	}
 	return list, nil
 }
 
-func (s *DynamoStore) AddMessage(ctx context.Context, m *models.Message) error {
-	internal := "false"
-	if m.IsInternal {
-		internal = "true"
+func (s *DynamoStore) GetWebhookDelivery(ctx context.Context, subscriptionID, id string) (*models.WebhookDelivery, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.webhookDeliveriesTable),
+		Key: map[string]types.AttributeValue{
+			"subscription_id": &types.AttributeValueMemberS{Value: subscriptionID},
+			"id":              &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return itemToWebhookDelivery(out.Item), nil
+}
+
+// ListDueWebhookDeliveries returns pending deliveries whose next attempt is at or before now.
+func (s *DynamoStore) ListDueWebhookDeliveries(ctx context.Context, now time.Time) ([]models.WebhookDelivery, error) {
+	out, err := s.client.Scan(ctx, &dynamodb.ScanInput{
+		TableName:        aws.String(s.webhookDeliveriesTable),
+		FilterExpression: aws.String("#st = :pending AND next_attempt_at <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#st": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: "pending"},
+			":now":     &types.AttributeValueMemberS{Value: timeToStr(now)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.WebhookDelivery
+	for _, item := range out.Items {
+		list = append(list, *itemToWebhookDelivery(item))
 	}
+	return list, nil
+}
+
+// PutWebhookDelivery creates or replaces a delivery. Deliveries expire from the
+// log 30 days after they were created.
+func (s *DynamoStore) PutWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
 	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(s.messagesTable),
-		Item: map[string]types.AttributeValue{
-			"ticket_id":   &types.AttributeValueMemberS{Value: m.TicketID},
-			"id":          &types.AttributeValueMemberS{Value: m.ID},
-			"user_id":     &types.AttributeValueMemberS{Value: m.UserID},
-			"content":     &types.AttributeValueMemberS{Value: m.Content},
-			"is_internal": &types.AttributeValueMemberS{Value: internal},
-			"created_at":  &types.AttributeValueMemberS{Value: timeToStr(m.CreatedAt)},
+		TableName: aws.String(s.webhookDeliveriesTable),
+		Item:      webhookDeliveryItem(d),
+	})
+	return err
+}
+
+func (s *DynamoStore) CreateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.webhookDeliveriesTable),
+		Item:                webhookDeliveryItem(d),
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if isConditionFailed(err) {
+		return ErrDeliveryExists
+	}
+	return err
+}
+
+func (s *DynamoStore) ClaimWebhookDelivery(ctx context.Context, d *models.WebhookDelivery, until time.Time) error {
+	cond := "#st = :pending AND attempts = :attempts AND attribute_not_exists(next_attempt_at)"
+	values := map[string]types.AttributeValue{
+		":until":    &types.AttributeValueMemberS{Value: timeToStr(until)},
+		":pending":  &types.AttributeValueMemberS{Value: "pending"},
+		":attempts": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", d.Attempts)},
+	}
+	if d.NextAttemptAt != nil {
+		cond = "#st = :pending AND attempts = :attempts AND next_attempt_at = :old"
+		values[":old"] = &types.AttributeValueMemberS{Value: timeToStr(*d.NextAttemptAt)}
+	}
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.webhookDeliveriesTable),
+		Key: map[string]types.AttributeValue{
+			"subscription_id": &types.AttributeValueMemberS{Value: d.SubscriptionID},
+			"id":              &types.AttributeValueMemberS{Value: d.ID},
 		},
+		UpdateExpression:          aws.String("SET next_attempt_at = :until"),
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeNames:  map[string]string{"#st": "status"},
+		ExpressionAttributeValues: values,
 	})
+	if isConditionFailed(err) {
+		return ErrJobBusy
+	}
 	return err
 }
 
-func itemToMessage(item map[string]types.AttributeValue) (*models.Message, error) {
+func webhookDeliveryItem(d *models.WebhookDelivery) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"subscription_id": &types.AttributeValueMemberS{Value: d.SubscriptionID},
+		"id":              &types.AttributeValueMemberS{Value: d.ID},
+		"event":           &types.AttributeValueMemberS{Value: d.Event},
+		"payload":         &types.AttributeValueMemberS{Value: d.Payload},
+		"status":          &types.AttributeValueMemberS{Value: d.Status},
+		"attempts":        &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", d.Attempts)},
+		"created_at":      &types.AttributeValueMemberS{Value: timeToStr(d.CreatedAt)},
+		"updated_at":      &types.AttributeValueMemberS{Value: timeToStr(d.UpdatedAt)},
+		"ttl":             &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", d.CreatedAt.Add(30*24*time.Hour).Unix())},
+	}
+	if d.ResponseStatus != 0 {
+		item["response_status"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", d.ResponseStatus)}
+	}
+	if d.LastError != "" {
+		item["last_error"] = &types.AttributeValueMemberS{Value: d.LastError}
+	}
+	if d.NextAttemptAt != nil {
+		item["next_attempt_at"] = &types.AttributeValueMemberS{Value: timeToStr(*d.NextAttemptAt)}
+	}
+	return item
+}
+
+func itemToWebhookDelivery(item map[string]types.AttributeValue) *models.WebhookDelivery {
 	createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
-	internal := getStr(item, "is_internal") == "true"
-	return &models.Message{
-		ID:         getStr(item, "id"),
-		TicketID:   getStr(item, "ticket_id"),
-		UserID:     getStr(item, "user_id"),
-		Content:    getStr(item, "content"),
-		IsInternal: internal,
-		CreatedAt:  createdAt,
-	}, nil
+	updatedAt, _ := time.Parse(time.RFC3339, getStr(item, "updated_at"))
+	d := &models.WebhookDelivery{
+		ID:             getStr(item, "id"),
+		SubscriptionID: getStr(item, "subscription_id"),
+		Event:          getStr(item, "event"),
+		Payload:        getStr(item, "payload"),
+		Status:         getStr(item, "status"),
+		Attempts:       getInt(item, "attempts"),
+		ResponseStatus: getInt(item, "response_status"),
+		LastError:      getStr(item, "last_error"),
+		CreatedAt:      createdAt,
+		UpdatedAt:      updatedAt,
+	}
+	if v := getStr(item, "next_attempt_at"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			d.NextAttemptAt = &t
+		}
+	}
+	return d
 }
 
 // --- Time entries ---
@@ -762,8 +2467,8 @@ func (s *DynamoStore) GetConversionByID(ctx context.Context, id string) (*models
 	return itemToConversion(out.Item)
 }
 
-func (s *DynamoStore) CreateConversionRequest(ctx context.Context, cr *models.ConversionRequest) error {
-	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+func (s *DynamoStore) CreateConversionRequest(ctx context.Context, cr *models.ConversionRequest, outbox ...models.OutboxMessage) error {
+	return s.write(ctx, types.TransactWriteItem{Put: &types.Put{
 		TableName: aws.String(s.conversionTable),
 		Item: map[string]types.AttributeValue{
 			"id":                &types.AttributeValueMemberS{Value: cr.ID},
@@ -773,33 +2478,54 @@ func (s *DynamoStore) CreateConversionRequest(ctx context.Context, cr *models.Co
 			"internal_approval": &types.AttributeValueMemberS{Value: cr.InternalApproval},
 			"client_approval":   &types.AttributeValueMemberS{Value: cr.ClientApproval},
 			"proposed_by":       &types.AttributeValueMemberS{Value: cr.ProposedBy},
-			"created_at":       &types.AttributeValueMemberS{Value: timeToStr(cr.CreatedAt)},
+			"created_at":        &types.AttributeValueMemberS{Value: timeToStr(cr.CreatedAt)},
 		},
-	})
-	return err
+	}}, outbox)
 }
 
-func (s *DynamoStore) UpdateConversionRequest(ctx context.Context, id string, internalApproval, clientApproval *string) error {
-	expr := "SET "
-	attrs := map[string]types.AttributeValue{}
+// UpdateConversionRequest is conditioned on both approvals still being the
+// ones in cr, so two decisions made at the same time cannot both miss that
+// the request is now approved on each side.
+func (s *DynamoStore) UpdateConversionRequest(ctx context.Context, cr *models.ConversionRequest, internalApproval, clientApproval, category *string, outbox ...models.OutboxMessage) error {
+	var set []string
+	attrs := map[string]types.AttributeValue{
+		":old_ia": &types.AttributeValueMemberS{Value: cr.InternalApproval},
+		":old_ca": &types.AttributeValueMemberS{Value: cr.ClientApproval},
+	}
 	if internalApproval != nil {
-		expr += " internal_approval = :ia, "
+		set = append(set, "internal_approval = :ia")
 		attrs[":ia"] = &types.AttributeValueMemberS{Value: *internalApproval}
 	}
 	if clientApproval != nil {
-		expr += " client_approval = :ca, "
+		set = append(set, "client_approval = :ca")
 		attrs[":ca"] = &types.AttributeValueMemberS{Value: *clientApproval}
 	}
-	expr = strings.TrimSuffix(strings.TrimSuffix(expr, ", "), ", ")
-	if expr == "SET " {
+	if len(set) == 0 {
 		return nil
 	}
-	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+	ops := []types.TransactWriteItem{{Update: &types.Update{
 		TableName:                 aws.String(s.conversionTable),
-		Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
-		UpdateExpression:          aws.String(expr),
+		Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: cr.ID}},
+		UpdateExpression:          aws.String("SET " + strings.Join(set, ", ")),
+		ConditionExpression:       aws.String("internal_approval = :old_ia AND client_approval = :old_ca"),
 		ExpressionAttributeValues: attrs,
-	})
+	}}}
+	if category != nil {
+		ops = append(ops, types.TransactWriteItem{Update: &types.Update{
+			TableName:           aws.String(s.ticketsTable),
+			Key:                 map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: cr.TicketID}},
+			UpdateExpression:    aws.String("SET category = :cat, updated_at = :ua"),
+			ConditionExpression: aws.String("attribute_exists(id)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":cat": &types.AttributeValueMemberS{Value: *category},
+				":ua":  &types.AttributeValueMemberS{Value: timeToStr(time.Now().UTC())},
+			},
+		}})
+	}
+	err := s.writeAll(ctx, ops, outbox)
+	if canceledBy(err, 0) {
+		return ErrStale
+	}
 	return err
 }
 
@@ -847,60 +2573,273 @@ func (s *DynamoStore) ListInvoices(ctx context.Context, role, orgID string) ([]m
 	var list []models.Invoice
 	for _, item := range out.Items {
 		inv, _ := itemToInvoice(item)
-		if role == "client" && orgID != "" && inv.OrganizationID != orgID {
+		if role == policy.Client && inv.OrganizationID != orgID {
 			continue
 		}
 		list = append(list, *inv)
 	}
-	return list, nil
+	return list, nil
+}
+
+func (s *DynamoStore) GetInvoice(ctx context.Context, id string) (*models.Invoice, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.invoicesTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return itemToInvoice(out.Item)
+}
+
+func (s *DynamoStore) CreateInvoice(ctx context.Context, inv *models.Invoice) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.invoicesTable),
+		Item: map[string]types.AttributeValue{
+			"id":              &types.AttributeValueMemberS{Value: inv.ID},
+			"organization_id": &types.AttributeValueMemberS{Value: inv.OrganizationID},
+			"month":           &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", inv.Month)},
+			"year":            &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", inv.Year)},
+			"tickets_closed":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", inv.TicketsClosed)},
+			"total_hours":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", inv.TotalHours)},
+			"rate_per_hour":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", inv.RatePerHour)},
+			"total_amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", inv.TotalAmount)},
+			"status":          &types.AttributeValueMemberS{Value: inv.Status},
+			"created_at":      &types.AttributeValueMemberS{Value: timeToStr(inv.CreatedAt)},
+		},
+	})
+	return err
+}
+
+func (s *DynamoStore) UpdateInvoiceStatus(ctx context.Context, id, status string, outbox ...models.OutboxMessage) error {
+	return s.write(ctx, types.TransactWriteItem{Update: &types.Update{
+		TableName:                aws.String(s.invoicesTable),
+		Key:                      map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		UpdateExpression:         aws.String("SET #st = :status"),
+		ExpressionAttributeNames: map[string]string{"#st": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: status},
+		},
+	}}, outbox)
+}
+
+func itemToInvoice(item map[string]types.AttributeValue) (*models.Invoice, error) {
+	createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
+	return &models.Invoice{
+		ID:             getStr(item, "id"),
+		OrganizationID: getStr(item, "organization_id"),
+		Month:          getInt(item, "month"),
+		Year:           getInt(item, "year"),
+		TicketsClosed:  getInt(item, "tickets_closed"),
+		TotalHours:     getNum(item, "total_hours"),
+		RatePerHour:    getNum(item, "rate_per_hour"),
+		TotalAmount:    getNum(item, "total_amount"),
+		Status:         getStr(item, "status"),
+		CreatedAt:      createdAt,
+	}, nil
+}
+
+// --- Outbox ---
+
+// write performs op on its own, or in one transaction with the outbox
+// messages when there are any.
+func (s *DynamoStore) write(ctx context.Context, op types.TransactWriteItem, outbox []models.OutboxMessage) error {
+	if len(outbox) == 0 {
+		var err error
+		switch {
+		case op.Put != nil:
+			_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+				TableName:                 op.Put.TableName,
+				Item:                      op.Put.Item,
+				ConditionExpression:       op.Put.ConditionExpression,
+				ExpressionAttributeNames:  op.Put.ExpressionAttributeNames,
+				ExpressionAttributeValues: op.Put.ExpressionAttributeValues,
+			})
+		case op.Update != nil:
+			_, err = s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 op.Update.TableName,
+				Key:                       op.Update.Key,
+				UpdateExpression:          op.Update.UpdateExpression,
+				ConditionExpression:       op.Update.ConditionExpression,
+				ExpressionAttributeNames:  op.Update.ExpressionAttributeNames,
+				ExpressionAttributeValues: op.Update.ExpressionAttributeValues,
+			})
+		case op.Delete != nil:
+			_, err = s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName:                 op.Delete.TableName,
+				Key:                       op.Delete.Key,
+				ConditionExpression:       op.Delete.ConditionExpression,
+				ExpressionAttributeNames:  op.Delete.ExpressionAttributeNames,
+				ExpressionAttributeValues: op.Delete.ExpressionAttributeValues,
+			})
+		default:
+			err = errors.New("store: write needs a Put, Update or Delete")
+		}
+		return err
+	}
+
+	return s.writeAll(ctx, []types.TransactWriteItem{op}, outbox)
+}
+
+// writeAll performs ops and puts the outbox messages in one transaction.
+func (s *DynamoStore) writeAll(ctx context.Context, ops []types.TransactWriteItem, outbox []models.OutboxMessage) error {
+	items := append([]types.TransactWriteItem{}, ops...)
+	for i := range outbox {
+		items = append(items, types.TransactWriteItem{Put: &types.Put{
+			TableName: aws.String(s.outboxTable),
+			Item:      outboxItem(&outbox[i]),
+		}})
+	}
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	return err
+}
+
+// ListDueOutbox returns up to limit messages whose next attempt is at or before now.
+func (s *DynamoStore) ListDueOutbox(ctx context.Context, now time.Time, limit int) ([]models.OutboxMessage, error) {
+	in := &dynamodb.ScanInput{
+		TableName:        aws.String(s.outboxTable),
+		FilterExpression: aws.String("next_attempt_at <= :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberS{Value: timeToStr(now)},
+		},
+	}
+	var list []models.OutboxMessage
+	for {
+		out, err := s.client.Scan(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			list = append(list, *itemToOutbox(item))
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+	// Oldest first so events for the same ticket are dispatched in order.
+	sort.SliceStable(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// ClaimOutbox moves a message's next attempt to until, provided it is still
+// at m.NextAttemptAt; it returns ErrJobBusy if another runner claimed or
+// dispatched it first.
+func (s *DynamoStore) ClaimOutbox(ctx context.Context, m *models.OutboxMessage, until time.Time) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.outboxTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: m.ID},
+		},
+		UpdateExpression:    aws.String("SET next_attempt_at = :until"),
+		ConditionExpression: aws.String("next_attempt_at = :old"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":until": &types.AttributeValueMemberS{Value: timeToStr(until)},
+			":old":   &types.AttributeValueMemberS{Value: timeToStr(m.NextAttemptAt)},
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrJobBusy
+	}
+	return err
 }
 
-func (s *DynamoStore) CreateInvoice(ctx context.Context, inv *models.Invoice) error {
+// PutOutbox replaces a message, e.g. to record a failed dispatch attempt. A
+// message that was deleted meanwhile stays deleted.
+func (s *DynamoStore) PutOutbox(ctx context.Context, m *models.OutboxMessage) error {
 	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(s.invoicesTable),
+		TableName:           aws.String(s.outboxTable),
+		Item:                outboxItem(m),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+// MarkSent records that the notification identified by key went out; it
+// returns ErrAlreadySent if it was recorded before. Keys are kept for a week,
+// longer than an outbox message is retried.
+func (s *DynamoStore) MarkSent(ctx context.Context, key string) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.sentTable),
 		Item: map[string]types.AttributeValue{
-			"id":               &types.AttributeValueMemberS{Value: inv.ID},
-			"organization_id":  &types.AttributeValueMemberS{Value: inv.OrganizationID},
-			"month":            &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", inv.Month)},
-			"year":             &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", inv.Year)},
-			"tickets_closed":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", inv.TicketsClosed)},
-			"total_hours":      &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", inv.TotalHours)},
-			"rate_per_hour":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", inv.RatePerHour)},
-			"total_amount":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", inv.TotalAmount)},
-			"status":          &types.AttributeValueMemberS{Value: inv.Status},
-			"created_at":      &types.AttributeValueMemberS{Value: timeToStr(inv.CreatedAt)},
+			"id":  &types.AttributeValueMemberS{Value: key},
+			"ttl": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Add(7*24*time.Hour).Unix())},
 		},
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
 	})
+	if isConditionFailed(err) {
+		return ErrAlreadySent
+	}
 	return err
 }
 
-func (s *DynamoStore) UpdateInvoiceStatus(ctx context.Context, id, status string) error {
-	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(s.invoicesTable),
-		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
-		UpdateExpression: aws.String("SET #st = :status"),
-		ExpressionAttributeNames: map[string]string{"#st": "status"},
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":status": &types.AttributeValueMemberS{Value: status},
+// WasSent reports whether MarkSent recorded key.
+func (s *DynamoStore) WasSent(ctx context.Context, key string) (bool, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.sentTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return out.Item != nil, nil
+}
+
+func (s *DynamoStore) DeleteOutbox(ctx context.Context, id string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.outboxTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
 		},
 	})
 	return err
 }
 
-func itemToInvoice(item map[string]types.AttributeValue) (*models.Invoice, error) {
+func outboxItem(m *models.OutboxMessage) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"id":              &types.AttributeValueMemberS{Value: m.ID},
+		"event":           &types.AttributeValueMemberS{Value: m.Event},
+		"payload":         &types.AttributeValueMemberS{Value: m.Payload},
+		"attempts":        &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", m.Attempts)},
+		"next_attempt_at": &types.AttributeValueMemberS{Value: timeToStr(m.NextAttemptAt)},
+		// Nanosecond precision keeps messages from one write in order.
+		"created_at": &types.AttributeValueMemberS{Value: m.CreatedAt.UTC().Format(time.RFC3339Nano)},
+	}
+	if m.LastError != "" {
+		item["last_error"] = &types.AttributeValueMemberS{Value: m.LastError}
+	}
+	if len(m.Delivered) > 0 {
+		item["delivered"] = &types.AttributeValueMemberSS{Value: m.Delivered}
+	}
+	return item
+}
+
+func itemToOutbox(item map[string]types.AttributeValue) *models.OutboxMessage {
+	next, _ := time.Parse(time.RFC3339, getStr(item, "next_attempt_at"))
 	createdAt, _ := time.Parse(time.RFC3339, getStr(item, "created_at"))
-	return &models.Invoice{
-		ID:             getStr(item, "id"),
-		OrganizationID: getStr(item, "organization_id"),
-		Month:          getInt(item, "month"),
-		Year:           getInt(item, "year"),
-		TicketsClosed:  getInt(item, "tickets_closed"),
-		TotalHours:     getNum(item, "total_hours"),
-		RatePerHour:    getNum(item, "rate_per_hour"),
-		TotalAmount:    getNum(item, "total_amount"),
-		Status:         getStr(item, "status"),
-		CreatedAt:      createdAt,
-	}, nil
+	return &models.OutboxMessage{
+		ID:            getStr(item, "id"),
+		Event:         getStr(item, "event"),
+		Payload:       getStr(item, "payload"),
+		Attempts:      getInt(item, "attempts"),
+		LastError:     getStr(item, "last_error"),
+		Delivered:     getStrSet(item, "delivered"),
+		NextAttemptAt: next,
+		CreatedAt:     createdAt,
+	}
 }
 
 // --- Activities ---
@@ -931,7 +2870,7 @@ func (s *DynamoStore) CreateActivity(ctx context.Context, a *models.ActivityItem
 		"user_id":     &types.AttributeValueMemberS{Value: a.UserID},
 		"created_at":  &types.AttributeValueMemberS{Value: timeToStr(a.CreatedAt)},
 	}
-	if a.TicketID != nil {
+	if a.TicketID != nil && *a.TicketID != "" {
 		item["ticket_id"] = &types.AttributeValueMemberS{Value: *a.TicketID}
 	}
 	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
@@ -958,3 +2897,599 @@ func itemToActivity(item map[string]types.AttributeValue) (*models.ActivityItem,
 		CreatedAt:   createdAt,
 	}, nil
 }
+
+// --- Event stream ---
+
+// streamEventTTL is how long stream events are kept: long enough for every
+// instance to read them and for clients to resume after a reconnect.
+const streamEventTTL = time.Hour
+
+// streamPartition holds every stream event, sorted by sk: the creation time
+// with nanoseconds, then the event ID.
+const streamPartition = "events"
+
+// streamTime formats t with a fixed width so that keys sort by time.
+func streamTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+func (s *DynamoStore) AppendStreamEvent(ctx context.Context, e *models.StreamEvent) error {
+	item := map[string]types.AttributeValue{
+		"stream":     &types.AttributeValueMemberS{Value: streamPartition},
+		"sk":         &types.AttributeValueMemberS{Value: streamTime(e.CreatedAt) + "#" + e.ID},
+		"id":         &types.AttributeValueMemberS{Value: e.ID},
+		"type":       &types.AttributeValueMemberS{Value: e.Type},
+		"ticket_id":  &types.AttributeValueMemberS{Value: e.TicketID},
+		"data":       &types.AttributeValueMemberS{Value: e.Data},
+		"internal":   &types.AttributeValueMemberS{Value: fmt.Sprint(e.Internal)},
+		"created_at": &types.AttributeValueMemberS{Value: streamTime(e.CreatedAt)},
+		"ttl":        &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", e.CreatedAt.Add(streamEventTTL).Unix())},
+	}
+	if e.OrganizationID != "" {
+		item["organization_id"] = &types.AttributeValueMemberS{Value: e.OrganizationID}
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.streamTable),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *DynamoStore) ListStreamEvents(ctx context.Context, since time.Time) ([]models.StreamEvent, error) {
+	in := &dynamodb.QueryInput{
+		TableName:              aws.String(s.streamTable),
+		KeyConditionExpression: aws.String("#stream = :stream AND sk > :since"),
+		ExpressionAttributeNames: map[string]string{
+			"#stream": "stream",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":stream": &types.AttributeValueMemberS{Value: streamPartition},
+			// Past every key created at since.
+			":since": &types.AttributeValueMemberS{Value: streamTime(since) + "#~"},
+		},
+	}
+	var list []models.StreamEvent
+	for {
+		out, err := s.client.Query(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			created, _ := time.Parse(time.RFC3339Nano, getStr(item, "created_at"))
+			list = append(list, models.StreamEvent{
+				ID:             getStr(item, "id"),
+				Type:           getStr(item, "type"),
+				TicketID:       getStr(item, "ticket_id"),
+				OrganizationID: getStr(item, "organization_id"),
+				Internal:       getStr(item, "internal") == "true",
+				Data:           getStr(item, "data"),
+				CreatedAt:      created,
+			})
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return list, nil
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// --- Purge ---
+
+// PurgeUser permanently deletes a user with their API tokens and watches,
+// and unassigns their tickets.
+func (s *DynamoStore) PurgeUser(ctx context.Context, id string) error {
+	uid := map[string]types.AttributeValue{":uid": &types.AttributeValueMemberS{Value: id}}
+
+	tokens, err := s.queryKeys(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(s.apiTokensTable),
+		IndexName:                 aws.String("user-id-index"),
+		KeyConditionExpression:    aws.String("user_id = :uid"),
+		ExpressionAttributeValues: uid,
+	}, "id")
+	if err != nil {
+		return err
+	}
+	if err := s.batchDelete(ctx, s.apiTokensTable, tokens); err != nil {
+		return err
+	}
+
+	watches, err := s.queryKeys(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(s.watchersTable),
+		IndexName:                 aws.String("user-id-index"),
+		KeyConditionExpression:    aws.String("user_id = :uid"),
+		ExpressionAttributeValues: uid,
+	}, "ticket_id", "user_id")
+	if err != nil {
+		return err
+	}
+	if err := s.batchDelete(ctx, s.watchersTable, watches); err != nil {
+		return err
+	}
+
+	assigned, err := s.queryKeys(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(s.ticketsTable),
+		IndexName:                 aws.String("assigned-to-index"),
+		KeyConditionExpression:    aws.String("assigned_to = :uid"),
+		ExpressionAttributeValues: uid,
+	}, "id")
+	if err != nil {
+		return err
+	}
+	for _, key := range assigned {
+		_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(s.ticketsTable),
+			Key:                       key,
+			UpdateExpression:          aws.String("REMOVE assigned_to"),
+			ConditionExpression:       aws.String("assigned_to = :uid"),
+			ExpressionAttributeValues: uid,
+		})
+		if err != nil && !isConditionFailed(err) {
+			return err
+		}
+	}
+
+	return s.DeleteUser(ctx, id)
+}
+
+// Organizations are purged in steps that each delete one batch and can be
+// repeated safely, so an interrupted purge resumes (see models.PurgeJob).
+
+// ListOrgTicketIDs returns the IDs of up to limit of the organization's
+// tickets, or all of them if limit is 0.
+func (s *DynamoStore) ListOrgTicketIDs(ctx context.Context, orgID string, limit int) ([]string, error) {
+	in := &dynamodb.QueryInput{
+		TableName:              aws.String(s.ticketsTable),
+		IndexName:              aws.String("org-created-index"),
+		KeyConditionExpression: aws.String("organization_id = :org"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":org": &types.AttributeValueMemberS{Value: orgID},
+		},
+	}
+	if limit > 0 {
+		in.Limit = aws.Int32(int32(limit))
+		out, err := s.client.Query(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		return keyStrings(pickKeys(out.Items, []string{"id"}), "id"), nil
+	}
+	keys, err := s.queryKeys(ctx, in, "id")
+	if err != nil {
+		return nil, err
+	}
+	return keyStrings(keys, "id"), nil
+}
+
+// PurgeTicket permanently deletes a ticket with its messages, time entries,
+// attachment records, watchers, conversion requests and activities, deleting
+// the ticket last. Attachment content is left to the caller.
+func (s *DynamoStore) PurgeTicket(ctx context.Context, ticketID string) error {
+	tid := map[string]types.AttributeValue{":tid": &types.AttributeValueMemberS{Value: ticketID}}
+	children := []struct {
+		table, index string
+		keyNames     []string
+	}{
+		{s.messagesTable, "", []string{"ticket_id", "id"}},
+		{s.timeEntriesTable, "", []string{"ticket_id", "id"}},
+		{s.attachmentsTable, "", []string{"ticket_id", "id"}},
+		{s.watchersTable, "", []string{"ticket_id", "user_id"}},
+		{s.conversionTable, "ticket-id-index", []string{"id"}},
+		{s.activitiesTable, "ticket-id-index", []string{"id"}},
+	}
+	for _, c := range children {
+		in := &dynamodb.QueryInput{
+			TableName:                 aws.String(c.table),
+			KeyConditionExpression:    aws.String("ticket_id = :tid"),
+			ExpressionAttributeValues: tid,
+		}
+		if c.index != "" {
+			in.IndexName = aws.String(c.index)
+		}
+		keys, err := s.queryKeys(ctx, in, c.keyNames...)
+		if err != nil {
+			return err
+		}
+		if err := s.batchDelete(ctx, c.table, keys); err != nil {
+			return err
+		}
+	}
+	return s.batchDelete(ctx, s.ticketsTable, []map[string]types.AttributeValue{
+		{"id": &types.AttributeValueMemberS{Value: ticketID}},
+	})
+}
+
+// PurgeOrgInvoices deletes the organization's invoices and returns how many.
+func (s *DynamoStore) PurgeOrgInvoices(ctx context.Context, orgID string) (int, error) {
+	return s.purgeByOrgIndex(ctx, s.invoicesTable, "org-index", orgID)
+}
+
+// PurgeOrgRoles deletes the organization's custom roles and returns how many.
+func (s *DynamoStore) PurgeOrgRoles(ctx context.Context, orgID string) (int, error) {
+	return s.purgeByOrgIndex(ctx, s.rolesTable, "organization-id-index", orgID)
+}
+
+// PurgeOrgWebhooks deletes the organization's webhook subscriptions and their
+// delivery logs, whose payloads carry the organization's data. The webhooks
+// table has no organization index; it is small enough to scan.
+func (s *DynamoStore) PurgeOrgWebhooks(ctx context.Context, orgID string) (int, error) {
+	in := &dynamodb.ScanInput{
+		TableName:        aws.String(s.webhooksTable),
+		FilterExpression: aws.String("organization_id = :org"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":org": &types.AttributeValueMemberS{Value: orgID},
+		},
+	}
+	var subs []map[string]types.AttributeValue
+	for {
+		out, err := s.client.Scan(ctx, in)
+		if err != nil {
+			return 0, err
+		}
+		subs = append(subs, pickKeys(out.Items, []string{"id"})...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+	for _, sub := range subs {
+		deliveries, err := s.queryKeys(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(s.webhookDeliveriesTable),
+			KeyConditionExpression: aws.String("subscription_id = :sid"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":sid": sub["id"],
+			},
+		}, "subscription_id", "id")
+		if err != nil {
+			return 0, err
+		}
+		if err := s.batchDelete(ctx, s.webhookDeliveriesTable, deliveries); err != nil {
+			return 0, err
+		}
+	}
+	return len(subs), s.batchDelete(ctx, s.webhooksTable, subs)
+}
+
+func (s *DynamoStore) purgeByOrgIndex(ctx context.Context, table, index, orgID string) (int, error) {
+	keys, err := s.queryKeys(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		IndexName:              aws.String(index),
+		KeyConditionExpression: aws.String("organization_id = :org"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":org": &types.AttributeValueMemberS{Value: orgID},
+		},
+	}, "id")
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), s.batchDelete(ctx, table, keys)
+}
+
+// ListOrgMemberIDs returns the IDs of every user in the organization,
+// including deleted and invited ones.
+func (s *DynamoStore) ListOrgMemberIDs(ctx context.Context, orgID string) ([]string, error) {
+	keys, err := s.queryKeys(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.usersTable),
+		IndexName:              aws.String("organization-id-index"),
+		KeyConditionExpression: aws.String("organization_id = :org"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":org": &types.AttributeValueMemberS{Value: orgID},
+		},
+	}, "id")
+	if err != nil {
+		return nil, err
+	}
+	return keyStrings(keys, "id"), nil
+}
+
+// queryKeys returns the keyNames attributes of every item the query finds,
+// following pagination.
+func (s *DynamoStore) queryKeys(ctx context.Context, in *dynamodb.QueryInput, keyNames ...string) ([]map[string]types.AttributeValue, error) {
+	var keys []map[string]types.AttributeValue
+	for {
+		out, err := s.client.Query(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pickKeys(out.Items, keyNames)...)
+		if len(out.LastEvaluatedKey) == 0 {
+			return keys, nil
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// keyStrings returns the string attribute name of each key.
+func keyStrings(keys []map[string]types.AttributeValue, name string) []string {
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, getStr(key, name))
+	}
+	return ids
+}
+
+func pickKeys(items []map[string]types.AttributeValue, names []string) []map[string]types.AttributeValue {
+	keys := make([]map[string]types.AttributeValue, 0, len(items))
+	for _, item := range items {
+		key := map[string]types.AttributeValue{}
+		for _, n := range names {
+			if v, ok := item[n]; ok {
+				key[n] = v
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// batchDeleteSize is the most items a BatchWriteItem call accepts.
+const batchDeleteSize = 25
+
+// batchDelete deletes keys from table in batches, retrying unprocessed items
+// with a short backoff.
+func (s *DynamoStore) batchDelete(ctx context.Context, table string, keys []map[string]types.AttributeValue) error {
+	for len(keys) > 0 {
+		n := min(len(keys), batchDeleteSize)
+		reqs := make([]types.WriteRequest, n)
+		for i, key := range keys[:n] {
+			reqs[i] = types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}}
+		}
+		pending := map[string][]types.WriteRequest{table: reqs}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == 5 {
+				return fmt.Errorf("store: %d deletes from %s left unprocessed", len(pending[table]), table)
+			}
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+				}
+			}
+			out, err := s.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = out.UnprocessedItems
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// --- Purge jobs ---
+
+// CreatePurgeJob stores a new job; it returns ErrPurgeJobExists if the
+// organization already has one.
+func (s *DynamoStore) CreatePurgeJob(ctx context.Context, j *models.PurgeJob) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.purgeJobsTable),
+		Item:                purgeJobItem(j),
+		ConditionExpression: aws.String("attribute_not_exists(organization_id)"),
+	})
+	if isConditionFailed(err) {
+		return ErrPurgeJobExists
+	}
+	return err
+}
+
+func (s *DynamoStore) GetPurgeJob(ctx context.Context, orgID string) (*models.PurgeJob, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.purgeJobsTable),
+		Key: map[string]types.AttributeValue{
+			"organization_id": &types.AttributeValueMemberS{Value: orgID},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return nil, err
+	}
+	return itemToPurgeJob(out.Item), nil
+}
+
+// ListActivePurgeJobs returns the jobs that are pending or running.
+func (s *DynamoStore) ListActivePurgeJobs(ctx context.Context) ([]models.PurgeJob, error) {
+	out, err := s.client.Scan(ctx, &dynamodb.ScanInput{
+		TableName:                aws.String(s.purgeJobsTable),
+		FilterExpression:         aws.String("#st IN (:pending, :running)"),
+		ExpressionAttributeNames: map[string]string{"#st": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: "pending"},
+			":running": &types.AttributeValueMemberS{Value: "running"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.PurgeJob
+	for _, item := range out.Items {
+		list = append(list, *itemToPurgeJob(item))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+// ClaimPurgeJob leases an active job to the caller until until; it returns
+// ErrJobBusy if another runner holds the lease or the job is finished.
+func (s *DynamoStore) ClaimPurgeJob(ctx context.Context, orgID string, now, until time.Time) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.purgeJobsTable),
+		Key: map[string]types.AttributeValue{
+			"organization_id": &types.AttributeValueMemberS{Value: orgID},
+		},
+		UpdateExpression:         aws.String("SET lease_until = :until"),
+		ConditionExpression:      aws.String("#st IN (:pending, :running) AND (attribute_not_exists(lease_until) OR lease_until <= :now)"),
+		ExpressionAttributeNames: map[string]string{"#st": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":until":   &types.AttributeValueMemberS{Value: timeToStr(until)},
+			":now":     &types.AttributeValueMemberS{Value: timeToStr(now)},
+			":pending": &types.AttributeValueMemberS{Value: "pending"},
+			":running": &types.AttributeValueMemberS{Value: "running"},
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrJobBusy
+	}
+	return err
+}
+
+// PutPurgeJob replaces a job, e.g. to record progress.
+func (s *DynamoStore) PutPurgeJob(ctx context.Context, j *models.PurgeJob) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.purgeJobsTable),
+		Item:      purgeJobItem(j),
+	})
+	return err
+}
+
+func purgeJobItem(j *models.PurgeJob) map[string]types.AttributeValue {
+	deleted := map[string]types.AttributeValue{}
+	for step, n := range j.Deleted {
+		deleted[step] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", n)}
+	}
+	item := map[string]types.AttributeValue{
+		"organization_id": &types.AttributeValueMemberS{Value: j.OrganizationID},
+		"status":          &types.AttributeValueMemberS{Value: j.Status},
+		"step":            &types.AttributeValueMemberS{Value: j.Step},
+		"deleted":         &types.AttributeValueMemberM{Value: deleted},
+		"failures":        &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", j.Failures)},
+		"lease_until":     &types.AttributeValueMemberS{Value: timeToStr(j.LeaseUntil)},
+		"created_at":      &types.AttributeValueMemberS{Value: timeToStr(j.CreatedAt)},
+		"updated_at":      &types.AttributeValueMemberS{Value: timeToStr(j.UpdatedAt)},
+	}
+	if j.LastError != "" {
+		item["last_error"] = &types.AttributeValueMemberS{Value: j.LastError}
+	}
+	if j.RequestedBy != "" {
+		item["requested_by"] = &types.AttributeValueMemberS{Value: j.RequestedBy}
+	}
+	if j.CompletedAt != nil {
+		item["completed_at"] = &types.AttributeValueMemberS{Value: timeToStr(*j.CompletedAt)}
+	}
+	return item
+}
+
+func itemToPurgeJob(item map[string]types.AttributeValue) *models.PurgeJob {
+	deleted := map[string]int{}
+	if m, ok := item["deleted"].(*types.AttributeValueMemberM); ok {
+		for step := range m.Value {
+			deleted[step] = getInt(m.Value, step)
+		}
+	}
+	leaseUntil, _ := strToTime(getStr(item, "lease_until"))
+	createdAt, _ := strToTime(getStr(item, "created_at"))
+	updatedAt, _ := strToTime(getStr(item, "updated_at"))
+	var completedAt *time.Time
+	if t, err := strToTime(getStr(item, "completed_at")); err == nil {
+		completedAt = &t
+	}
+	return &models.PurgeJob{
+		OrganizationID: getStr(item, "organization_id"),
+		Status:         getStr(item, "status"),
+		Step:           getStr(item, "step"),
+		Deleted:        deleted,
+		Failures:       getInt(item, "failures"),
+		LastError:      getStr(item, "last_error"),
+		RequestedBy:    getStr(item, "requested_by"),
+		LeaseUntil:     leaseUntil,
+		CreatedAt:      createdAt,
+		UpdatedAt:      updatedAt,
+		CompletedAt:    completedAt,
+	}
+}
+
+// --- Email locks ---
+
+// EmailConflict is an address more than one user had before addresses were
+// made unique; Others need a new address.
+type EmailConflict struct {
+	Email  string
+	Holder string
+	Others []string
+}
+
+// BackfillEmailLocks normalizes the email address of users stored before
+// addresses were unique and creates their email locks. Users whose address
+// is already locked by another user are reported rather than changed. With
+// dryRun nothing is written.
+func (s *DynamoStore) BackfillEmailLocks(ctx context.Context, dryRun bool) (int, []EmailConflict, error) {
+	in := &dynamodb.ScanInput{
+		TableName:            aws.String(s.usersTable),
+		ProjectionExpression: aws.String("id, email"),
+	}
+	holders := map[string]string{}
+	conflicts := map[string]*EmailConflict{}
+	locked := 0
+	for {
+		out, err := s.client.Scan(ctx, in)
+		if err != nil {
+			return locked, nil, err
+		}
+		for _, item := range out.Items {
+			id, email := getStr(item, "id"), getStr(item, "email")
+			norm := NormalizeEmail(email)
+			if norm == "" {
+				continue
+			}
+			if holder, ok := holders[norm]; ok {
+				if conflicts[norm] == nil {
+					conflicts[norm] = &EmailConflict{Email: norm, Holder: holder}
+				}
+				conflicts[norm].Others = append(conflicts[norm].Others, id)
+				continue
+			}
+			holders[norm] = id
+			if dryRun {
+				locked++
+				continue
+			}
+			err := s.backfillEmailLock(ctx, id, email, norm)
+			if isConditionFailed(err) {
+				// Locked by a user created since the scan started.
+				if conflicts[norm] == nil {
+					conflicts[norm] = &EmailConflict{Email: norm}
+				}
+				conflicts[norm].Others = append(conflicts[norm].Others, id)
+				continue
+			}
+			if err != nil {
+				return locked, nil, err
+			}
+			locked++
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+
+	var list []EmailConflict
+	for _, c := range conflicts {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Email < list[j].Email })
+	return locked, list, nil
+}
+
+// backfillEmailLock locks norm for the user and stores it as their address.
+func (s *DynamoStore) backfillEmailLock(ctx context.Context, id, email, norm string) error {
+	lock := s.lockEmail(norm, id)
+	lock.Put.ConditionExpression = aws.String("attribute_not_exists(email) OR user_id = :uid")
+	lock.Put.ExpressionAttributeValues = map[string]types.AttributeValue{
+		":uid": &types.AttributeValueMemberS{Value: id},
+	}
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Update: &types.Update{
+			TableName: aws.String(s.usersTable),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			},
+			UpdateExpression:    aws.String("SET email = :new"),
+			ConditionExpression: aws.String("email = :old"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":new": &types.AttributeValueMemberS{Value: norm},
+				":old": &types.AttributeValueMemberS{Value: email},
+			},
+		}},
+		lock,
+	}})
+	return err
+}
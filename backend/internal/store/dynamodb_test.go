@@ -0,0 +1,312 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/supporttickr/backend/internal/models"
+)
+
+// fakeDynamo serves the few DynamoDB operations the email locks use:
+// GetItem and TransactWriteItems with Put, Update and Delete. Transactions
+// run one at a time and evaluate the conditions the store writes: AND and OR
+// of attribute_exists, attribute_not_exists and equality.
+type fakeDynamo struct {
+	mu     sync.Mutex
+	keys   map[string]string                        // key attribute by table
+	tables map[string]map[string]map[string]attrVal // table, key value, item
+}
+
+// attrVal is an attribute value in DynamoDB's JSON form, e.g. {"S": "x"}.
+type attrVal map[string]any
+
+func (v attrVal) String() string {
+	for _, x := range v {
+		return fmt.Sprint(x)
+	}
+	return ""
+}
+
+type writeItem struct {
+	TableName                 string
+	Item                      map[string]attrVal
+	Key                       map[string]attrVal
+	ConditionExpression       string
+	UpdateExpression          string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]attrVal
+}
+
+func newFakeDynamo(keys map[string]string) *fakeDynamo {
+	f := &fakeDynamo{keys: keys, tables: map[string]map[string]map[string]attrVal{}}
+	for t := range keys {
+		f.tables[t] = map[string]map[string]attrVal{}
+	}
+	return f
+}
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	switch op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810."); op {
+	case "GetItem":
+		var in writeItem
+		json.NewDecoder(r.Body).Decode(&in)
+		item := f.tables[in.TableName][in.Key[f.keys[in.TableName]].String()]
+		json.NewEncoder(w).Encode(map[string]any{"Item": item})
+	case "TransactWriteItems":
+		var in struct {
+			TransactItems []map[string]writeItem
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		f.transact(w, in.TransactItems)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type":"com.amazon.coral.validate#ValidationException","message":"fake does not support %s"}`, op)
+	}
+}
+
+func (f *fakeDynamo) transact(w http.ResponseWriter, items []map[string]writeItem) {
+	reasons := make([]map[string]string, len(items))
+	failed := false
+	for i, m := range items {
+		reasons[i] = map[string]string{"Code": "None"}
+		for _, it := range m {
+			if it.ConditionExpression != "" && !f.holds(it, f.current(it)) {
+				reasons[i] = map[string]string{"Code": "ConditionalCheckFailed"}
+				failed = true
+			}
+		}
+	}
+	if failed {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{
+			"__type":              "com.amazonaws.dynamodb.v20120810#TransactionCanceledException",
+			"message":             "Transaction cancelled",
+			"CancellationReasons": reasons,
+		})
+		return
+	}
+	for _, m := range items {
+		for op, it := range m {
+			table, key := f.tables[it.TableName], f.keyOf(it)
+			switch op {
+			case "Put":
+				table[key] = it.Item
+			case "Delete":
+				delete(table, key)
+			case "Update":
+				table[key] = f.update(it, table[key])
+			}
+		}
+	}
+	w.Write([]byte("{}"))
+}
+
+func (f *fakeDynamo) keyOf(it writeItem) string {
+	name := f.keys[it.TableName]
+	if it.Item != nil {
+		return it.Item[name].String()
+	}
+	return it.Key[name].String()
+}
+
+func (f *fakeDynamo) current(it writeItem) map[string]attrVal {
+	return f.tables[it.TableName][f.keyOf(it)]
+}
+
+func (f *fakeDynamo) holds(it writeItem, item map[string]attrVal) bool {
+	for _, alt := range strings.Split(it.ConditionExpression, " OR ") {
+		ok := true
+		for _, c := range strings.Split(alt, " AND ") {
+			c = strings.TrimSpace(c)
+			switch {
+			case strings.HasPrefix(c, "attribute_exists("):
+				_, has := item[f.name(it, strings.TrimSuffix(strings.TrimPrefix(c, "attribute_exists("), ")"))]
+				ok = ok && has
+			case strings.HasPrefix(c, "attribute_not_exists("):
+				_, has := item[f.name(it, strings.TrimSuffix(strings.TrimPrefix(c, "attribute_not_exists("), ")"))]
+				ok = ok && !has
+			default:
+				a, v, _ := strings.Cut(c, " = ")
+				cur, has := item[f.name(it, a)]
+				ok = ok && has && cur.String() == it.ExpressionAttributeValues[v].String()
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// update applies "SET a = :v, ..." and "ADD n :v" clauses.
+func (f *fakeDynamo) update(it writeItem, item map[string]attrVal) map[string]attrVal {
+	next := map[string]attrVal{}
+	for k, v := range item {
+		next[k] = v
+	}
+	for k, v := range it.Key {
+		next[k] = v
+	}
+	expr := it.UpdateExpression
+	set, add, _ := strings.Cut(expr, " ADD ")
+	if strings.HasPrefix(expr, "ADD ") {
+		set, add = "", strings.TrimPrefix(expr, "ADD ")
+	}
+	for _, part := range strings.Split(strings.TrimPrefix(set, "SET "), ",") {
+		if a, v, ok := strings.Cut(strings.TrimSpace(part), " = "); ok {
+			next[f.name(it, a)] = it.ExpressionAttributeValues[v]
+		}
+	}
+	if a, v, ok := strings.Cut(strings.TrimSpace(add), " "); ok {
+		var cur, n int
+		fmt.Sscan(next[f.name(it, a)].String(), &cur)
+		fmt.Sscan(it.ExpressionAttributeValues[v].String(), &n)
+		next[f.name(it, a)] = attrVal{"N": fmt.Sprint(cur + n)}
+	}
+	return next
+}
+
+func (f *fakeDynamo) name(it writeItem, a string) string {
+	if n, ok := it.ExpressionAttributeNames[a]; ok {
+		return n
+	}
+	return a
+}
+
+func newEmailTest(t *testing.T) (*DynamoStore, *fakeDynamo) {
+	fake := newFakeDynamo(map[string]string{"users": "id", "user-emails": "email", "user-tokens": "token_hash"})
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	s, err := NewDynamoStore(&DynamoConfig{
+		UsersTable:      "users",
+		UserEmailsTable: "user-emails",
+		UserTokensTable: "user-tokens",
+		DynamoDBClient: func(context.Context) (*dynamodb.Client, error) {
+			return dynamodb.New(dynamodb.Options{
+				BaseEndpoint: aws.String(srv.URL),
+				Region:       "us-east-1",
+				Credentials:  aws.AnonymousCredentials{},
+			}), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, fake
+}
+
+func TestCreateUserLocksEmail(t *testing.T) {
+	s, fake := newEmailTest(t)
+	ctx := context.Background()
+
+	// Concurrent sign-ups with the same address, spelled differently.
+	const n = 8
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			email := "Jane@Acme.test"
+			if i%2 == 1 {
+				email = " jane@acme.TEST"
+			}
+			errs[i] = s.CreateUser(ctx, &models.User{ID: fmt.Sprintf("user-%d", i), Email: email, CreatedAt: time.Now()})
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrEmailTaken):
+			t.Errorf("CreateUser %d: %v, want ErrEmailTaken", i, err)
+		}
+	}
+	if created != 1 || len(fake.tables["users"]) != 1 {
+		t.Fatalf("%d creates succeeded and %d users stored, want one", created, len(fake.tables["users"]))
+	}
+	lock := fake.tables["user-emails"]["jane@acme.test"]
+	if lock == nil || fake.tables["users"][lock["user_id"].String()] == nil {
+		t.Fatalf("email lock %v does not name the created user", lock)
+	}
+}
+
+func TestChangeEmailMovesLock(t *testing.T) {
+	s, fake := newEmailTest(t)
+	ctx := context.Background()
+	for _, u := range []*models.User{
+		{ID: "user-jane", Email: "jane@acme.test", CreatedAt: time.Now()},
+		{ID: "user-joe", Email: "joe@acme.test", CreatedAt: time.Now()},
+	} {
+		if err := s.CreateUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.ChangeEmail(ctx, "user-joe", "JANE@acme.test", ""); !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("ChangeEmail to a taken address: %v, want ErrEmailTaken", err)
+	}
+	if u, _ := s.GetUser(ctx, "user-joe"); u.Email != "joe@acme.test" || u.TokenVersion != 0 {
+		t.Fatalf("user after the failed change: %s, token version %d", u.Email, u.TokenVersion)
+	}
+
+	if err := s.ChangeEmail(ctx, "user-jane", "jane@globex.test", ""); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := s.GetUser(ctx, "user-jane"); u.Email != "jane@globex.test" || u.TokenVersion != 1 {
+		t.Fatalf("user after the change: %s, token version %d, want the new address and sessions revoked", u.Email, u.TokenVersion)
+	}
+	if _, held := fake.tables["user-emails"]["jane@acme.test"]; held {
+		t.Fatal("the old address is still locked")
+	}
+
+	// The released address can be taken, once.
+	if err := s.ChangeEmail(ctx, "user-joe", "jane@acme.test", ""); err != nil {
+		t.Fatalf("ChangeEmail to the released address: %v", err)
+	}
+	if err := s.CreateUser(ctx, &models.User{ID: "user-new", Email: "jane@acme.test", CreatedAt: time.Now()}); !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("CreateUser with the address just taken: %v, want ErrEmailTaken", err)
+	}
+	if err := s.CreateUser(ctx, &models.User{ID: "user-new", Email: "joe@acme.test", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("CreateUser with the address given up: %v", err)
+	}
+}
+
+func TestChangeEmailConsumesToken(t *testing.T) {
+	s, fake := newEmailTest(t)
+	ctx := context.Background()
+	if err := s.CreateUser(ctx, &models.User{ID: "user-jane", Email: "jane@acme.test", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	fake.tables["user-tokens"]["hash-1"] = map[string]attrVal{"token_hash": {"S": "hash-1"}, "user_id": {"S": "user-jane"}}
+
+	if err := s.ChangeEmail(ctx, "user-jane", "jane@globex.test", "hash-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ChangeEmail(ctx, "user-jane", "jane@initech.test", "hash-1"); !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("ChangeEmail with a used token: %v, want ErrTokenUsed", err)
+	}
+	if u, _ := s.GetUser(ctx, "user-jane"); u.Email != "jane@globex.test" {
+		t.Fatalf("email %s after the used token, want it unchanged", u.Email)
+	}
+	if _, held := fake.tables["user-emails"]["jane@initech.test"]; held {
+		t.Fatal("a failed change locked the address")
+	}
+}
@@ -2,39 +2,228 @@ package store
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/supporttickr/backend/internal/models"
 )
 
+// ErrTokenUsed is returned when a single-use token has already been consumed.
+var ErrTokenUsed = errors.New("token already used")
+
+// ErrNotInvited is returned for users without a pending invitation, such as
+// one that was just accepted.
+var ErrNotInvited = errors.New("user has no pending invitation")
+
+// ErrNotDeleted is returned when restoring a user or organization that is not
+// soft-deleted.
+var ErrNotDeleted = errors.New("not deleted")
+
+// ErrEmailTaken is returned when another user already has the email address.
+var ErrEmailTaken = errors.New("email address already in use")
+
+// NormalizeEmail returns the form email addresses are stored and looked up
+// in, so that addresses differing only in case or surrounding space are one.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ErrPurgeJobExists is returned when an organization already has a purge job.
+var ErrPurgeJobExists = errors.New("purge job already exists")
+
+// ErrDeliveryExists is returned when a webhook delivery was already recorded.
+var ErrDeliveryExists = errors.New("webhook delivery already exists")
+
+// ErrJobBusy is returned when a job is leased to another runner or finished.
+var ErrJobBusy = errors.New("job is busy")
+
+// ErrStale is returned when a record changed since it was read.
+var ErrStale = errors.New("record changed since it was read")
+
+// ErrDuplicateEmail is returned for an inbound email whose Message-ID was
+// processed before.
+var ErrDuplicateEmail = errors.New("email already processed")
+
+// ErrAlreadySent is returned by MarkSent for a notification that went out before.
+var ErrAlreadySent = errors.New("notification already sent")
+
 // Store is the data access interface (DynamoDB).
+//
+// Write methods that take trailing outbox messages store them atomically with
+// the change (see OutboxMessage); without messages they are plain writes.
 type Store interface {
 	// Users
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
 	GetUser(ctx context.Context, id string) (*models.User, error)
 	ListUsers(ctx context.Context, role, orgID string) ([]models.UserResponse, error)
+	// Email addresses are unique and stored normalized (see NormalizeEmail);
+	// CreateUser and ChangeEmail return ErrEmailTaken for one in use,
+	// including by a deleted user that can still be restored. ChangeEmail
+	// also revokes the user's sessions; with a tokenHash it consumes that
+	// single-use token in the same transaction, returning ErrTokenUsed if it
+	// already was.
 	CreateUser(ctx context.Context, u *models.User) error
-	UpdateUser(ctx context.Context, id string, name, email, role *string, orgID *string, avatar *string) error
+	ChangeEmail(ctx context.Context, id, email, tokenHash string) error
+	UpdateUser(ctx context.Context, id string, name, role *string, orgID *string, avatar *string) error
 	UpdateMyProfile(ctx context.Context, id string, name, phone *string) error
-	UpdatePassword(ctx context.Context, id string, newHash string) error
+	// UpdatePassword also stores the previous password hashes to remember
+	// and revokes the user's sessions. With a tokenHash it consumes that
+	// single-use token in the same transaction, returning ErrTokenUsed and
+	// leaving the password alone if it already was.
+	UpdatePassword(ctx context.Context, id string, newHash string, history []string, tokenHash string) error
+	UpdateUserTeam(ctx context.Context, id, team string) error
+	SetUserCustomRole(ctx context.Context, id, roleID string) error
 	DeleteUser(ctx context.Context, id string) error
+	// Soft deletion. SoftDeleteUser also revokes the user's sessions;
+	// RestoreUser returns ErrNotDeleted if the user is not deleted.
+	SoftDeleteUser(ctx context.Context, id string, at time.Time) error
+	RestoreUser(ctx context.Context, id string) error
+	ListDeletedUsers(ctx context.Context) ([]models.User, error)
+	// PurgeUser permanently deletes the user with their API tokens and
+	// watches, and unassigns their tickets.
+	PurgeUser(ctx context.Context, id string) error
+	// Invitations. AcceptInvitation returns ErrTokenUsed if tokenHash is no
+	// longer the user's invitation link; the others ErrNotInvited once the
+	// invitation was accepted. ClearInvitation drops the invitation of a user
+	// who signed in through SSO instead; it returns ErrNotInvited if the user
+	// was deleted meanwhile.
+	RenewInvitation(ctx context.Context, id, tokenHash string, expiresAt time.Time) error
+	AcceptInvitation(ctx context.Context, id, tokenHash, name, avatar, passwordHash string) error
+	ClearInvitation(ctx context.Context, id string) error
+	RevokeInvitation(ctx context.Context, id string) error
+	// BumpTokenVersion revokes every access token and session of the user.
+	BumpTokenVersion(ctx context.Context, id string) error
+
+	// Two-factor authentication
+	SetPendingTOTP(ctx context.Context, id, secret string) error
+	// EnableTOTP promotes the pending secret; it returns ErrTokenUsed if the
+	// pending secret changed in the meantime.
+	EnableTOTP(ctx context.Context, id, secret string, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, id string) error
+	// UseTOTPStep records an accepted code's time step; it returns ErrTokenUsed
+	// unless step is later than the last one recorded.
+	UseTOTPStep(ctx context.Context, id string, step int64) error
+	// UseRecoveryCode removes a recovery code; it returns ErrTokenUsed if the
+	// user has no such code.
+	UseRecoveryCode(ctx context.Context, id, codeHash string) error
+	SetRecoveryCodes(ctx context.Context, id string, codeHashes []string) error
+
+	// Single-use user tokens (password reset links)
+	CreateUserToken(ctx context.Context, t *models.UserToken) error
+	GetUserToken(ctx context.Context, tokenHash string) (*models.UserToken, error)
+	// ConsumeUserToken marks the token used; it returns ErrTokenUsed if it already was.
+	ConsumeUserToken(ctx context.Context, tokenHash string) error
+
+	// Sessions (refresh tokens)
+	CreateSession(ctx context.Context, s *models.Session) error
+	GetSession(ctx context.Context, id string) (*models.Session, error)
+	// RotateSession replaces the session's token hash; it returns ErrTokenUsed
+	// if oldHash is no longer current or the session was revoked.
+	RotateSession(ctx context.Context, id, oldHash, newHash string) error
+	RevokeSession(ctx context.Context, id string) error
+
+	// Failed sign-ins. RecordLoginFailure counts a failure at at and returns
+	// the new count; failures more than window apart start a new count.
+	GetLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error)
+	RecordLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (*models.LoginAttempts, error)
+	LockLogin(ctx context.Context, key string, until time.Time, outbox ...models.OutboxMessage) error
+	ClearLoginAttempts(ctx context.Context, key string, outbox ...models.OutboxMessage) error
+
+	// API tokens
+	CreateAPIToken(ctx context.Context, t *models.APIToken) error
+	GetAPIToken(ctx context.Context, id string) (*models.APIToken, error)
+	ListAPITokens(ctx context.Context, userID string) ([]models.APIToken, error)
+	TouchAPIToken(ctx context.Context, id string, at time.Time) error
+	RevokeAPIToken(ctx context.Context, id string) error
 
 	// Organizations
 	ListOrgs(ctx context.Context, role, orgID string) ([]models.Organization, error)
 	GetOrg(ctx context.Context, id string) (*models.Organization, error)
 	CreateOrg(ctx context.Context, o *models.Organization) error
-	UpdateOrg(ctx context.Context, id string, name, plan, contactEmail *string) error
+	UpdateOrg(ctx context.Context, id string, name, plan, contactEmail, chatWebhookURL *string, requireTwoFactor, memberInvites *bool) error
+	// UpdateOrgSSO replaces the organization's SSO configuration; nil removes it.
+	UpdateOrgSSO(ctx context.Context, id string, sso *models.SSOConfig) error
 	DeleteOrg(ctx context.Context, id string) error
+	SoftDeleteOrg(ctx context.Context, id string, at time.Time, outbox ...models.OutboxMessage) error
+	RestoreOrg(ctx context.Context, id string) error
+	ListDeletedOrgs(ctx context.Context) ([]models.Organization, error)
+
+	// Organization purge, in batches that can be repeated safely (see
+	// models.PurgeJob). PurgeTicket deletes the ticket and what is stored
+	// under it, including its activities.
+	ListOrgTicketIDs(ctx context.Context, orgID string, limit int) ([]string, error)
+	PurgeTicket(ctx context.Context, ticketID string) error
+	PurgeOrgInvoices(ctx context.Context, orgID string) (int, error)
+	PurgeOrgRoles(ctx context.Context, orgID string) (int, error)
+	PurgeOrgWebhooks(ctx context.Context, orgID string) (int, error)
+	ListOrgMemberIDs(ctx context.Context, orgID string) ([]string, error)
+
+	// Purge jobs. CreatePurgeJob returns ErrPurgeJobExists and ClaimPurgeJob
+	// ErrJobBusy when they cannot proceed.
+	CreatePurgeJob(ctx context.Context, j *models.PurgeJob) error
+	GetPurgeJob(ctx context.Context, orgID string) (*models.PurgeJob, error)
+	ListActivePurgeJobs(ctx context.Context) ([]models.PurgeJob, error)
+	ClaimPurgeJob(ctx context.Context, orgID string, now, until time.Time) error
+	PutPurgeJob(ctx context.Context, j *models.PurgeJob) error
+
+	// Custom roles
+	ListCustomRoles(ctx context.Context, orgID string) ([]models.CustomRole, error)
+	GetCustomRole(ctx context.Context, id string) (*models.CustomRole, error)
+	PutCustomRole(ctx context.Context, role *models.CustomRole) error
+	DeleteCustomRole(ctx context.Context, id string) error
 
 	// Tickets
 	ListTickets(ctx context.Context, status, priority, category, organizationID, assignedTo, search string) ([]models.Ticket, error)
 	GetTicket(ctx context.Context, id string) (*models.Ticket, error)
-	CreateTicket(ctx context.Context, t *models.Ticket) error
-	UpdateTicket(ctx context.Context, id string, status, priority, assignedTo *string, hoursWorked *float64) error
-	UpdateTicketCategory(ctx context.Context, id, category string) error
+	CreateTicket(ctx context.Context, t *models.Ticket, outbox ...models.OutboxMessage) error
+	UpdateTicket(ctx context.Context, id string, status, priority, assignedTo *string, hoursWorked *float64, outbox ...models.OutboxMessage) error
+	// ApplyTicketChanges posts msg and sets the status, priority, assignee and
+	// tags that are not nil in one transaction. An empty assignedTo unassigns
+	// the ticket.
+	ApplyTicketChanges(ctx context.Context, id string, msg *models.Message, status, priority, assignedTo *string, tags []string, outbox ...models.OutboxMessage) error
 
 	// Messages
 	GetMessagesByTicketID(ctx context.Context, ticketID string) ([]models.Message, error)
-	AddMessage(ctx context.Context, m *models.Message) error
+	AddMessage(ctx context.Context, m *models.Message, outbox ...models.OutboxMessage) error
+
+	// Inbound email. These write like CreateTicket and AddMessage and also
+	// record the email's Message-ID, returning ErrDuplicateEmail if it was
+	// recorded before. An empty messageID is not recorded.
+	CreateTicketFromEmail(ctx context.Context, t *models.Ticket, messageID string, outbox ...models.OutboxMessage) error
+	AddMessageFromEmail(ctx context.Context, m *models.Message, messageID string, outbox ...models.OutboxMessage) error
+
+	// Attachments
+	ListAttachmentsByTicketID(ctx context.Context, ticketID string) ([]models.Attachment, error)
+	GetAttachment(ctx context.Context, ticketID, id string) (*models.Attachment, error)
+	AddAttachment(ctx context.Context, a *models.Attachment) error
+
+	// Watchers
+	ListWatchers(ctx context.Context, ticketID string) ([]models.TicketWatcher, error)
+	AddWatcher(ctx context.Context, w *models.TicketWatcher) error
+	RemoveWatcher(ctx context.Context, ticketID, userID string) error
+
+	// Macros
+	ListMacros(ctx context.Context) ([]models.Macro, error)
+	GetMacro(ctx context.Context, id string) (*models.Macro, error)
+	PutMacro(ctx context.Context, m *models.Macro) error
+	DeleteMacro(ctx context.Context, id string) error
+
+	// Webhooks
+	ListWebhooks(ctx context.Context) ([]models.WebhookSubscription, error)
+	GetWebhook(ctx context.Context, id string) (*models.WebhookSubscription, error)
+	PutWebhook(ctx context.Context, w *models.WebhookSubscription) error
+	DeleteWebhook(ctx context.Context, id string) error
+	ListWebhookDeliveries(ctx context.Context, subscriptionID string) ([]models.WebhookDelivery, error)
+	GetWebhookDelivery(ctx context.Context, subscriptionID, id string) (*models.WebhookDelivery, error)
+	ListDueWebhookDeliveries(ctx context.Context, now time.Time) ([]models.WebhookDelivery, error)
+	PutWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error
+	// CreateWebhookDelivery returns ErrDeliveryExists if d.ID is taken.
+	CreateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error
+	// ClaimWebhookDelivery moves a pending delivery's next attempt to until,
+	// provided it still has d's attempt count and next attempt time. It
+	// returns ErrJobBusy if another runner claimed or finished it first.
+	ClaimWebhookDelivery(ctx context.Context, d *models.WebhookDelivery, until time.Time) error
 
 	// Time entries
 	GetTimeEntriesByTicketID(ctx context.Context, ticketID string) ([]models.TimeEntry, error)
@@ -43,14 +232,34 @@ type Store interface {
 	// Conversion requests
 	GetConversionByTicketID(ctx context.Context, ticketID string) (*models.ConversionRequest, error)
 	GetConversionByID(ctx context.Context, id string) (*models.ConversionRequest, error)
-	CreateConversionRequest(ctx context.Context, cr *models.ConversionRequest) error
-	UpdateConversionRequest(ctx context.Context, id string, internalApproval, clientApproval *string) error
+	CreateConversionRequest(ctx context.Context, cr *models.ConversionRequest, outbox ...models.OutboxMessage) error
+	// UpdateConversionRequest records the approvals that are not nil and, with
+	// a category, sets the ticket's category in the same transaction. It
+	// returns ErrStale unless both approvals are still the ones in cr.
+	UpdateConversionRequest(ctx context.Context, cr *models.ConversionRequest, internalApproval, clientApproval, category *string, outbox ...models.OutboxMessage) error
 	ListConversionRequestsPending(ctx context.Context) ([]models.ConversionRequest, error)
 
 	// Invoices
 	ListInvoices(ctx context.Context, role, orgID string) ([]models.Invoice, error)
+	GetInvoice(ctx context.Context, id string) (*models.Invoice, error)
 	CreateInvoice(ctx context.Context, inv *models.Invoice) error
-	UpdateInvoiceStatus(ctx context.Context, id, status string) error
+	UpdateInvoiceStatus(ctx context.Context, id, status string, outbox ...models.OutboxMessage) error
+
+	// Outbox
+	ListDueOutbox(ctx context.Context, now time.Time, limit int) ([]models.OutboxMessage, error)
+	// ClaimOutbox returns ErrJobBusy if m was claimed or dispatched since it
+	// was read.
+	ClaimOutbox(ctx context.Context, m *models.OutboxMessage, until time.Time) error
+	PutOutbox(ctx context.Context, m *models.OutboxMessage) error
+	DeleteOutbox(ctx context.Context, id string) error
+	// MarkSent returns ErrAlreadySent if key was marked before.
+	MarkSent(ctx context.Context, key string) error
+	WasSent(ctx context.Context, key string) (bool, error)
+
+	// Event stream
+	AppendStreamEvent(ctx context.Context, e *models.StreamEvent) error
+	// ListStreamEvents returns the events created after since, oldest first.
+	ListStreamEvents(ctx context.Context, since time.Time) ([]models.StreamEvent, error)
 
 	// Activities
 	ListActivities(ctx context.Context, limit int) ([]models.ActivityItem, error)
@@ -0,0 +1,1239 @@
+// Package storetest provides an in-memory store.Store for tests.
+//
+// Memory keeps users with their two-factor secrets, organizations, custom
+// roles, API tokens, single-use tokens, sessions, login attempts, tickets with
+// their messages and watchers, macros, conversion requests, invoices, webhook
+// subscriptions with their deliveries, purge jobs, activities, outbox
+// messages, sent notifications, inbound Message-IDs and stream events. It has
+// no attachments and lists none. Every other method belongs to the embedded
+// store.Store, which is nil, so a test that reaches one panics and shows what
+// it still needs.
+package storetest
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+type Memory struct {
+	store.Store
+
+	mu         sync.Mutex
+	users      map[string]models.User
+	orgs       map[string]models.Organization
+	roles      map[string]models.CustomRole
+	apiTokens  map[string]models.APIToken
+	userTokens map[string]models.UserToken
+	sessions   map[string]models.Session
+	attempts   map[string]models.LoginAttempts
+	tickets    map[string]models.Ticket
+	messages   map[string][]models.Message
+	watchers   map[string][]models.TicketWatcher
+	macros     map[string]models.Macro
+	outbox     map[string]models.OutboxMessage
+	convs      map[string]models.ConversionRequest
+	invoices   map[string]models.Invoice
+	webhooks   map[string]models.WebhookSubscription
+	deliveries map[string]models.WebhookDelivery // by subscription ID and ID
+	purgeJobs  map[string]models.PurgeJob
+	activities []models.ActivityItem
+	sent       map[string]bool
+	stream     []models.StreamEvent
+}
+
+func New() *Memory {
+	return &Memory{
+		users:      map[string]models.User{},
+		orgs:       map[string]models.Organization{},
+		roles:      map[string]models.CustomRole{},
+		apiTokens:  map[string]models.APIToken{},
+		userTokens: map[string]models.UserToken{},
+		sessions:   map[string]models.Session{},
+		attempts:   map[string]models.LoginAttempts{},
+		tickets:    map[string]models.Ticket{},
+		messages:   map[string][]models.Message{},
+		watchers:   map[string][]models.TicketWatcher{},
+		macros:     map[string]models.Macro{},
+		outbox:     map[string]models.OutboxMessage{},
+		convs:      map[string]models.ConversionRequest{},
+		invoices:   map[string]models.Invoice{},
+		webhooks:   map[string]models.WebhookSubscription{},
+		deliveries: map[string]models.WebhookDelivery{},
+		purgeJobs:  map[string]models.PurgeJob{},
+		sent:       map[string]bool{},
+	}
+}
+
+// Users
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	email = store.NormalizeEmail(email)
+	for _, u := range m.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) GetUser(ctx context.Context, id string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+func (m *Memory) CreateUser(ctx context.Context, u *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u.Email = store.NormalizeEmail(u.Email)
+	for _, other := range m.users {
+		if other.Email == u.Email {
+			return store.ErrEmailTaken
+		}
+	}
+	m.users[u.ID] = *u
+	return nil
+}
+
+func (m *Memory) ListUsers(ctx context.Context, role, orgID string) ([]models.UserResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.UserResponse
+	for _, u := range m.users {
+		if u.DeletedAt != nil {
+			continue
+		}
+		if role == policy.Client && u.OrganizationID != nil && *u.OrganizationID != orgID {
+			continue
+		}
+		list = append(list, u.ToResponse())
+	}
+	return list, nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, id string, name, role *string, orgID *string, avatar *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[id]
+	if name != nil {
+		u.Name = *name
+	}
+	if role != nil {
+		u.Role = *role
+	}
+	if orgID != nil {
+		u.OrganizationID = orgID
+	}
+	if avatar != nil {
+		u.Avatar = *avatar
+	}
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) SetUserCustomRole(ctx context.Context, id, roleID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[id]
+	u.CustomRoleID = roleID
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) SoftDeleteUser(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[id]
+	u.DeletedAt = &at
+	u.TokenVersion++
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) RenewInvitation(ctx context.Context, id, tokenHash string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok || u.Invitation == nil {
+		return store.ErrNotInvited
+	}
+	inv := *u.Invitation
+	inv.TokenHash, inv.ExpiresAt = tokenHash, expiresAt
+	u.Invitation = &inv
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) AcceptInvitation(ctx context.Context, id, tokenHash, name, avatar, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok || u.DeletedAt != nil || u.Invitation == nil || u.Invitation.TokenHash != tokenHash {
+		return store.ErrTokenUsed
+	}
+	u.Name, u.Avatar, u.PasswordHash, u.Invitation = name, avatar, passwordHash, nil
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) ClearInvitation(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok || u.DeletedAt != nil {
+		return store.ErrNotInvited
+	}
+	u.Invitation = nil
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) RevokeInvitation(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[id]; !ok || u.Invitation == nil {
+		return store.ErrNotInvited
+	}
+	delete(m.users, id)
+	return nil
+}
+
+func (m *Memory) UpdatePassword(ctx context.Context, id string, newHash string, history []string, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tokenHash != "" {
+		t, ok := m.userTokens[tokenHash]
+		if !ok || t.UsedAt != nil {
+			return store.ErrTokenUsed
+		}
+		now := time.Now().UTC()
+		t.UsedAt = &now
+		m.userTokens[tokenHash] = t
+	}
+	u := m.users[id]
+	u.PasswordHash = newHash
+	u.PasswordHistory = history
+	u.PasswordChangeRequired = false
+	u.TokenVersion++
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) BumpTokenVersion(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[id]
+	u.TokenVersion++
+	m.users[id] = u
+	return nil
+}
+
+// Two-factor
+
+func (m *Memory) SetPendingTOTP(ctx context.Context, id, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[id]
+	u.TOTPPendingSecret = secret
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) EnableTOTP(ctx context.Context, id, secret string, step int64, recoveryCodeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok || u.TOTPPendingSecret != secret {
+		return store.ErrTokenUsed
+	}
+	u.TOTPSecret, u.TOTPPendingSecret = secret, ""
+	u.TOTPLastStep = step
+	u.RecoveryCodes = recoveryCodeHashes
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok || u.TOTPLastStep >= step {
+		return store.ErrTokenUsed
+	}
+	u.TOTPLastStep = step
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) UseRecoveryCode(ctx context.Context, id, codeHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[id]
+	for i, h := range u.RecoveryCodes {
+		if h == codeHash {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			m.users[id] = u
+			return nil
+		}
+	}
+	return store.ErrTokenUsed
+}
+
+// Single-use tokens and sessions
+
+func (m *Memory) CreateUserToken(ctx context.Context, t *models.UserToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.userTokens[t.TokenHash] = *t
+	return nil
+}
+
+func (m *Memory) GetUserToken(ctx context.Context, tokenHash string) (*models.UserToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.userTokens[tokenHash]
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+func (m *Memory) ConsumeUserToken(ctx context.Context, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.userTokens[tokenHash]
+	if !ok || t.UsedAt != nil {
+		return store.ErrTokenUsed
+	}
+	now := time.Now().UTC()
+	t.UsedAt = &now
+	m.userTokens[tokenHash] = t
+	return nil
+}
+
+func (m *Memory) CreateSession(ctx context.Context, s *models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = *s
+	return nil
+}
+
+func (m *Memory) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+func (m *Memory) RotateSession(ctx context.Context, id, oldHash, newHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.TokenHash != oldHash || s.RevokedAt != nil {
+		return store.ErrTokenUsed
+	}
+	s.PreviousHash, s.TokenHash = oldHash, newHash
+	s.LastUsedAt = time.Now().UTC()
+	m.sessions[id] = s
+	return nil
+}
+
+func (m *Memory) RevokeSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	s.RevokedAt = &now
+	m.sessions[id] = s
+	return nil
+}
+
+// API tokens
+
+func (m *Memory) CreateAPIToken(ctx context.Context, t *models.APIToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.apiTokens[t.ID] = *t
+	return nil
+}
+
+func (m *Memory) GetAPIToken(ctx context.Context, id string) (*models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.apiTokens[id]
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+func (m *Memory) ListAPITokens(ctx context.Context, userID string) ([]models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.APIToken
+	for _, t := range m.apiTokens {
+		if t.UserID == userID {
+			list = append(list, t)
+		}
+	}
+	return list, nil
+}
+
+func (m *Memory) TouchAPIToken(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.apiTokens[id]; ok {
+		t.LastUsedAt = &at
+		m.apiTokens[id] = t
+	}
+	return nil
+}
+
+func (m *Memory) RevokeAPIToken(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.apiTokens[id]; ok && t.RevokedAt == nil {
+		now := time.Now().UTC()
+		t.RevokedAt = &now
+		m.apiTokens[id] = t
+	}
+	return nil
+}
+
+// Organizations
+
+func (m *Memory) CreateOrg(ctx context.Context, o *models.Organization) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.orgs[o.ID] = *o
+	return nil
+}
+
+func (m *Memory) GetOrg(ctx context.Context, id string) (*models.Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orgs[id]
+	if !ok {
+		return nil, nil
+	}
+	return &o, nil
+}
+
+func (m *Memory) SoftDeleteOrg(ctx context.Context, id string, at time.Time, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := m.orgs[id]
+	o.DeletedAt = &at
+	m.orgs[id] = o
+	m.addOutbox(outbox)
+	return nil
+}
+
+func (m *Memory) RestoreOrg(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orgs[id]
+	if !ok || o.DeletedAt == nil {
+		return store.ErrNotDeleted
+	}
+	o.DeletedAt = nil
+	m.orgs[id] = o
+	return nil
+}
+
+func (m *Memory) ListOrgs(ctx context.Context, role, orgID string) ([]models.Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orgs []models.Organization
+	for _, o := range m.orgs {
+		if o.DeletedAt == nil && (role != policy.Client || o.ID == orgID) {
+			orgs = append(orgs, o)
+		}
+	}
+	return orgs, nil
+}
+
+// Custom roles
+
+func (m *Memory) ListCustomRoles(ctx context.Context, orgID string) ([]models.CustomRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var roles []models.CustomRole
+	for _, r := range m.roles {
+		if r.OrganizationID == orgID {
+			roles = append(roles, r)
+		}
+	}
+	return roles, nil
+}
+
+func (m *Memory) GetCustomRole(ctx context.Context, id string) (*models.CustomRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.roles[id]
+	if !ok {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+func (m *Memory) PutCustomRole(ctx context.Context, role *models.CustomRole) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.roles[role.ID] = *role
+	return nil
+}
+
+// Login attempts
+
+func (m *Memory) GetLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	return &a, nil
+}
+
+func (m *Memory) RecordLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (*models.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.attempts[key]
+	if !ok || a.LastFailureAt.Before(at.Add(-window)) {
+		a = models.LoginAttempts{Key: key}
+	}
+	a.Failures++
+	a.LastFailureAt = at
+	m.attempts[key] = a
+	return &a, nil
+}
+
+func (m *Memory) LockLogin(ctx context.Context, key string, until time.Time, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := m.attempts[key]
+	a.Key = key
+	a.LockedUntil = &until
+	m.attempts[key] = a
+	m.addOutbox(outbox)
+	return nil
+}
+
+func (m *Memory) ClearLoginAttempts(ctx context.Context, key string, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	m.addOutbox(outbox)
+	return nil
+}
+
+// Tickets
+
+func (m *Memory) ListTickets(ctx context.Context, status, priority, category, organizationID, assignedTo, search string) ([]models.Ticket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.Ticket
+	for _, t := range m.tickets {
+		assignee := ""
+		if t.AssignedTo != nil {
+			assignee = *t.AssignedTo
+		}
+		if (status != "" && t.Status != status) || (priority != "" && t.Priority != priority) ||
+			(category != "" && t.Category != category) || (organizationID != "" && t.OrganizationID != organizationID) ||
+			(assignedTo != "" && assignee != assignedTo) ||
+			(search != "" && !strings.Contains(strings.ToLower(t.Title+" "+t.Description), strings.ToLower(search))) {
+			continue
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+func (m *Memory) GetTicket(ctx context.Context, id string) (*models.Ticket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tickets[id]
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+func (m *Memory) CreateTicket(ctx context.Context, t *models.Ticket, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tickets[t.ID] = *t
+	m.addOutbox(outbox)
+	return nil
+}
+
+func (m *Memory) CreateTicketFromEmail(ctx context.Context, t *models.Ticket, messageID string, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.recordEmail(messageID) {
+		return store.ErrDuplicateEmail
+	}
+	m.tickets[t.ID] = *t
+	m.addOutbox(outbox)
+	return nil
+}
+
+func (m *Memory) UpdateTicket(ctx context.Context, id string, status, priority, assignedTo *string, hoursWorked *float64, outbox ...models.OutboxMessage) error {
+	if hoursWorked != nil {
+		m.mu.Lock()
+		t := m.tickets[id]
+		t.HoursWorked = *hoursWorked
+		m.tickets[id] = t
+		m.mu.Unlock()
+	}
+	return m.ApplyTicketChanges(ctx, id, nil, status, priority, assignedTo, nil, outbox...)
+}
+
+func (m *Memory) ApplyTicketChanges(ctx context.Context, id string, msg *models.Message, status, priority, assignedTo *string, tags []string, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.tickets[id]
+	if status != nil {
+		t.Status = *status
+	}
+	if priority != nil {
+		t.Priority = *priority
+	}
+	if assignedTo != nil {
+		t.AssignedTo = nil
+		if *assignedTo != "" {
+			a := *assignedTo
+			t.AssignedTo = &a
+		}
+	}
+	if tags != nil {
+		t.Tags = tags
+	}
+	t.UpdatedAt = time.Now().UTC()
+	m.tickets[id] = t
+	if msg != nil {
+		m.messages[id] = append(m.messages[id], *msg)
+	}
+	m.addOutbox(outbox)
+	return nil
+}
+
+func (m *Memory) GetMessagesByTicketID(ctx context.Context, ticketID string) ([]models.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.Message(nil), m.messages[ticketID]...), nil
+}
+
+func (m *Memory) AddMessage(ctx context.Context, msg *models.Message, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[msg.TicketID] = append(m.messages[msg.TicketID], *msg)
+	m.addOutbox(outbox)
+	return nil
+}
+
+func (m *Memory) AddMessageFromEmail(ctx context.Context, msg *models.Message, messageID string, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.recordEmail(messageID) {
+		return store.ErrDuplicateEmail
+	}
+	m.messages[msg.TicketID] = append(m.messages[msg.TicketID], *msg)
+	m.addOutbox(outbox)
+	return nil
+}
+
+// recordEmail notes an inbound Message-ID with the sent keys, as the Dynamo
+// store does, and reports false if it was seen before.
+func (m *Memory) recordEmail(messageID string) bool {
+	if messageID == "" {
+		return true
+	}
+	key := "inbound:" + messageID
+	if m.sent[key] {
+		return false
+	}
+	m.sent[key] = true
+	return true
+}
+
+func (m *Memory) ListWatchers(ctx context.Context, ticketID string) ([]models.TicketWatcher, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.TicketWatcher(nil), m.watchers[ticketID]...), nil
+}
+
+func (m *Memory) AddWatcher(ctx context.Context, w *models.TicketWatcher) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, other := range m.watchers[w.TicketID] {
+		if other.UserID == w.UserID {
+			return nil
+		}
+	}
+	m.watchers[w.TicketID] = append(m.watchers[w.TicketID], *w)
+	return nil
+}
+
+func (m *Memory) RemoveWatcher(ctx context.Context, ticketID, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchers[ticketID] = slices.DeleteFunc(m.watchers[ticketID], func(w models.TicketWatcher) bool {
+		return w.UserID == userID
+	})
+	return nil
+}
+
+// Macros
+
+func (m *Memory) ListMacros(ctx context.Context) ([]models.Macro, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.Macro
+	for _, mac := range m.macros {
+		list = append(list, mac)
+	}
+	return list, nil
+}
+
+func (m *Memory) GetMacro(ctx context.Context, id string) (*models.Macro, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mac, ok := m.macros[id]
+	if !ok {
+		return nil, nil
+	}
+	return &mac, nil
+}
+
+func (m *Memory) PutMacro(ctx context.Context, mac *models.Macro) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.macros[mac.ID] = *mac
+	return nil
+}
+
+// Conversion requests
+
+func (m *Memory) GetConversionByID(ctx context.Context, id string) (*models.ConversionRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cr, ok := m.convs[id]
+	if !ok {
+		return nil, nil
+	}
+	return &cr, nil
+}
+
+func (m *Memory) CreateConversionRequest(ctx context.Context, cr *models.ConversionRequest, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.convs[cr.ID] = *cr
+	m.addOutbox(outbox)
+	return nil
+}
+
+func (m *Memory) UpdateConversionRequest(ctx context.Context, cr *models.ConversionRequest, internalApproval, clientApproval, category *string, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.convs[cr.ID]
+	if !ok || cur.InternalApproval != cr.InternalApproval || cur.ClientApproval != cr.ClientApproval {
+		return store.ErrStale
+	}
+	if internalApproval != nil {
+		cur.InternalApproval = *internalApproval
+	}
+	if clientApproval != nil {
+		cur.ClientApproval = *clientApproval
+	}
+	m.convs[cr.ID] = cur
+	if category != nil {
+		t := m.tickets[cur.TicketID]
+		t.Category = *category
+		m.tickets[cur.TicketID] = t
+	}
+	m.addOutbox(outbox)
+	return nil
+}
+
+func (m *Memory) ListConversionRequestsPending(ctx context.Context) ([]models.ConversionRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.ConversionRequest
+	for _, cr := range m.convs {
+		if cr.InternalApproval == "pending" || cr.ClientApproval == "pending" {
+			list = append(list, cr)
+		}
+	}
+	return list, nil
+}
+
+// Invoices
+
+func (m *Memory) ListInvoices(ctx context.Context, role, orgID string) ([]models.Invoice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.Invoice
+	for _, inv := range m.invoices {
+		if role != policy.Client || inv.OrganizationID == orgID {
+			list = append(list, inv)
+		}
+	}
+	return list, nil
+}
+
+func (m *Memory) GetInvoice(ctx context.Context, id string) (*models.Invoice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, ok := m.invoices[id]
+	if !ok {
+		return nil, nil
+	}
+	return &inv, nil
+}
+
+func (m *Memory) CreateInvoice(ctx context.Context, inv *models.Invoice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.invoices[inv.ID] = *inv
+	return nil
+}
+
+func (m *Memory) UpdateInvoiceStatus(ctx context.Context, id, status string, outbox ...models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv := m.invoices[id]
+	inv.Status = status
+	m.invoices[id] = inv
+	m.addOutbox(outbox)
+	return nil
+}
+
+// Webhooks
+
+func (m *Memory) ListWebhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.WebhookSubscription
+	for _, w := range m.webhooks {
+		list = append(list, w)
+	}
+	return list, nil
+}
+
+func (m *Memory) GetWebhook(ctx context.Context, id string) (*models.WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.webhooks[id]
+	if !ok {
+		return nil, nil
+	}
+	return &w, nil
+}
+
+func (m *Memory) PutWebhook(ctx context.Context, w *models.WebhookSubscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.webhooks[w.ID] = *w
+	return nil
+}
+
+func (m *Memory) DeleteWebhook(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.webhooks, id)
+	return nil
+}
+
+func (m *Memory) ListWebhookDeliveries(ctx context.Context, subscriptionID string) ([]models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.WebhookDelivery
+	for _, d := range m.deliveries {
+		if d.SubscriptionID == subscriptionID {
+			list = append(list, cloneDelivery(d))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (m *Memory) GetWebhookDelivery(ctx context.Context, subscriptionID, id string) (*models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[subscriptionID+"/"+id]
+	if !ok {
+		return nil, nil
+	}
+	d = cloneDelivery(d)
+	return &d, nil
+}
+
+func (m *Memory) ListDueWebhookDeliveries(ctx context.Context, now time.Time) ([]models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.WebhookDelivery
+	for _, d := range m.deliveries {
+		if d.Status == "pending" && d.NextAttemptAt != nil && !d.NextAttemptAt.After(now) {
+			list = append(list, cloneDelivery(d))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (m *Memory) PutWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries[d.SubscriptionID+"/"+d.ID] = cloneDelivery(*d)
+	return nil
+}
+
+func (m *Memory) CreateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := d.SubscriptionID + "/" + d.ID
+	if _, ok := m.deliveries[key]; ok {
+		return store.ErrDeliveryExists
+	}
+	m.deliveries[key] = cloneDelivery(*d)
+	return nil
+}
+
+func (m *Memory) ClaimWebhookDelivery(ctx context.Context, d *models.WebhookDelivery, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := d.SubscriptionID + "/" + d.ID
+	cur, ok := m.deliveries[key]
+	sameNext := (cur.NextAttemptAt == nil) == (d.NextAttemptAt == nil) &&
+		(cur.NextAttemptAt == nil || cur.NextAttemptAt.Equal(*d.NextAttemptAt))
+	if !ok || cur.Status != "pending" || cur.Attempts != d.Attempts || !sameNext {
+		return store.ErrJobBusy
+	}
+	cur.NextAttemptAt = &until
+	m.deliveries[key] = cur
+	return nil
+}
+
+func cloneDelivery(d models.WebhookDelivery) models.WebhookDelivery {
+	if d.NextAttemptAt != nil {
+		next := *d.NextAttemptAt
+		d.NextAttemptAt = &next
+	}
+	return d
+}
+
+// Purge jobs
+
+func (m *Memory) CreatePurgeJob(ctx context.Context, j *models.PurgeJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.purgeJobs[j.OrganizationID]; ok {
+		return store.ErrPurgeJobExists
+	}
+	m.purgeJobs[j.OrganizationID] = clonePurgeJob(*j)
+	return nil
+}
+
+func (m *Memory) GetPurgeJob(ctx context.Context, orgID string) (*models.PurgeJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.purgeJobs[orgID]
+	if !ok {
+		return nil, nil
+	}
+	j = clonePurgeJob(j)
+	return &j, nil
+}
+
+func (m *Memory) ListActivePurgeJobs(ctx context.Context) ([]models.PurgeJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.PurgeJob
+	for _, j := range m.purgeJobs {
+		if j.Status == "pending" || j.Status == "running" {
+			list = append(list, clonePurgeJob(j))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (m *Memory) ClaimPurgeJob(ctx context.Context, orgID string, now, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.purgeJobs[orgID]
+	if !ok || (j.Status != "pending" && j.Status != "running") || j.LeaseUntil.After(now) {
+		return store.ErrJobBusy
+	}
+	j.LeaseUntil = until
+	m.purgeJobs[orgID] = j
+	return nil
+}
+
+func (m *Memory) PutPurgeJob(ctx context.Context, j *models.PurgeJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purgeJobs[j.OrganizationID] = clonePurgeJob(*j)
+	return nil
+}
+
+// clonePurgeJob copies j's counts, which runners update in place.
+func clonePurgeJob(j models.PurgeJob) models.PurgeJob {
+	deleted := make(map[string]int, len(j.Deleted))
+	for k, v := range j.Deleted {
+		deleted[k] = v
+	}
+	j.Deleted = deleted
+	return j
+}
+
+// Purging organizations
+
+func (m *Memory) ListOrgTicketIDs(ctx context.Context, orgID string, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for _, t := range m.tickets {
+		if t.OrganizationID == orgID {
+			ids = append(ids, t.ID)
+		}
+	}
+	sort.Strings(ids)
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (m *Memory) ListAttachmentsByTicketID(ctx context.Context, ticketID string) ([]models.Attachment, error) {
+	return nil, nil
+}
+
+func (m *Memory) PurgeTicket(ctx context.Context, ticketID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tickets, ticketID)
+	delete(m.messages, ticketID)
+	delete(m.watchers, ticketID)
+	for id, cr := range m.convs {
+		if cr.TicketID == ticketID {
+			delete(m.convs, id)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) PurgeOrgInvoices(ctx context.Context, orgID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, inv := range m.invoices {
+		if inv.OrganizationID == orgID {
+			delete(m.invoices, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) PurgeOrgRoles(ctx context.Context, orgID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, r := range m.roles {
+		if r.OrganizationID == orgID {
+			delete(m.roles, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) PurgeOrgWebhooks(ctx context.Context, orgID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, w := range m.webhooks {
+		if w.OrganizationID == orgID {
+			delete(m.webhooks, id)
+			n++
+			for key, d := range m.deliveries {
+				if d.SubscriptionID == id {
+					delete(m.deliveries, key)
+				}
+			}
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) ListOrgMemberIDs(ctx context.Context, orgID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for _, u := range m.users {
+		if u.OrganizationID != nil && *u.OrganizationID == orgID {
+			ids = append(ids, u.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *Memory) PurgeUser(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, id)
+	for tid, list := range m.watchers {
+		m.watchers[tid] = slices.DeleteFunc(list, func(w models.TicketWatcher) bool { return w.UserID == id })
+	}
+	for tid, t := range m.tickets {
+		if t.AssignedTo != nil && *t.AssignedTo == id {
+			t.AssignedTo = nil
+			m.tickets[tid] = t
+		}
+	}
+	return nil
+}
+
+func (m *Memory) DeleteOrg(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.orgs, id)
+	return nil
+}
+
+// Event stream
+
+func (m *Memory) AppendStreamEvent(ctx context.Context, e *models.StreamEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stream = append(m.stream, *e)
+	return nil
+}
+
+func (m *Memory) ListStreamEvents(ctx context.Context, since time.Time) ([]models.StreamEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.StreamEvent
+	for _, e := range m.stream {
+		if e.CreatedAt.After(since) {
+			list = append(list, e)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+// Activities
+
+// ListActivities returns the newest activities first.
+func (m *Memory) ListActivities(ctx context.Context, limit int) ([]models.ActivityItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := slices.Clone(m.activities)
+	slices.Reverse(list)
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (m *Memory) CreateActivity(ctx context.Context, a *models.ActivityItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.activities = append(m.activities, *a)
+	return nil
+}
+
+// Outbox
+
+// ListDueOutbox returns the outbox messages written with the other changes,
+// oldest first.
+func (m *Memory) ListDueOutbox(ctx context.Context, now time.Time, limit int) ([]models.OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []models.OutboxMessage
+	for _, msg := range m.outbox {
+		if !msg.NextAttemptAt.After(now) {
+			list = append(list, msg)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (m *Memory) ClaimOutbox(ctx context.Context, msg *models.OutboxMessage, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.outbox[msg.ID]
+	if !ok || !cur.NextAttemptAt.Equal(msg.NextAttemptAt) {
+		return store.ErrJobBusy
+	}
+	cur.NextAttemptAt = until
+	m.outbox[msg.ID] = cur
+	return nil
+}
+
+func (m *Memory) PutOutbox(ctx context.Context, msg *models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.outbox[msg.ID]; ok {
+		m.outbox[msg.ID] = *msg
+	}
+	return nil
+}
+
+func (m *Memory) DeleteOutbox(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.outbox, id)
+	return nil
+}
+
+func (m *Memory) MarkSent(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sent[key] {
+		return store.ErrAlreadySent
+	}
+	m.sent[key] = true
+	return nil
+}
+
+func (m *Memory) WasSent(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sent[key], nil
+}
+
+func (m *Memory) addOutbox(msgs []models.OutboxMessage) {
+	for _, msg := range msgs {
+		m.outbox[msg.ID] = msg
+	}
+}
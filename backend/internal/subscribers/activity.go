@@ -0,0 +1,88 @@
+package subscribers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// Activity records events in the dashboard activity feed.
+type Activity struct {
+	Store store.Store
+}
+
+func (a *Activity) Handle(ctx context.Context, e events.Event) error {
+	item := activityFor(e)
+	if item == nil {
+		return nil
+	}
+	item.ID = "act-" + uuid.NewString()[:8]
+	if id := events.ID(ctx); id != "" {
+		// Derived from the outbox message so a redelivered event overwrites
+		// its entry instead of adding a duplicate.
+		item.ID = "act-" + strings.TrimPrefix(id, "obx-")
+	}
+	item.CreatedAt = time.Now().UTC()
+	if err := a.Store.CreateActivity(ctx, item); err != nil {
+		return fmt.Errorf("record %s: %w", item.Type, err)
+	}
+	return nil
+}
+
+// activityFor maps an event to its feed entry, or nil for events that are not shown.
+func activityFor(e events.Event) *models.ActivityItem {
+	switch e := e.(type) {
+	case events.TicketCreated:
+		desc := "New ticket: " + e.Ticket.Title
+		if e.Via == "email" {
+			desc = "New ticket by email: " + e.Ticket.Title
+		}
+		return &models.ActivityItem{Type: "ticket-created", Description: desc, UserID: e.ActorID, TicketID: &e.Ticket.ID}
+	case events.StatusChanged:
+		typ := "ticket-updated"
+		if e.NewStatus == "resolved" {
+			typ = "ticket-resolved"
+		}
+		return &models.ActivityItem{
+			Type:        typ,
+			Description: fmt.Sprintf("Ticket %s status changed to %s", e.Ticket.ID, e.NewStatus),
+			UserID:      e.ActorID,
+			TicketID:    &e.Ticket.ID,
+		}
+	case events.MessageAdded:
+		desc := "New message on " + e.Ticket.ID
+		if e.Via == "email" {
+			desc = "New email reply on " + e.Ticket.ID
+		}
+		return &models.ActivityItem{Type: "message-added", Description: desc, UserID: e.Message.UserID, TicketID: &e.Ticket.ID}
+	case events.ConversionRequested:
+		return &models.ActivityItem{
+			Type:        "conversion-requested",
+			Description: fmt.Sprintf("Conversion requested: %s to %s", e.Ticket.ID, e.Request.ProposedType),
+			UserID:      e.Request.ProposedBy,
+			TicketID:    &e.Ticket.ID,
+		}
+	case events.ApprovalDecided:
+		return &models.ActivityItem{
+			Type:        "conversion-approved",
+			Description: fmt.Sprintf("%s %s conversion for %s", e.Side, e.Status, e.Request.TicketID),
+			UserID:      e.ActorID,
+			TicketID:    &e.Request.TicketID,
+		}
+	case events.AccountLocked:
+		desc := fmt.Sprintf("Sign-in locked for %s after %d failed attempts", e.Email, e.Failures)
+		if e.Email == "" {
+			desc = fmt.Sprintf("Sign-in from %s blocked after %d failed attempts", e.IP, e.Failures)
+		}
+		return &models.ActivityItem{Type: "account-locked", Description: desc, UserID: e.UserID}
+	case events.AccountUnlocked:
+		return &models.ActivityItem{Type: "account-unlocked", Description: "Sign-in unlocked for " + e.UserID, UserID: e.ActorID}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package subscribers
+
+import (
+	"context"
+
+	"github.com/supporttickr/backend/internal/chat"
+	"github.com/supporttickr/backend/internal/events"
+)
+
+// Chat posts urgent new tickets and pending approvals to the organization's
+// chat channel. Posts are best effort: failures are logged, not retried, and
+// a redelivered event is not posted twice.
+type Chat struct {
+	Notifier *chat.Notifier
+}
+
+func (c *Chat) Handle(ctx context.Context, e events.Event) error {
+	switch e := e.(type) {
+	case events.TicketCreated:
+		c.Notifier.UrgentTicket(ctx, e.Ticket)
+	case events.ConversionRequested:
+		c.Notifier.ApprovalPending(ctx, e.Ticket, e.Request)
+	}
+	return nil
+}
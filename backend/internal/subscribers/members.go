@@ -0,0 +1,49 @@
+package subscribers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// Members soft-deletes the members of a deleted organization with the
+// organization's timestamp, so that restoring the organization brings back
+// exactly those members. Members deleted before keep their own timestamp.
+// Redelivery is harmless: members that are already deleted are skipped.
+type Members struct {
+	Store store.Store
+}
+
+func (m *Members) Handle(ctx context.Context, e events.Event) error {
+	d, ok := e.(events.OrgDeleted)
+	if !ok {
+		return nil
+	}
+	o, err := m.Store.GetOrg(ctx, d.OrganizationID)
+	if err != nil {
+		return fmt.Errorf("load organization %s: %w", d.OrganizationID, err)
+	}
+	// Restored (or deleted again) since the event, or already purged.
+	if o == nil || o.DeletedAt == nil || !o.DeletedAt.Equal(d.DeletedAt) {
+		return nil
+	}
+	ids, err := m.Store.ListOrgMemberIDs(ctx, d.OrganizationID)
+	if err != nil {
+		return fmt.Errorf("list members of %s: %w", d.OrganizationID, err)
+	}
+	for _, id := range ids {
+		u, err := m.Store.GetUser(ctx, id)
+		if err != nil {
+			return fmt.Errorf("load member %s: %w", id, err)
+		}
+		if u == nil || u.DeletedAt != nil {
+			continue
+		}
+		if err := m.Store.SoftDeleteUser(ctx, id, d.DeletedAt); err != nil {
+			return fmt.Errorf("delete member %s: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package subscribers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+func TestMembersDeletedWithOrganization(t *testing.T) {
+	ctx := context.Background()
+	st := storetest.New()
+	acme, globex := "org-acme", "org-globex"
+	for _, o := range []string{acme, globex} {
+		st.CreateOrg(ctx, &models.Organization{ID: o, Name: o})
+	}
+	for _, u := range []*models.User{
+		{ID: "user-jane", Email: "jane@example.test", Role: policy.Client, OrganizationID: &acme},
+		{ID: "user-joe", Email: "joe@example.test", Role: policy.Client, OrganizationID: &acme},
+		{ID: "user-gil", Email: "gil@example.test", Role: policy.Client, OrganizationID: &globex},
+		{ID: "user-ann", Email: "ann@example.test", Role: policy.Admin},
+	} {
+		st.CreateUser(ctx, u)
+	}
+	earlier := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	st.SoftDeleteUser(ctx, "user-joe", earlier)
+
+	bus := events.NewBus()
+	bus.Subscribe("members", (&Members{Store: st}).Handle, events.NameOrgDeleted)
+	relay := &outbox.Relay{Store: st, Bus: bus}
+	drain := func() {
+		due, _ := st.ListDueOutbox(ctx, time.Now().Add(time.Hour), 0)
+		relay.Flush(ctx, due)
+	}
+	deletedAt := func(id string) *time.Time {
+		u, _ := st.GetUser(ctx, id)
+		return u.DeletedAt
+	}
+
+	at := time.Now().UTC().Truncate(time.Second)
+	for _, o := range []string{acme, globex} {
+		st.SoftDeleteOrg(ctx, o, at, outbox.Messages(events.OrgDeleted{OrganizationID: o, DeletedAt: at})...)
+	}
+	if deletedAt("user-jane") != nil {
+		t.Fatal("members were deleted before the relay ran")
+	}
+	// globex is restored before the relay gets to it.
+	st.RestoreOrg(ctx, globex)
+
+	drain()
+	if d := deletedAt("user-jane"); d == nil || !d.Equal(at) {
+		t.Errorf("jane deleted at %v, want %v with the organization", d, at)
+	}
+	if d := deletedAt("user-joe"); d == nil || !d.Equal(earlier) {
+		t.Errorf("joe deleted at %v, want the earlier %v kept", d, earlier)
+	}
+	if d := deletedAt("user-gil"); d != nil {
+		t.Errorf("member of the restored organization deleted at %v", d)
+	}
+	if d := deletedAt("user-ann"); d != nil {
+		t.Errorf("user of no organization deleted at %v", d)
+	}
+	if due, _ := st.ListDueOutbox(ctx, time.Now().Add(time.Hour), 0); len(due) != 0 {
+		t.Errorf("outbox after the cascade %+v, want it empty", due)
+	}
+}
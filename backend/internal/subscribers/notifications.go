@@ -0,0 +1,31 @@
+package subscribers
+
+import (
+	"context"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/notify"
+)
+
+// Notifications sends the emails for ticket and invoice events. A mail that
+// fails is returned so the outbox redelivers the event; the notifier records
+// each email it sent by event ID and skips those on redelivery.
+type Notifications struct {
+	Notifier *notify.Notifier
+}
+
+func (n *Notifications) Handle(ctx context.Context, e events.Event) error {
+	switch e := e.(type) {
+	case events.TicketCreated:
+		return n.Notifier.TicketCreated(ctx, e.Ticket, e.ActorID)
+	case events.MessageAdded:
+		return n.Notifier.PublicReply(ctx, e.Ticket, e.Message)
+	case events.StatusChanged:
+		return n.Notifier.StatusChanged(ctx, e.Ticket, e.OldStatus, e.NewStatus, e.ActorID)
+	case events.TicketAssigned:
+		return n.Notifier.Assigned(ctx, e.Ticket, e.AssigneeID, e.ActorID)
+	case events.InvoiceIssued:
+		return n.Notifier.InvoiceSent(ctx, e.Invoice)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package subscribers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/notify"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/policy"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+// flakyMailer fails every message to an address in down.
+type flakyMailer struct {
+	mu   sync.Mutex
+	down map[string]bool
+	sent []string
+}
+
+func (m *flakyMailer) Send(ctx context.Context, e *notify.Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down[e.To[0]] {
+		return errors.New("connection refused")
+	}
+	m.sent = append(m.sent, e.To[0])
+	return nil
+}
+
+func (m *flakyMailer) take() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent := m.sent
+	m.sent = nil
+	slices.Sort(sent)
+	return sent
+}
+
+func TestNotificationsRetryFailedMail(t *testing.T) {
+	ctx := context.Background()
+	st := storetest.New()
+	org := "org-acme"
+	for _, u := range []*models.User{
+		{ID: "user-jane", Email: "jane@acme.test", Role: policy.Client, OrganizationID: &org},
+		{ID: "user-joe", Email: "joe@acme.test", Role: policy.Client, OrganizationID: &org},
+	} {
+		st.CreateUser(ctx, u)
+	}
+	tk := &models.Ticket{ID: "tkt-1", Title: "Printer on fire", Status: "open", OrganizationID: org, CreatedBy: "user-jane"}
+	for _, id := range []string{"user-jane", "user-joe"} {
+		st.AddWatcher(ctx, &models.TicketWatcher{TicketID: tk.ID, UserID: id})
+	}
+
+	mailer := &flakyMailer{down: map[string]bool{"joe@acme.test": true}}
+	bus := events.NewBus()
+	bus.Subscribe("notifications", (&Notifications{Notifier: notify.New(st, mailer, "http://app.test", "support@acme.test")}).Handle)
+	relay := &outbox.Relay{Store: st, Bus: bus}
+
+	msgs := outbox.Messages(events.TicketCreated{Ticket: tk, ActorID: "user-jane"})
+	st.CreateTicket(ctx, tk, msgs...)
+	relay.Flush(ctx, msgs)
+	if sent := mailer.take(); !slices.Equal(sent, []string{"jane@acme.test"}) {
+		t.Fatalf("first attempt mailed %v", sent)
+	}
+	// redeliver dispatches whatever the relay kept, as Drain will once the
+	// backoff is over, and returns the messages as they were left.
+	redeliver := func() []models.OutboxMessage {
+		due, _ := st.ListDueOutbox(ctx, time.Now().Add(2*time.Hour), 0)
+		relay.Flush(ctx, due)
+		return due
+	}
+
+	// The event is kept while the mail fails, and jane is not mailed again.
+	if due := redeliver(); len(due) != 1 || due[0].Attempts != 2 || due[0].LastError == "" {
+		t.Fatalf("outbox after the failed mail %+v, want the event kept for a retry", due)
+	}
+	if sent := mailer.take(); len(sent) != 0 {
+		t.Fatalf("retry while the mail is down mailed %v", sent)
+	}
+
+	mailer.down = nil
+	redeliver()
+	if sent := mailer.take(); !slices.Equal(sent, []string{"joe@acme.test"}) {
+		t.Fatalf("retry mailed %v, want only the address that failed", sent)
+	}
+	if due := redeliver(); len(due) != 0 {
+		t.Fatalf("outbox after the retry %+v, want it empty", due)
+	}
+}
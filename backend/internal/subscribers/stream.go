@@ -0,0 +1,62 @@
+package subscribers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/realtime"
+)
+
+// Stream pushes ticket events to clients connected to GET /api/stream.
+type Stream struct {
+	Broker realtime.Broker
+}
+
+func (s *Stream) Handle(ctx context.Context, e events.Event) error {
+	m, ok := streamFor(e)
+	if !ok {
+		return nil
+	}
+	// Use the same ID as the webhook payload so clients can correlate them.
+	if id := events.ID(ctx); id != "" {
+		m.ID = "evt-" + strings.TrimPrefix(id, "obx-")
+	} else {
+		m.ID = "evt-" + uuid.NewString()[:8]
+	}
+	// A retried event keeps its ID, so clients can tell it is a repeat.
+	if err := s.Broker.Publish(ctx, m); err != nil {
+		return fmt.Errorf("publish %s: %w", m.Type, err)
+	}
+	return nil
+}
+
+func streamFor(e events.Event) (realtime.Message, bool) {
+	switch e := e.(type) {
+	case events.TicketCreated:
+		return realtime.Message{
+			Type:           realtime.TicketCreated,
+			TicketID:       e.Ticket.ID,
+			OrganizationID: e.Ticket.OrganizationID,
+			Data:           e.Ticket.ToResponse(),
+		}, true
+	case events.TicketUpdated:
+		return realtime.Message{
+			Type:           realtime.TicketUpdated,
+			TicketID:       e.After.ID,
+			OrganizationID: e.After.OrganizationID,
+			Data:           e.After.ToResponse(),
+		}, true
+	case events.MessageAdded:
+		return realtime.Message{
+			Type:           realtime.MessageAdded,
+			TicketID:       e.Ticket.ID,
+			OrganizationID: e.Ticket.OrganizationID,
+			Internal:       e.Message.IsInternal,
+			Data:           e.Message,
+		}, true
+	}
+	return realtime.Message{}, false
+}
@@ -0,0 +1,51 @@
+// Package subscribers connects the domain event bus to its side effects:
+// the activity feed, email notifications, outgoing webhooks, chat, the
+// real-time stream, metrics and the deletion of an organization's members.
+// Events reach the bus through the outbox relay.
+package subscribers
+
+import (
+	"github.com/supporttickr/backend/internal/chat"
+	"github.com/supporttickr/backend/internal/config"
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/notify"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/presence"
+	"github.com/supporttickr/backend/internal/realtime"
+	"github.com/supporttickr/backend/internal/store"
+	"github.com/supporttickr/backend/internal/webhooks"
+)
+
+// Set is an event bus with the standard subscribers attached, plus the
+// services behind them for callers that need them directly.
+type Set struct {
+	Bus      *events.Bus
+	Outbox   *outbox.Relay
+	Metrics  *events.Metrics
+	Notifier *notify.Notifier
+	Webhooks *webhooks.Dispatcher
+	Chat     *chat.Notifier
+	Broker   *realtime.StoreBroker
+	Presence *presence.Hub
+}
+
+func New(st store.Store, cfg *config.Config) *Set {
+	s := &Set{
+		Bus:      events.NewBus(),
+		Metrics:  events.NewMetrics(),
+		Notifier: notify.New(st, notify.NewMailer(cfg), cfg.AppURL(), cfg.MailFrom),
+		Webhooks: webhooks.New(st),
+		Chat:     chat.New(st, cfg.AppURL()),
+		Broker:   realtime.NewStoreBroker(st, 500),
+		Presence: presence.NewHub(),
+	}
+	s.Outbox = &outbox.Relay{Store: st, Bus: s.Bus}
+	s.Bus.Subscribe("metrics", s.Metrics.Handle)
+	s.Bus.Subscribe("activity", (&Activity{Store: st}).Handle)
+	s.Bus.Subscribe("notifications", (&Notifications{Notifier: s.Notifier}).Handle)
+	s.Bus.Subscribe("webhooks", (&Webhooks{Dispatcher: s.Webhooks}).Handle)
+	s.Bus.Subscribe("chat", (&Chat{Notifier: s.Chat}).Handle, events.NameTicketCreated, events.NameConversionRequested)
+	s.Bus.Subscribe("stream", (&Stream{Broker: s.Broker}).Handle, events.NameTicketCreated, events.NameTicketUpdated, events.NameMessageAdded)
+	s.Bus.Subscribe("members", (&Members{Store: st}).Handle, events.NameOrgDeleted)
+	return s
+}
@@ -0,0 +1,104 @@
+package subscribers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/chat"
+	"github.com/supporttickr/backend/internal/config"
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/outbox"
+	"github.com/supporttickr/backend/internal/realtime"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+func TestChatAndStream(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var posts []string
+	posted := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		p := posts
+		posts = nil
+		return p
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m chat.Message
+		json.NewDecoder(r.Body).Decode(&m)
+		mu.Lock()
+		posts = append(posts, m.Text)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	st := storetest.New()
+	st.CreateOrg(ctx, &models.Organization{ID: "org-acme", Name: "Acme", ChatWebhookURL: srv.URL})
+	set := New(st, &config.Config{})
+
+	urgent := &models.Ticket{ID: "tkt-1", Title: "Site down", Priority: "critical", Status: "open", OrganizationID: "org-acme"}
+	routine := &models.Ticket{ID: "tkt-2", Title: "New mouse", Priority: "low", Status: "open", OrganizationID: "org-acme"}
+	after := *routine
+	after.Status = "resolved"
+	msgs := outbox.Messages(
+		events.TicketCreated{Ticket: urgent, ActorID: "user-cal"},
+		events.TicketCreated{Ticket: routine, ActorID: "user-cal"},
+		events.ConversionRequested{Ticket: routine, Request: &models.ConversionRequest{ID: "cr-1", TicketID: routine.ID, ProposedType: "project"}},
+		events.MessageAdded{Ticket: routine, Message: &models.Message{ID: "msg-1", TicketID: routine.ID, Content: "Thanks", IsInternal: true}},
+		events.TicketUpdated{Before: routine, After: &after, ActorID: "user-jane"},
+	)
+	st.CreateTicket(ctx, urgent, msgs...)
+	set.Outbox.Flush(ctx, msgs)
+	if due, _ := st.ListDueOutbox(ctx, time.Now().Add(time.Hour), 0); len(due) != 0 {
+		t.Fatalf("undelivered %+v", due)
+	}
+
+	// Chat hears about urgent tickets and pending approvals only.
+	if got, want := posted(), []string{
+		"New critical priority ticket tkt-1: Site down",
+		"Approval needed: convert tkt-2 to project",
+	}; !slices.Equal(got, want) {
+		t.Errorf("posted %q, want %q", got, want)
+	}
+
+	// The stream carries ticket and message events under the outbox IDs.
+	stream, _ := st.ListStreamEvents(ctx, time.Time{})
+	var got []string
+	for _, e := range stream {
+		got = append(got, e.Type+" "+e.TicketID+" "+e.ID)
+		if e.Type == realtime.MessageAdded && !e.Internal {
+			t.Errorf("internal note streamed as public: %+v", e)
+		}
+	}
+	id := func(i int) string { return "evt-" + strings.TrimPrefix(msgs[i].ID, "obx-") }
+	want := []string{
+		realtime.TicketCreated + " tkt-1 " + id(0),
+		realtime.TicketCreated + " tkt-2 " + id(1),
+		realtime.MessageAdded + " tkt-2 " + id(3),
+		realtime.TicketUpdated + " tkt-2 " + id(4),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("streamed %q, want %q", got, want)
+	}
+
+	// An event redelivered after a crash, before its delivery was recorded, is
+	// not posted again and streams under the same ID.
+	redelivered := msgs[0]
+	redelivered.Delivered = nil
+	st.CreateTicket(ctx, urgent, redelivered)
+	set.Outbox.Flush(ctx, []models.OutboxMessage{redelivered})
+	if again := posted(); len(again) != 0 {
+		t.Errorf("redelivery posted %q again", again)
+	}
+	if again, _ := st.ListStreamEvents(ctx, time.Time{}); len(again) != len(stream)+1 || again[len(stream)].ID != stream[0].ID {
+		t.Errorf("redelivery streamed %+v, want the first event's ID again", again[len(stream):])
+	}
+}
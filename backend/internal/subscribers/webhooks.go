@@ -0,0 +1,101 @@
+package subscribers
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/supporttickr/backend/internal/events"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/webhooks"
+)
+
+// Webhooks forwards events to webhook subscriptions using the public event
+// names in webhooks.EventTypes.
+type Webhooks struct {
+	Dispatcher *webhooks.Dispatcher
+}
+
+func (w *Webhooks) Handle(ctx context.Context, e events.Event) error {
+	ev, ok := webhookFor(e)
+	if !ok {
+		return nil
+	}
+	if id := events.ID(ctx); id != "" {
+		ev.ID = "evt-" + strings.TrimPrefix(id, "obx-")
+	}
+	return w.Dispatcher.Emit(ctx, ev)
+}
+
+func webhookFor(e events.Event) (webhooks.Event, bool) {
+	switch e := e.(type) {
+	case events.TicketCreated:
+		return webhooks.Event{
+			Type:           "ticket-created",
+			OrganizationID: e.Ticket.OrganizationID,
+			Data:           map[string]any{"ticket": e.Ticket.ToResponse(), "actorId": e.ActorID},
+		}, true
+	case events.TicketUpdated:
+		typ := "ticket-updated"
+		if e.After.Status == "resolved" && e.Before.Status != "resolved" {
+			typ = "ticket-resolved"
+		}
+		return webhooks.Event{
+			Type:           typ,
+			OrganizationID: e.After.OrganizationID,
+			Data:           map[string]any{"ticket": e.After.ToResponse(), "changes": ticketChanges(e.Before, e.After), "actorId": e.ActorID},
+		}, true
+	case events.MessageAdded:
+		return webhooks.Event{
+			Type:           "message-added",
+			OrganizationID: e.Ticket.OrganizationID,
+			Internal:       e.Message.IsInternal,
+			Data:           map[string]any{"ticketId": e.Ticket.ID, "message": e.Message},
+		}, true
+	case events.ConversionRequested:
+		return webhooks.Event{
+			Type:           "conversion-requested",
+			OrganizationID: e.Ticket.OrganizationID,
+			Data:           map[string]any{"conversionRequest": e.Request},
+		}, true
+	case events.ApprovalDecided:
+		return webhooks.Event{
+			Type:           "conversion-approved",
+			OrganizationID: e.OrganizationID,
+			Data:           map[string]any{"conversionRequest": e.Request, "side": e.Side, "status": e.Status, "actorId": e.ActorID},
+		}, true
+	case events.InvoiceStatusChanged:
+		return webhooks.Event{
+			Type:           "invoice-status-changed",
+			OrganizationID: e.Invoice.OrganizationID,
+			Data:           map[string]any{"invoice": e.Invoice, "oldStatus": e.OldStatus, "newStatus": e.Invoice.Status},
+		}, true
+	}
+	return webhooks.Event{}, false
+}
+
+// ticketChanges lists the fields that differ between before and after as
+// {"field": {"from": ..., "to": ...}}.
+func ticketChanges(before, after *models.Ticket) map[string]map[string]any {
+	changes := map[string]map[string]any{}
+	if before.Status != after.Status {
+		changes["status"] = map[string]any{"from": before.Status, "to": after.Status}
+	}
+	if before.Priority != after.Priority {
+		changes["priority"] = map[string]any{"from": before.Priority, "to": after.Priority}
+	}
+	if derefStr(before.AssignedTo) != derefStr(after.AssignedTo) {
+		changes["assignedTo"] = map[string]any{"from": before.AssignedTo, "to": after.AssignedTo}
+	}
+	if !slices.Equal(before.Tags, after.Tags) {
+		changes["tags"] = map[string]any{"from": before.Tags, "to": after.Tags}
+	}
+	return changes
+}
+
+func derefStr(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,389 @@
+// Package webhooks delivers signed event payloads to subscriber URLs, with
+// retries and a per-subscription delivery log.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+// EventTypes are the events a subscription can ask for. They match the
+// ActivityItem types, plus invoice status changes.
+var EventTypes = []string{
+	"ticket-created",
+	"ticket-updated",
+	"ticket-resolved",
+	"message-added",
+	"conversion-requested",
+	"conversion-approved",
+	"invoice-status-changed",
+}
+
+// Signature headers. The signature is a hex HMAC-SHA256 over "<t>.<body>"
+// using the subscription secret, sent as "t=<unix seconds>,v1=<hex>".
+const (
+	SignatureHeader = "X-SupportFix-Signature"
+	EventHeader     = "X-SupportFix-Event"
+	DeliveryHeader  = "X-SupportFix-Delivery"
+)
+
+// backoff is the wait before each retry; a delivery is marked failed once
+// every retry has been used.
+var backoff = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+	6 * time.Hour,
+}
+
+// Event is a domain event to fan out to matching subscriptions.
+type Event struct {
+	// ID is the payload ID; one is generated if empty.
+	ID             string
+	Type           string
+	OrganizationID string
+	// Internal events (e.g. internal notes) are only sent to global subscriptions.
+	Internal bool
+	Data     any
+}
+
+// Payload is the JSON body posted to subscribers. ID is stable across
+// retries and redeliveries so receivers can deduplicate.
+type Payload struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type"`
+	OrganizationID string    `json:"organizationId,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Data           any       `json:"data"`
+}
+
+// Dispatcher records and sends webhook deliveries. A nil *Dispatcher is valid
+// and sends nothing.
+type Dispatcher struct {
+	Store store.Store
+	// Client sends deliveries. Without one, deliveries go through a client
+	// that refuses to connect to the addresses CheckURL refuses.
+	Client *http.Client
+}
+
+func New(st store.Store) *Dispatcher {
+	return &Dispatcher{Store: st, Client: publicClient}
+}
+
+// ErrForbiddenTarget is returned for webhook URLs that lead to this host, its
+// network or the cloud metadata service rather than to the internet.
+var ErrForbiddenTarget = errors.New("webhook url must not point to a loopback, link-local or private address")
+
+// CheckURL reports whether raw is an absolute http or https URL whose host
+// resolves only to public addresses. Subscriptions are checked when they are
+// saved; deliveries are checked again when they connect, since the host may
+// resolve differently by then.
+func CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("url host %s could not be resolved", u.Hostname())
+	}
+	for _, a := range addrs {
+		if forbidden(a) {
+			return ErrForbiddenTarget
+		}
+	}
+	return nil
+}
+
+// forbidden reports whether a is an address webhooks may not be sent to:
+// loopback, link-local (which includes the metadata service at
+// 169.254.169.254), private, shared or unspecified.
+func forbidden(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsLoopback() || a.IsLinkLocalUnicast() || a.IsLinkLocalMulticast() ||
+		a.IsInterfaceLocalMulticast() || a.IsMulticast() || a.IsPrivate() || a.IsUnspecified() ||
+		sharedSpace.Contains(a) || thisNetwork.Contains(a)
+}
+
+var (
+	sharedSpace = netip.MustParsePrefix("100.64.0.0/10") // carrier-grade NAT
+	thisNetwork = netip.MustParsePrefix("0.0.0.0/8")
+)
+
+// publicClient sends deliveries. Its dialer checks the address each
+// connection is actually made to, after DNS resolution and on redirects, so a
+// host that resolved to a public address when the subscription was saved
+// cannot later point deliveries inside the network. It ignores proxy
+// settings, which would hide the destination from the dialer.
+var publicClient = func() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || forbidden(ap.Addr()) {
+				return ErrForbiddenTarget
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}()
+
+// Emit records a delivery, due at once, for every active subscription that
+// wants the event; RetryDue sends it. Sending from here would outlive the
+// request, and under Lambda such an attempt can resume after RetryDue has
+// already sent the delivery. Delivery IDs are derived from the event ID, so
+// emitting the same event again only records the deliveries that are
+// missing. The error reports deliveries that could not be recorded.
+func (d *Dispatcher) Emit(ctx context.Context, e Event) error {
+	if d == nil {
+		return nil
+	}
+	subs, err := d.Store.ListWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("list subscriptions: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if e.ID == "" {
+		e.ID = "evt-" + uuid.NewString()[:8]
+	}
+	body, err := json.Marshal(Payload{
+		ID:             e.ID,
+		Type:           e.Type,
+		OrganizationID: e.OrganizationID,
+		CreatedAt:      now,
+		Data:           e.Data,
+	})
+	if err != nil {
+		// Retrying cannot fix an event that does not encode.
+		log.Printf("webhooks: encode %s: %v", e.Type, err)
+		return nil
+	}
+
+	var errs []error
+	for i := range subs {
+		sub := &subs[i]
+		if !matches(sub, e) {
+			continue
+		}
+		next := now
+		dlv := &models.WebhookDelivery{
+			ID:             "whd-" + strings.TrimPrefix(e.ID, "evt-") + "-" + sub.ID,
+			SubscriptionID: sub.ID,
+			Event:          e.Type,
+			Payload:        string(body),
+			Status:         "pending",
+			NextAttemptAt:  &next,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		err := d.Store.CreateWebhookDelivery(ctx, dlv)
+		if err != nil && !errors.Is(err, store.ErrDeliveryExists) {
+			errs = append(errs, fmt.Errorf("record delivery for %s: %w", sub.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RetryDue sends pending deliveries that are due, new ones and retries, and
+// returns how many were attempted.
+func (d *Dispatcher) RetryDue(ctx context.Context) (int, error) {
+	due, err := d.Store.ListDueWebhookDeliveries(ctx, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	subs := map[string]*models.WebhookSubscription{}
+	attempted := 0
+	for i := range due {
+		dlv := &due[i]
+		// Another runner may have listed the same delivery; only the one
+		// whose claim lands sends it, and it holds the delivery until the
+		// first retry would be due.
+		until := time.Now().UTC().Add(backoff[0])
+		if err := d.Store.ClaimWebhookDelivery(ctx, dlv, until); err != nil {
+			if !errors.Is(err, store.ErrJobBusy) {
+				log.Printf("webhooks: claim delivery %s: %v", dlv.ID, err)
+			}
+			continue
+		}
+		dlv.NextAttemptAt = &until
+		attempted++
+		sub, ok := subs[dlv.SubscriptionID]
+		if !ok {
+			sub, _ = d.Store.GetWebhook(ctx, dlv.SubscriptionID)
+			subs[dlv.SubscriptionID] = sub
+		}
+		if sub == nil || !sub.Active {
+			dlv.Status = "failed"
+			dlv.LastError = "subscription deleted or disabled"
+			dlv.NextAttemptAt = nil
+			dlv.UpdatedAt = time.Now().UTC()
+			_ = d.Store.PutWebhookDelivery(ctx, dlv)
+			continue
+		}
+		d.attempt(ctx, sub, dlv)
+	}
+	return attempted, nil
+}
+
+// Run retries due deliveries until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.RetryDue(ctx); err != nil {
+			log.Printf("webhooks: retry: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Redeliver sends a logged delivery again as a new delivery with the same
+// payload, and returns it once the attempt has completed.
+func (d *Dispatcher) Redeliver(ctx context.Context, sub *models.WebhookSubscription, orig *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	now := time.Now().UTC()
+	dlv := &models.WebhookDelivery{
+		ID:             "whd-" + uuid.NewString()[:8],
+		SubscriptionID: sub.ID,
+		Event:          orig.Event,
+		Payload:        orig.Payload,
+		Status:         "pending",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := d.Store.PutWebhookDelivery(ctx, dlv); err != nil {
+		return nil, err
+	}
+	d.attempt(ctx, sub, dlv)
+	return dlv, nil
+}
+
+// attempt posts the delivery once and records the outcome and next retry.
+func (d *Dispatcher) attempt(ctx context.Context, sub *models.WebhookSubscription, dlv *models.WebhookDelivery) {
+	status, err := d.post(ctx, sub, dlv)
+
+	dlv.Attempts++
+	dlv.ResponseStatus = status
+	dlv.UpdatedAt = time.Now().UTC()
+	switch {
+	case err == nil:
+		dlv.Status = "succeeded"
+		dlv.LastError = ""
+		dlv.NextAttemptAt = nil
+	case dlv.Attempts > len(backoff):
+		dlv.Status = "failed"
+		dlv.LastError = err.Error()
+		dlv.NextAttemptAt = nil
+	default:
+		next := dlv.UpdatedAt.Add(backoff[dlv.Attempts-1])
+		dlv.Status = "pending"
+		dlv.LastError = err.Error()
+		dlv.NextAttemptAt = &next
+	}
+	if err := d.Store.PutWebhookDelivery(ctx, dlv); err != nil {
+		log.Printf("webhooks: update delivery %s: %v", dlv.ID, err)
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, sub *models.WebhookSubscription, dlv *models.WebhookDelivery) (int, error) {
+	body := []byte(dlv.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SupportFix-Webhooks/1.0")
+	req.Header.Set(EventHeader, dlv.Event)
+	req.Header.Set(DeliveryHeader, dlv.ID)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, time.Now().Unix(), body))
+
+	client := d.Client
+	if client == nil {
+		client = publicClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header value for body sent at unix time ts.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// NewSecret generates a signing secret for a new subscription.
+func NewSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// ValidEvent reports whether name is one of EventTypes.
+func ValidEvent(name string) bool {
+	for _, t := range EventTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(sub *models.WebhookSubscription, e Event) bool {
+	if !sub.Active {
+		return false
+	}
+	if sub.OrganizationID != "" && (sub.OrganizationID != e.OrganizationID || e.Internal) {
+		return false
+	}
+	if len(sub.Events) == 0 {
+		return true
+	}
+	for _, t := range sub.Events {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/store/storetest"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"evt-1"}`)
+	got := Sign("whsec_test", 1700000000, body)
+
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1700000000." + string(body)))
+	if want := "t=1700000000,v1=" + hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+	for _, other := range []string{
+		Sign("whsec_other", 1700000000, body),
+		Sign("whsec_test", 1700000001, body),
+		Sign("whsec_test", 1700000000, []byte(`{"id":"evt-2"}`)),
+	} {
+		if other == got {
+			t.Errorf("signature %s does not depend on the secret, time and body", other)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		url string
+		ok  bool
+	}{
+		{"https://93.184.215.14/hooks", true},
+		{"http://[2606:2800:21f:cb07:6820:80da:af6b:8b2c]/hooks", true},
+		{"ftp://93.184.215.14/hooks", false},
+		{"/hooks", false},
+		{"http://127.0.0.1:8080/hooks", false},
+		{"http://localhost/hooks", false},
+		{"http://[::1]/hooks", false},
+		{"http://[::ffff:127.0.0.1]/hooks", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://[fd00:ec2::254]/latest/meta-data/", false},
+		{"http://10.0.0.5/hooks", false},
+		{"http://172.16.3.4/hooks", false},
+		{"http://192.168.1.1/hooks", false},
+		{"http://100.64.0.1/hooks", false},
+		{"http://0.0.0.0/hooks", false},
+	} {
+		if err := CheckURL(ctx, tc.url); (err == nil) != tc.ok {
+			t.Errorf("CheckURL(%s) = %v, want ok %v", tc.url, err, tc.ok)
+		}
+	}
+}
+
+// newTestDispatcher returns a dispatcher whose single subscription posts to
+// srv, and that delivery's ID once an event has been emitted.
+func newTestDispatcher(t *testing.T, srv *httptest.Server) (*Dispatcher, *storetest.Memory, string) {
+	t.Helper()
+	ctx := context.Background()
+	st := storetest.New()
+	st.PutWebhook(ctx, &models.WebhookSubscription{ID: "whk-1", URL: srv.URL, Secret: "whsec_test", Active: true})
+	d := &Dispatcher{Store: st, Client: srv.Client()}
+	if err := d.Emit(ctx, Event{ID: "evt-1", Type: "ticket-created"}); err != nil {
+		t.Fatal(err)
+	}
+	return d, st, "whd-1-whk-1"
+}
+
+func TestBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	d, st, id := newTestDispatcher(t, srv)
+	ctx := context.Background()
+	sub, _ := st.GetWebhook(ctx, "whk-1")
+
+	for i, wait := range backoff {
+		dlv, _ := st.GetWebhookDelivery(ctx, sub.ID, id)
+		d.attempt(ctx, sub, dlv)
+		dlv, _ = st.GetWebhookDelivery(ctx, sub.ID, id)
+		if dlv.Status != "pending" || dlv.NextAttemptAt == nil || dlv.NextAttemptAt.Sub(dlv.UpdatedAt) != wait {
+			t.Fatalf("after attempt %d: status %s, next attempt %v after the attempt, want pending in %v", i+1, dlv.Status, dlv.NextAttemptAt.Sub(dlv.UpdatedAt), wait)
+		}
+		if dlv.ResponseStatus != http.StatusServiceUnavailable || dlv.LastError == "" {
+			t.Fatalf("after attempt %d: response %d, error %q", i+1, dlv.ResponseStatus, dlv.LastError)
+		}
+	}
+
+	// The attempt after the last retry fails the delivery for good.
+	dlv, _ := st.GetWebhookDelivery(ctx, sub.ID, id)
+	d.attempt(ctx, sub, dlv)
+	dlv, _ = st.GetWebhookDelivery(ctx, sub.ID, id)
+	if dlv.Status != "failed" || dlv.NextAttemptAt != nil || dlv.Attempts != len(backoff)+1 {
+		t.Fatalf("after every retry: %+v, want it failed after %d attempts", dlv, len(backoff)+1)
+	}
+}
+
+func TestRetryDueClaim(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.Header.Get(SignatureHeader) == "" || r.Header.Get(DeliveryHeader) != "whd-1-whk-1" {
+			t.Errorf("headers %v", r.Header)
+		}
+	}))
+	defer srv.Close()
+	d, st, id := newTestDispatcher(t, srv)
+	ctx := context.Background()
+
+	// Several runners see the same due delivery; only one sends it.
+	var wg sync.WaitGroup
+	var attempted atomic.Int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := d.RetryDue(ctx)
+			if err != nil {
+				t.Error(err)
+			}
+			attempted.Add(int32(n))
+		}()
+	}
+	wg.Wait()
+	if hits.Load() != 1 || attempted.Load() != 1 {
+		t.Fatalf("sent %d times in %d attempts, want once", hits.Load(), attempted.Load())
+	}
+	if dlv, _ := st.GetWebhookDelivery(ctx, "whk-1", id); dlv.Status != "succeeded" || dlv.Attempts != 1 {
+		t.Fatalf("delivery %+v, want it succeeded", dlv)
+	}
+
+	// A runner holding a stale copy cannot claim it either.
+	stale := models.WebhookDelivery{ID: id, SubscriptionID: "whk-1", Status: "pending"}
+	if err := st.ClaimWebhookDelivery(ctx, &stale, time.Now().Add(time.Minute)); err == nil {
+		t.Fatal("claimed a delivery that was already sent")
+	}
+	if n, _ := d.RetryDue(ctx); n != 0 || hits.Load() != 1 {
+		t.Fatalf("retry after success attempted %d and sent %d times", n, hits.Load())
+	}
+}
+
+func TestDeliveryRefusesPrivateAddress(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+	d, st, id := newTestDispatcher(t, srv)
+	ctx := context.Background()
+
+	// The subscription was saved pointing elsewhere; its host now leads to
+	// loopback. The default client refuses to connect.
+	d.Client = nil
+	if n, _ := d.RetryDue(ctx); n != 1 {
+		t.Fatalf("attempted %d deliveries, want 1", n)
+	}
+	dlv, _ := st.GetWebhookDelivery(ctx, "whk-1", id)
+	if hits.Load() != 0 || dlv.Status != "pending" || !strings.Contains(dlv.LastError, ErrForbiddenTarget.Error()) {
+		t.Fatalf("delivery %+v after %d requests, want it refused", dlv, hits.Load())
+	}
+	if _, err := publicClient.Get(srv.URL); !errors.Is(err, ErrForbiddenTarget) {
+		t.Fatalf("GET %s: %v, want ErrForbiddenTarget", srv.URL, err)
+	}
+}
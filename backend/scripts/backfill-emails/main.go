@@ -0,0 +1,60 @@
+// Normalize user email addresses and create the email locks that keep them
+// unique, for users stored before addresses were made unique. Run it once
+// after deploying. Addresses shared by several users are listed; give those
+// users new addresses (PUT /api/users/{id}) and run it again.
+// Usage:
+//
+//	go run ./scripts/backfill-emails -dry-run
+//	go run ./scripts/backfill-emails
+//
+// Env (optional): USERS_TABLE and USER_EMAILS_TABLE override the default table names. AWS credentials must be configured.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/supporttickr/backend/internal/config"
+	"github.com/supporttickr/backend/internal/store"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "Only report what would change")
+	flag.Parse()
+
+	ctx := context.Background()
+	st, err := store.NewStore(ctx, config.Load())
+	if err != nil {
+		log.Fatalf("Failed to create store: %v (ensure AWS credentials and region are set)", err)
+	}
+	ds, ok := st.(*store.DynamoStore)
+	if !ok {
+		log.Fatal("Backfill needs the DynamoDB store")
+	}
+
+	locked, conflicts, err := ds.BackfillEmailLocks(ctx, *dryRun)
+	if err != nil {
+		log.Fatalf("Backfill failed: %v", err)
+	}
+	verb := "Locked"
+	if *dryRun {
+		verb = "Would lock"
+	}
+	fmt.Printf("%s %d email addresses.\n", verb, locked)
+	if len(conflicts) == 0 {
+		return
+	}
+	fmt.Printf("\n%d addresses are used by more than one user:\n", len(conflicts))
+	for _, c := range conflicts {
+		holder := c.Holder
+		if holder == "" {
+			holder = "(locked since the scan started)"
+		}
+		fmt.Printf("  %s  kept by %s, also used by %s\n", c.Email, holder, strings.Join(c.Others, ", "))
+	}
+	os.Exit(1)
+}
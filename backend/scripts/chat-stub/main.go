@@ -0,0 +1,86 @@
+// Stand-in for a Slack-compatible workspace when developing the chat integration.
+// Usage:
+//
+//	# Receive notifications: set an organization's chatWebhookUrl to http://localhost:9090/hooks/<anything>
+//	go run ./scripts/chat-stub -listen :9090
+//
+//	# Send a signed slash command to the API (CHAT_SIGNING_SECRET must match the server)
+//	go run ./scripts/chat-stub -secret my-signing-secret -command /ticket -text tkt-1a2b3c4d
+//	go run ./scripts/chat-stub -secret my-signing-secret -user lead@example.com -command /assign -text "tkt-1a2b3c4d agent@example.com"
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/supporttickr/backend/internal/chat"
+)
+
+func main() {
+	listen := flag.String("listen", "", "Address to receive incoming-webhook posts on (e.g. :9090)")
+	api := flag.String("api", "http://localhost:8080", "API base URL for slash commands")
+	secret := flag.String("secret", "", "Signing secret (CHAT_SIGNING_SECRET)")
+	command := flag.String("command", "", "Slash command to send, e.g. /ticket")
+	text := flag.String("text", "", "Slash command arguments")
+	user := flag.String("user", "stub-user", "user_name sent with the command; /assign needs an agent's email or, with CHAT_USER_DOMAIN, its local part")
+	flag.Parse()
+
+	switch {
+	case *command != "":
+		if *secret == "" {
+			log.Fatal("-secret is required to send a command")
+		}
+		sendCommand(*api, *secret, *command, *text, *user)
+	case *listen != "":
+		receive(*listen)
+	default:
+		log.Fatal("Usage: go run ./scripts/chat-stub -listen :9090 | -secret s -command /ticket -text tkt-123")
+	}
+}
+
+func receive(addr string) {
+	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var out bytes.Buffer
+		if json.Indent(&out, body, "", "  ") != nil {
+			out.Write(body)
+		}
+		fmt.Printf("--- %s %s\n%s\n", time.Now().Format(time.TimeOnly), r.URL.Path, out.String())
+		w.Write([]byte("ok"))
+	})
+	log.Printf("chat-stub: receiving webhooks on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+func sendCommand(api, secret, command, text, user string) {
+	body := []byte(url.Values{
+		"command":   {command},
+		"text":      {text},
+		"user_name": {user},
+	}.Encode())
+	ts := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, api+"/api/chat/commands", bytes.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(chat.TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(chat.SignatureHeader, chat.Sign(secret, ts, body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(resp.Body)
+	fmt.Printf("%s\n%s\n", resp.Status, out)
+}
@@ -0,0 +1,41 @@
+// Local OpenID Connect provider for trying single sign-on without a real IdP.
+// Usage:
+//
+//	go run ./scripts/mock-idp -listen :9400 -groups "lead@acme.test=acme-leads"
+//
+// Then configure an organization (PUT /api/organizations/{id}) with
+//
+//	"sso": {"issuer": "http://localhost:9400", "clientId": "supportdesk", "clientSecret": "dev-secret",
+//	        "domains": ["acme.test"], "roleClaim": "groups", "roleMapping": {"acme-leads": "support-lead"}}
+//
+// and sign in as any @acme.test address.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/supporttickr/backend/internal/sso/mockidp"
+)
+
+func main() {
+	listen := flag.String("listen", ":9400", "Address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9400", "Issuer URL (must match the organization's sso.issuer)")
+	clientID := flag.String("client-id", "supportdesk", "OAuth client ID")
+	clientSecret := flag.String("client-secret", "dev-secret", "OAuth client secret")
+	groups := flag.String("groups", "", `Groups claim per user: "email=group|group,email=group"; "*" matches everyone`)
+	flag.Parse()
+
+	idp := mockidp.New(*issuer, *clientID, *clientSecret)
+	for _, entry := range strings.Split(*groups, ",") {
+		email, list, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok {
+			idp.Groups[strings.ToLower(email)] = strings.Split(list, "|")
+		}
+	}
+
+	log.Printf("mock identity provider %s listening on %s", *issuer, *listen)
+	log.Fatal(http.ListenAndServe(*listen, idp))
+}
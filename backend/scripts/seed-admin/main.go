@@ -18,22 +18,29 @@ import (
 	"github.com/google/uuid"
 	"github.com/supporttickr/backend/internal/config"
 	"github.com/supporttickr/backend/internal/models"
+	"github.com/supporttickr/backend/internal/password"
 	"github.com/supporttickr/backend/internal/store"
 	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
 	email := flag.String("email", "", "Admin email (required)")
-	password := flag.String("password", "", "Admin password (required)")
+	pass := flag.String("password", "", "Admin password (required)")
 	name := flag.String("name", "Admin", "Display name")
 	hashOnly := flag.Bool("hash-only", false, "Only print bcrypt hash (for AWS CLI put-item)")
 	flag.Parse()
 
-	if *password == "" {
+	if *pass == "" {
 		log.Fatal("Usage: go run ./scripts/seed-admin -email admin@example.com -password your-password [-name \"Admin Name\"] [-hash-only]")
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	cfg := config.Load()
+	policy := password.Policy{MinLength: cfg.PasswordMinLength, MinClasses: cfg.PasswordMinClasses}
+	if err := policy.Check(*pass, *email); err != nil {
+		log.Fatalf("Password rejected: %v", err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*pass), bcrypt.DefaultCost)
 	if err != nil {
 		log.Fatalf("Failed to hash password: %v", err)
 	}
@@ -47,7 +54,6 @@ func main() {
 		log.Fatal("Usage: -email is required when not using -hash-only")
 	}
 
-	cfg := config.Load()
 	ctx := context.Background()
 
 	st, err := store.NewStore(ctx, cfg)
@@ -1,7 +1,9 @@
 "use client"
 
-import { useState } from "react"
+import { useEffect, useState } from "react"
 import { useStore } from "@/lib/store"
+import * as api from "@/lib/api"
+import type { InvitationInfo, User } from "@/lib/types"
 import { Headphones, AlertCircle } from "lucide-react"
 import {
   Dialog,
@@ -11,24 +13,80 @@ import {
 } from "@/components/ui/dialog"
 
 export function LoginScreen() {
-  const { loginWithCredentials, forgotPassword } = useStore()
+  const { loginWithCredentials, completeTwoFactor, finishLogin, forgotPassword } = useStore()
   const [email, setEmail] = useState("")
   const [password, setPassword] = useState("")
   const [error, setError] = useState("")
   const [loading, setLoading] = useState(false)
 
+  // Second step, when the account uses two-factor authentication.
+  const [challenge, setChallenge] = useState<api.TwoFactorChallenge | null>(null)
+  const [setup, setSetup] = useState<api.TwoFactorSetup | null>(null)
+  const [code, setCode] = useState("")
+  const [useRecovery, setUseRecovery] = useState(false)
+  const [recoveryCodes, setRecoveryCodes] = useState<{ codes: string[]; user: User } | null>(null)
+
+  // Before either, when the password was set by an administrator.
+  const [passwordChange, setPasswordChange] = useState<api.PasswordChangeChallenge | null>(null)
+  const [newPassword, setNewPassword] = useState("")
+  const [confirmPassword, setConfirmPassword] = useState("")
+
+  // Or instead of signing in, when opened from an invitation email.
+  const [invitation, setInvitation] = useState<{ token: string; info: InvitationInfo } | null>(null)
+  const [inviteName, setInviteName] = useState("")
+
   const [forgotOpen, setForgotOpen] = useState(false)
   const [forgotEmail, setForgotEmail] = useState("")
   const [forgotError, setForgotError] = useState("")
   const [forgotSuccess, setForgotSuccess] = useState(false)
   const [forgotLoading, setForgotLoading] = useState(false)
 
+  // From an invitation email: show who it is for before asking for a password.
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.search).get("invitation-token")
+    if (!token) return
+    window.history.replaceState(null, "", window.location.pathname)
+    api
+      .getInvitation(token)
+      .then((info) => {
+        setInvitation({ token, info })
+        setInviteName(info.name)
+      })
+      .catch((err) => setError(err instanceof Error ? err.message : "Invalid invitation"))
+  }, [])
+
+  // Back from single sign-on: trade the one-time code for a session.
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.search)
+    const ssoCode = params.get("sso_code")
+    const ssoError = params.get("sso_error")
+    if (!ssoCode && !ssoError) return
+    window.history.replaceState(null, "", window.location.pathname)
+    if (ssoError) {
+      setError(ssoError)
+      return
+    }
+    setLoading(true)
+    api
+      .exchangeSSOCode(ssoCode!)
+      .then((result) => finishLogin(result.user))
+      .catch((err) => setError(err instanceof Error ? err.message : "Sign-in failed"))
+      .finally(() => setLoading(false))
+  }, [finishLogin])
+
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
     setError("")
     setLoading(true)
     try {
-      await loginWithCredentials(email, password)
+      const next = await loginWithCredentials(email, password)
+      if (next && "passwordChangeRequired" in next) {
+        setPasswordChange(next)
+        setNewPassword("")
+        setConfirmPassword("")
+      } else if (next) {
+        await startTwoFactor(next)
+      }
     } catch (err) {
       setError(err instanceof Error ? err.message : "Login failed")
     } finally {
@@ -36,6 +94,79 @@ export function LoginScreen() {
     }
   }
 
+  const startTwoFactor = async (next: api.TwoFactorChallenge) => {
+    setChallenge(next)
+    setCode("")
+    if (next.setupRequired) setSetup(await api.setupTwoFactor(next.twoFactorToken))
+  }
+
+  const handleInvitationSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (!invitation) return
+    if (newPassword !== confirmPassword) {
+      setError("Passwords do not match")
+      return
+    }
+    setError("")
+    setLoading(true)
+    try {
+      const result = await api.acceptInvitation(invitation.token, inviteName, newPassword)
+      setInvitation(null)
+      if ("twoFactorRequired" in result) {
+        await startTwoFactor(result)
+      } else {
+        finishLogin(result.user)
+      }
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "Could not accept invitation")
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  const handleNewPasswordSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (!passwordChange) return
+    if (newPassword !== confirmPassword) {
+      setError("Passwords do not match")
+      return
+    }
+    setError("")
+    setLoading(true)
+    try {
+      const result = await api.setPassword(passwordChange.passwordChangeToken, newPassword)
+      setPasswordChange(null)
+      if ("twoFactorRequired" in result) {
+        await startTwoFactor(result)
+      } else {
+        finishLogin(result.user)
+      }
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "Could not set password")
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  const handleCodeSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (!challenge) return
+    setError("")
+    setLoading(true)
+    try {
+      const result = await completeTwoFactor(challenge.twoFactorToken, useRecovery ? { recoveryCode: code } : { code })
+      if (result.recoveryCodes?.length) {
+        setRecoveryCodes({ codes: result.recoveryCodes, user: result.user })
+      } else {
+        finishLogin(result.user)
+      }
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "Verification failed")
+    } finally {
+      setLoading(false)
+    }
+  }
+
   const handleForgotSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
     setForgotError("")
@@ -68,6 +199,196 @@ export function LoginScreen() {
           </p>
         </div>
 
+        {recoveryCodes ? (
+          <div className="space-y-4">
+            <p className="text-sm text-muted-foreground">
+              Two-factor authentication is on. Save these recovery codes somewhere safe: each one signs you in once if you lose your authenticator.
+            </p>
+            <div className="grid grid-cols-2 gap-2 rounded-md border border-border bg-card p-3 font-mono text-sm text-foreground">
+              {recoveryCodes.codes.map((c) => (
+                <span key={c}>{c}</span>
+              ))}
+            </div>
+            <button
+              type="button"
+              onClick={() => finishLogin(recoveryCodes.user)}
+              className="w-full rounded-md border border-primary/30 bg-primary/10 p-3 text-sm font-bold tracking-wider text-primary uppercase transition-all hover:bg-primary/20"
+            >
+              Continue
+            </button>
+          </div>
+        ) : invitation ? (
+          <form onSubmit={handleInvitationSubmit} className="space-y-4">
+            {error && (
+              <div className="flex items-center gap-2 rounded-md border border-destructive/50 bg-destructive/10 p-3 text-sm text-destructive">
+                <AlertCircle className="h-4 w-4 shrink-0" />
+                {error}
+              </div>
+            )}
+
+            <p className="text-sm text-muted-foreground">
+              You have been invited as {invitation.info.email}
+              {invitation.info.organizationName ? ` to ${invitation.info.organizationName}` : ""}. Choose your name and
+              password to finish setting up your account.
+            </p>
+
+            <div className="space-y-2">
+              <label className="text-xs font-bold tracking-wider text-muted-foreground uppercase">
+                Name
+              </label>
+              <input
+                type="text"
+                autoComplete="name"
+                value={inviteName}
+                onChange={(e) => setInviteName(e.target.value)}
+                className="w-full rounded-md border border-border bg-card p-3 text-sm text-foreground placeholder:text-muted-foreground focus:border-primary/50 focus:outline-none"
+                required
+              />
+            </div>
+
+            <div className="space-y-2">
+              <label className="text-xs font-bold tracking-wider text-muted-foreground uppercase">
+                Password
+              </label>
+              <input
+                type="password"
+                autoComplete="new-password"
+                value={newPassword}
+                onChange={(e) => setNewPassword(e.target.value)}
+                className="w-full rounded-md border border-border bg-card p-3 text-sm text-foreground placeholder:text-muted-foreground focus:border-primary/50 focus:outline-none"
+                required
+              />
+            </div>
+
+            <div className="space-y-2">
+              <label className="text-xs font-bold tracking-wider text-muted-foreground uppercase">
+                Confirm password
+              </label>
+              <input
+                type="password"
+                autoComplete="new-password"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                className="w-full rounded-md border border-border bg-card p-3 text-sm text-foreground placeholder:text-muted-foreground focus:border-primary/50 focus:outline-none"
+                required
+              />
+            </div>
+
+            <button
+              type="submit"
+              disabled={loading}
+              className="w-full rounded-md border border-primary/30 bg-primary/10 p-3 text-sm font-bold tracking-wider text-primary uppercase transition-all hover:bg-primary/20 disabled:opacity-50"
+            >
+              {loading ? "Saving..." : "Accept Invitation"}
+            </button>
+          </form>
+        ) : passwordChange ? (
+          <form onSubmit={handleNewPasswordSubmit} className="space-y-4">
+            {error && (
+              <div className="flex items-center gap-2 rounded-md border border-destructive/50 bg-destructive/10 p-3 text-sm text-destructive">
+                <AlertCircle className="h-4 w-4 shrink-0" />
+                {error}
+              </div>
+            )}
+
+            <p className="text-sm text-muted-foreground">
+              Your password was set by an administrator. Choose a new one to continue.
+            </p>
+
+            <div className="space-y-2">
+              <label className="text-xs font-bold tracking-wider text-muted-foreground uppercase">
+                New password
+              </label>
+              <input
+                type="password"
+                autoComplete="new-password"
+                value={newPassword}
+                onChange={(e) => setNewPassword(e.target.value)}
+                className="w-full rounded-md border border-border bg-card p-3 text-sm text-foreground placeholder:text-muted-foreground focus:border-primary/50 focus:outline-none"
+                required
+              />
+            </div>
+
+            <div className="space-y-2">
+              <label className="text-xs font-bold tracking-wider text-muted-foreground uppercase">
+                Confirm password
+              </label>
+              <input
+                type="password"
+                autoComplete="new-password"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                className="w-full rounded-md border border-border bg-card p-3 text-sm text-foreground placeholder:text-muted-foreground focus:border-primary/50 focus:outline-none"
+                required
+              />
+            </div>
+
+            <button
+              type="submit"
+              disabled={loading}
+              className="w-full rounded-md border border-primary/30 bg-primary/10 p-3 text-sm font-bold tracking-wider text-primary uppercase transition-all hover:bg-primary/20 disabled:opacity-50"
+            >
+              {loading ? "Saving..." : "Set Password"}
+            </button>
+          </form>
+        ) : challenge ? (
+          <form onSubmit={handleCodeSubmit} className="space-y-4">
+            {error && (
+              <div className="flex items-center gap-2 rounded-md border border-destructive/50 bg-destructive/10 p-3 text-sm text-destructive">
+                <AlertCircle className="h-4 w-4 shrink-0" />
+                {error}
+              </div>
+            )}
+
+            {setup && (
+              <div className="space-y-2 text-sm text-muted-foreground">
+                <p>Your organization requires two-factor authentication. Add this key to your authenticator app, then enter the code it shows.</p>
+                <p className="break-all rounded-md border border-border bg-card p-3 font-mono text-foreground">{setup.secret}</p>
+                <a href={setup.uri} className="text-[11px] text-primary hover:underline">
+                  Open in authenticator app
+                </a>
+              </div>
+            )}
+
+            <div className="space-y-2">
+              <div className="flex items-center justify-between">
+                <label className="text-xs font-bold tracking-wider text-muted-foreground uppercase">
+                  {useRecovery ? "Recovery code" : "Authentication code"}
+                </label>
+                {!setup && (
+                  <button
+                    type="button"
+                    onClick={() => {
+                      setUseRecovery(!useRecovery)
+                      setCode("")
+                    }}
+                    className="text-[11px] text-primary hover:underline"
+                  >
+                    {useRecovery ? "Use authenticator code" : "Use a recovery code"}
+                  </button>
+                )}
+              </div>
+              <input
+                type="text"
+                inputMode={useRecovery ? "text" : "numeric"}
+                autoComplete="one-time-code"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                placeholder={useRecovery ? "xxxxx-xxxxx" : "123456"}
+                className="w-full rounded-md border border-border bg-card p-3 text-sm text-foreground placeholder:text-muted-foreground focus:border-primary/50 focus:outline-none"
+                required
+              />
+            </div>
+
+            <button
+              type="submit"
+              disabled={loading}
+              className="w-full rounded-md border border-primary/30 bg-primary/10 p-3 text-sm font-bold tracking-wider text-primary uppercase transition-all hover:bg-primary/20 disabled:opacity-50"
+            >
+              {loading ? "Verifying..." : "Verify"}
+            </button>
+          </form>
+        ) : (
         <form onSubmit={handleSubmit} className="space-y-4">
           {error && (
             <div className="flex items-center gap-2 rounded-md border border-destructive/50 bg-destructive/10 p-3 text-sm text-destructive">
@@ -112,9 +433,8 @@ export function LoginScreen() {
               type="password"
               value={password}
               onChange={(e) => setPassword(e.target.value)}
-              placeholder="Enter password"
+              placeholder="Enter password (not needed for single sign-on)"
               className="w-full rounded-md border border-border bg-card p-3 text-sm text-foreground placeholder:text-muted-foreground focus:border-primary/50 focus:outline-none"
-              required
             />
           </div>
 
@@ -126,6 +446,7 @@ export function LoginScreen() {
             {loading ? "Signing in..." : "Sign In"}
           </button>
         </form>
+        )}
       </div>
 
       <Dialog open={forgotOpen} onOpenChange={setForgotOpen}>
@@ -16,8 +16,8 @@ interface ProfileModalProps {
 }
 
 export function ProfileModal({ open, onOpenChange }: ProfileModalProps) {
-  const { currentUser, changePassword, updateMyProfile } = useStore()
-  const [activeTab, setActiveTab] = useState<"profile" | "password">("profile")
+  const { currentUser, changePassword, updateMyProfile, requestEmailChange } = useStore()
+  const [activeTab, setActiveTab] = useState<"profile" | "email" | "password">("profile")
 
   // Profile fields
   const [name, setName] = useState(currentUser?.name ?? "")
@@ -34,6 +34,13 @@ export function ProfileModal({ open, onOpenChange }: ProfileModalProps) {
   const [passwordSuccess, setPasswordSuccess] = useState(false)
   const [passwordLoading, setPasswordLoading] = useState(false)
 
+  // Email fields
+  const [newEmail, setNewEmail] = useState("")
+  const [emailPassword, setEmailPassword] = useState("")
+  const [emailError, setEmailError] = useState("")
+  const [emailSent, setEmailSent] = useState("")
+  const [emailLoading, setEmailLoading] = useState(false)
+
   const handleProfileSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
     setProfileError("")
@@ -56,6 +63,28 @@ export function ProfileModal({ open, onOpenChange }: ProfileModalProps) {
     }
   }
 
+  const handleEmailSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setEmailError("")
+    setEmailSent("")
+    const email = newEmail.trim()
+    if (!email) {
+      setEmailError("New email is required")
+      return
+    }
+    setEmailLoading(true)
+    try {
+      await requestEmailChange(email, emailPassword)
+      setEmailSent(email)
+      setNewEmail("")
+      setEmailPassword("")
+    } catch (err) {
+      setEmailError(err instanceof Error ? err.message : "Failed to request email change")
+    } finally {
+      setEmailLoading(false)
+    }
+  }
+
   const handlePasswordSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
     setPasswordError("")
@@ -110,6 +139,17 @@ export function ProfileModal({ open, onOpenChange }: ProfileModalProps) {
           >
             Profile
           </button>
+          <button
+            type="button"
+            onClick={() => setActiveTab("email")}
+            className={`px-3 py-1.5 text-xs font-medium tracking-wider uppercase transition-colors ${
+              activeTab === "email"
+                ? "text-primary border-b-2 border-primary"
+                : "text-muted-foreground hover:text-foreground"
+            }`}
+          >
+            Change Email
+          </button>
           <button
             type="button"
             onClick={() => setActiveTab("password")}
@@ -185,6 +225,70 @@ export function ProfileModal({ open, onOpenChange }: ProfileModalProps) {
           </form>
         )}
 
+        {activeTab === "email" && (
+          <form onSubmit={handleEmailSubmit} className="space-y-4">
+            {emailError && (
+              <div className="flex items-center gap-2 rounded-md border border-destructive/50 bg-destructive/10 p-2 text-sm text-destructive">
+                <AlertCircle className="h-4 w-4 shrink-0" />
+                {emailError}
+              </div>
+            )}
+            {emailSent && (
+              <div className="rounded-md border border-primary/30 bg-primary/10 p-2 text-sm text-primary">
+                We sent a confirmation link to {emailSent}. Your email changes once you follow it.
+              </div>
+            )}
+
+            <div className="space-y-2">
+              <label className="text-xs font-bold tracking-wider text-muted-foreground uppercase">
+                Current Email
+              </label>
+              <input
+                type="email"
+                value={currentUser.email}
+                readOnly
+                className="w-full rounded-md border border-border bg-muted/50 p-3 text-sm text-muted-foreground"
+              />
+            </div>
+
+            <div className="space-y-2">
+              <label className="text-xs font-bold tracking-wider text-muted-foreground uppercase">
+                New Email
+              </label>
+              <input
+                type="email"
+                value={newEmail}
+                onChange={(e) => setNewEmail(e.target.value)}
+                placeholder="Enter new email address"
+                className="w-full rounded-md border border-border bg-card p-3 text-sm text-foreground placeholder:text-muted-foreground focus:border-primary/50 focus:outline-none"
+                required
+              />
+            </div>
+
+            <div className="space-y-2">
+              <label className="text-xs font-bold tracking-wider text-muted-foreground uppercase">
+                Current Password
+              </label>
+              <input
+                type="password"
+                value={emailPassword}
+                onChange={(e) => setEmailPassword(e.target.value)}
+                placeholder="Enter current password"
+                className="w-full rounded-md border border-border bg-card p-3 text-sm text-foreground placeholder:text-muted-foreground focus:border-primary/50 focus:outline-none"
+                required
+              />
+            </div>
+
+            <button
+              type="submit"
+              disabled={emailLoading}
+              className="w-full rounded-md border border-primary/30 bg-primary/10 p-3 text-sm font-bold tracking-wider text-primary uppercase transition-all hover:bg-primary/20 disabled:opacity-50"
+            >
+              {emailLoading ? "Sending..." : "Send Confirmation Link"}
+            </button>
+          </form>
+        )}
+
         {activeTab === "password" && (
           <form onSubmit={handlePasswordSubmit} className="space-y-4">
             {passwordError && (
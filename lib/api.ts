@@ -19,7 +19,54 @@ export function getToken(): string | null {
   return authToken
 }
 
-async function apiFetch<T>(path: string, options: RequestInit = {}): Promise<T> {
+// The refresh token renews the short-lived access token. It is rotated on
+// every use, so only the latest one is kept.
+function setRefreshToken(token: string | null) {
+  if (typeof window === "undefined") return
+  if (token) localStorage.setItem("st_refresh_token", token)
+  else localStorage.removeItem("st_refresh_token")
+}
+
+function getRefreshToken(): string | null {
+  if (typeof window === "undefined") return null
+  return localStorage.getItem("st_refresh_token")
+}
+
+let refreshing: Promise<boolean> | null = null
+
+// refreshSession exchanges the refresh token for a new access token. Concurrent
+// callers share one request: a refresh token works only once, and presenting
+// it twice revokes the session. Resolves false when the user must log in again.
+export function refreshSession(): Promise<boolean> {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = getRefreshToken()
+      if (!refreshToken) return false
+      const res = await fetch(`${API_URL}/api/auth/refresh`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ refreshToken }),
+      }).catch(() => null)
+      if (!res) return false
+      if (!res.ok) {
+        if (res.status === 401) {
+          setToken(null)
+          setRefreshToken(null)
+        }
+        return false
+      }
+      const result: LoginResult = await res.json()
+      setToken(result.token)
+      setRefreshToken(result.refreshToken)
+      return true
+    })().finally(() => {
+      refreshing = null
+    })
+  }
+  return refreshing
+}
+
+async function apiFetch<T>(path: string, options: RequestInit = {}, retry = true): Promise<T> {
   const token = getToken()
   const headers: Record<string, string> = {
     "Content-Type": "application/json",
@@ -34,6 +81,11 @@ async function apiFetch<T>(path: string, options: RequestInit = {}): Promise<T>
     headers,
   })
 
+  // An expired access token is renewed once with the refresh token.
+  if (res.status === 401 && retry && token && (await refreshSession())) {
+    return apiFetch<T>(path, options, false)
+  }
+
   if (!res.ok) {
     const err = await res.json().catch(() => ({ error: res.statusText }))
     throw new Error(err.error || `API error: ${res.status}`)
@@ -48,27 +100,146 @@ async function apiFetch<T>(path: string, options: RequestInit = {}): Promise<T>
 
 export interface LoginResult {
   token: string
+  refreshToken: string
+  expiresAt: string
   user: import("./types").User
+  // Only returned when two-factor setup completes during login.
+  recoveryCodes?: string[]
+}
+
+// Returned by login instead of a session when a second factor is needed. Pass
+// twoFactorToken to verifyTwoFactor (and first to setupTwoFactor when
+// setupRequired is set).
+export interface TwoFactorChallenge {
+  twoFactorRequired: true
+  setupRequired: boolean
+  twoFactorToken: string
+}
+
+// Returned by login when the password was set by an administrator and has to
+// be replaced first. Pass passwordChangeToken to setPassword with the new one.
+export interface PasswordChangeChallenge {
+  passwordChangeRequired: true
+  passwordChangeToken: string
+}
+
+// Returned by login for addresses in an organization's single sign-on domains.
+// Send the browser to ssoLoginUrl(loginUrl); it comes back with ?sso_code=
+// for exchangeSSOCode, or ?sso_error= with a message.
+export interface SSORedirect {
+  ssoRequired: true
+  loginUrl: string
+}
+
+export function ssoLoginUrl(loginUrl: string): string {
+  return `${API_URL}${loginUrl}`
+}
+
+export interface TwoFactorSetup {
+  secret: string
+  uri: string // otpauth:// URI for a QR code
 }
 
-export async function login(email: string, password: string): Promise<LoginResult> {
-  const result = await apiFetch<LoginResult>("/api/auth/login", {
+export async function login(
+  email: string,
+  password: string,
+): Promise<LoginResult | TwoFactorChallenge | PasswordChangeChallenge | SSORedirect> {
+  const result = await apiFetch<LoginResult | TwoFactorChallenge | PasswordChangeChallenge | SSORedirect>("/api/auth/login", {
     method: "POST",
     body: JSON.stringify({ email, password }),
   })
+  if ("token" in result) {
+    setToken(result.token)
+    setRefreshToken(result.refreshToken)
+  }
+  return result
+}
+
+// Continues a login that returned a PasswordChangeChallenge; accounts with
+// two-factor still get a TwoFactorChallenge afterwards.
+export async function setPassword(
+  passwordChangeToken: string,
+  newPassword: string,
+): Promise<LoginResult | TwoFactorChallenge> {
+  const result = await apiFetch<LoginResult | TwoFactorChallenge>("/api/auth/set-password", {
+    method: "POST",
+    body: JSON.stringify({ passwordChangeToken, newPassword }),
+  })
+  if ("token" in result) {
+    setToken(result.token)
+    setRefreshToken(result.refreshToken)
+  }
+  return result
+}
+
+export async function verifyTwoFactor(
+  twoFactorToken: string,
+  data: { code?: string; recoveryCode?: string },
+): Promise<LoginResult> {
+  const result = await apiFetch<LoginResult>("/api/auth/2fa/verify", {
+    method: "POST",
+    body: JSON.stringify({ twoFactorToken, ...data }),
+  })
+  setToken(result.token)
+  setRefreshToken(result.refreshToken)
+  return result
+}
+
+export async function exchangeSSOCode(code: string): Promise<LoginResult> {
+  const result = await apiFetch<LoginResult>("/api/auth/sso/exchange", {
+    method: "POST",
+    body: JSON.stringify({ code }),
+  })
   setToken(result.token)
+  setRefreshToken(result.refreshToken)
   return result
 }
 
+export async function setupTwoFactor(twoFactorToken: string): Promise<TwoFactorSetup> {
+  return apiFetch("/api/auth/2fa/setup", {
+    method: "POST",
+    body: JSON.stringify({ twoFactorToken }),
+  })
+}
+
+export async function enrollTwoFactor(): Promise<TwoFactorSetup> {
+  return apiFetch("/api/auth/2fa/enroll", { method: "POST" })
+}
+
+export async function confirmTwoFactor(code: string): Promise<{ status: string; recoveryCodes: string[] }> {
+  return apiFetch("/api/auth/2fa/confirm", {
+    method: "POST",
+    body: JSON.stringify({ code }),
+  })
+}
+
+export async function disableTwoFactor(password: string): Promise<{ status: string }> {
+  return apiFetch("/api/auth/2fa/disable", {
+    method: "POST",
+    body: JSON.stringify({ password }),
+  })
+}
+
+export async function regenerateRecoveryCodes(code: string): Promise<{ recoveryCodes: string[] }> {
+  return apiFetch("/api/auth/2fa/recovery-codes", {
+    method: "POST",
+    body: JSON.stringify({ code }),
+  })
+}
+
 export async function getMe(): Promise<import("./types").User> {
   return apiFetch("/api/auth/me")
 }
 
 export async function changePassword(currentPassword: string, newPassword: string): Promise<{ status: string }> {
-  return apiFetch("/api/auth/change-password", {
+  // Changing the password revokes existing sessions; the response carries a fresh one.
+  const result = await apiFetch<{ status: string; token?: string; refreshToken?: string }>("/api/auth/change-password", {
     method: "PUT",
     body: JSON.stringify({ currentPassword, newPassword }),
   })
+  if (result.token) setToken(result.token)
+  if (result.refreshToken) setRefreshToken(result.refreshToken)
+  return result
 }
 
 export async function updateMyProfile(data: { name?: string; phone?: string }): Promise<import("./types").User> {
@@ -78,6 +249,23 @@ export async function updateMyProfile(data: { name?: string; phone?: string }):
   })
 }
 
+// requestEmailChange mails a confirmation link to the new address; the
+// email only changes once that link is followed.
+export async function requestEmailChange(newEmail: string, currentPassword: string): Promise<{ status: string }> {
+  return apiFetch("/api/auth/me/email", {
+    method: "POST",
+    body: JSON.stringify({ newEmail, currentPassword }),
+  })
+}
+
+// verifyEmail confirms an email change from a ?verify-email-token= link.
+export async function verifyEmail(token: string): Promise<{ status: string; email: string }> {
+  return apiFetch("/api/auth/verify-email", {
+    method: "POST",
+    body: JSON.stringify({ token }),
+  })
+}
+
 export async function forgotPassword(email: string): Promise<{ message: string }> {
   return apiFetch("/api/auth/forgot-password", {
     method: "POST",
@@ -85,8 +273,24 @@ export async function forgotPassword(email: string): Promise<{ message: string }
   })
 }
 
-export function logout() {
+export async function resetPassword(token: string, newPassword: string): Promise<{ status: string }> {
+  return apiFetch("/api/auth/reset-password", {
+    method: "POST",
+    body: JSON.stringify({ token, newPassword }),
+  })
+}
+
+export async function logout(): Promise<void> {
+  const refreshToken = getRefreshToken()
   setToken(null)
+  setRefreshToken(null)
+  if (refreshToken) {
+    await fetch(`${API_URL}/api/auth/logout`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ refreshToken }),
+    }).catch(() => {})
+  }
 }
 
 // ============================================================================
@@ -103,10 +307,12 @@ export async function getUser(id: string): Promise<import("./types").User> {
 
 export async function createUser(data: {
   name: string
-  email: string
+  email?: string // optional for service accounts
   role: string
   organizationId: string | null
-  password?: string
+  password?: string // temporary, changed at first login; omit to invite the user by email
+  serviceAccount?: boolean
+  customRoleId?: string
 }): Promise<import("./types").User> {
   return apiFetch("/api/users", {
     method: "POST",
@@ -119,6 +325,8 @@ export async function updateUser(id: string, data: {
   email?: string
   role?: string
   organizationId?: string | null
+  // An empty string removes the custom role.
+  customRoleId?: string
 }): Promise<import("./types").User> {
   return apiFetch(`/api/users/${id}`, {
     method: "PUT",
@@ -130,6 +338,132 @@ export async function deleteUser(id: string): Promise<void> {
   await apiFetch(`/api/users/${id}`, { method: "DELETE" })
 }
 
+// Deleted users are kept for a retention period, during which they can be restored.
+export async function getDeletedUsers(): Promise<import("./types").User[]> {
+  return apiFetch("/api/users?deleted=true")
+}
+
+export async function restoreUser(id: string): Promise<import("./types").User> {
+  return apiFetch(`/api/users/${id}/restore`, { method: "POST" })
+}
+
+// resetUserTwoFactor removes a user's two-factor setup and signs them out (admin only).
+export async function resetUserTwoFactor(id: string): Promise<{ status: string }> {
+  return apiFetch(`/api/users/${id}/reset-2fa`, { method: "POST" })
+}
+
+// unlockUser lifts a sign-in lockout caused by failed attempts (admin only).
+export async function unlockUser(id: string): Promise<{ status: string }> {
+  return apiFetch(`/api/users/${id}/unlock`, { method: "POST" })
+}
+
+// revokeUserSessions signs the user out on every device (admin only).
+export async function revokeUserSessions(id: string): Promise<{ status: string }> {
+  return apiFetch(`/api/users/${id}/revoke-sessions`, { method: "POST" })
+}
+
+// ============================================================================
+// Invitations
+// ============================================================================
+
+export interface InvitationInput {
+  email: string
+  name?: string
+  role?: string // default client
+  organizationId?: string // default the caller's organization
+  customRoleId?: string
+  team?: string
+}
+
+// Pending users, i.e. users with an invitation.
+export async function getInvitations(): Promise<import("./types").User[]> {
+  return apiFetch("/api/invitations")
+}
+
+export async function createInvitation(data: InvitationInput): Promise<import("./types").User> {
+  return apiFetch("/api/invitations", {
+    method: "POST",
+    body: JSON.stringify(data),
+  })
+}
+
+// resendInvitation emails a new link; the previous one stops working.
+export async function resendInvitation(userId: string): Promise<import("./types").User> {
+  return apiFetch(`/api/invitations/${userId}/resend`, { method: "POST" })
+}
+
+export async function revokeInvitation(userId: string): Promise<{ status: string }> {
+  return apiFetch(`/api/invitations/${userId}`, { method: "DELETE" })
+}
+
+// getInvitation describes the invitation behind an ?invitation-token= link.
+export async function getInvitation(token: string): Promise<import("./types").InvitationInfo> {
+  return apiFetch(`/api/invitations/accept?token=${encodeURIComponent(token)}`)
+}
+
+// acceptInvitation activates the account and signs in; accounts in
+// organizations that require two-factor get a TwoFactorChallenge first.
+export async function acceptInvitation(
+  token: string,
+  name: string,
+  password: string,
+): Promise<LoginResult | TwoFactorChallenge> {
+  const result = await apiFetch<LoginResult | TwoFactorChallenge>("/api/invitations/accept", {
+    method: "POST",
+    body: JSON.stringify({ token, name, password }),
+  })
+  if ("token" in result) {
+    setToken(result.token)
+    setRefreshToken(result.refreshToken)
+  }
+  return result
+}
+
+// ============================================================================
+// API tokens
+// ============================================================================
+
+export interface CreateAPITokenInput {
+  name: string
+  scopes: import("./types").APITokenScope[]
+  expiresInDays?: number // default 90, at most 365
+}
+
+// The token secret is only returned here, once.
+export type CreatedAPIToken = import("./types").APIToken & { token: string }
+
+export async function getMyAPITokens(): Promise<import("./types").APIToken[]> {
+  return apiFetch("/api/auth/tokens")
+}
+
+export async function createMyAPIToken(data: CreateAPITokenInput): Promise<CreatedAPIToken> {
+  return apiFetch("/api/auth/tokens", {
+    method: "POST",
+    body: JSON.stringify(data),
+  })
+}
+
+export async function revokeMyAPIToken(id: string): Promise<{ status: string }> {
+  return apiFetch(`/api/auth/tokens/${id}`, { method: "DELETE" })
+}
+
+// Admin only: any user's tokens can be listed and revoked; tokens can be
+// created for service accounts.
+export async function getUserAPITokens(userId: string): Promise<import("./types").APIToken[]> {
+  return apiFetch(`/api/users/${userId}/tokens`)
+}
+
+export async function createServiceAccountToken(userId: string, data: CreateAPITokenInput): Promise<CreatedAPIToken> {
+  return apiFetch(`/api/users/${userId}/tokens`, {
+    method: "POST",
+    body: JSON.stringify(data),
+  })
+}
+
+export async function revokeUserAPIToken(userId: string, tokenId: string): Promise<{ status: string }> {
+  return apiFetch(`/api/users/${userId}/tokens/${tokenId}`, { method: "DELETE" })
+}
+
 // ============================================================================
 // Organizations
 // ============================================================================
@@ -146,6 +480,7 @@ export async function createOrganization(data: {
   name: string
   plan: string
   contactEmail: string
+  chatWebhookUrl?: string
 }): Promise<import("./types").Organization> {
   return apiFetch("/api/organizations", {
     method: "POST",
@@ -157,6 +492,8 @@ export async function updateOrganization(id: string, data: {
   name?: string
   plan?: string
   contactEmail?: string
+  // An empty string removes the webhook.
+  chatWebhookUrl?: string
 }): Promise<import("./types").Organization> {
   return apiFetch(`/api/organizations/${id}`, {
     method: "PUT",
@@ -168,6 +505,52 @@ export async function deleteOrganization(id: string): Promise<void> {
   await apiFetch(`/api/organizations/${id}`, { method: "DELETE" })
 }
 
+export async function getDeletedOrganizations(): Promise<import("./types").Organization[]> {
+  return apiFetch("/api/organizations?deleted=true")
+}
+
+// restoreOrganization also restores the members deleted with the organization.
+export async function restoreOrganization(id: string): Promise<import("./types").Organization> {
+  return apiFetch(`/api/organizations/${id}/restore`, { method: "POST" })
+}
+
+// purgeOrganization permanently deletes a deleted organization in the background.
+export async function purgeOrganization(id: string): Promise<import("./types").PurgeJob> {
+  return apiFetch(`/api/organizations/${id}/purge`, { method: "POST" })
+}
+
+export async function getPurgeJob(id: string): Promise<import("./types").PurgeJob> {
+  return apiFetch(`/api/organizations/${id}/purge`)
+}
+
+export interface CustomRoleInput {
+  name: string
+  description?: string
+  permissions: string[]
+}
+
+export async function getCustomRoles(orgId: string): Promise<import("./types").CustomRole[]> {
+  return apiFetch(`/api/organizations/${orgId}/roles`)
+}
+
+export async function createCustomRole(orgId: string, data: CustomRoleInput): Promise<import("./types").CustomRole> {
+  return apiFetch(`/api/organizations/${orgId}/roles`, {
+    method: "POST",
+    body: JSON.stringify(data),
+  })
+}
+
+export async function updateCustomRole(orgId: string, roleId: string, data: CustomRoleInput): Promise<import("./types").CustomRole> {
+  return apiFetch(`/api/organizations/${orgId}/roles/${roleId}`, {
+    method: "PUT",
+    body: JSON.stringify(data),
+  })
+}
+
+export async function deleteCustomRole(orgId: string, roleId: string): Promise<void> {
+  await apiFetch(`/api/organizations/${orgId}/roles/${roleId}`, { method: "DELETE" })
+}
+
 // ============================================================================
 // Tickets
 // ============================================================================
@@ -236,6 +619,148 @@ export async function requestConversion(ticketId: string, data: {
   })
 }
 
+// ============================================================================
+// Real-time stream
+// ============================================================================
+
+export interface StreamEvent {
+  id: string
+  type: "ticket.created" | "ticket.updated" | "message.added"
+  ticketId: string
+  data: unknown
+}
+
+// subscribeToStream follows GET /api/stream and calls onEvent for each ticket
+// event. onReset is called when events may have been missed and the caller
+// should reload. EventSource cannot send the Authorization header, so the
+// stream is read with fetch. Returns a function that closes the stream.
+export function subscribeToStream(
+  onEvent: (event: StreamEvent) => void,
+  onReset?: () => void,
+): () => void {
+  const controller = new AbortController()
+  let lastEventId = ""
+
+  const connect = async () => {
+    while (!controller.signal.aborted) {
+      try {
+        const headers: Record<string, string> = { Accept: "text/event-stream" }
+        const token = getToken()
+        if (token) headers["Authorization"] = `Bearer ${token}`
+        if (lastEventId) headers["Last-Event-ID"] = lastEventId
+
+        const res = await fetch(`${API_URL}/api/stream`, { headers, signal: controller.signal })
+        if (!res.ok || !res.body) {
+          if (res.status === 401 && (await refreshSession())) continue
+          // 404: the serverless deployment has no stream.
+          if (res.status === 401 || res.status === 404 || res.status === 501) return
+          throw new Error(`stream error: ${res.status}`)
+        }
+
+        const reader = res.body.pipeThrough(new TextDecoderStream()).getReader()
+        let buffer = ""
+        for (;;) {
+          const { value, done } = await reader.read()
+          if (done) break
+          buffer += value
+          let end
+          while ((end = buffer.indexOf("\n\n")) >= 0) {
+            const block = buffer.slice(0, end)
+            buffer = buffer.slice(end + 2)
+            let name = "message"
+            let data = ""
+            for (const line of block.split("\n")) {
+              if (line.startsWith("id: ")) lastEventId = line.slice(4)
+              else if (line.startsWith("event: ")) name = line.slice(7)
+              else if (line.startsWith("data: ")) data += line.slice(6)
+            }
+            if (name === "reset") onReset?.()
+            else if (data) onEvent(JSON.parse(data))
+          }
+        }
+      } catch {
+        if (controller.signal.aborted) return
+      }
+      await new Promise((resolve) => setTimeout(resolve, 3000))
+    }
+  }
+  connect()
+
+  return () => controller.abort()
+}
+
+// ============================================================================
+// Ticket presence
+// ============================================================================
+
+export interface PresenceViewer {
+  userId: string
+  name: string
+  role: string
+  state: "viewing" | "typing"
+  since: string
+}
+
+export interface PresenceConnection {
+  // Call on every keystroke in the reply box; it is throttled here.
+  typing: () => void
+  // Call when the reply box is cleared, sent or closed.
+  stopTyping: () => void
+  close: () => void
+}
+
+// watchPresence reports who else is viewing or replying to a ticket (agents
+// only). The token is passed as a WebSocket subprotocol because browsers
+// cannot set the Authorization header on WebSocket connections.
+export function watchPresence(ticketId: string, onChange: (viewers: PresenceViewer[]) => void): PresenceConnection {
+  const wsUrl = API_URL.replace(/^http/, "ws")
+  let socket: WebSocket | null = null
+  let closed = false
+  let lastTyping = 0
+  let ping: ReturnType<typeof setInterval> | undefined
+
+  const send = (type: string) => {
+    if (socket?.readyState === WebSocket.OPEN) socket.send(JSON.stringify({ type }))
+  }
+
+  const connect = () => {
+    const protocols = ["presence"]
+    const token = getToken()
+    if (token) protocols.push(`bearer.${token}`)
+    socket = new WebSocket(`${wsUrl}/api/tickets/${ticketId}/presence`, protocols)
+    socket.onmessage = (e) => {
+      const msg = JSON.parse(e.data)
+      if (msg.type === "presence") onChange(msg.viewers)
+    }
+    socket.onopen = () => {
+      ping = setInterval(() => send("ping"), 25000)
+    }
+    socket.onclose = () => {
+      clearInterval(ping)
+      if (!closed) setTimeout(connect, 3000)
+    }
+  }
+  connect()
+
+  return {
+    typing: () => {
+      const now = Date.now()
+      if (now - lastTyping < 3000) return
+      lastTyping = now
+      send("typing")
+    },
+    stopTyping: () => {
+      lastTyping = 0
+      send("viewing")
+    },
+    close: () => {
+      closed = true
+      clearInterval(ping)
+      socket?.close()
+    },
+  }
+}
+
 // ============================================================================
 // Approvals
 // ============================================================================
@@ -284,6 +809,79 @@ export async function updateInvoiceStatus(id: string, status: string): Promise<{
   })
 }
 
+// ============================================================================
+// Webhooks (admin)
+// ============================================================================
+
+export interface WebhookSubscription {
+  id: string
+  organizationId?: string
+  url: string
+  events: string[]
+  active: boolean
+  description?: string
+  createdBy: string
+  createdAt: string
+  updatedAt: string
+  // Only returned when the subscription is created.
+  secret?: string
+}
+
+export interface WebhookDelivery {
+  id: string
+  subscriptionId: string
+  event: string
+  payload: string
+  status: "pending" | "succeeded" | "failed"
+  attempts: number
+  responseStatus?: number
+  lastError?: string
+  nextAttemptAt?: string
+  createdAt: string
+  updatedAt: string
+}
+
+export async function getWebhooks(): Promise<WebhookSubscription[]> {
+  return apiFetch("/api/webhooks")
+}
+
+export async function createWebhook(data: {
+  url: string
+  events?: string[]
+  organizationId?: string
+  description?: string
+}): Promise<WebhookSubscription> {
+  return apiFetch("/api/webhooks", {
+    method: "POST",
+    body: JSON.stringify(data),
+  })
+}
+
+export async function updateWebhook(id: string, data: {
+  url?: string
+  events?: string[]
+  organizationId?: string
+  active?: boolean
+  description?: string
+}): Promise<WebhookSubscription> {
+  return apiFetch(`/api/webhooks/${id}`, {
+    method: "PUT",
+    body: JSON.stringify(data),
+  })
+}
+
+export async function deleteWebhook(id: string): Promise<void> {
+  await apiFetch(`/api/webhooks/${id}`, { method: "DELETE" })
+}
+
+export async function getWebhookDeliveries(id: string): Promise<WebhookDelivery[]> {
+  return apiFetch(`/api/webhooks/${id}/deliveries`)
+}
+
+export async function redeliverWebhook(id: string, deliveryId: string): Promise<WebhookDelivery> {
+  return apiFetch(`/api/webhooks/${id}/deliveries/${deliveryId}/redeliver`, { method: "POST" })
+}
+
 // ============================================================================
 // Dashboard
 // ============================================================================
@@ -28,10 +28,22 @@ interface StoreContextType {
   ratePerHour: number
   loading: boolean
 
-  loginWithCredentials: (email: string, password: string) => Promise<void>
+  // Resolves to a challenge when a second factor is needed; finish with completeTwoFactor.
+  // Single sign-on accounts are redirected to their identity provider instead.
+  loginWithCredentials: (
+    email: string,
+    password: string,
+  ) => Promise<api.TwoFactorChallenge | api.PasswordChangeChallenge | null>
+  // Verifies the second factor. The caller shows any recovery codes, then calls finishLogin.
+  completeTwoFactor: (twoFactorToken: string, data: { code?: string; recoveryCode?: string }) => Promise<api.LoginResult>
+  finishLogin: (user: User) => void
   logout: () => void
   changePassword: (currentPassword: string, newPassword: string) => Promise<void>
   updateMyProfile: (data: { name?: string; phone?: string }) => Promise<void>
+  // Mails a confirmation link to the new address; the email changes once it is followed.
+  requestEmailChange: (newEmail: string, currentPassword: string) => Promise<void>
+  // Confirms an email change from a ?verify-email-token= link and resolves to the new address.
+  verifyEmail: (token: string) => Promise<string>
   forgotPassword: (email: string) => Promise<void>
 
   createTicket: (ticket: Omit<Ticket, "id" | "createdAt" | "updatedAt" | "hoursWorked" | "messages" | "timeEntries">) => Promise<void>
@@ -122,11 +134,26 @@ export function StoreProvider({ children }: { children: React.ReactNode }) {
   const loginWithCredentials = useCallback(
     async (email: string, password: string) => {
       const result = await api.login(email, password)
+      if ("ssoRequired" in result) {
+        window.location.href = api.ssoLoginUrl(result.loginUrl)
+        return null
+      }
+      if ("twoFactorRequired" in result || "passwordChangeRequired" in result) return result
       setCurrentUser(result.user)
+      return null
     },
     []
   )
 
+  const completeTwoFactor = useCallback(
+    async (twoFactorToken: string, data: { code?: string; recoveryCode?: string }) => {
+      return api.verifyTwoFactor(twoFactorToken, data)
+    },
+    []
+  )
+
+  const finishLogin = useCallback((user: User) => setCurrentUser(user), [])
+
   const logout = useCallback(() => {
     setCurrentUser(null)
     setOrganizations([])
@@ -159,6 +186,22 @@ export function StoreProvider({ children }: { children: React.ReactNode }) {
     []
   )
 
+  const requestEmailChange = useCallback(async (newEmail: string, currentPassword: string) => {
+    await api.requestEmailChange(newEmail, currentPassword)
+  }, [])
+
+  const verifyEmail = useCallback(async (token: string) => {
+    const result = await api.verifyEmail(token)
+    // The link may be opened while signed in; pick up the new address.
+    if (api.getToken()) {
+      api
+        .getMe()
+        .then((user) => setCurrentUser(user))
+        .catch(() => {})
+    }
+    return result.email
+  }, [])
+
   const forgotPassword = useCallback(async (email: string) => {
     await api.forgotPassword(email)
   }, [])
@@ -341,9 +384,13 @@ export function StoreProvider({ children }: { children: React.ReactNode }) {
         ratePerHour,
         loading,
         loginWithCredentials,
+        completeTwoFactor,
+        finishLogin,
         logout,
         changePassword,
         updateMyProfile,
+        requestEmailChange,
+        verifyEmail,
         forgotPassword,
         createTicket,
         updateTicketStatus,
@@ -10,11 +10,31 @@ export type ApprovalStatus = "pending" | "approved" | "rejected"
 
 export type InvoiceStatus = "draft" | "sent" | "paid"
 
+export interface SSOConfig {
+  issuer: string
+  clientId: string
+  clientSecret?: string // write-only; omit on update to keep the current one
+  domains: string[]
+  roleClaim?: string
+  roleMapping?: Record<string, UserRole>
+  defaultRole?: UserRole
+}
+
 export interface Organization {
   id: string
   name: string
   plan: "starter" | "professional" | "enterprise"
   contactEmail: string
+  // Slack-compatible incoming webhook; only returned to staff.
+  chatWebhookUrl?: string
+  // Members must set up two-factor authentication to sign in.
+  requireTwoFactor?: boolean
+  // Members whose custom role grants user.invite may invite clients into the organization.
+  memberInvites?: boolean
+  // OpenID Connect single sign-on for the listed email domains; only returned to staff.
+  sso?: SSOConfig
+  // Set while the organization is deleted and can still be restored.
+  deletedAt?: string
   createdAt: string
 }
 
@@ -26,6 +46,81 @@ export interface User {
   organizationId: string | null
   avatar: string
   phone?: string
+  twoFactorEnabled?: boolean
+  serviceAccount?: boolean
+  customRoleId?: string
+  // Only set on the current user (GET /api/auth/me).
+  permissions?: string[]
+  // Set while sign-in is locked after failed attempts (admins, GET /api/users/{id}).
+  lockedUntil?: string
+  // Set until the user accepts their invitation.
+  invitation?: Invitation
+  // Set while the user is deleted and can still be restored.
+  deletedAt?: string
+}
+
+export interface Invitation {
+  invitedBy: string
+  invitedAt: string
+  expiresAt: string
+}
+
+// What an invitation link shows before it is accepted.
+export interface InvitationInfo {
+  email: string
+  name: string
+  organizationName?: string
+  expiresAt: string
+}
+
+// PurgeJob reports the progress of permanently deleting an organization.
+export interface PurgeJob {
+  organizationId: string
+  status: "pending" | "running" | "done" | "failed"
+  // One of "tickets", "invoices", "roles", "webhooks", "members", "organization".
+  step: string
+  // Records deleted so far, by step.
+  deleted: Record<string, number>
+  failures: number
+  lastError?: string
+  requestedBy?: string
+  createdAt: string
+  updatedAt: string
+  completedAt?: string
+}
+
+// CustomRole is a role an organization defines for its members. Members keep
+// their built-in role but only hold the permissions listed here.
+export interface CustomRole {
+  id: string
+  organizationId: string
+  name: string
+  description?: string
+  permissions: string[]
+  createdAt: string
+  updatedAt: string
+}
+
+export type APITokenScope =
+  | "tickets:read" | "tickets:write"
+  | "approvals:read" | "approvals:write"
+  | "invoices:read" | "invoices:write"
+  | "organizations:read" | "organizations:write"
+  | "users:read" | "users:write"
+  | "macros:read" | "macros:write"
+  | "webhooks:read" | "webhooks:write"
+  | "dashboard:read"
+
+export interface APIToken {
+  id: string
+  userId: string
+  name: string
+  scopes: APITokenScope[]
+  createdBy: string
+  createdAt: string
+  expiresAt: string
+  lastUsedAt?: string
+  revokedAt?: string
 }
 
 export interface Message {
@@ -91,6 +186,7 @@ export interface Invoice {
 export interface ActivityItem {
   id: string
   type: "ticket-created" | "ticket-updated" | "message-added" | "ticket-resolved" | "conversion-requested" | "conversion-approved"
+    | "account-locked" | "account-unlocked"
   description: string
   userId: string
   ticketId?: string